@@ -0,0 +1,150 @@
+package rexCache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type (
+	// localCache 是一个带容量上限和过期时间的进程内 LRU 缓存
+	localCache struct {
+		lock     sync.Mutex
+		capacity int
+		ttl      time.Duration
+		ll       *list.List
+		items    map[string]*list.Element
+		now      func() time.Time
+		onEvict  func(key string)
+		// generation 每次 del/clear 加一，回源写入前比较，避免把失效之前读到的旧值写回本地
+		generation uint64
+	}
+	localEntry struct {
+		key      string
+		value    string
+		expireAt time.Time
+	}
+)
+
+func newLocalCache(capacity int, ttl time.Duration) *localCache {
+	return &localCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+func (c *localCache) get(key string) (string, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := el.Value.(*localEntry)
+	if !entry.expireAt.IsZero() && !c.now().Before(entry.expireAt) {
+		// note: 已过期，惰性删除
+		c.removeElement(el)
+		return "", false
+	}
+	c.ll.MoveToFront(el)
+	return entry.value, true
+}
+
+func (c *localCache) set(key, value string) {
+	c.setWithTtl(key, value, c.ttl)
+}
+
+// currentGeneration 读取 redis 之前调用，配合 setIfGeneration 使用
+func (c *localCache) currentGeneration() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+// setIfGeneration 期间发生过失效时放弃写入，返回是否写入
+func (c *localCache) setIfGeneration(key, value string, generation uint64) bool {
+	if c.capacity <= 0 {
+		return false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.generation != generation {
+		return false
+	}
+	c.setLocked(key, value, c.ttl)
+	return true
+}
+
+func (c *localCache) setWithTtl(key, value string, ttl time.Duration) {
+	if c.capacity <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setLocked(key, value, ttl)
+}
+
+func (c *localCache) setLocked(key, value string, ttl time.Duration) {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = c.now().Add(ttl)
+	}
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*localEntry)
+		entry.value = value
+		entry.expireAt = expireAt
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&localEntry{
+		key:      key,
+		value:    value,
+		expireAt: expireAt,
+	})
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.removeElement(oldest)
+		if c.onEvict != nil {
+			c.onEvict(oldest.Value.(*localEntry).key)
+		}
+	}
+}
+
+func (c *localCache) del(keys ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+func (c *localCache) clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.generation++
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+}
+
+func (c *localCache) len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+func (c *localCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*localEntry).key)
+}
@@ -0,0 +1,41 @@
+package rexCache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalCache(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	evicted := []string{}
+	c := newLocalCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+	c.onEvict = func(key string) { evicted = append(evicted, key) }
+
+	c.set("a", "1")
+	c.set("b", "2")
+	// note: 访问 a 之后 b 变成最久未使用
+	if v, ok := c.get("a"); !ok || v != "1" {
+		t.Fatalf("get(a) = %v, %v, want 1, true", v, ok)
+	}
+	c.set("c", "3")
+	if _, ok := c.get("b"); ok {
+		t.Errorf("get(b) should be evicted")
+	}
+	if len(evicted) != 1 || evicted[0] != "b" {
+		t.Errorf("evicted = %v, want [b]", evicted)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Errorf("get(a) should be expired")
+	}
+	if c.len() != 1 {
+		t.Errorf("len() = %d, want 1", c.len())
+	}
+
+	c.del("c")
+	if _, ok := c.get("c"); ok {
+		t.Errorf("get(c) should be deleted")
+	}
+}
@@ -0,0 +1,308 @@
+package rexCache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexUlid"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/syncx"
+)
+
+const (
+	defaultLocalCapacity     = 1024
+	defaultLocalTtl          = time.Minute
+	defaultRemoteTtl         = 10 * time.Minute
+	defaultInvalidateChannel = "rex-cache-invalidate"
+)
+
+var (
+	// ErrEmptyKey is an error that indicates the cache key is empty.
+	ErrEmptyKey = errors.New("empty cache key")
+	// ErrCacheClosed is an error that indicates the cache has been closed.
+	ErrCacheClosed = errors.New("cache closed")
+)
+
+type (
+	TwoLevelCacheConf struct {
+		// Name 会作为失效广播频道的后缀，同一个 Name 的节点互相广播
+		Name          string        `json:",default=default"`
+		LocalCapacity int           `json:",default=1024"`
+		LocalTtl      time.Duration `json:",default=1m"`
+		RemoteTtl     time.Duration `json:",default=10m"`
+		Channel       string        `json:",optional"`
+	}
+	Stats struct {
+		LocalHits     uint64 `json:"local_hits"`
+		LocalMisses   uint64 `json:"local_misses"`
+		RemoteHits    uint64 `json:"remote_hits"`
+		RemoteMisses  uint64 `json:"remote_misses"`
+		Evictions     uint64 `json:"evictions"`
+		Invalidations uint64 `json:"invalidations"`
+		LocalSize     int    `json:"local_size"`
+	}
+	TwoLevelCache interface {
+		Get(key string) (string, bool, error)
+		GetCtx(ctx context.Context, key string) (string, bool, error)
+		Set(key, value string) error
+		SetCtx(ctx context.Context, key, value string) error
+		SetEx(key, value string, seconds int) error
+		SetExCtx(ctx context.Context, key, value string, seconds int) error
+		Del(keys ...string) error
+		DelCtx(ctx context.Context, keys ...string) error
+		Invalidate(keys ...string) error
+		InvalidateCtx(ctx context.Context, keys ...string) error
+		Take(key string, fetch func(ctx context.Context) (string, error)) (string, error)
+		TakeCtx(ctx context.Context, key string, fetch func(ctx context.Context) (string, error)) (string, error)
+		Stats() Stats
+		NodeId() string
+		Close() error
+	}
+	defaultTwoLevelCache struct {
+		conf    TwoLevelCacheConf
+		store   rexDao.RedisDao
+		local   *localCache
+		flight  syncx.SingleFlight
+		nodeId  string
+		channel string
+		cancel  context.CancelFunc
+		closed  atomic.Bool
+
+		localHits     atomic.Uint64
+		localMisses   atomic.Uint64
+		remoteHits    atomic.Uint64
+		remoteMisses  atomic.Uint64
+		evictions     atomic.Uint64
+		invalidations atomic.Uint64
+	}
+	invalidateMessage struct {
+		Node string   `json:"node"`
+		Keys []string `json:"keys"`
+	}
+)
+
+// NewTwoLevelCache 创建一个进程内 LRU + redis 的二级缓存，并开始监听其他节点的失效广播
+func NewTwoLevelCache(conf TwoLevelCacheConf, store rexDao.RedisDao) TwoLevelCache {
+	if conf.LocalCapacity <= 0 {
+		conf.LocalCapacity = defaultLocalCapacity
+	}
+	if conf.LocalTtl <= 0 {
+		conf.LocalTtl = defaultLocalTtl
+	}
+	if conf.RemoteTtl <= 0 {
+		conf.RemoteTtl = defaultRemoteTtl
+	}
+	channel := conf.Channel
+	if channel == "" {
+		channel = fmt.Sprintf("%s:%s", defaultInvalidateChannel, conf.Name)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &defaultTwoLevelCache{
+		conf:    conf,
+		store:   store,
+		local:   newLocalCache(conf.LocalCapacity, conf.LocalTtl),
+		flight:  syncx.NewSingleFlight(),
+		nodeId:  rexUlid.NewString(),
+		channel: channel,
+		cancel:  cancel,
+	}
+	c.local.onEvict = func(string) {
+		c.evictions.Add(1)
+	}
+
+	go func() {
+		if err := store.NewWatcherCtx(ctx, channel, c.handleInvalidate); err != nil {
+			logx.Errorf("two level cache watch channel %s failed: %v", channel, err)
+		}
+	}()
+	return c
+}
+
+func (c *defaultTwoLevelCache) Get(key string) (string, bool, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx 先查本地缓存，未命中再查 redis，redis 中空字符串视为未命中
+func (c *defaultTwoLevelCache) GetCtx(ctx context.Context, key string) (string, bool, error) {
+	if key == "" {
+		return "", false, ErrEmptyKey
+	}
+	if val, ok := c.local.get(key); ok {
+		c.localHits.Add(1)
+		return val, true, nil
+	}
+	c.localMisses.Add(1)
+
+	// note: 读 redis 期间收到的失效会让 generation 变化，这时不写本地缓存，避免旧值覆盖失效
+	generation := c.local.currentGeneration()
+	val, err := c.store.GetCtx(ctx, key)
+	if err != nil {
+		return "", false, err
+	}
+	if val == "" {
+		c.remoteMisses.Add(1)
+		return "", false, nil
+	}
+	c.remoteHits.Add(1)
+	c.local.setIfGeneration(key, val, generation)
+	return val, true, nil
+}
+
+func (c *defaultTwoLevelCache) Set(key, value string) error {
+	return c.SetCtx(context.Background(), key, value)
+}
+
+func (c *defaultTwoLevelCache) SetCtx(ctx context.Context, key, value string) error {
+	return c.SetExCtx(ctx, key, value, int(c.conf.RemoteTtl/time.Second))
+}
+
+func (c *defaultTwoLevelCache) SetEx(key, value string, seconds int) error {
+	return c.SetExCtx(context.Background(), key, value, seconds)
+}
+
+// SetExCtx 写入 redis 后刷新本地缓存，并广播让其他节点丢弃本地副本
+func (c *defaultTwoLevelCache) SetExCtx(ctx context.Context, key, value string, seconds int) error {
+	if key == "" {
+		return ErrEmptyKey
+	}
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	var err error
+	if seconds > 0 {
+		err = c.store.SetExCtx(ctx, key, value, seconds)
+	} else {
+		err = c.store.SetCtx(ctx, key, value)
+	}
+	if err != nil {
+		return err
+	}
+
+	// note: 本地缓存的有效期不能超过 redis 中的有效期
+	localTtl := c.conf.LocalTtl
+	if seconds > 0 && time.Duration(seconds)*time.Second < localTtl {
+		localTtl = time.Duration(seconds) * time.Second
+	}
+	c.local.setWithTtl(key, value, localTtl)
+	return c.publish(ctx, key)
+}
+
+func (c *defaultTwoLevelCache) Del(keys ...string) error {
+	return c.DelCtx(context.Background(), keys...)
+}
+
+func (c *defaultTwoLevelCache) DelCtx(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	if _, err := c.store.DelCtx(ctx, keys...); err != nil {
+		return err
+	}
+	c.local.del(keys...)
+	return c.publish(ctx, keys...)
+}
+
+func (c *defaultTwoLevelCache) Invalidate(keys ...string) error {
+	return c.InvalidateCtx(context.Background(), keys...)
+}
+
+// InvalidateCtx 只丢弃所有节点的本地副本，不删除 redis 中的数据
+func (c *defaultTwoLevelCache) InvalidateCtx(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if c.closed.Load() {
+		return ErrCacheClosed
+	}
+	c.local.del(keys...)
+	return c.publish(ctx, keys...)
+}
+
+func (c *defaultTwoLevelCache) Take(key string, fetch func(ctx context.Context) (string, error)) (string, error) {
+	return c.TakeCtx(context.Background(), key, fetch)
+}
+
+// TakeCtx 未命中时调用 fetch 回源，同一个 key 的并发回源会合并成一次
+func (c *defaultTwoLevelCache) TakeCtx(ctx context.Context, key string, fetch func(ctx context.Context) (string, error)) (string, error) {
+	val, ok, err := c.GetCtx(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return val, nil
+	}
+
+	res, err := c.flight.Do(key, func() (any, error) {
+		fetched, err := fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err := c.SetCtx(ctx, key, fetched); err != nil {
+			return "", err
+		}
+		return fetched, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.(string), nil
+}
+
+func (c *defaultTwoLevelCache) Stats() Stats {
+	return Stats{
+		LocalHits:     c.localHits.Load(),
+		LocalMisses:   c.localMisses.Load(),
+		RemoteHits:    c.remoteHits.Load(),
+		RemoteMisses:  c.remoteMisses.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		LocalSize:     c.local.len(),
+	}
+}
+
+func (c *defaultTwoLevelCache) NodeId() string {
+	return c.nodeId
+}
+
+func (c *defaultTwoLevelCache) Close() error {
+	if c.closed.Swap(true) {
+		return nil
+	}
+	c.cancel()
+	c.local.clear()
+	return nil
+}
+
+func (c *defaultTwoLevelCache) publish(ctx context.Context, keys ...string) error {
+	body, err := json.Marshal(&invalidateMessage{
+		Node: c.nodeId,
+		Keys: keys,
+	})
+	if err != nil {
+		return err
+	}
+	return c.store.PublishCtx(ctx, c.channel, string(body))
+}
+
+func (c *defaultTwoLevelCache) handleInvalidate(msg *redis.Message) {
+	var m invalidateMessage
+	if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
+		logx.Errorf("two level cache invalid message on %s: %v", msg.Channel, err)
+		return
+	}
+	// note: 自己发出的广播不需要处理，本地已经是最新值
+	if m.Node == c.nodeId {
+		return
+	}
+	c.local.del(m.Keys...)
+	c.invalidations.Add(uint64(len(m.Keys)))
+}
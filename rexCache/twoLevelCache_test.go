@@ -0,0 +1,72 @@
+package rexCache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexDao"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
+
+func TestTwoLevelCache(t *testing.T) {
+	store := rexDao.NewMemoryRedisDao()
+	conf := TwoLevelCacheConf{Name: "test"}
+	a := NewTwoLevelCache(conf, store)
+	defer a.Close()
+	b := NewTwoLevelCache(conf, store)
+	defer b.Close()
+
+	if err := a.Set("user:1", "v1"); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if val, ok, err := b.Get("user:1"); err != nil || !ok || val != "v1" {
+		t.Fatalf("Get() = %v, %v, %v, want v1 from redis", val, ok, err)
+	}
+	if _, _, _ = b.Get("user:1"); b.Stats().LocalHits != 1 {
+		t.Errorf("stats = %+v, want the second get to hit the local cache", b.Stats())
+	}
+	// note: 订阅在后台建立，重复写入直到 b 收到广播
+	waitFor(t, func() bool {
+		_ = a.Set("user:1", "v2")
+		val, _, _ := b.Get("user:1")
+		return val == "v2"
+	})
+	if b.Stats().Invalidations == 0 {
+		t.Errorf("stats = %+v, want invalidations from a", b.Stats())
+	}
+
+	val, err := b.Take("user:2", func(ctx context.Context) (string, error) { return "fetched", nil })
+	if err != nil || val != "fetched" {
+		t.Errorf("Take() = %v, %v", val, err)
+	}
+	if val, _ := store.Get("user:2"); val != "fetched" {
+		t.Errorf("redis value = %q, want fetched", val)
+	}
+}
+
+func TestTwoLevelCacheGeneration(t *testing.T) {
+	c := newLocalCache(4, time.Minute)
+	generation := c.currentGeneration()
+	// note: 模拟回源读 redis 期间收到失效广播
+	c.del("user:1")
+	if c.setIfGeneration("user:1", "stale", generation) {
+		t.Error("setIfGeneration() wrote a value read before the invalidation")
+	}
+	if _, ok := c.get("user:1"); ok {
+		t.Error("get() returned the stale value")
+	}
+	if !c.setIfGeneration("user:1", "fresh", c.currentGeneration()) {
+		t.Error("setIfGeneration() with the current generation = false")
+	}
+}
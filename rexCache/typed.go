@@ -0,0 +1,54 @@
+package rexCache
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// GetTyped 读取缓存并按 json 反序列化为 T
+func GetTyped[T any](ctx context.Context, c TwoLevelCache, key string) (T, bool, error) {
+	var out T
+	val, ok, err := c.GetCtx(ctx, key)
+	if err != nil || !ok {
+		return out, false, err
+	}
+	if err := json.Unmarshal([]byte(val), &out); err != nil {
+		return out, false, err
+	}
+	return out, true, nil
+}
+
+// SetTyped 按 json 序列化 v 后写入缓存，seconds <= 0 时使用默认有效期
+func SetTyped[T any](ctx context.Context, c TwoLevelCache, key string, v T, seconds int) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if seconds > 0 {
+		return c.SetExCtx(ctx, key, string(body), seconds)
+	}
+	return c.SetCtx(ctx, key, string(body))
+}
+
+// TakeTyped 是 TakeCtx 的泛型版本，未命中时调用 fetch 回源
+func TakeTyped[T any](ctx context.Context, c TwoLevelCache, key string, fetch func(ctx context.Context) (T, error)) (T, error) {
+	var out T
+	val, err := c.TakeCtx(ctx, key, func(ctx context.Context) (string, error) {
+		v, err := fetch(ctx)
+		if err != nil {
+			return "", err
+		}
+		body, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(body), nil
+	})
+	if err != nil {
+		return out, err
+	}
+	if err := json.Unmarshal([]byte(val), &out); err != nil {
+		return out, err
+	}
+	return out, nil
+}
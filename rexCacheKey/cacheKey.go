@@ -1,41 +1,116 @@
 package rexCacheKey
 
+import "time"
+
+type (
+	// BuiltinKeys 原来以格式字符串声明的公共缓存 key，迁移到 Registry 后的类型化版本；
+	// 迁移后 key 的格式变为 {service}:{name}:v{version}:{args}，旧格式的缓存会自然过期，不会被读取
+	BuiltinKeys struct {
+		Csrf              Key1[string]
+		Online            Key1[string]
+		AccessToken       Key1[string]
+		RefreshToken      Key1[string]
+		EncryptionData    Key1[string]
+		HttpAccessToken   Key1[int64]
+		AccountAuth       Key1[string]
+		EmsAuth           Key1[string]
+		SmsAuth           Key1[string]
+		WechatCron        Key2[int64, string]
+		WechatAccess      Key1[string]
+		WechatUserToken   Key1[string]
+		WechatJsApiTicket Key2[int64, string]
+		WechatTicket      Key1[string]
+		DouyinAccess      Key1[string]
+		DouyinUserToken   Key1[string]
+		// note: 旧格式中抖音和微信的 jsApiTicket 共用同一个格式，会互相覆盖，迁移后分开声明
+		DouyinJsApiTicket Key2[int64, string]
+		DouyinTicket      Key1[string]
+		KeyChange         Key1[string]
+	}
+)
+
+// RegisterBuiltinKeys 在 r 中声明所有公共缓存 key，同一个 Registry 只能调用一次；
+// 微信和抖音的 access_token、ticket 有效期为 7200 秒（抖音用户 access_token 为 15 天），声明的有效期比平台短，留出提前刷新的时间
+func RegisterBuiltinKeys(r *Registry) *BuiltinKeys {
+	return &BuiltinKeys{
+		Csrf:              Define1[string](r, "csrf", WithParams("token"), WithTtl(2*time.Hour)),
+		Online:            Define1[string](r, "online", WithParams("userId"), WithTtl(30*time.Minute)),
+		AccessToken:       Define1[string](r, "accessToken", WithParams("token"), WithTtl(2*time.Hour)),
+		RefreshToken:      Define1[string](r, "refreshToken", WithParams("token"), WithTtl(7*24*time.Hour)),
+		EncryptionData:    Define1[string](r, "encryption", WithParams("id"), WithTtl(10*time.Minute)),
+		HttpAccessToken:   Define1[int64](r, "httpAccessToken", WithParams("id"), WithTtl(2*time.Hour)),
+		AccountAuth:       Define1[string](r, "accountAuth", WithParams("account"), WithTtl(5*time.Minute), WithDescription("登录验证码")),
+		EmsAuth:           Define1[string](r, "emsAuth", WithParams("email"), WithTtl(5*time.Minute), WithDescription("邮件验证码")),
+		SmsAuth:           Define1[string](r, "smsAuth", WithParams("phone"), WithTtl(5*time.Minute), WithDescription("短信验证码")),
+		WechatCron:        Define2[int64, string](r, "wechatCron", WithParams("tenantId", "key"), WithTtl(7000*time.Second)),
+		WechatAccess:      Define1[string](r, "wxAccess", WithParams("appId"), WithTtl(7000*time.Second), WithDescription("服务名+公众号的Appid")),
+		WechatUserToken:   Define1[string](r, "wechatUserToken", WithParams("openId"), WithTtl(7000*time.Second)),
+		WechatJsApiTicket: Define2[int64, string](r, "wxJsApiTicket", WithParams("tenantId", "key"), WithTtl(7000*time.Second)),
+		WechatTicket:      Define1[string](r, "wxTicket", WithParams("appId"), WithTtl(7000*time.Second)),
+		DouyinAccess:      Define1[string](r, "dyAccess", WithParams("appId"), WithTtl(7000*time.Second), WithDescription("服务名+抖音的Appid")),
+		DouyinUserToken:   Define1[string](r, "dyUserToken", WithParams("openId"), WithTtl(14*24*time.Hour)),
+		DouyinJsApiTicket: Define2[int64, string](r, "dyJsApiTicket", WithParams("tenantId", "key"), WithTtl(7000*time.Second)),
+		DouyinTicket:      Define1[string](r, "dyTicket", WithParams("appId"), WithTtl(7000*time.Second)),
+		KeyChange:         Define1[string](r, "keyChange", WithParams("key"), WithTtl(24*time.Hour)),
+	}
+}
+
+// Deprecated: 格式字符串无法校验参数类型和顺序，请使用 RegisterBuiltinKeys 返回的 BuiltinKeys 中对应的 key。
 const (
+	// Deprecated: 使用 BuiltinKeys.Csrf
 	CsrfCacheKey = "%s:csrf-%s"
 
+	// Deprecated: 使用 BuiltinKeys.Online
 	OnlineKey = "%s:online-%s"
 
+	// Deprecated: 使用 BuiltinKeys.AccessToken
 	ACCESS_TOKEN_KEY = "%s:accessToken-%s"
 
+	// Deprecated: 使用 BuiltinKeys.RefreshToken
 	REFRESH_TOKEN_KEY = "%s:refreshToken-%s"
 
+	// Deprecated: 使用 BuiltinKeys.EncryptionData
 	ENCRYPTION_DATA_KEY = "%s:encryption-%s"
 
+	// Deprecated: 使用 BuiltinKeys.HttpAccessToken
 	HTTP_ACCESS_TOKEN_KEY = "%s:httpAccessToken-%d"
 
+	// Deprecated: 使用 BuiltinKeys.AccountAuth
 	ACCOUNT_AUTH_KEY = "%s:accountAuth-%s"
 
+	// Deprecated: 使用 BuiltinKeys.EmsAuth
 	EMS_AUTH_KEY = "%s:emsAuth-%s"
 
+	// Deprecated: 使用 BuiltinKeys.SmsAuth
 	SMS_AUTH_KEY = "%s:smsAuth-%s"
 
+	// Deprecated: 使用 BuiltinKeys.WechatCron
 	WECHAT_KEY_CRON = "%s:wechatCron-TID%d-key-%s"
 
 	// note: 服务名+公众号的Appid
+	// Deprecated: 使用 BuiltinKeys.WechatAccess
 	WECHAT_APPID_CRON = "%s:wx-access-%s"
 
+	// Deprecated: 使用 BuiltinKeys.WechatUserToken
 	WECHAT_USER_TOKEN = "%s:wechat-usertokenn-%s"
 
+	// Deprecated: 使用 BuiltinKeys.WechatJsApiTicket
 	WECHAT_JSAPI_TICKET_KEY_CRON = "%s:jsApiTicket-TID%d-key-%s"
-	WECHAT_TICKET_APPID_CRON     = "%s:wx-ticket-%s"
+	// Deprecated: 使用 BuiltinKeys.WechatTicket
+	WECHAT_TICKET_APPID_CRON = "%s:wx-ticket-%s"
 
 	// note: 服务名+抖音的Appid
+	// Deprecated: 使用 BuiltinKeys.DouyinAccess
 	DOUYIN_APPID_CRON = "%s:dy-access-%s"
 
+	// Deprecated: 使用 BuiltinKeys.DouyinUserToken
 	DOUYIN_USER_TOKEN = "%s:dy-usertokenn-%s"
 
+	// Deprecated: 使用 BuiltinKeys.DouyinJsApiTicket
 	DOUYIN_JSAPI_TICKET_KEY_CRON = "%s:jsApiTicket-TID%d-key-%s"
-	DOUYIN_TICKET_APPID_CRON     = "%s:dy-ticket-%s"
+	// Deprecated: 使用 BuiltinKeys.DouyinTicket
+	DOUYIN_TICKET_APPID_CRON = "%s:dy-ticket-%s"
 
+	// Deprecated: 使用 BuiltinKeys.KeyChange
 	KeyChangeCacheKey = "%s-cache-key-%s"
 )
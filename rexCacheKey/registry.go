@@ -0,0 +1,319 @@
+package rexCacheKey
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	keySeparator   = ":"
+	defaultVersion = 1
+	// globChars redis glob 模式中的特殊字符，出现在服务名或 key 名称中时无法安全地生成匹配模式
+	globChars = "*?[]\\"
+	// scanCount 失效时每次 SCAN 的建议数量
+	scanCount = 500
+	// deleteBatch 失效时每次 DEL 的最大 key 数量
+	deleteBatch = 500
+)
+
+// argEscaper 转义参数中的分隔符和 glob 特殊字符，保证参数不会跨越段边界，也不会被 Pattern 误匹配
+var argEscaper = strings.NewReplacer(
+	"%", "%25",
+	":", "%3A",
+	"*", "%2A",
+	"?", "%3F",
+	"[", "%5B",
+	"]", "%5D",
+	"\\", "%5C",
+)
+
+type (
+	// KeyDef 描述一个缓存 key，格式为 {service}:{name}:v{version}:{arg1}:{arg2}...
+	KeyDef struct {
+		Service     string        `json:"service"`
+		Name        string        `json:"name"`
+		Version     int           `json:"version"`
+		Ttl         time.Duration `json:"ttl"`
+		Params      []string      `json:"params"`
+		Description string        `json:"description"`
+	}
+	KeyOption func(def *KeyDef)
+
+	// Registry 保存一个服务用到的所有缓存 key，每个 key 只能声明一次
+	Registry struct {
+		lock    sync.RWMutex
+		service string
+		defs    map[string]*KeyDef
+	}
+
+	// KeyStore 是失效时需要的最小 redis 能力，rexDao.RedisDao 已经实现
+	KeyStore interface {
+		ScanCtx(ctx context.Context, match string, count int64) ([]string, error)
+		DelCtx(ctx context.Context, keys ...string) (int, error)
+	}
+
+	Key0 struct {
+		def *KeyDef
+	}
+	Key1[A any] struct {
+		def *KeyDef
+	}
+	Key2[A, B any] struct {
+		def *KeyDef
+	}
+	Key3[A, B, C any] struct {
+		def *KeyDef
+	}
+)
+
+func WithTtl(ttl time.Duration) KeyOption {
+	return func(def *KeyDef) {
+		def.Ttl = ttl
+	}
+}
+
+// WithVersion 当缓存内容的结构发生变化时递增版本，旧版本的 key 自然失效
+func WithVersion(version int) KeyOption {
+	return func(def *KeyDef) {
+		def.Version = version
+	}
+}
+
+func WithParams(params ...string) KeyOption {
+	return func(def *KeyDef) {
+		def.Params = params
+	}
+}
+
+func WithDescription(description string) KeyOption {
+	return func(def *KeyDef) {
+		def.Description = description
+	}
+}
+
+// NewRegistry service 不能包含分隔符和 glob 特殊字符，否则直接 panic
+func NewRegistry(service string) *Registry {
+	if service == "" || strings.ContainsAny(service, keySeparator+globChars) {
+		panic(fmt.Sprintf("rexCacheKey: invalid service %q", service))
+	}
+	return &Registry{
+		service: service,
+		defs:    make(map[string]*KeyDef),
+	}
+}
+
+func (r *Registry) Service() string {
+	return r.service
+}
+
+// register 在声明阶段调用，重复或非法的声明直接 panic，尽早暴露问题
+func (r *Registry) register(name string, arity int, opts ...KeyOption) *KeyDef {
+	if name == "" || strings.ContainsAny(name, keySeparator+globChars) {
+		panic(fmt.Sprintf("rexCacheKey: invalid key name %q", name))
+	}
+	def := &KeyDef{
+		Service: r.service,
+		Name:    name,
+		Version: defaultVersion,
+	}
+	for _, opt := range opts {
+		opt(def)
+	}
+	if def.Version <= 0 {
+		panic(fmt.Sprintf("rexCacheKey: key %q version must be positive", name))
+	}
+	if len(def.Params) == 0 {
+		for i := 0; i < arity; i++ {
+			def.Params = append(def.Params, fmt.Sprintf("arg%d", i+1))
+		}
+	}
+	if len(def.Params) != arity {
+		panic(fmt.Sprintf("rexCacheKey: key %q declares %d params but takes %d args", name, len(def.Params), arity))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.defs[name]; ok {
+		panic(fmt.Sprintf("rexCacheKey: key %q already registered in service %q", name, r.service))
+	}
+	r.defs[name] = def
+	return def
+}
+
+// Get 按名称查询 key 的声明
+func (r *Registry) Get(name string) (KeyDef, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	def, ok := r.defs[name]
+	if !ok {
+		return KeyDef{}, false
+	}
+	return *def, true
+}
+
+// List 返回所有声明，按名称排序
+func (r *Registry) List() []KeyDef {
+	return r.ListWithPrefix("")
+}
+
+// ListWithPrefix 返回完整前缀（{service}:{name}:v{version}）按段匹配 prefix 的所有声明，
+// 比如 svc:user 匹配 svc:user:v1，但不匹配 svc:userProfile:v1
+func (r *Registry) ListWithPrefix(prefix string) []KeyDef {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	prefix = strings.TrimSuffix(prefix, keySeparator)
+	result := make([]KeyDef, 0, len(r.defs))
+	for _, def := range r.defs {
+		full := def.Prefix()
+		if prefix == "" || full == prefix || strings.HasPrefix(full, prefix+keySeparator) {
+			result = append(result, *def)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Patterns 返回 prefix 下所有 key 的 glob 匹配模式，可以直接交给 redis KEYS/SCAN
+func (r *Registry) Patterns(prefix string) []string {
+	defs := r.ListWithPrefix(prefix)
+	result := make([]string, 0, len(defs))
+	for _, def := range defs {
+		result = append(result, def.Pattern())
+	}
+	return result
+}
+
+// Invalidate 删除 prefix 下所有声明的 key 的全部实例，返回删除数量；使用 SCAN 遍历，不会阻塞 redis
+func (r *Registry) Invalidate(ctx context.Context, store KeyStore, prefix string) (int, error) {
+	total := 0
+	for _, pattern := range r.Patterns(prefix) {
+		keys, err := store.ScanCtx(ctx, pattern, scanCount)
+		if err != nil {
+			return total, err
+		}
+		for start := 0; start < len(keys); start += deleteBatch {
+			end := start + deleteBatch
+			if end > len(keys) {
+				end = len(keys)
+			}
+			n, err := store.DelCtx(ctx, keys[start:end]...)
+			if err != nil {
+				return total, err
+			}
+			total += n
+		}
+	}
+	return total, nil
+}
+
+// Markdown 生成该服务所有缓存 key 的文档
+func (r *Registry) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Cache keys of %s\n\n", r.service)
+	b.WriteString("| Name | Key | TTL | Version | Description |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, def := range r.List() {
+		ttl := "-"
+		if def.Ttl > 0 {
+			ttl = def.Ttl.String()
+		}
+		fmt.Fprintf(&b, "| %s | `%s` | %s | %d | %s |\n", def.Name, def.Template(), ttl, def.Version, def.Description)
+	}
+	return b.String()
+}
+
+// Prefix 返回 {service}:{name}:v{version}
+func (d KeyDef) Prefix() string {
+	return fmt.Sprintf("%s%s%s%sv%d", d.Service, keySeparator, d.Name, keySeparator, d.Version)
+}
+
+// Pattern 返回匹配该 key 所有实例的 glob 模式
+func (d KeyDef) Pattern() string {
+	if len(d.Params) == 0 {
+		return d.Prefix()
+	}
+	return d.Prefix() + keySeparator + "*"
+}
+
+// Template 返回带参数名的 key 模板，用于文档
+func (d KeyDef) Template() string {
+	segments := []string{d.Prefix()}
+	for _, p := range d.Params {
+		segments = append(segments, "{"+p+"}")
+	}
+	return strings.Join(segments, keySeparator)
+}
+
+// TtlSeconds 返回秒级有效期，方便直接传给 RedisDao.SetEx
+func (d KeyDef) TtlSeconds() int {
+	return int(d.Ttl / time.Second)
+}
+
+// build 参数中的分隔符和 glob 特殊字符会被转义，见 EscapeArg
+func (d KeyDef) build(args ...any) string {
+	segments := make([]string, 0, len(args)+1)
+	segments = append(segments, d.Prefix())
+	for _, arg := range args {
+		segments = append(segments, EscapeArg(fmt.Sprint(arg)))
+	}
+	return strings.Join(segments, keySeparator)
+}
+
+// EscapeArg 按百分号编码转义参数中的 %、:、*、?、[、]、\，不含这些字符的参数保持不变
+func EscapeArg(arg string) string {
+	return argEscaper.Replace(arg)
+}
+
+func Define0(r *Registry, name string, opts ...KeyOption) Key0 {
+	return Key0{def: r.register(name, 0, opts...)}
+}
+
+func Define1[A any](r *Registry, name string, opts ...KeyOption) Key1[A] {
+	return Key1[A]{def: r.register(name, 1, opts...)}
+}
+
+func Define2[A, B any](r *Registry, name string, opts ...KeyOption) Key2[A, B] {
+	return Key2[A, B]{def: r.register(name, 2, opts...)}
+}
+
+func Define3[A, B, C any](r *Registry, name string, opts ...KeyOption) Key3[A, B, C] {
+	return Key3[A, B, C]{def: r.register(name, 3, opts...)}
+}
+
+func (k Key0) Build() string {
+	return k.def.build()
+}
+
+func (k Key0) Def() KeyDef {
+	return *k.def
+}
+
+func (k Key1[A]) Build(a A) string {
+	return k.def.build(a)
+}
+
+func (k Key1[A]) Def() KeyDef {
+	return *k.def
+}
+
+func (k Key2[A, B]) Build(a A, b B) string {
+	return k.def.build(a, b)
+}
+
+func (k Key2[A, B]) Def() KeyDef {
+	return *k.def
+}
+
+func (k Key3[A, B, C]) Build(a A, b B, c C) string {
+	return k.def.build(a, b, c)
+}
+
+func (k Key3[A, B, C]) Def() KeyDef {
+	return *k.def
+}
@@ -0,0 +1,110 @@
+package rexCacheKey
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexDao"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("svc")
+	accessToken := Define1[string](r, "accessToken", WithTtl(2*time.Hour), WithParams("userId"))
+	wechatCron := Define2[int, string](r, "wechatCron", WithVersion(3), WithParams("tenantId", "key"))
+	online := Define0(r, "online")
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "key1", got: accessToken.Build("u1"), want: "svc:accessToken:v1:u1"},
+		{name: "key2", got: wechatCron.Build(7, "k"), want: "svc:wechatCron:v3:7:k"},
+		{name: "key0", got: online.Build(), want: "svc:online:v1"},
+		{name: "template", got: wechatCron.Def().Template(), want: "svc:wechatCron:v3:{tenantId}:{key}"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	if got := accessToken.Def().TtlSeconds(); got != 7200 {
+		t.Errorf("TtlSeconds() = %v, want 7200", got)
+	}
+	if got := r.Patterns("svc:wechatCron"); !reflect.DeepEqual(got, []string{"svc:wechatCron:v3:*"}) {
+		t.Errorf("Patterns() = %v", got)
+	}
+	// note: 前缀按段匹配，不会匹配到名称相同开头的其他 key
+	if got := r.Patterns("svc:wechat"); len(got) != 0 {
+		t.Errorf("Patterns(svc:wechat) = %v, want none", got)
+	}
+	if got := accessToken.Build("a:b*"); got != "svc:accessToken:v1:a%3Ab%2A" {
+		t.Errorf("Build() with special chars = %v", got)
+	}
+	if doc := r.Markdown(); !strings.Contains(doc, "`svc:accessToken:v1:{userId}` | 2h0m0s") {
+		t.Errorf("Markdown() = %v", doc)
+	}
+}
+
+func TestRegistryDuplicate(t *testing.T) {
+	r := NewRegistry("svc")
+	Define0(r, "dup")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Define0() should panic on duplicate name")
+		}
+	}()
+	Define1[string](r, "dup")
+}
+
+func TestRegistryInvalidate(t *testing.T) {
+	r := NewRegistry("svc")
+	accessToken := Define1[string](r, "accessToken")
+	refreshToken := Define1[string](r, "refreshToken")
+
+	store := rexDao.NewMemoryRedisDao()
+	_ = store.Set(accessToken.Build("u1"), "a")
+	_ = store.Set(accessToken.Build("u2"), "a")
+	_ = store.Set(refreshToken.Build("u1"), "r")
+	// note: 参数中的 glob 字符被转义后不会影响其他 key
+	_ = store.Set(refreshToken.Build("*"), "r")
+
+	n, err := r.Invalidate(context.Background(), store, "svc:accessToken")
+	if err != nil {
+		t.Fatalf("Invalidate() error = %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Invalidate() = %v, want 2", n)
+	}
+	if keys, _ := store.Keys("*"); !reflect.DeepEqual(keys, []string{refreshToken.Build("*"), refreshToken.Build("u1")}) {
+		t.Errorf("remaining keys = %v", keys)
+	}
+}
+
+func TestRegisterBuiltinKeys(t *testing.T) {
+	r := NewRegistry("svc")
+	keys := RegisterBuiltinKeys(r)
+	if got := keys.WechatCron.Build(7, "k"); got != "svc:wechatCron:v1:7:k" {
+		t.Errorf("WechatCron.Build() = %v", got)
+	}
+	if keys.WechatJsApiTicket.Build(1, "k") == keys.DouyinJsApiTicket.Build(1, "k") {
+		t.Error("wechat and douyin jsApiTicket keys collide")
+	}
+	if n := len(r.List()); n != 19 {
+		t.Errorf("List() = %d defs, want 19", n)
+	}
+	for _, def := range r.List() {
+		if def.Ttl <= 0 {
+			t.Errorf("%s declares no ttl", def.Name)
+		}
+	}
+	if got := keys.SmsAuth.Def().TtlSeconds(); got != 300 {
+		t.Errorf("SmsAuth TtlSeconds() = %d, want 300", got)
+	}
+}
@@ -160,6 +160,11 @@ const (
 	EngineStatusCodeUsed                      int32 = 4059  // 语义: 验证码已被使用
 	EngineStatusCodeRevoked                   int32 = 4060  // 语义: 验证码已被失效
 	EngineStatusAccountRiskLocked             int32 = 4061  // 语义: 账号存在异常行为，已被风险系统锁定
+	EngineStatusTaskSignatureMissing          int32 = 4062  // 语义: 缺少任务回调签名头
+	EngineStatusTaskSignatureInvalid          int32 = 4063  // 语义: 任务回调签名错误
+	EngineStatusTaskTimestampSkewed           int32 = 4064  // 语义: 任务回调时间戳超出允许的时钟偏差
+	EngineStatusTaskNonceReplayed             int32 = 4065  // 语义: 任务回调nonce已被使用
+	EngineStatusTaskSourceUnknown             int32 = 4066  // 语义: 未知的任务回调来源
 	EngineStatusInternalServerError           int32 = 5000  // 语义: 服务器内部错误，无法完成请求
 	EngineStatusNotImplemented                int32 = 5001  // 语义: 服务器不支持请求的功能，无法完成请求
 	EngineStatusBadGateway                    int32 = 5002  // 语义: 作为网关或者代理工作的服务器尝试执行请求时，从远程服务器接收到了一个无效的响应
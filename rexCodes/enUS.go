@@ -151,6 +151,11 @@ var WrongMessageEnUS = map[int32]string{
 	EngineStatusCodeUsed:                      "Code Used",
 	EngineStatusCodeRevoked:                   "Code Revoked",
 	EngineStatusAccountRiskLocked:             "Account risk locked",
+	EngineStatusTaskSignatureMissing:          "Task signature headers missing",
+	EngineStatusTaskSignatureInvalid:          "Task signature invalid",
+	EngineStatusTaskTimestampSkewed:           "Task timestamp out of allowed skew",
+	EngineStatusTaskNonceReplayed:             "Task nonce replayed",
+	EngineStatusTaskSourceUnknown:             "Task source unknown",
 	EngineStatusInternalServerError:           "Internal Server Error",
 	EngineStatusNotImplemented:                "Not Implemented",
 	EngineStatusBadGateway:                    "Bad Gateway",
@@ -0,0 +1,350 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexErrors"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexRes"
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/rest"
+	"github.com/zeromicro/go-zero/rest/pathvar"
+)
+
+type AdminAction string
+
+const (
+	AdminActionList    AdminAction = "list"
+	AdminActionGet     AdminAction = "get"
+	AdminActionCreate  AdminAction = "create"
+	AdminActionUpdate  AdminAction = "update"
+	AdminActionDelete  AdminAction = "delete"
+	AdminActionPause   AdminAction = "pause"
+	AdminActionResume  AdminAction = "resume"
+	AdminActionTrigger AdminAction = "trigger"
+	// AdminActionPayload 读取任务的原始 Payload，其中可能包含 webhook 的鉴权 header 等敏感信息
+	AdminActionPayload AdminAction = "payload"
+
+	DefaultAdminPrefix = "/admin/crontab"
+	adminMaxBodyBytes  = 1 << 20
+	adminUuidPathVar   = "uuid"
+	adminLastRunQuery  = "last_run"
+)
+
+var (
+	ErrAdminForbidden = errors.New("没有权限操作任务池")
+)
+
+type (
+	// AdminAuthorizer 每个管理请求执行前调用，返回错误时拒绝请求；返回 *rexErrors.CodeMsg 时直接使用其中的错误码，
+	// 其他错误按 EngineStatusForbidden 返回；taskUuid 在 list 和 create 时为空
+	AdminAuthorizer interface {
+		Authorize(r *http.Request, action AdminAction, taskUuid string) error
+	}
+
+	AdminAuthorizerFunc func(r *http.Request, action AdminAction, taskUuid string) error
+
+	AdminConf struct {
+		// Prefix 路由前缀，默认 /admin/crontab
+		Prefix string
+		// Authorizer 为空时拒绝所有请求
+		Authorizer AdminAuthorizer
+		// ReadOnly 为 true 时只注册 list、get 和 payload
+		ReadOnly bool
+	}
+
+	// AdminTaskReq 创建和修改任务的请求，只能创建通过 RegisterJobFactory 注册过的 JobType
+	AdminTaskReq struct {
+		TaskUuid string      `json:"task_uuid"`
+		Name     string      `json:"name"`
+		Spec     string      `json:"spec"`
+		JobType  string      `json:"job_type"`
+		Payload  string      `json:"payload"`
+		Options  TaskOptions `json:"options"`
+	}
+
+	// AdminTask 不返回 Payload，需要时通过 AdminActionPayload 单独读取
+	AdminTask struct {
+		*TaskInfo
+		Entry   AdminEntry `json:"entry"`
+		LastRun *JobRun    `json:"last_run,omitempty"`
+	}
+
+	// AdminEntry 调度器中的 cron.Entry，暂停的任务没有 Entry
+	AdminEntry struct {
+		Id   int       `json:"id"`
+		Next time.Time `json:"next"`
+		Prev time.Time `json:"prev"`
+	}
+
+	AdminTaskPayload struct {
+		TaskUuid string `json:"task_uuid"`
+		JobType  string `json:"job_type"`
+		Payload  string `json:"payload"`
+	}
+
+	AdminTaskList struct {
+		List      []*AdminTask `json:"list"`
+		TaskCount int          `json:"task_count"`
+	}
+
+	adminHandler struct {
+		pool *CrontabPool
+		conf AdminConf
+	}
+)
+
+func (f AdminAuthorizerFunc) Authorize(r *http.Request, action AdminAction, taskUuid string) error {
+	return f(r, action, taskUuid)
+}
+
+// NewAdminTokenAuthorizer 校验 Authorization: Bearer {token}，适合内网运维使用
+func NewAdminTokenAuthorizer(token string) AdminAuthorizer {
+	return AdminAuthorizerFunc(func(r *http.Request, action AdminAction, taskUuid string) error {
+		got, ok := strings.CutPrefix(r.Header.Get(rexHeaders.HeaderAuthorization), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			return rexErrors.New(rexCodes.EngineStatusUnauthorized, rexCodes.StatusText(rexCodes.EngineStatusUnauthorized, rexCodes.LangEnUS))
+		}
+		return nil
+	})
+}
+
+// RegisterAdminRoutes 把任务池管理接口注册到 go-zero server
+func RegisterAdminRoutes(server *rest.Server, c *CrontabPool, conf AdminConf) {
+	if conf.Prefix == "" {
+		conf.Prefix = DefaultAdminPrefix
+	}
+	server.AddRoutes(AdminRoutes(c, conf), rest.WithPrefix(conf.Prefix))
+}
+
+// AdminRoutes 返回不带前缀的管理路由，可以和其他路由一起加上中间件后注册
+func AdminRoutes(c *CrontabPool, conf AdminConf) []rest.Route {
+	h := &adminHandler{pool: c, conf: conf}
+	routes := []rest.Route{
+		{Method: http.MethodGet, Path: "/tasks", Handler: h.list},
+		{Method: http.MethodGet, Path: "/tasks/:uuid", Handler: h.get},
+		{Method: http.MethodGet, Path: "/tasks/:uuid/payload", Handler: h.payload},
+	}
+	if conf.ReadOnly {
+		return routes
+	}
+	return append(routes,
+		rest.Route{Method: http.MethodPost, Path: "/tasks", Handler: h.create},
+		rest.Route{Method: http.MethodPut, Path: "/tasks/:uuid", Handler: h.update},
+		rest.Route{Method: http.MethodDelete, Path: "/tasks/:uuid", Handler: h.delete},
+		rest.Route{Method: http.MethodPost, Path: "/tasks/:uuid/pause", Handler: h.pause},
+		rest.Route{Method: http.MethodPost, Path: "/tasks/:uuid/resume", Handler: h.resume},
+		rest.Route{Method: http.MethodPost, Path: "/tasks/:uuid/trigger", Handler: h.trigger},
+	)
+}
+
+// list 默认不返回 last_run，带上 ?last_run=true 时逐个查询 HistoryStore
+func (h *adminHandler) list(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, AdminActionList, "") {
+		return
+	}
+	withLastRun, _ := strconv.ParseBool(r.URL.Query().Get(adminLastRunQuery))
+	infos := h.pool.List()
+	resp := &AdminTaskList{
+		List:      make([]*AdminTask, 0, len(infos)),
+		TaskCount: h.pool.TaskCount(),
+	}
+	for _, info := range infos {
+		resp.List = append(resp.List, h.task(r.Context(), info, withLastRun))
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, resp, nil)
+}
+
+func (h *adminHandler) get(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionGet, taskUuid) {
+		return
+	}
+	h.respondTask(w, r, taskUuid, nil)
+}
+
+func (h *adminHandler) payload(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionPayload, taskUuid) {
+		return
+	}
+	info, err := h.pool.Get(taskUuid)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, &AdminTaskPayload{TaskUuid: info.TaskUuid, JobType: info.JobType, Payload: info.Payload}, nil)
+}
+
+func (h *adminHandler) create(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(w, r, AdminActionCreate, "") {
+		return
+	}
+	task, err := h.parseTask(w, r)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	if task.TaskUuid == "" {
+		task.TaskUuid = uuid.NewString()
+	}
+	h.respondTask(w, r, task.TaskUuid, h.pool.Add(r.Context(), task))
+}
+
+func (h *adminHandler) update(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionUpdate, taskUuid) {
+		return
+	}
+	task, err := h.parseTask(w, r)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	task.TaskUuid = taskUuid
+	h.respondTask(w, r, taskUuid, h.pool.Update(r.Context(), task))
+}
+
+func (h *adminHandler) delete(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionDelete, taskUuid) {
+		return
+	}
+	if err := h.pool.Remove(r.Context(), taskUuid); err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, nil, nil)
+}
+
+func (h *adminHandler) pause(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionPause, taskUuid) {
+		return
+	}
+	h.respondTask(w, r, taskUuid, h.pool.Pause(r.Context(), taskUuid))
+}
+
+func (h *adminHandler) resume(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionResume, taskUuid) {
+		return
+	}
+	h.respondTask(w, r, taskUuid, h.pool.Resume(r.Context(), taskUuid))
+}
+
+func (h *adminHandler) trigger(w http.ResponseWriter, r *http.Request) {
+	taskUuid := pathvar.Vars(r)[adminUuidPathVar]
+	if !h.authorize(w, r, AdminActionTrigger, taskUuid) {
+		return
+	}
+	// note: 执行是异步的，结果通过 last_run 查看
+	h.respondTask(w, r, taskUuid, h.pool.TriggerNow(context.WithoutCancel(r.Context()), taskUuid))
+}
+
+func (h *adminHandler) authorize(w http.ResponseWriter, r *http.Request, action AdminAction, taskUuid string) bool {
+	var err error
+	if h.conf.Authorizer == nil {
+		err = ErrAdminForbidden
+	} else {
+		err = h.conf.Authorizer.Authorize(r, action, taskUuid)
+	}
+	if err == nil {
+		return true
+	}
+	logx.WithContext(r.Context()).Infof("crontab admin denied, and action = %s, task uuid = %s, err = %v", action, taskUuid, err)
+	var codeMsg *rexErrors.CodeMsg
+	if !errors.As(err, &codeMsg) {
+		err = rexErrors.New(rexCodes.EngineStatusForbidden, err.Error())
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, nil, err)
+	return false
+}
+
+func (h *adminHandler) parseTask(w http.ResponseWriter, r *http.Request) (*Task, error) {
+	var req AdminTaskReq
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, adminMaxBodyBytes)).Decode(&req); err != nil {
+		return nil, rexErrors.New(rexCodes.EngineStatusBadRequest, err.Error())
+	}
+	if req.JobType == "" {
+		return nil, rexErrors.New(rexCodes.EngineStatusBadRequest, "job_type is required")
+	}
+	return &Task{
+		TaskUuid: req.TaskUuid,
+		Name:     req.Name,
+		Spec:     req.Spec,
+		JobType:  req.JobType,
+		Payload:  req.Payload,
+		Options:  req.Options,
+	}, nil
+}
+
+// respondTask err 不为空时返回错误，否则返回任务的最新状态
+func (h *adminHandler) respondTask(w http.ResponseWriter, r *http.Request, taskUuid string, err error) {
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	info, err := h.pool.Get(taskUuid)
+	if err != nil {
+		h.fail(w, r, err)
+		return
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, h.task(r.Context(), info, true), nil)
+}
+
+func (h *adminHandler) task(ctx context.Context, info *TaskInfo, withLastRun bool) *AdminTask {
+	// note: Payload 可能包含密钥，不随任务信息返回
+	redacted := *info
+	redacted.Payload = ""
+	task := &AdminTask{TaskInfo: &redacted}
+	if info.JobId != 0 {
+		entry := h.pool.cron.Entry(info.JobId)
+		task.Entry = AdminEntry{Id: int(entry.ID), Next: entry.Next, Prev: entry.Prev}
+	}
+	if !withLastRun {
+		return task
+	}
+	runs, err := h.pool.History(ctx, info.TaskUuid, 1)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("crontab admin load history failed, and task uuid = %s, err = %v", info.TaskUuid, err)
+	} else if len(runs) > 0 {
+		task.LastRun = runs[0]
+	}
+	return task
+}
+
+// fail 把任务池的错误转换为 rexCodes 错误码
+func (h *adminHandler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	var codeMsg *rexErrors.CodeMsg
+	if !errors.As(err, &codeMsg) {
+		code, msg := rexCodes.EngineStatusInternalServerError, err.Error()
+		switch {
+		case errors.Is(err, ErrTaskNotFound):
+			code = rexCodes.EngineStatusNotFound
+		case errors.Is(err, ErrTaskExists), errors.Is(err, ErrTaskPaused), errors.Is(err, ErrTaskNotPaused),
+			errors.Is(err, ErrVersionConflict):
+			code = rexCodes.EngineStatusConflict
+		case errors.Is(err, ErrInvalidSpec), errors.Is(err, ErrInvalidTask), errors.Is(err, ErrInvalidTimezone),
+			errors.Is(err, ErrInvalidPolicy), errors.Is(err, ErrJobTypeNotRegistered), errors.Is(err, ErrWebhookPayload):
+			code = rexCodes.EngineStatusBadRequest
+		case errors.Is(err, ErrPoolClosed):
+			code = rexCodes.EngineStatusServiceUnavailable
+		default:
+			// note: 未知错误可能包含数据库、redis 的连接信息，只记录日志，不返回给调用方
+			logx.WithContext(r.Context()).Errorf("crontab admin failed, err = %v", err)
+			msg = rexCodes.StatusText(code, rexCodes.LangEnUS)
+		}
+		err = rexErrors.New(code, msg)
+	}
+	rexRes.JsonBaseResponseCtx(r.Context(), w, r, nil, err)
+}
@@ -0,0 +1,161 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexRes"
+	"github.com/zeromicro/go-zero/rest/router"
+)
+
+func TestAdminRoutes(t *testing.T) {
+	RegisterJobFactory("admin-noop", func(record *JobRecord) (cron.Job, error) {
+		return JobFunc(func(ctx context.Context) error { return nil }), nil
+	})
+	ctx := context.Background()
+	c := NewCrontabPool(WithHistory(&memoryHistoryStore{runs: map[string]JobRun{}}))
+	c.Start()
+	defer c.Shutdown(ctx)
+
+	rt := router.NewRouter()
+	token := NewAdminTokenAuthorizer("ops")
+	authorizer := AdminAuthorizerFunc(func(r *http.Request, action AdminAction, taskUuid string) error {
+		if action == AdminActionPayload && r.Header.Get("X-Admin-Role") != "owner" {
+			return ErrAdminForbidden
+		}
+		return token.Authorize(r, action, taskUuid)
+	})
+	for _, route := range AdminRoutes(c, AdminConf{Authorizer: authorizer}) {
+		if err := rt.Handle(route.Method, route.Path, route.Handler); err != nil {
+			t.Fatalf("Handle() error = %v", err)
+		}
+	}
+	role := ""
+	call := func(method, path, token, body string) rexRes.BaseResponse[json.RawMessage] {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set(rexHeaders.HeaderXRequestIdFor, "req-1")
+		if role != "" {
+			r.Header.Set("X-Admin-Role", role)
+		}
+		if token != "" {
+			r.Header.Set(rexHeaders.HeaderAuthorization, "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		var resp rexRes.BaseResponse[json.RawMessage]
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s %s response = %s", method, path, w.Body.String())
+		}
+		return resp
+	}
+
+	if resp := call(http.MethodGet, "/tasks", "guess", ""); resp.Code != rexCodes.EngineStatusUnauthorized {
+		t.Errorf("list with wrong token code = %d, want %d", resp.Code, rexCodes.EngineStatusUnauthorized)
+	}
+	resp := call(http.MethodPost, "/tasks", "ops", `{"task_uuid":"report","name":"report","spec":"0 0 9 * * *","job_type":"admin-noop","payload":"{\"token\":\"secret\"}"}`)
+	var task AdminTask
+	if err := json.Unmarshal(resp.Data, &task); resp.Code != rexCodes.OK || err != nil || task.Entry.Next.IsZero() {
+		t.Fatalf("create response = %+v, task = %+v", resp, task)
+	}
+	if strings.Contains(string(resp.Data), "secret") {
+		t.Errorf("create response leaks payload: %s", resp.Data)
+	}
+	if resp := call(http.MethodGet, "/tasks/report/payload", "ops", ""); resp.Code != rexCodes.EngineStatusForbidden {
+		t.Errorf("payload without role code = %d, want %d", resp.Code, rexCodes.EngineStatusForbidden)
+	}
+	role = "owner"
+	var payload AdminTaskPayload
+	resp = call(http.MethodGet, "/tasks/report/payload", "ops", "")
+	if err := json.Unmarshal(resp.Data, &payload); err != nil || payload.Payload != `{"token":"secret"}` {
+		t.Errorf("payload response = %+v", resp)
+	}
+	role = ""
+	if resp := call(http.MethodPost, "/tasks", "ops", `{"task_uuid":"report","spec":"0 0 9 * * *","job_type":"admin-noop"}`); resp.Code != rexCodes.EngineStatusConflict {
+		t.Errorf("create duplicate code = %d, want %d", resp.Code, rexCodes.EngineStatusConflict)
+	}
+	if resp := call(http.MethodPost, "/tasks", "ops", `{"spec":"bad","job_type":"admin-noop"}`); resp.Code != rexCodes.EngineStatusBadRequest {
+		t.Errorf("create invalid spec code = %d, want %d", resp.Code, rexCodes.EngineStatusBadRequest)
+	}
+
+	resp = call(http.MethodPost, "/tasks/report/pause", "ops", "")
+	if err := json.Unmarshal(resp.Data, &task); err != nil || !task.Paused || task.Entry.Id != 0 {
+		t.Errorf("pause response = %+v", resp)
+	}
+	if resp := call(http.MethodPost, "/tasks/report/resume", "ops", ""); resp.Code != rexCodes.OK {
+		t.Errorf("resume code = %d", resp.Code)
+	}
+	if resp := call(http.MethodPost, "/tasks/report/trigger", "ops", ""); resp.Code != rexCodes.OK {
+		t.Errorf("trigger code = %d", resp.Code)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for runs, _ := c.History(ctx, "report", 1); len(runs) == 0; runs, _ = c.History(ctx, "report", 1) {
+		if time.Now().After(deadline) {
+			t.Fatal("triggered run not recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	var list AdminTaskList
+	resp = call(http.MethodGet, "/tasks", "ops", "")
+	if err := json.Unmarshal(resp.Data, &list); err != nil || list.TaskCount != 1 || list.List[0].TaskUuid != "report" || list.List[0].LastRun != nil {
+		t.Errorf("list response = %s", resp.Data)
+	}
+	if strings.Contains(string(resp.Data), "secret") {
+		t.Errorf("list response leaks payload: %s", resp.Data)
+	}
+	resp = call(http.MethodGet, "/tasks?last_run=true", "ops", "")
+	if err := json.Unmarshal(resp.Data, &list); err != nil || len(list.List) != 1 || list.List[0].LastRun == nil {
+		t.Errorf("list with last_run response = %s", resp.Data)
+	}
+	if resp := call(http.MethodDelete, "/tasks/report", "ops", ""); resp.Code != rexCodes.OK {
+		t.Errorf("delete code = %d", resp.Code)
+	}
+	if resp := call(http.MethodGet, "/tasks/report", "ops", ""); resp.Code != rexCodes.EngineStatusNotFound {
+		t.Errorf("get deleted code = %d, want %d", resp.Code, rexCodes.EngineStatusNotFound)
+	}
+}
+
+func TestAdminFail(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		wantCode int32
+		wantMsg  string
+	}{
+		{name: "version conflict", err: fmt.Errorf("%w: report, version 3", ErrVersionConflict),
+			wantCode: rexCodes.EngineStatusConflict, wantMsg: "report, version 3"},
+		{name: "not found", err: ErrTaskNotFound, wantCode: rexCodes.EngineStatusNotFound, wantMsg: ErrTaskNotFound.Error()},
+		// note: 未知错误只返回通用信息，不泄漏连接地址等内部细节
+		{name: "internal", err: errors.New("dial tcp 10.0.0.8:3306: connection refused"),
+			wantCode: rexCodes.EngineStatusInternalServerError,
+			wantMsg:  rexCodes.StatusText(rexCodes.EngineStatusInternalServerError, rexCodes.LangEnUS)},
+	}
+	h := &adminHandler{}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/tasks", nil)
+			w := httptest.NewRecorder()
+			h.fail(w, r, c.err)
+			var resp rexRes.BaseResponse[json.RawMessage]
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("response = %s", w.Body.String())
+			}
+			if resp.Code != c.wantCode || !strings.Contains(resp.Msg, c.wantMsg) {
+				t.Errorf("fail() = %d %q, want %d %q", resp.Code, resp.Msg, c.wantCode, c.wantMsg)
+			}
+			if c.wantCode == rexCodes.EngineStatusInternalServerError && strings.Contains(resp.Msg, "10.0.0.8") {
+				t.Errorf("fail() leaks the internal error: %q", resp.Msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type ClusterMode string
+
+const (
+	// ClusterModeLeader 选出一个 leader 执行所有任务，leader 失去租约后由其他节点接替
+	ClusterModeLeader ClusterMode = "leader"
+	// ClusterModePerFire 每次触发按任务和计划触发时间加锁，同一次触发只会有一个节点执行；
+	// @every 的触发时间取决于各节点的启动时间，不能在这个模式下使用
+	ClusterModePerFire ClusterMode = "per-fire"
+
+	DefaultClusterPrefix   = "crontab"
+	DefaultLeaseTtl        = 15 * time.Second
+	DefaultFireLockTtl     = 10 * time.Minute
+	clusterLockOpTimeout   = 5 * time.Second
+	clusterReleaseTimeout  = 2 * time.Second
+	leaderRenewDivisor     = 3
+	fireTimeKeyLayout      = "20060102150405"
+	leaderKeyFormat        = "%s:leader"
+	fireLockKeyFormat      = "%s:fire:%s:%s"
+	workflowKeyFormat      = "%s:workflow:%s"
+	defaultNodeIdSeparator = "-"
+)
+
+type SkipReason string
+
+const (
+	// SkipNotLeader leader 模式下本节点不是 leader
+	SkipNotLeader SkipReason = "not_leader"
+	// SkipLocked 本次触发已经被其他节点执行
+	SkipLocked SkipReason = "locked"
+	// SkipOverlap 上一次还没结束，按任务的 Overlap 策略跳过
+	SkipOverlap SkipReason = "overlap"
+)
+
+type (
+	// LockStore 集群模式使用的锁，owner 用来保证只有持有者可以续期和释放
+	LockStore interface {
+		// Acquire key 不存在时设置为 owner，返回是否获得锁
+		Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+		// Renew key 的值为 owner 时延长过期时间，返回 false 表示已经失去锁
+		Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error)
+		// Release key 的值为 owner 时删除
+		Release(ctx context.Context, key, owner string) error
+	}
+
+	ClusterConf struct {
+		Mode  ClusterMode
+		Locks LockStore
+		// NodeId 为空时使用 hostname 加随机后缀
+		NodeId string
+		// Prefix 锁的 key 前缀，默认 crontab
+		Prefix string
+		// LeaseTtl leader 租约时长，每 LeaseTtl/3 续期一次，默认 15s
+		LeaseTtl time.Duration
+		// FireLockTtl 单次触发锁的保留时长，执行结束后不会释放，需要大于节点间的最大时钟偏差，默认 10m
+		FireLockTtl time.Duration
+		// OnSkip 本节点不执行这次触发时回调，属于正常情况；Overlap 策略跳过的触发也会回调
+		OnSkip func(ctx context.Context, taskUuid string, fireAt time.Time, reason SkipReason)
+		// OnLockError 加锁失败时回调，此时这次触发在本节点被放弃；leader 租约操作失败时 taskUuid 为空
+		OnLockError func(ctx context.Context, taskUuid string, fireAt time.Time, err error)
+	}
+
+	redisLockStore struct {
+		rd rexDao.RedisDao
+	}
+)
+
+// NewRedisLockStore 基于 RedisDao 的锁，memory dao 也可以使用；ttl 按秒向上取整
+func NewRedisLockStore(rd rexDao.RedisDao) LockStore {
+	return &redisLockStore{rd: rd}
+}
+
+func (s *redisLockStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	return s.rd.SetNxExCtx(ctx, key, owner, rexDao.TtlSeconds(ttl))
+}
+
+func (s *redisLockStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	return s.rd.CompareAndExpireCtx(ctx, key, owner, rexDao.TtlSeconds(ttl))
+}
+
+func (s *redisLockStore) Release(ctx context.Context, key, owner string) error {
+	_, err := s.rd.CompareAndDeleteCtx(ctx, key, owner)
+	return err
+}
+
+// WithCluster 开启集群模式，多个节点加载同样的任务时保证每次触发只执行一次，TriggerNow 不受影响
+func WithCluster(conf ClusterConf) PoolOption {
+	return func(c *CrontabPool) {
+		if conf.NodeId == "" {
+			hostname, _ := os.Hostname()
+			conf.NodeId = hostname + defaultNodeIdSeparator + uuid.NewString()[:8]
+		}
+		if conf.Prefix == "" {
+			conf.Prefix = DefaultClusterPrefix
+		}
+		if conf.LeaseTtl <= 0 {
+			conf.LeaseTtl = DefaultLeaseTtl
+		}
+		if conf.FireLockTtl <= 0 {
+			conf.FireLockTtl = DefaultFireLockTtl
+		}
+		c.cluster = &conf
+	}
+}
+
+// IsLeader leader 模式下本节点是否持有租约，非 leader 模式始终返回 true
+func (c *CrontabPool) IsLeader() bool {
+	if c.cluster == nil || c.cluster.Mode != ClusterModeLeader {
+		return true
+	}
+	return c.leader.Load()
+}
+
+// NodeId 集群模式下的节点 id，非集群模式返回 hostname
+func (c *CrontabPool) NodeId() string {
+	if c.cluster == nil {
+		return c.nodeId
+	}
+	return c.cluster.NodeId
+}
+
+// claim 判断本节点是否执行这次计划触发
+func (c *CrontabPool) claim(e *taskEntry, taskUuid string, fireAt time.Time) bool {
+	if c.cluster == nil {
+		return true
+	}
+	switch c.cluster.Mode {
+	case ClusterModeLeader:
+		if !c.leader.Load() {
+			c.skip(e, taskUuid, fireAt, SkipNotLeader)
+			return false
+		}
+	case ClusterModePerFire:
+		ctx, cancel := context.WithTimeout(c.stopCtx, clusterLockOpTimeout)
+		defer cancel()
+		key := fmt.Sprintf(fireLockKeyFormat, c.cluster.Prefix, taskUuid, fireAt.UTC().Format(fireTimeKeyLayout))
+		ok, err := c.cluster.Locks.Acquire(ctx, key, c.cluster.NodeId, c.cluster.FireLockTtl)
+		if err != nil {
+			c.lockError(e, taskUuid, fireAt, err)
+			return false
+		}
+		if !ok {
+			c.skip(e, taskUuid, fireAt, SkipLocked)
+			return false
+		}
+	}
+	return true
+}
+
+func (c *CrontabPool) skip(e *taskEntry, taskUuid string, fireAt time.Time, reason SkipReason) {
+	c.lock.Lock()
+	e.skipped++
+	c.lock.Unlock()
+	logx.Debugf("task skipped, and task uuid = %s, fire at = %s, reason = %s", taskUuid, fireAt.Format(time.RFC3339), reason)
+	if c.cluster != nil && c.cluster.OnSkip != nil {
+		c.cluster.OnSkip(c.stopCtx, taskUuid, fireAt, reason)
+	}
+}
+
+func (c *CrontabPool) lockError(e *taskEntry, taskUuid string, fireAt time.Time, err error) {
+	c.lock.Lock()
+	e.lockErrors++
+	c.lock.Unlock()
+	logx.Errorf("task lock failed, and task uuid = %s, fire at = %s, err = %v", taskUuid, fireAt.Format(time.RFC3339), err)
+	if c.cluster.OnLockError != nil {
+		c.cluster.OnLockError(c.stopCtx, taskUuid, fireAt, err)
+	}
+}
+
+// startLeaderElection 定期抢占或续期 leader 租约，续期一直失败直到租约快过期时放弃 leader
+func (c *CrontabPool) startLeaderElection() {
+	if c.cluster == nil || c.cluster.Mode != ClusterModeLeader {
+		return
+	}
+	key := fmt.Sprintf(leaderKeyFormat, c.cluster.Prefix)
+	ttl := c.cluster.LeaseTtl
+	go func() {
+		ticker := time.NewTicker(ttl / leaderRenewDivisor)
+		defer ticker.Stop()
+		var renewedAt time.Time
+		for {
+			c.campaign(key, ttl, &renewedAt)
+			select {
+			case <-c.stopCtx.Done():
+				if c.leader.Swap(false) {
+					ctx, cancel := context.WithTimeout(context.Background(), clusterReleaseTimeout)
+					if err := c.cluster.Locks.Release(ctx, key, c.cluster.NodeId); err != nil {
+						logx.Errorf("crontab leader release failed, node = %s, err = %v", c.cluster.NodeId, err)
+					}
+					cancel()
+				}
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *CrontabPool) campaign(key string, ttl time.Duration, renewedAt *time.Time) {
+	ctx, cancel := context.WithTimeout(c.stopCtx, clusterLockOpTimeout)
+	defer cancel()
+	var ok bool
+	var err error
+	if c.leader.Load() {
+		ok, err = c.cluster.Locks.Renew(ctx, key, c.cluster.NodeId, ttl)
+	} else {
+		ok, err = c.cluster.Locks.Acquire(ctx, key, c.cluster.NodeId, ttl)
+	}
+	if err != nil {
+		logx.Errorf("crontab leader lease failed, node = %s, err = %v", c.cluster.NodeId, err)
+		if c.cluster.OnLockError != nil {
+			c.cluster.OnLockError(c.stopCtx, "", time.Now(), err)
+		}
+		// note: 租约可能已经过期，其他节点随时会接替，这里不能继续执行任务
+		if c.leader.Load() && time.Since(*renewedAt) >= ttl-ttl/leaderRenewDivisor {
+			c.leader.Store(false)
+			logx.Errorf("crontab leader lost, node = %s", c.cluster.NodeId)
+		}
+		return
+	}
+	if ok {
+		*renewedAt = time.Now()
+		if !c.leader.Swap(true) {
+			logx.Infof("crontab leader elected, node = %s", c.cluster.NodeId)
+			go c.catchUpAll()
+		}
+		return
+	}
+	if c.leader.Swap(false) {
+		logx.Errorf("crontab leader lost, node = %s", c.cluster.NodeId)
+	}
+}
+
+// fireTime 本次计划触发的时间，各节点按同一个 schedule 计算，不受本地时钟偏差影响
+func (c *CrontabPool) fireTime(e *taskEntry) time.Time {
+	c.lock.RLock()
+	id := e.task.JobId
+	c.lock.RUnlock()
+	if id != 0 {
+		if entry := c.cron.Entry(id); !entry.Prev.IsZero() {
+			return entry.Prev
+		}
+	}
+	return time.Now().Truncate(time.Second)
+}
@@ -0,0 +1,203 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
+)
+
+type memoryLockStore struct {
+	lock   sync.Mutex
+	owners map[string]string
+	err    error
+}
+
+func newMemoryLockStore() *memoryLockStore {
+	return &memoryLockStore{owners: map[string]string{}}
+}
+
+func (s *memoryLockStore) Acquire(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	if _, ok := s.owners[key]; ok {
+		return false, nil
+	}
+	s.owners[key] = owner
+	return true, nil
+}
+
+func (s *memoryLockStore) Renew(ctx context.Context, key, owner string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+	return s.owners[key] == owner, nil
+}
+
+func (s *memoryLockStore) Release(ctx context.Context, key, owner string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owners[key] == owner {
+		delete(s.owners, key)
+	}
+	return nil
+}
+
+func (s *memoryLockStore) setErr(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.err = err
+}
+
+func TestCrontabPoolPerFireLock(t *testing.T) {
+	ctx := context.Background()
+	locks := newMemoryLockStore()
+	var mu sync.Mutex
+	runs := map[string]int{}
+	var skipped, lockErrors atomic.Int32
+
+	pools := make([]*CrontabPool, 3)
+	for i := range pools {
+		pool := NewCrontabPool(WithCluster(ClusterConf{
+			Mode:  ClusterModePerFire,
+			Locks: locks,
+			OnSkip: func(ctx context.Context, taskUuid string, fireAt time.Time, reason SkipReason) {
+				skipped.Add(1)
+			},
+			OnLockError: func(ctx context.Context, taskUuid string, fireAt time.Time, err error) {
+				lockErrors.Add(1)
+			},
+		}))
+		job := cron.FuncJob(func() {
+			info, _ := pool.Get("tick")
+			mu.Lock()
+			runs[info.Prev.Format(time.RFC3339)]++
+			mu.Unlock()
+		})
+		if err := pool.Add(ctx, &Task{TaskUuid: "tick", Spec: "* * * * * *", Job: job}); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		pools[i] = pool
+	}
+	for _, pool := range pools {
+		pool.Start()
+	}
+	time.Sleep(2500 * time.Millisecond)
+	locks.setErr(errors.New("redis down"))
+	time.Sleep(1100 * time.Millisecond)
+	for _, pool := range pools {
+		_ = pool.Shutdown(ctx)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(runs) < 2 {
+		t.Fatalf("runs = %v, want at least 2 fires", runs)
+	}
+	for fireAt, n := range runs {
+		if n != 1 {
+			t.Errorf("fire %s ran %d times, want 1", fireAt, n)
+		}
+	}
+	if skipped.Load() == 0 {
+		t.Error("OnSkip was not called")
+	}
+	if lockErrors.Load() == 0 {
+		t.Error("OnLockError was not called")
+	}
+}
+
+func TestCrontabPoolPerFireEvery(t *testing.T) {
+	ctx := context.Background()
+	c := NewCrontabPool(WithCluster(ClusterConf{Mode: ClusterModePerFire, Locks: newMemoryLockStore()}))
+	defer c.Shutdown(ctx)
+	noop := cron.FuncJob(func() {})
+	for _, spec := range []string{"@every 1s", "CRON_TZ=Asia/Shanghai @every 1m"} {
+		if err := c.Add(ctx, &Task{TaskUuid: "every", Spec: spec, Job: noop}); !errors.Is(err, ErrInvalidSpec) {
+			t.Errorf("Add(%q) error = %v, want %v", spec, err, ErrInvalidSpec)
+		}
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "every", Spec: "@every 1m", Options: TaskOptions{Timezone: "Asia/Shanghai"}, Job: noop}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Add() with timezone error = %v, want %v", err, ErrInvalidSpec)
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "hourly", Spec: "@hourly", Job: noop}); err != nil {
+		t.Errorf("Add(@hourly) error = %v", err)
+	}
+	if err := c.Update(ctx, &Task{TaskUuid: "hourly", Spec: "@every 1h", Job: noop}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Update(@every) error = %v, want %v", err, ErrInvalidSpec)
+	}
+}
+
+func TestRedisLockStore(t *testing.T) {
+	ctx := context.Background()
+	rd := rexDao.NewMemoryRedisDao()
+	defer rd.Close()
+	locks := NewRedisLockStore(rd)
+
+	if ok, err := locks.Acquire(ctx, "lock", "a", time.Second); err != nil || !ok {
+		t.Fatalf("Acquire(a) = %v, %v", ok, err)
+	}
+	if ok, err := locks.Acquire(ctx, "lock", "b", time.Second); err != nil || ok {
+		t.Errorf("Acquire(b) = %v, %v, want false", ok, err)
+	}
+	if ok, err := locks.Renew(ctx, "lock", "b", time.Second); err != nil || ok {
+		t.Errorf("Renew(b) = %v, %v, want false", ok, err)
+	}
+	if ok, err := locks.Renew(ctx, "lock", "a", time.Second); err != nil || !ok {
+		t.Errorf("Renew(a) = %v, %v", ok, err)
+	}
+	if err := locks.Release(ctx, "lock", "b"); err != nil {
+		t.Fatalf("Release(b) error = %v", err)
+	}
+	if ok, _ := locks.Acquire(ctx, "lock", "b", time.Second); ok {
+		t.Error("Release by non-owner released the lock")
+	}
+	if err := locks.Release(ctx, "lock", "a"); err != nil {
+		t.Fatalf("Release(a) error = %v", err)
+	}
+	if ok, err := locks.Acquire(ctx, "lock", "b", time.Second); err != nil || !ok {
+		t.Errorf("Acquire(b) after Release = %v, %v", ok, err)
+	}
+}
+
+func TestCrontabPoolLeaderElection(t *testing.T) {
+	ctx := context.Background()
+	locks := newMemoryLockStore()
+	conf := ClusterConf{Mode: ClusterModeLeader, Locks: locks, LeaseTtl: 60 * time.Millisecond}
+	a := NewCrontabPool(WithCluster(conf))
+	b := NewCrontabPool(WithCluster(conf))
+	a.Start()
+	waitLeader(t, a)
+	b.Start()
+	defer b.Shutdown(ctx)
+
+	time.Sleep(50 * time.Millisecond)
+	if b.IsLeader() {
+		t.Fatal("both nodes are leader")
+	}
+	if err := a.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	waitLeader(t, b)
+}
+
+func waitLeader(t *testing.T, c *CrontabPool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.IsLeader() {
+		if time.Now().After(deadline) {
+			t.Fatalf("node %s not elected", c.NodeId())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -1,103 +1,738 @@
 package rexCrontabPool
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
 	"github.com/zeromicro/go-zero/core/logx"
-	"sync"
 )
 
+const (
+	DefaultJobSyncChannel = "crontab-jobs"
+)
+
+var (
+	ErrTaskExists    = errors.New("任务已存在")
+	ErrTaskNotFound  = errors.New("任务不存在")
+	ErrInvalidSpec   = errors.New("cron 表达式错误")
+	ErrInvalidTask   = errors.New("任务缺少 uuid 或 job")
+	ErrPoolClosed    = errors.New("任务池已关闭")
+	ErrTaskPaused    = errors.New("任务已暂停")
+	ErrTaskNotPaused = errors.New("任务未暂停")
+)
+
+// specParser 和 cron.WithSeconds 一致，秒字段必填，支持 @every 等描述符和 CRON_TZ 前缀
+var specParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
 type CrontabPool struct {
-	*cron.Cron
-	TaskPool   map[string]*Task
-	Lock       sync.Mutex
-	Register   chan *Task
-	Update     chan *Task
+	cron  *cron.Cron
+	lock  sync.RWMutex
+	tasks map[string]*taskEntry
+	// runLock 保护 closed 和 running，保证 Shutdown 开始等待之后不会再有任务启动
+	runLock sync.Mutex
+	running sync.WaitGroup
+	closed  bool
+	started bool
+	// stopCtx 在 Shutdown 时取消，用来停止后台同步
+	stopCtx  context.Context
+	stopFunc context.CancelFunc
+
+	store        JobStore
+	syncStore    rexDao.RedisDao
+	syncChannel  string
+	syncInterval time.Duration
+
+	cluster *ClusterConf
+	leader  atomic.Bool
+	nodeId  string
+
+	history   HistoryStore
+	onFailure func(ctx context.Context, run *JobRun)
+
+	fires FireStore
+
+	// wfLock 保护 workflows 以及其中工作流和步骤的状态
+	wfLock        sync.Mutex
+	workflows     map[string]*workflowRun
+	workflowStore WorkflowStore
+
+	// Deprecated: 使用 Add，Register 只在 Run 中读取，注册失败只会记录日志
+	Register chan *Task
+	// Deprecated: 使用 Remove
 	UnRegister chan string
-	Close      chan int
-	TaskCount  int
+	// Deprecated: 使用 Shutdown
+	Close chan int
 }
 
 type Task struct {
 	TaskUuid string // note: 最好用uuid
 	Name     string
 	Spec     string
-	JobId    cron.EntryID
-	Job      cron.Job
+	// JobId 由任务池维护，暂停时为 0
+	JobId cron.EntryID
+	Job   cron.Job
+	// JobType 不为空并且配置了 JobStore 时任务会被持久化；Job 为空时由 JobType 对应的 JobFactory 创建，不要求持久化
+	JobType string
+	Payload string
+	Options TaskOptions
+}
+
+// TaskOptions 任务的执行选项，持久化任务会一起保存
+type TaskOptions struct {
+	// Timeout 单次执行的超时时间，通过 ctx 传给 CtxJob，0 表示不限制
+	Timeout time.Duration `json:"timeout,omitempty"`
+	Retry   RetryPolicy   `json:"retry,omitempty"`
+	// Timezone IANA 时区，例如 Asia/Shanghai，为空时使用服务器时区，不能和 spec 的 CRON_TZ 前缀同时使用
+	Timezone string `json:"timezone,omitempty"`
+	// Jitter 每次计划执行前随机等待 [0, Jitter)，分散同一时刻触发的任务，手动触发不等待
+	Jitter time.Duration `json:"jitter,omitempty"`
+	// Overlap 上一次还没结束时的处理方式，只对计划执行生效，默认 allow
+	Overlap OverlapPolicy `json:"overlap,omitempty"`
+	// Misfire 停机期间错过的触发的处理方式，需要配置 WithFireStore，默认 ignore
+	Misfire MisfirePolicy `json:"misfire,omitempty"`
+}
+
+type PoolOption func(c *CrontabPool)
+
+// TaskInfo 任务的快照，修改它不会影响任务池
+type TaskInfo struct {
+	TaskUuid string       `json:"task_uuid"`
+	Name     string       `json:"name"`
+	Spec     string       `json:"spec"`
+	JobType  string       `json:"job_type,omitempty"`
+	Payload  string       `json:"payload,omitempty"`
+	Options  TaskOptions  `json:"options"`
+	JobId    cron.EntryID `json:"job_id"`
+	Paused   bool         `json:"paused"`
+	// Version 持久化任务的版本号，未持久化的任务为 0
+	Version int64 `json:"version"`
+	// Running 正在执行的次数，包含 TriggerNow 触发的执行
+	Running int `json:"running"`
+	// Next 下一次执行时间，暂停或未启动时为零值
+	Next time.Time `json:"next"`
+	// Prev 上一次按计划执行的时间
+	Prev time.Time `json:"prev"`
+	// Skipped 由其他节点执行或者上一次还没结束而跳过的次数
+	Skipped int64 `json:"skipped"`
+	// LockErrors 集群模式下加锁失败而放弃执行的次数
+	LockErrors int64 `json:"lock_errors"`
+}
+
+type taskEntry struct {
+	task     Task
+	schedule cron.Schedule
+	paused   bool
+	running  int
+	prev     time.Time
+	// queued overlap 为 queue 时排队的计划触发时间，零值表示没有排队
+	queued time.Time
+	// onceFired 一次性任务已经触发过，避免计划触发和补执行重复
+	onceFired bool
+	// recoverLock 保证同一个任务同时只有一个 recoverMisfire，后执行的会读到已经推进的触发时间
+	recoverLock sync.Mutex
+	// persisted 为 true 时修改会写入 JobStore
+	persisted bool
+	version   int64
+
+	skipped    int64
+	lockErrors int64
+}
+
+func NewCrontabPool(opts ...PoolOption) *CrontabPool {
+	stopCtx, stopFunc := context.WithCancel(context.Background())
+	hostname, _ := os.Hostname()
+	c := &CrontabPool{
+		nodeId:      hostname,
+		cron:        cron.New(cron.WithParser(specParser)),
+		tasks:       make(map[string]*taskEntry),
+		workflows:   make(map[string]*workflowRun),
+		stopCtx:     stopCtx,
+		stopFunc:    stopFunc,
+		syncChannel: DefaultJobSyncChannel,
+		Register:    make(chan *Task),
+		UnRegister:  make(chan string),
+		Close:       make(chan int),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithJobStore 持久化 JobType 不为空的任务，启动前调用 Load 加载已保存的任务
+func WithJobStore(store JobStore) PoolOption {
+	return func(c *CrontabPool) {
+		c.store = store
+	}
+}
+
+// WithJobSync 修改持久化任务后通过 redis 频道通知其他节点同步，interval 大于 0 时还会定期全量同步，
+// 避免节点错过通知；channel 为空时使用 DefaultJobSyncChannel，store 为空时只定期同步
+func WithJobSync(store rexDao.RedisDao, channel string, interval time.Duration) PoolOption {
+	return func(c *CrontabPool) {
+		c.syncStore = store
+		if channel != "" {
+			c.syncChannel = channel
+		}
+		c.syncInterval = interval
+	}
+}
+
+// ParseSpec 校验 cron 表达式，格式和任务池使用的一致，另外支持一次性任务的 "@at RFC3339"，见 RunAt
+func ParseSpec(spec string) (cron.Schedule, error) {
+	if once, ok, err := parseOnceSpec(spec); ok {
+		if err != nil {
+			return nil, err
+		}
+		return once, nil
+	}
+	schedule, err := specParser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidSpec, spec, err)
+	}
+	return schedule, nil
+}
+
+// Start 开始调度，启动之前添加的任务也会按计划执行
+func (c *CrontabPool) Start() {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if c.closed || c.started {
+		return
+	}
+	c.started = true
+	c.cron.Start()
+	c.startSync()
+	c.startLeaderElection()
+	// note: leader 模式在当选后再补执行
+	if c.cluster == nil || c.cluster.Mode != ClusterModeLeader {
+		go c.catchUpAll()
+	}
+}
+
+// Add 添加任务，uuid 已存在时返回 ErrTaskExists，持久化任务先写入 JobStore 再开始调度；
+// JobStore 的读写不持有 c.lock，并发创建同一个 uuid 由 JobStore 保证只有一个成功
+func (c *CrontabPool) Add(ctx context.Context, task *Task) error {
+	e, err := c.newEntry(task)
+	if err != nil {
+		return err
+	}
+	if c.isClosed() {
+		return ErrPoolClosed
+	}
+	c.lock.RLock()
+	_, exists := c.tasks[task.TaskUuid]
+	c.lock.RUnlock()
+	if exists {
+		return fmt.Errorf("%w: %s", ErrTaskExists, task.TaskUuid)
+	}
+	if e.persisted {
+		record := e.record()
+		if err := c.store.Create(ctx, record); err != nil {
+			return err
+		}
+		e.version = record.Version
+	}
+
+	c.lock.Lock()
+	if c.isClosed() {
+		c.lock.Unlock()
+		return ErrPoolClosed
+	}
+	if old, ok := c.tasks[task.TaskUuid]; ok {
+		// note: 定期同步可能已经从 JobStore 加载了刚创建的任务
+		synced := e.persisted && old.persisted && old.version >= e.version
+		task.JobId = old.task.JobId
+		c.lock.Unlock()
+		if !synced {
+			return fmt.Errorf("%w: %s", ErrTaskExists, task.TaskUuid)
+		}
+		c.notify(ctx, task.TaskUuid, true)
+		return nil
+	}
+	c.schedule(e)
+	c.tasks[task.TaskUuid] = e
+	task.JobId = e.task.JobId
+	c.lock.Unlock()
+	logx.WithContext(ctx).Infof("task register success, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
+	c.notify(ctx, task.TaskUuid, e.persisted)
+	go c.catchUp(e)
+	return nil
+}
+
+// Update 替换任务的名称、表达式和 job，暂停状态保持不变，正在执行的旧 job 不会被打断；
+// 持久化任务按版本号更新 JobStore，期间任务被其他调用修改时返回 ErrVersionConflict
+func (c *CrontabPool) Update(ctx context.Context, task *Task) error {
+	updated, err := c.newEntry(task)
+	if err != nil {
+		return err
+	}
+	if c.isClosed() {
+		return ErrPoolClosed
+	}
+	c.lock.RLock()
+	e, ok := c.tasks[task.TaskUuid]
+	var persisted bool
+	var version int64
+	if ok {
+		updated.paused = e.paused
+		persisted = e.persisted
+		version = e.version
+	}
+	c.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, task.TaskUuid)
+	}
+	if err := c.saveTask(ctx, updated, persisted, version); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	if c.tasks[task.TaskUuid] != e {
+		c.lock.Unlock()
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, task.TaskUuid)
+	}
+	updated.paused = e.paused
+	// note: 同步可能已经应用了刚写入的版本
+	if !updated.persisted || !e.persisted || e.version < updated.version {
+		c.replace(e, updated)
+	}
+	task.JobId = e.task.JobId
+	c.lock.Unlock()
+	logx.WithContext(ctx).Infof("task update success, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
+	c.notify(ctx, task.TaskUuid, persisted || updated.persisted)
+	return nil
+}
+
+// Remove 删除任务，正在执行的 job 不会被打断
+func (c *CrontabPool) Remove(ctx context.Context, taskUuid string) error {
+	c.lock.RLock()
+	e, ok := c.tasks[taskUuid]
+	persisted := ok && e.persisted
+	c.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	if persisted {
+		if err := c.store.Delete(ctx, taskUuid); err != nil {
+			return err
+		}
+	}
+	c.lock.Lock()
+	if c.tasks[taskUuid] == e {
+		c.unschedule(e)
+		delete(c.tasks, taskUuid)
+	}
+	c.lock.Unlock()
+	logx.WithContext(ctx).Infof("task unregister success, and task uuid = %s", taskUuid)
+	c.notify(ctx, taskUuid, persisted)
+	return nil
+}
+
+func (c *CrontabPool) Get(taskUuid string) (*TaskInfo, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	e, ok := c.tasks[taskUuid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	return c.info(e), nil
+}
+
+// List 返回所有任务的快照，按 uuid 排序
+func (c *CrontabPool) List() []*TaskInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	list := make([]*TaskInfo, 0, len(c.tasks))
+	for _, e := range c.tasks {
+		list = append(list, c.info(e))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].TaskUuid < list[j].TaskUuid
+	})
+	return list
+}
+
+func (c *CrontabPool) TaskCount() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.tasks)
+}
+
+// Pause 暂停任务的计划执行，TriggerNow 仍然可以手动触发
+func (c *CrontabPool) Pause(ctx context.Context, taskUuid string) error {
+	e, err := c.setPaused(ctx, taskUuid, true)
+	if err != nil {
+		return err
+	}
+	logx.WithContext(ctx).Infof("task pause success, and task uuid = %s", taskUuid)
+	c.notify(ctx, taskUuid, e.persisted)
+	return nil
+}
+
+func (c *CrontabPool) Resume(ctx context.Context, taskUuid string) error {
+	if c.isClosed() {
+		return ErrPoolClosed
+	}
+	e, err := c.setPaused(ctx, taskUuid, false)
+	if err != nil {
+		return err
+	}
+	logx.WithContext(ctx).Infof("task resume success, and task uuid = %s", taskUuid)
+	// note: 暂停期间的触发不算错过，一次性任务的执行时间已过时立即执行
+	c.saveFire(taskUuid, time.Now())
+	go c.catchUp(e)
+	c.notify(ctx, taskUuid, e.persisted)
+	return nil
 }
 
-func NewCrontabPool() *CrontabPool {
-	c := cron.New(cron.WithSeconds())
-	return &CrontabPool{
-		Cron:       c,
-		TaskPool:   make(map[string]*Task),
-		Lock:       sync.Mutex{},
-		Register:   make(chan *Task),
-		Update:     make(chan *Task),
-		UnRegister: make(chan string),
-		Close:      make(chan int),
-		TaskCount:  0,
+// TriggerNow 立即在新的 goroutine 中执行一次任务，不影响计划执行，Shutdown 同样会等待它结束
+func (c *CrontabPool) TriggerNow(ctx context.Context, taskUuid string) error {
+	c.lock.RLock()
+	e, ok := c.tasks[taskUuid]
+	c.lock.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	if !c.enterRun() {
+		return ErrPoolClosed
+	}
+	logx.WithContext(ctx).Infof("task trigger now, and task uuid = %s", taskUuid)
+	go c.execute(e, time.Time{})
+	return nil
+}
+
+// Shutdown 停止调度并等待正在执行的任务结束，正在执行的 CtxJob 会收到 ctx 取消，重试也会停止；
+// ctx 结束时返回 ctx.Err()，任务仍会在后台执行完
+func (c *CrontabPool) Shutdown(ctx context.Context) error {
+	c.runLock.Lock()
+	c.closed = true
+	c.runLock.Unlock()
+	c.stopFunc()
+	c.cron.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		c.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
+// Run 启动任务池并读取 Register、UnRegister、Close 通道，收到 Close 后等待正在执行的任务结束再返回
+//
+// Deprecated: 使用 Start、Add、Remove 和 Shutdown，它们会把错误返回给调用方
 func (c *CrontabPool) Run() {
 	c.Start()
-	defer c.Stop()
-
 	for {
 		select {
 		case num := <-c.Close:
 			logx.Infof("pool close signal = %d", num)
-			break
-		case task := <-c.Register:
-			c.Lock.Lock()
-			// note: 如果任务已经存在，则不再注册，但是万一需要更新，这个需要改进
-			if _, ok := c.TaskPool[task.TaskUuid]; ok {
-				logx.Infof("task already exists, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
-				return
+			if err := c.Shutdown(context.Background()); err != nil {
+				logx.Errorf("pool shutdown failed, err = %v", err)
 			}
-			jobId, err := c.AddJob(task.Spec, task.Job)
-			if err != nil {
-				logx.Errorf("task register failed, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
-				return
+			return
+		case task := <-c.Register:
+			if err := c.Add(context.Background(), task); err != nil {
+				logx.Errorf("task register failed, and task uuid = %s, task name = %s, err = %v", task.TaskUuid, task.Name, err)
 			}
-			task.JobId = jobId
-			c.TaskPool[task.TaskUuid] = task
-			c.TaskCount += 1
-			c.Lock.Unlock()
-			logx.Infof("task register success, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
-		case task := <-c.Update:
-			c.Lock.Lock()
-			// note: 如果是更新通道，说明有可能任务会存在
-			if _, ok := c.TaskPool[task.TaskUuid]; ok {
-				c.Remove(c.TaskPool[task.TaskUuid].JobId)
-				//删除分组中的任务
-				delete(c.TaskPool, task.TaskUuid)
-				//任务数量减1
-				c.TaskCount -= 1
-				logx.Infof("task delete old success, and task uuid = %s", task.TaskUuid)
+		case taskUuid := <-c.UnRegister:
+			if err := c.Remove(context.Background(), taskUuid); err != nil {
+				logx.Errorf("task unregister failed, and task uuid = %s, err = %v", taskUuid, err)
 			}
-			jobId, err := c.AddJob(task.Spec, task.Job)
-			if err != nil {
-				logx.Errorf("task register failed, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
+		}
+	}
+}
+
+func (c *CrontabPool) isStarted() bool {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	return c.started && !c.closed
+}
+
+func (c *CrontabPool) isClosed() bool {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	return c.closed
+}
+
+// enterRun 登记一次执行，任务池关闭后返回 false
+func (c *CrontabPool) enterRun() bool {
+	c.runLock.Lock()
+	defer c.runLock.Unlock()
+	if c.closed {
+		return false
+	}
+	c.running.Add(1)
+	return true
+}
+
+// newEntry 校验任务并创建 job，不修改任务池
+func (c *CrontabPool) newEntry(task *Task) (*taskEntry, error) {
+	if task == nil || task.TaskUuid == "" {
+		return nil, ErrInvalidTask
+	}
+	e := &taskEntry{task: *task, persisted: c.store != nil && task.JobType != ""}
+	if err := task.Options.validate(); err != nil {
+		return nil, err
+	}
+	schedule, err := parseTaskSpec(task.Spec, task.Options.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	// note: @every 从各节点添加任务的时间开始计算，同一次触发在各节点的时间不同，按触发时间加锁无法去重
+	if _, ok := schedule.(cron.ConstantDelaySchedule); ok && c.cluster != nil && c.cluster.Mode == ClusterModePerFire {
+		return nil, fmt.Errorf("%w: %s: per-fire 模式不支持 @every，请使用 cron 表达式", ErrInvalidSpec, task.Spec)
+	}
+	e.schedule = schedule
+	if e.task.Job == nil && e.task.JobType != "" {
+		job, err := buildJob(e.record())
+		if err != nil {
+			return nil, err
+		}
+		e.task.Job = job
+	}
+	if e.task.Job == nil {
+		return nil, ErrInvalidTask
+	}
+	return e, nil
+}
+
+// replace 用 updated 的任务内容替换 e，保留运行状态，需要持有 c.lock
+func (c *CrontabPool) replace(e, updated *taskEntry) {
+	c.unschedule(e)
+	e.task = updated.task
+	e.schedule = updated.schedule
+	e.persisted = updated.persisted
+	e.version = updated.version
+	e.paused = updated.paused
+	e.onceFired = false
+	if !e.paused {
+		c.schedule(e)
+	}
+}
+
+// saveTask 把 Update 的修改写入 JobStore，不持有 c.lock；persisted 和 version 为修改前的状态
+func (c *CrontabPool) saveTask(ctx context.Context, updated *taskEntry, persisted bool, version int64) error {
+	if !updated.persisted {
+		if persisted {
+			return c.store.Delete(ctx, updated.task.TaskUuid)
+		}
+		return nil
+	}
+	record := updated.record()
+	var err error
+	// note: 原来不是持久化任务时需要新建记录
+	if persisted {
+		record.Version = version
+		err = c.store.Update(ctx, record)
+	} else {
+		err = c.store.Create(ctx, record)
+	}
+	if err != nil {
+		return err
+	}
+	updated.version = record.Version
+	return nil
+}
+
+// setPaused 修改任务的暂停状态，持久化任务先按版本号写入 JobStore，写入时不持有 c.lock
+func (c *CrontabPool) setPaused(ctx context.Context, taskUuid string, paused bool) (*taskEntry, error) {
+	stateErr := ErrTaskNotPaused
+	if paused {
+		stateErr = ErrTaskPaused
+	}
+	c.lock.RLock()
+	e, ok := c.tasks[taskUuid]
+	var record *JobRecord
+	var persisted, current bool
+	if ok {
+		record = e.record()
+		persisted = e.persisted
+		current = e.paused
+	}
+	c.lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	if current == paused {
+		return nil, fmt.Errorf("%w: %s", stateErr, taskUuid)
+	}
+	if persisted {
+		record.Enabled = !paused
+		if err := c.store.Update(ctx, record); err != nil {
+			return nil, err
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.tasks[taskUuid] != e {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	if persisted && e.version < record.Version {
+		e.version = record.Version
+	} else if e.paused == paused {
+		// note: 持久化任务已经由同步应用，非持久化任务被并发修改
+		if persisted {
+			return e, nil
+		}
+		return nil, fmt.Errorf("%w: %s", stateErr, taskUuid)
+	}
+	e.paused = paused
+	if paused {
+		c.unschedule(e)
+	} else {
+		c.schedule(e)
+	}
+	return e, nil
+}
+
+// schedule 需要持有 c.lock
+func (c *CrontabPool) schedule(e *taskEntry) {
+	e.task.JobId = c.cron.Schedule(e.schedule, cron.FuncJob(func() {
+		c.fire(e)
+	}))
+}
+
+// fire 计划触发
+func (c *CrontabPool) fire(e *taskEntry) {
+	c.fireAt(e, c.fireTime(e))
+}
+
+// fireAt 执行 fireAt 这次计划触发，集群模式下先确认由本节点执行，记录触发时间后按 Jitter 随机等待
+func (c *CrontabPool) fireAt(e *taskEntry, fireAt time.Time) {
+	c.lock.Lock()
+	taskUuid := e.task.TaskUuid
+	jitter := e.task.Options.Jitter
+	once := isOnce(e.schedule)
+	if once && e.onceFired {
+		c.lock.Unlock()
+		return
+	}
+	c.lock.Unlock()
+	if !c.claim(e, taskUuid, fireAt) {
+		return
+	}
+	// note: 没有抢到这次触发时不标记，加锁失败后补执行还可以重试；单机和 leader 模式下 claim 总是成功，这里再检查一次避免重复执行
+	if once {
+		c.lock.Lock()
+		fired := e.onceFired
+		e.onceFired = true
+		c.lock.Unlock()
+		if fired {
+			return
+		}
+	}
+	c.saveFire(taskUuid, fireAt)
+	if !c.waitJitter(jitter) {
+		return
+	}
+	if !c.enterRun() {
+		return
+	}
+	c.execute(e, fireAt)
+	if once {
+		c.finishOnce(e, fireAt)
+	}
+}
+
+// unschedule 需要持有 c.lock
+func (c *CrontabPool) unschedule(e *taskEntry) {
+	if e.task.JobId != 0 {
+		c.cron.Remove(e.task.JobId)
+		e.task.JobId = 0
+	}
+}
+
+// execute 执行一次任务，fireAt 为计划触发时间，手动触发时为零值，调用前需要 enterRun 成功；
+// 计划执行按 Overlap 策略处理上一次还没结束的情况，排队的触发在本次结束后接着执行
+func (c *CrontabPool) execute(e *taskEntry, fireAt time.Time) {
+	defer c.running.Done()
+	c.lock.Lock()
+	if !fireAt.IsZero() && e.running > 0 {
+		taskUuid := e.task.TaskUuid
+		switch e.task.Options.Overlap {
+		case OverlapSkip:
+			c.lock.Unlock()
+			c.skip(e, taskUuid, fireAt, SkipOverlap)
+			return
+		case OverlapQueue:
+			if e.queued.IsZero() {
+				e.queued = fireAt
+				c.lock.Unlock()
 				return
 			}
-			task.JobId = jobId
-			c.TaskPool[task.TaskUuid] = task
-			c.TaskCount += 1
-			c.Lock.Unlock()
-			logx.Infof("task update success, and task uuid = %s, task name = %s, and task ID = %d", task.TaskUuid, task.Name, task.JobId)
-		case taskUuid := <-c.UnRegister:
-			//注销客户端
-			c.Lock.Lock()
-			if _, ok := c.TaskPool[taskUuid]; ok {
-				c.Remove(c.TaskPool[taskUuid].JobId)
-				//删除分组中的任务
-				delete(c.TaskPool, taskUuid)
-				//任务数量减1
-				c.TaskCount -= 1
-				logx.Infof("task unregister success, and task uuid = %s", taskUuid)
-			}
-			c.Lock.Unlock()
+			c.lock.Unlock()
+			c.skip(e, taskUuid, fireAt, SkipOverlap)
+			return
 		}
 	}
+	e.running++
+	for {
+		task := e.task
+		if !fireAt.IsZero() {
+			e.prev = fireAt
+		}
+		c.lock.Unlock()
+		c.run(c.stopCtx, task, fireAt)
+		c.lock.Lock()
+		// note: 任务池关闭后丢弃排队的触发
+		if e.queued.IsZero() || c.isClosed() {
+			break
+		}
+		fireAt, e.queued = e.queued, time.Time{}
+	}
+	e.queued = time.Time{}
+	e.running--
+	c.lock.Unlock()
+}
+
+// info 需要持有 c.lock
+func (c *CrontabPool) info(e *taskEntry) *TaskInfo {
+	info := &TaskInfo{
+		TaskUuid:   e.task.TaskUuid,
+		Name:       e.task.Name,
+		Spec:       e.task.Spec,
+		JobType:    e.task.JobType,
+		Payload:    e.task.Payload,
+		Options:    e.task.Options,
+		JobId:      e.task.JobId,
+		Paused:     e.paused,
+		Version:    e.version,
+		Running:    e.running,
+		Prev:       e.prev,
+		Skipped:    e.skipped,
+		LockErrors: e.lockErrors,
+	}
+	if e.task.JobId != 0 {
+		info.Next = c.cron.Entry(e.task.JobId).Next
+	}
+	return info
+}
+
+func (e *taskEntry) record() *JobRecord {
+	return &JobRecord{
+		TaskUuid: e.task.TaskUuid,
+		Name:     e.task.Name,
+		Spec:     e.task.Spec,
+		JobType:  e.task.JobType,
+		Payload:  e.task.Payload,
+		Options:  e.task.Options,
+		Enabled:  !e.paused,
+		Version:  e.version,
+	}
 }
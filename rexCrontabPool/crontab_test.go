@@ -0,0 +1,99 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestCrontabPoolTasks(t *testing.T) {
+	c := NewCrontabPool()
+	ctx := context.Background()
+	noop := cron.FuncJob(func() {})
+
+	if err := c.Add(ctx, &Task{TaskUuid: "a", Spec: "* * *", Job: noop}); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("Add() invalid spec error = %v, want %v", err, ErrInvalidSpec)
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "a", Spec: "0 0 * * * *", Job: noop}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "a", Spec: "0 0 * * * *", Job: noop}); !errors.Is(err, ErrTaskExists) {
+		t.Errorf("Add() duplicate error = %v, want %v", err, ErrTaskExists)
+	}
+	if err := c.Update(ctx, &Task{TaskUuid: "b", Spec: "@every 1m", Job: noop}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Update() missing error = %v, want %v", err, ErrTaskNotFound)
+	}
+
+	c.Start()
+	if err := c.Update(ctx, &Task{TaskUuid: "a", Name: "hourly", Spec: "@every 1h", Job: noop}); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	info, err := c.Get("a")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if info.Name != "hourly" || info.Next.IsZero() {
+		t.Errorf("Get() = %+v", info)
+	}
+
+	if err := c.Pause(ctx, "a"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	if info, _ := c.Get("a"); !info.Paused || !info.Next.IsZero() {
+		t.Errorf("Get() after pause = %+v", info)
+	}
+	if err := c.Resume(ctx, "a"); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if err := c.Remove(ctx, "a"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if c.TaskCount() != 0 || len(c.List()) != 0 {
+		t.Errorf("TaskCount() = %d after remove", c.TaskCount())
+	}
+	if err := c.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "a", Spec: "@every 1h", Job: noop}); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("Add() after shutdown error = %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+func TestCrontabPoolShutdownWaitsForJobs(t *testing.T) {
+	c := NewCrontabPool()
+	ctx := context.Background()
+	release := make(chan struct{})
+	var finished atomic.Bool
+	job := cron.FuncJob(func() {
+		<-release
+		finished.Store(true)
+	})
+	if err := c.Add(ctx, &Task{TaskUuid: "slow", Spec: "@every 1h", Job: job}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	c.Start()
+	if err := c.TriggerNow(ctx, "slow"); err != nil {
+		t.Fatalf("TriggerNow() error = %v", err)
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := c.Shutdown(timeoutCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := c.TriggerNow(ctx, "slow"); !errors.Is(err, ErrPoolClosed) {
+		t.Errorf("TriggerNow() after shutdown error = %v, want %v", err, ErrPoolClosed)
+	}
+
+	close(release)
+	if err := c.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if !finished.Load() {
+		t.Error("Shutdown() returned before the running job finished")
+	}
+}
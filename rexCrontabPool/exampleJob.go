@@ -1,18 +1,16 @@
 package rexCrontabPool
 
 import (
-	"fmt"
-	"github.com/go-redsync/redsync/v4"
+	"context"
+	"github.com/google/uuid"
 	"github.com/rootexit/rexLib/rexDao"
 	"github.com/rootexit/rexLib/rexRequest"
 	"github.com/zeromicro/go-zero/core/logx"
-	"time"
 )
 
 type PeriodicJob struct {
 	dao           rexDao.Dao
 	store         rexDao.RedisDao
-	rs            *redsync.Redsync
 	RequestClient rexRequest.RequestClient
 	Data          PeriodicJobData
 	fn            func(taskUuid, taskName string)
@@ -23,37 +21,31 @@ type PeriodicJobData struct {
 	taskName string
 }
 
+// Run 集群下的互斥由 CrontabPool 的集群模式保证，见 WithCluster
 func (j *PeriodicJob) Run() {
-	// 分布式锁 key
-	mutex := j.rs.NewMutex(fmt.Sprintf("lock-%s", j.Data.taskUuid), redsync.WithExpiry(5*time.Minute))
-	if err := mutex.Lock(); err == nil {
-		// 获得锁，执行任务
-		defer mutex.Unlock()
-		logx.Infof("任务uuid: %s, 任务名称: %s, 我来执行任务", j.Data.taskUuid, j.Data.taskName)
-		j.fn(j.Data.taskUuid, j.Data.taskName)
-	} else {
-		logx.Infof("任务uuid: %s, 任务名称: %s, 其他节点已在执行任务，跳过", j.Data.taskUuid, j.Data.taskName)
-	}
+	logx.Infof("任务uuid: %s, 任务名称: %s, 我来执行任务", j.Data.taskUuid, j.Data.taskName)
+	j.fn(j.Data.taskUuid, j.Data.taskName)
 }
 
-func AddTask(uuidStr string, taskName, spec string, fn func(taskUuid, taskName string)) (taskUuid string, err error) {
-	// note: 添加一个周期任务
-	//job := &Task{
-	//	TaskUuid: uuidStr,
-	//	Name:     taskName,
-	//	Spec:     spec,
-	//	Job: &PeriodicJob{
-	//		//dao:           s.Dao,
-	//		//store:         s.StoreDao,
-	//		//rs:            s.RdSync,
-	//		//RequestClient: s.RequestClient,
-	//		fn: fn,
-	//		Data: PeriodicJobData{
-	//			taskUuid: uuidStr,
-	//			taskName: taskName,
-	//		},
-	//	},
-	//}
-	//CrontabPool.Register <- job
+// AddTask 添加一个周期任务，uuidStr 为空时自动生成；fn 无法持久化，需要持久化的任务请使用 JobType 和 RegisterJobFactory
+func (c *CrontabPool) AddTask(uuidStr string, taskName, spec string, fn func(taskUuid, taskName string)) (taskUuid string, err error) {
+	if uuidStr == "" {
+		uuidStr = uuid.NewString()
+	}
+	job := &Task{
+		TaskUuid: uuidStr,
+		Name:     taskName,
+		Spec:     spec,
+		Job: &PeriodicJob{
+			fn: fn,
+			Data: PeriodicJobData{
+				taskUuid: uuidStr,
+				taskName: taskName,
+			},
+		},
+	}
+	if err := c.Add(context.Background(), job); err != nil {
+		return "", err
+	}
 	return uuidStr, nil
 }
@@ -0,0 +1,337 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultJobRunTableName = "crontab_job_runs"
+	// DefaultRedisHistoryKeep redis 中每个任务保留的执行记录数
+	DefaultRedisHistoryKeep = 100
+	historyWriteTimeout     = 5 * time.Second
+)
+
+type RunStatus string
+
+const (
+	RunStatusRunning RunStatus = "running"
+	RunStatusSuccess RunStatus = "success"
+	RunStatusFailed  RunStatus = "failed"
+	RunStatusTimeout RunStatus = "timeout"
+)
+
+type (
+	// JobRun 一次执行的记录，开始时以 running 状态写入一次，结束时再更新一次
+	JobRun struct {
+		RunId    string        `json:"run_id"`
+		TaskUuid string        `json:"task_uuid"`
+		TaskName string        `json:"task_name"`
+		NodeId   string        `json:"node_id"`
+		Manual   bool          `json:"manual"`
+		FireAt   time.Time     `json:"fire_at"`
+		StartAt  time.Time     `json:"start_at"`
+		EndAt    time.Time     `json:"end_at"`
+		Duration time.Duration `json:"duration"`
+		Attempts int           `json:"attempts"`
+		Status   RunStatus     `json:"status"`
+		Error    string        `json:"error,omitempty"`
+	}
+
+	HistoryStore interface {
+		// Record 按 RunId 新增或覆盖执行记录
+		Record(ctx context.Context, run *JobRun) error
+		// Recent 按开始时间倒序返回任务最近的 limit 条执行记录
+		Recent(ctx context.Context, taskUuid string, limit int) ([]*JobRun, error)
+	}
+
+	// runAttempt 放在 ctx 中，见 RunAttemptFromCtx
+	runAttempt struct {
+		runId   string
+		attempt int
+	}
+	runAttemptKey struct{}
+
+	gormHistoryStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	// redisHistoryStore 使用 hash 保存执行记录，zset 按开始时间排序
+	redisHistoryStore struct {
+		rd     rexDao.RedisDao
+		prefix string
+		keep   int
+	}
+
+	CrontabJobRun struct {
+		rexDatabase.BaseModel
+		RunId      string     `gorm:"uniqueIndex:idx_crontab_job_run_id;column:run_id;comment:执行id;type: varchar(64)" json:"run_id"`
+		TaskUuid   string     `gorm:"index:idx_crontab_job_run_task,priority:1;column:task_uuid;comment:任务uuid;type: varchar(64)" json:"task_uuid"`
+		TaskName   string     `gorm:"column:task_name;comment:任务名称;type: varchar(255)" json:"task_name"`
+		NodeId     string     `gorm:"column:node_id;comment:执行节点;type: varchar(255)" json:"node_id"`
+		Manual     bool       `gorm:"column:manual;comment:是否手动触发" json:"manual"`
+		FireAt     *time.Time `gorm:"column:fire_at;comment:计划触发时间" json:"fire_at"`
+		StartAt    time.Time  `gorm:"index:idx_crontab_job_run_task,priority:2;column:start_at;comment:开始时间" json:"start_at"`
+		EndAt      *time.Time `gorm:"column:end_at;comment:结束时间" json:"end_at"`
+		DurationMs int64      `gorm:"column:duration_ms;comment:耗时毫秒;type: bigint" json:"duration_ms"`
+		Attempts   int        `gorm:"column:attempts;comment:执行次数;type: int" json:"attempts"`
+		Status     RunStatus  `gorm:"column:status;comment:状态 running|success|failed|timeout;type: varchar(16)" json:"status"`
+		Error      string     `gorm:"column:error;comment:错误信息;type: text" json:"error"`
+	}
+)
+
+func (CrontabJobRun) TableName() string {
+	return DefaultJobRunTableName
+}
+
+// WithHistory 记录每次执行，写入失败只记录日志，不影响任务执行
+func WithHistory(store HistoryStore) PoolOption {
+	return func(c *CrontabPool) {
+		c.history = store
+	}
+}
+
+// WithOnFailure 执行失败（包括超时和 panic）并且重试用尽后回调，可以用来告警
+func WithOnFailure(fn func(ctx context.Context, run *JobRun)) PoolOption {
+	return func(c *CrontabPool) {
+		c.onFailure = fn
+	}
+}
+
+// History 查询任务最近的执行记录，没有配置 HistoryStore 时返回空
+func (c *CrontabPool) History(ctx context.Context, taskUuid string, limit int) ([]*JobRun, error) {
+	if c.history == nil {
+		return nil, nil
+	}
+	return c.history.Recent(ctx, taskUuid, limit)
+}
+
+// RunAttemptFromCtx 返回任务池当前执行的 RunId 和第几次尝试（从 1 开始），同一次执行的重试 RunId 相同；
+// 不是由任务池执行时返回 false
+func RunAttemptFromCtx(ctx context.Context) (string, int, bool) {
+	a, ok := ctx.Value(runAttemptKey{}).(runAttempt)
+	return a.runId, a.attempt, ok
+}
+
+// run 按任务配置包装 job 并执行一次，记录执行历史，ctx 结束时 job 会收到取消
+func (c *CrontabPool) run(parent context.Context, task Task, fireAt time.Time) *JobRun {
+	run := &JobRun{
+		RunId:    uuid.NewString(),
+		TaskUuid: task.TaskUuid,
+		TaskName: task.Name,
+		NodeId:   c.NodeId(),
+		Manual:   fireAt.IsZero(),
+		FireAt:   fireAt,
+		StartAt:  time.Now(),
+		Status:   RunStatusRunning,
+	}
+	c.recordRun(run)
+
+	job := Chain(AsCtxJob(task.Job),
+		RetryWrapper(task.Options.Retry),
+		func(next CtxJob) CtxJob {
+			return JobFunc(func(ctx context.Context) error {
+				run.Attempts++
+				return next.RunCtx(context.WithValue(ctx, runAttemptKey{}, runAttempt{runId: run.RunId, attempt: run.Attempts}))
+			})
+		},
+		RecoverWrapper(),
+		TimeoutWrapper(task.Options.Timeout),
+	)
+	ctx := logx.ContextWithFields(parent, logx.Field("task_uuid", task.TaskUuid), logx.Field("run_id", run.RunId))
+	err := job.RunCtx(ctx)
+
+	run.EndAt = time.Now()
+	run.Duration = run.EndAt.Sub(run.StartAt)
+	switch {
+	case err == nil:
+		run.Status = RunStatusSuccess
+	case errors.Is(err, ErrJobTimeout):
+		run.Status = RunStatusTimeout
+	default:
+		run.Status = RunStatusFailed
+	}
+	if err != nil {
+		run.Error = err.Error()
+		logx.WithContext(ctx).Errorf("task run failed, and task uuid = %s, attempts = %d, duration = %s, err = %v", task.TaskUuid, run.Attempts, run.Duration, err)
+	} else {
+		logx.WithContext(ctx).Infof("task run success, and task uuid = %s, attempts = %d, duration = %s", task.TaskUuid, run.Attempts, run.Duration)
+	}
+	c.recordRun(run)
+	if err != nil && c.onFailure != nil {
+		c.onFailure(ctx, run)
+	}
+	return run
+}
+
+func (c *CrontabPool) recordRun(run *JobRun) {
+	if c.history == nil {
+		return
+	}
+	// note: 任务池关闭时仍然要写入最后的结果
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.stopCtx), historyWriteTimeout)
+	defer cancel()
+	saved := *run
+	if err := c.history.Record(ctx, &saved); err != nil {
+		logx.Errorf("task run record failed, and task uuid = %s, run id = %s, err = %v", run.TaskUuid, run.RunId, err)
+	}
+}
+
+// NewGormHistoryStore tableName 为空时使用 crontab_job_runs，表结构见 CrontabJobRun
+func NewGormHistoryStore(dao rexDao.Dao, tableName string) HistoryStore {
+	if tableName == "" {
+		tableName = DefaultJobRunTableName
+	}
+	return &gormHistoryStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormHistoryStore) Record(ctx context.Context, run *JobRun) error {
+	row := CrontabJobRun{
+		RunId:      run.RunId,
+		TaskUuid:   run.TaskUuid,
+		TaskName:   run.TaskName,
+		NodeId:     run.NodeId,
+		Manual:     run.Manual,
+		StartAt:    run.StartAt,
+		DurationMs: run.Duration.Milliseconds(),
+		Attempts:   run.Attempts,
+		Status:     run.Status,
+		Error:      run.Error,
+	}
+	if !run.FireAt.IsZero() {
+		row.FireAt = &run.FireAt
+	}
+	if !run.EndAt.IsZero() {
+		row.EndAt = &run.EndAt
+	}
+	return s.dao.GetDB().WithContext(ctx).Table(s.tableName).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "run_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"end_at", "duration_ms", "attempts", "status", "error", "updated_at"}),
+	}).Create(&row).Error
+}
+
+func (s *gormHistoryStore) Recent(ctx context.Context, taskUuid string, limit int) ([]*JobRun, error) {
+	var rows []CrontabJobRun
+	if err := s.dao.FindAndLimitOrder(ctx, s.tableName, "start_at desc", limit, 0, &rows, "task_uuid = ?", taskUuid); err != nil {
+		return nil, err
+	}
+	runs := make([]*JobRun, 0, len(rows))
+	for _, row := range rows {
+		run := &JobRun{
+			RunId:    row.RunId,
+			TaskUuid: row.TaskUuid,
+			TaskName: row.TaskName,
+			NodeId:   row.NodeId,
+			Manual:   row.Manual,
+			StartAt:  row.StartAt,
+			Duration: time.Duration(row.DurationMs) * time.Millisecond,
+			Attempts: row.Attempts,
+			Status:   row.Status,
+			Error:    row.Error,
+		}
+		if row.FireAt != nil {
+			run.FireAt = *row.FireAt
+		}
+		if row.EndAt != nil {
+			run.EndAt = *row.EndAt
+		}
+		runs = append(runs, run)
+	}
+	return runs, nil
+}
+
+// NewRedisHistoryStore 执行记录保存在 {prefix}:crontab-runs:{taskUuid}，每个任务最多保留 keep 条，
+// keep 小于等于 0 时使用 DefaultRedisHistoryKeep
+func NewRedisHistoryStore(rd rexDao.RedisDao, prefix string, keep int) HistoryStore {
+	if keep <= 0 {
+		keep = DefaultRedisHistoryKeep
+	}
+	return &redisHistoryStore{
+		rd:     rd,
+		prefix: prefix,
+		keep:   keep,
+	}
+}
+
+func (s *redisHistoryStore) keys(taskUuid string) (runKey, indexKey string) {
+	runKey = fmt.Sprintf("%s:crontab-runs:%s", s.prefix, taskUuid)
+	return runKey, runKey + ":index"
+}
+
+func (s *redisHistoryStore) Record(ctx context.Context, run *JobRun) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+	runKey, indexKey := s.keys(run.TaskUuid)
+	// note: 先写记录再写索引，索引中的 RunId 总能找到记录，Recent 会跳过已经被清理的记录
+	if _, err := s.rd.HSetCtx(ctx, runKey, run.RunId, string(data)); err != nil {
+		return err
+	}
+	if _, err := s.rd.ZAddCtx(ctx, indexKey, float64(run.StartAt.UnixMilli()), run.RunId); err != nil {
+		return err
+	}
+	count, err := s.rd.ZCardCtx(ctx, indexKey)
+	if err != nil || count <= s.keep {
+		return err
+	}
+	expired, err := s.rd.ZRangeByScoreCtx(ctx, indexKey, math.Inf(-1), math.Inf(1), int64(count-s.keep))
+	if err != nil || len(expired) == 0 {
+		return err
+	}
+	ids := make([]string, 0, len(expired))
+	for _, z := range expired {
+		ids = append(ids, z.Member.(string))
+	}
+	if _, err := s.rd.ZRemCtx(ctx, indexKey, ids...); err != nil {
+		return err
+	}
+	_, err = s.rd.HDelCtx(ctx, runKey, ids...)
+	return err
+}
+
+func (s *redisHistoryStore) Recent(ctx context.Context, taskUuid string, limit int) ([]*JobRun, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	runKey, indexKey := s.keys(taskUuid)
+	// note: 每个任务最多保留 keep 条，直接读出全部索引再取最新的 limit 条
+	index, err := s.rd.ZRangeByScoreCtx(ctx, indexKey, math.Inf(-1), math.Inf(1), 0)
+	if err != nil || len(index) == 0 {
+		return nil, err
+	}
+	ids := make([]string, 0, limit)
+	for i := len(index) - 1; i >= 0 && len(ids) < limit; i-- {
+		ids = append(ids, index[i].Member.(string))
+	}
+	values, err := s.rd.HMGetCtx(ctx, runKey, ids...)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]*JobRun, 0, len(values))
+	for _, data := range values {
+		if data == "" {
+			continue
+		}
+		var run JobRun
+		if err := json.Unmarshal([]byte(data), &run); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &run)
+	}
+	return runs, nil
+}
@@ -0,0 +1,212 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type memoryHistoryStore struct {
+	lock sync.Mutex
+	runs map[string]JobRun
+}
+
+func (s *memoryHistoryStore) Record(ctx context.Context, run *JobRun) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.runs[run.RunId] = *run
+	return nil
+}
+
+func (s *memoryHistoryStore) Recent(ctx context.Context, taskUuid string, limit int) ([]*JobRun, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var runs []*JobRun
+	for _, run := range s.runs {
+		if run.TaskUuid == taskUuid {
+			run := run
+			runs = append(runs, &run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartAt.After(runs[j].StartAt)
+	})
+	if len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func TestCrontabPoolHistory(t *testing.T) {
+	ctx := context.Background()
+	history := &memoryHistoryStore{runs: map[string]JobRun{}}
+	failed := make(chan *JobRun, 10)
+	c := NewCrontabPool(WithHistory(history), WithOnFailure(func(ctx context.Context, run *JobRun) {
+		failed <- run
+	}))
+	defer c.Shutdown(ctx)
+
+	calls := 0
+	tasks := []*Task{
+		{TaskUuid: "flaky", Spec: "@every 1h", Options: TaskOptions{Retry: RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}},
+			Job: JobFunc(func(ctx context.Context) error {
+				if calls++; calls < 3 {
+					return errors.New("boom")
+				}
+				return nil
+			})},
+		{TaskUuid: "panic", Spec: "@every 1h", Job: JobFunc(func(ctx context.Context) error {
+			panic("bad payload")
+		})},
+		{TaskUuid: "slow", Spec: "@every 1h", Options: TaskOptions{Timeout: 10 * time.Millisecond},
+			Job: JobFunc(func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			})},
+	}
+	for _, task := range tasks {
+		if err := c.Add(ctx, task); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		if err := c.TriggerNow(ctx, task.TaskUuid); err != nil {
+			t.Fatalf("TriggerNow() error = %v", err)
+		}
+	}
+
+	want := map[string]struct {
+		status   RunStatus
+		attempts int
+	}{
+		"flaky": {RunStatusSuccess, 3},
+		"panic": {RunStatusFailed, 1},
+		"slow":  {RunStatusTimeout, 1},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for taskUuid, w := range want {
+		for {
+			runs, _ := c.History(ctx, taskUuid, 10)
+			if len(runs) == 1 && runs[0].Status != RunStatusRunning {
+				run := runs[0]
+				if run.Status != w.status || run.Attempts != w.attempts || !run.Manual || run.NodeId == "" {
+					t.Errorf("History(%s) = %+v, want status %s attempts %d", taskUuid, run, w.status, w.attempts)
+				}
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("History(%s) = %v, want a finished run", taskUuid, runs)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case run := <-failed:
+			got[run.TaskUuid] = true
+		case <-time.After(time.Second):
+			t.Fatal("OnFailure was not called")
+		}
+	}
+	if !got["panic"] || !got["slow"] {
+		t.Errorf("OnFailure tasks = %v, want panic and slow", got)
+	}
+}
+
+func newTestGormHistoryStore(t *testing.T) HistoryStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&CrontabJobRun{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormHistoryStore(rexDao.NewDao(db), "")
+}
+
+func TestHistoryStore(t *testing.T) {
+	cases := []struct {
+		name     string
+		newStore func(t *testing.T) HistoryStore
+		wantKept int
+	}{
+		{name: "gorm", newStore: newTestGormHistoryStore, wantKept: 4},
+		// note: keep 为 3，第 4 条记录写入后最早的记录被清理
+		{name: "redis", newStore: func(t *testing.T) HistoryStore {
+			return NewRedisHistoryStore(rexDao.NewMemoryRedisDao(), "test", 3)
+		}, wantKept: 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := c.newStore(t)
+			start := time.UnixMilli(1700000000000)
+			for i := 0; i < 4; i++ {
+				run := &JobRun{RunId: fmt.Sprintf("run-%d", i), TaskUuid: "job", StartAt: start.Add(time.Duration(i) * time.Minute),
+					FireAt: start, Attempts: 1, Status: RunStatusRunning}
+				if err := s.Record(ctx, run); err != nil {
+					t.Fatalf("Record(%s) error = %v", run.RunId, err)
+				}
+			}
+			_ = s.Record(ctx, &JobRun{RunId: "other", TaskUuid: "other", StartAt: start})
+			// note: 结束时按 RunId 覆盖开始时写入的记录
+			done := &JobRun{RunId: "run-3", TaskUuid: "job", StartAt: start.Add(3 * time.Minute), FireAt: start,
+				EndAt: start.Add(4 * time.Minute), Duration: time.Minute, Attempts: 2, Status: RunStatusFailed, Error: "boom"}
+			if err := s.Record(ctx, done); err != nil {
+				t.Fatalf("Record(done) error = %v", err)
+			}
+
+			runs, err := s.Recent(ctx, "job", 2)
+			if err != nil || len(runs) != 2 {
+				t.Fatalf("Recent() = %v, %v, want 2 runs", runs, err)
+			}
+			got := runs[0]
+			if got.RunId != "run-3" || runs[1].RunId != "run-2" {
+				t.Errorf("Recent() = [%s %s], want newest first", got.RunId, runs[1].RunId)
+			}
+			if got.Status != RunStatusFailed || got.Error != "boom" || got.Attempts != 2 || got.Duration != time.Minute ||
+				!got.EndAt.Equal(done.EndAt) || !got.FireAt.Equal(start) {
+				t.Errorf("Recent()[0] = %+v, want the finished run", got)
+			}
+			if all, _ := s.Recent(ctx, "job", 10); len(all) != c.wantKept {
+				t.Errorf("Recent() = %d runs, want %d", len(all), c.wantKept)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	for attempt, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 5: time.Second, 30: time.Second} {
+		if got := p.backoff(attempt); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", attempt, got, want)
+		}
+	}
+}
+
+func TestRetryWrapperNoRetry(t *testing.T) {
+	calls := 0
+	job := RetryWrapper(RetryPolicy{MaxAttempts: 5})(JobFunc(func(ctx context.Context) error {
+		calls++
+		if _, attempt, ok := RunAttemptFromCtx(ctx); ok || attempt != 0 {
+			t.Errorf("RunAttemptFromCtx() outside the pool = %d, %v", attempt, ok)
+		}
+		return fmt.Errorf("bad config: %w", ErrJobNoRetry)
+	}))
+	if err := job.RunCtx(context.Background()); !errors.Is(err, ErrJobNoRetry) {
+		t.Errorf("RunCtx() error = %v, want ErrJobNoRetry", err)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
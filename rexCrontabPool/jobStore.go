@@ -0,0 +1,220 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultJobTableName = "crontab_jobs"
+)
+
+var (
+	ErrJobTypeNotRegistered = errors.New("job 类型未注册")
+	ErrVersionConflict      = errors.New("任务已被修改，版本不一致")
+)
+
+type (
+	// JobRecord 持久化的任务，重启或其他节点加载时由 JobType 对应的 JobFactory 根据 Payload 创建 job
+	JobRecord struct {
+		TaskUuid string
+		Name     string
+		Spec     string
+		JobType  string
+		Payload  string
+		Options  TaskOptions
+		Enabled  bool
+		// Version 由 JobStore 维护，每次修改加 1，节点同步时用来判断任务是否变化
+		Version int64
+	}
+
+	// JobStore 保存动态创建的任务，Create 和 Update 成功后需要把新的 Version 写回 record
+	JobStore interface {
+		// Create uuid 已存在时返回 ErrTaskExists
+		Create(ctx context.Context, record *JobRecord) error
+		// Update record.Version 为修改前的版本，uuid 不存在时返回 ErrTaskNotFound，版本不一致时返回 ErrVersionConflict
+		Update(ctx context.Context, record *JobRecord) error
+		Delete(ctx context.Context, taskUuid string) error
+		// Get uuid 不存在时返回 ErrTaskNotFound
+		Get(ctx context.Context, taskUuid string) (*JobRecord, error)
+		List(ctx context.Context) ([]*JobRecord, error)
+	}
+
+	// JobFactory 根据持久化的参数创建 job，依赖（dao、client 等）通过闭包传入
+	JobFactory func(record *JobRecord) (cron.Job, error)
+
+	gormJobStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	CrontabJob struct {
+		rexDatabase.BaseModel
+		TaskUuid string `gorm:"uniqueIndex:idx_crontab_job_uuid;column:task_uuid;comment:任务uuid;type: varchar(64)" json:"task_uuid"`
+		Name     string `gorm:"column:name;comment:任务名称;type: varchar(255)" json:"name"`
+		Spec     string `gorm:"column:spec;comment:cron表达式;type: varchar(255)" json:"spec"`
+		JobType  string `gorm:"index:idx_crontab_job_type;column:job_type;comment:任务类型;type: varchar(64)" json:"job_type"`
+		Payload  string `gorm:"column:payload;comment:任务参数;type: text" json:"payload"`
+		Options  string `gorm:"column:options;comment:执行选项json;type: text" json:"options"`
+		Enabled  bool   `gorm:"column:enabled;comment:是否启用" json:"enabled"`
+		Version  int64  `gorm:"column:version;comment:版本号，每次修改加1;type: bigint" json:"version"`
+	}
+)
+
+var (
+	jobFactoryLock sync.RWMutex
+	jobFactories   = map[string]JobFactory{}
+)
+
+func (CrontabJob) TableName() string {
+	return DefaultJobTableName
+}
+
+// RegisterJobFactory 注册 job 类型，重复注册会覆盖之前的 factory
+func RegisterJobFactory(jobType string, factory JobFactory) {
+	jobFactoryLock.Lock()
+	defer jobFactoryLock.Unlock()
+	jobFactories[jobType] = factory
+}
+
+func buildJob(record *JobRecord) (cron.Job, error) {
+	jobFactoryLock.RLock()
+	factory, ok := jobFactories[record.JobType]
+	jobFactoryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobTypeNotRegistered, record.JobType)
+	}
+	return factory(record)
+}
+
+// NewGormJobStore tableName 为空时使用 crontab_jobs，表结构见 CrontabJob
+func NewGormJobStore(dao rexDao.Dao, tableName string) JobStore {
+	if tableName == "" {
+		tableName = DefaultJobTableName
+	}
+	return &gormJobStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormJobStore) Create(ctx context.Context, record *JobRecord) error {
+	var count int64
+	if err := s.dao.GetDB().WithContext(ctx).Table(s.tableName).Where("task_uuid = ?", record.TaskUuid).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", ErrTaskExists, record.TaskUuid)
+	}
+	options, err := json.Marshal(record.Options)
+	if err != nil {
+		return err
+	}
+	row := CrontabJob{
+		TaskUuid: record.TaskUuid,
+		Name:     record.Name,
+		Spec:     record.Spec,
+		JobType:  record.JobType,
+		Payload:  record.Payload,
+		Options:  string(options),
+		Enabled:  record.Enabled,
+		Version:  1,
+	}
+	// note: 并发创建时由唯一索引兜底
+	if err := s.dao.Create(ctx, s.tableName, &row); err != nil {
+		return err
+	}
+	record.Version = row.Version
+	return nil
+}
+
+// Update 按 uuid 和版本号更新，版本号在同一条语句中加 1，并发修改时只有一个成功
+func (s *gormJobStore) Update(ctx context.Context, record *JobRecord) error {
+	options, err := json.Marshal(record.Options)
+	if err != nil {
+		return err
+	}
+	tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).
+		Where("task_uuid = ? AND version = ?", record.TaskUuid, record.Version).
+		Updates(map[string]interface{}{
+			"name":       record.Name,
+			"spec":       record.Spec,
+			"job_type":   record.JobType,
+			"payload":    record.Payload,
+			"options":    string(options),
+			"enabled":    record.Enabled,
+			"version":    record.Version + 1,
+			"updated_at": time.Now(),
+		})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		if _, err := s.Get(ctx, record.TaskUuid); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s, version %d", ErrVersionConflict, record.TaskUuid, record.Version)
+	}
+	record.Version++
+	return nil
+}
+
+func (s *gormJobStore) Delete(ctx context.Context, taskUuid string) error {
+	// note: 物理删除，否则软删除的记录会占用 uuid 唯一索引
+	return s.dao.DeleteWhereAny(ctx, s.tableName, &CrontabJob{}, true, "task_uuid = ?", taskUuid)
+}
+
+func (s *gormJobStore) Get(ctx context.Context, taskUuid string) (*JobRecord, error) {
+	var row CrontabJob
+	err := s.dao.First(ctx, s.tableName, &row, "task_uuid = ?", taskUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.record()
+}
+
+func (s *gormJobStore) List(ctx context.Context) ([]*JobRecord, error) {
+	var rows []CrontabJob
+	if err := s.dao.Find(ctx, s.tableName, &rows, "1 = 1"); err != nil {
+		return nil, err
+	}
+	records := make([]*JobRecord, 0, len(rows))
+	for i := range rows {
+		record, err := rows[i].record()
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (j *CrontabJob) record() (*JobRecord, error) {
+	record := &JobRecord{
+		TaskUuid: j.TaskUuid,
+		Name:     j.Name,
+		Spec:     j.Spec,
+		JobType:  j.JobType,
+		Payload:  j.Payload,
+		Enabled:  j.Enabled,
+		Version:  j.Version,
+	}
+	if j.Options != "" {
+		if err := json.Unmarshal([]byte(j.Options), &record.Options); err != nil {
+			return nil, fmt.Errorf("task %s options: %w", j.TaskUuid, err)
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,82 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestGormJobStore(t *testing.T) JobStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&CrontabJob{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormJobStore(rexDao.NewDao(db), "")
+}
+
+func TestGormJobStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestGormJobStore(t)
+
+	record := &JobRecord{TaskUuid: "job", Name: "report", Spec: "@every 1h", JobType: "noop", Payload: "{}",
+		Options: TaskOptions{Timeout: time.Minute}, Enabled: true}
+	if err := store.Create(ctx, record); err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if record.Version != 1 {
+		t.Errorf("Create() version = %d, want 1", record.Version)
+	}
+	if err := store.Create(ctx, &JobRecord{TaskUuid: "job"}); !errors.Is(err, ErrTaskExists) {
+		t.Errorf("Create() duplicate error = %v, want %v", err, ErrTaskExists)
+	}
+
+	stale := *record
+	record.Enabled = false
+	if err := store.Update(ctx, record); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if record.Version != 2 {
+		t.Errorf("Update() version = %d, want 2", record.Version)
+	}
+	// note: 旧版本的修改不能覆盖新版本
+	stale.Name = "stale"
+	if err := store.Update(ctx, &stale); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Update() stale error = %v, want %v", err, ErrVersionConflict)
+	}
+	if err := store.Update(ctx, &JobRecord{TaskUuid: "missing", Version: 1}); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Update() missing error = %v, want %v", err, ErrTaskNotFound)
+	}
+
+	got, err := store.Get(ctx, "job")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if got.Name != "report" || got.Enabled || got.Version != 2 || got.Options.Timeout != time.Minute {
+		t.Errorf("Get() = %+v", got)
+	}
+	list, err := store.List(ctx)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("List() = %v, %v", list, err)
+	}
+
+	if err := store.Delete(ctx, "job"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get(ctx, "job"); !errors.Is(err, ErrTaskNotFound) {
+		t.Errorf("Get() after Delete error = %v, want %v", err, ErrTaskNotFound)
+	}
+	// note: 物理删除后可以重新创建同一个 uuid
+	if err := store.Create(ctx, &JobRecord{TaskUuid: "job", JobType: "noop"}); err != nil {
+		t.Errorf("Create() after Delete error = %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+// Load 从 JobStore 全量同步持久化任务：新增缺少的任务，替换版本变化的任务，删除已不存在的任务，
+// 启动时调用一次加载重启前的任务；JobType 未注册的任务会被跳过并返回错误
+func (c *CrontabPool) Load(ctx context.Context) error {
+	if c.store == nil {
+		return nil
+	}
+	// note: 只删除 List 之前已经存在的任务，之后 Add 的任务不在快照中，不会被误删
+	c.lock.RLock()
+	loaded := make(map[string]*taskEntry, len(c.tasks))
+	for taskUuid, e := range c.tasks {
+		if e.persisted {
+			loaded[taskUuid] = e
+		}
+	}
+	c.lock.RUnlock()
+	records, err := c.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{}, len(records))
+	var errs []error
+	for _, record := range records {
+		seen[record.TaskUuid] = struct{}{}
+		if err := c.apply(ctx, record); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	c.lock.Lock()
+	for taskUuid, e := range loaded {
+		if _, ok := seen[taskUuid]; !ok && c.tasks[taskUuid] == e && e.persisted {
+			c.unschedule(e)
+			delete(c.tasks, taskUuid)
+			logx.WithContext(ctx).Infof("task sync removed, and task uuid = %s", taskUuid)
+		}
+	}
+	c.lock.Unlock()
+	return errors.Join(errs...)
+}
+
+// syncOne 同步单个任务，JobStore 中不存在时删除本地的持久化任务
+func (c *CrontabPool) syncOne(ctx context.Context, taskUuid string) error {
+	c.lock.RLock()
+	loaded := c.tasks[taskUuid]
+	c.lock.RUnlock()
+	record, err := c.store.Get(ctx, taskUuid)
+	if errors.Is(err, ErrTaskNotFound) {
+		c.lock.Lock()
+		// note: Get 之后新添加的任务不删除
+		if e, ok := c.tasks[taskUuid]; ok && e == loaded && e.persisted {
+			c.unschedule(e)
+			delete(c.tasks, taskUuid)
+			logx.WithContext(ctx).Infof("task sync removed, and task uuid = %s", taskUuid)
+		}
+		c.lock.Unlock()
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return c.apply(ctx, record)
+}
+
+// apply 把 JobStore 中的任务应用到本地，版本相同时跳过，以 JobStore 为准
+func (c *CrontabPool) apply(ctx context.Context, record *JobRecord) error {
+	c.lock.RLock()
+	e, ok := c.tasks[record.TaskUuid]
+	same := ok && e.persisted && e.version == record.Version
+	c.lock.RUnlock()
+	if same {
+		return nil
+	}
+
+	updated, err := c.newEntry(&Task{
+		TaskUuid: record.TaskUuid,
+		Name:     record.Name,
+		Spec:     record.Spec,
+		JobType:  record.JobType,
+		Payload:  record.Payload,
+		Options:  record.Options,
+	})
+	if err != nil {
+		return err
+	}
+	updated.paused = !record.Enabled
+	updated.version = record.Version
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.isClosed() {
+		return ErrPoolClosed
+	}
+	if e, ok := c.tasks[record.TaskUuid]; ok {
+		// note: 并发同步时可能已经应用了更新的版本
+		if e.persisted && e.version >= record.Version {
+			return nil
+		}
+		c.replace(e, updated)
+		logx.WithContext(ctx).Infof("task sync updated, and task uuid = %s, version = %d", record.TaskUuid, record.Version)
+		return nil
+	}
+	if !updated.paused {
+		c.schedule(updated)
+	}
+	c.tasks[record.TaskUuid] = updated
+	logx.WithContext(ctx).Infof("task sync added, and task uuid = %s, version = %d", record.TaskUuid, record.Version)
+	go c.catchUp(updated)
+	return nil
+}
+
+// notify 通知其他节点同步持久化任务，失败时依赖定期全量同步
+func (c *CrontabPool) notify(ctx context.Context, taskUuid string, persisted bool) {
+	if c.syncStore == nil || !persisted {
+		return
+	}
+	if err := c.syncStore.PublishCtx(ctx, c.syncChannel, taskUuid); err != nil {
+		logx.WithContext(ctx).Errorf("task sync notify failed, and task uuid = %s, err = %v", taskUuid, err)
+	}
+}
+
+// startSync 启动后台同步，Shutdown 时停止
+func (c *CrontabPool) startSync() {
+	if c.store == nil {
+		return
+	}
+	if c.syncStore != nil {
+		go func() {
+			err := c.syncStore.NewWatcherCtx(c.stopCtx, c.syncChannel, func(msg *redis.Message) {
+				if err := c.syncOne(c.stopCtx, msg.Payload); err != nil {
+					logx.Errorf("task sync failed, and task uuid = %s, err = %v", msg.Payload, err)
+				}
+			})
+			if err != nil {
+				logx.Errorf("task sync watcher stopped, err = %v", err)
+			}
+		}()
+	}
+	if c.syncInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(c.syncInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.stopCtx.Done():
+					return
+				case <-ticker.C:
+					if err := c.Load(c.stopCtx); err != nil {
+						logx.Errorf("task sync load failed, err = %v", err)
+					}
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,183 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
+)
+
+type memoryJobStore struct {
+	lock    sync.Mutex
+	records map[string]JobRecord
+}
+
+func newMemoryJobStore() *memoryJobStore {
+	return &memoryJobStore{records: map[string]JobRecord{}}
+}
+
+func (s *memoryJobStore) Create(ctx context.Context, record *JobRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.records[record.TaskUuid]; ok {
+		return fmt.Errorf("%w: %s", ErrTaskExists, record.TaskUuid)
+	}
+	record.Version = 1
+	s.records[record.TaskUuid] = *record
+	return nil
+}
+
+func (s *memoryJobStore) Update(ctx context.Context, record *JobRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old, ok := s.records[record.TaskUuid]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTaskNotFound, record.TaskUuid)
+	}
+	if old.Version != record.Version {
+		return fmt.Errorf("%w: %s", ErrVersionConflict, record.TaskUuid)
+	}
+	record.Version = old.Version + 1
+	s.records[record.TaskUuid] = *record
+	return nil
+}
+
+func (s *memoryJobStore) Delete(ctx context.Context, taskUuid string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.records, taskUuid)
+	return nil
+}
+
+func (s *memoryJobStore) Get(ctx context.Context, taskUuid string) (*JobRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record, ok := s.records[taskUuid]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTaskNotFound, taskUuid)
+	}
+	return &record, nil
+}
+
+func (s *memoryJobStore) List(ctx context.Context) ([]*JobRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	records := make([]*JobRecord, 0, len(s.records))
+	for _, record := range s.records {
+		record := record
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+func TestCrontabPoolJobSync(t *testing.T) {
+	RegisterJobFactory("noop", func(record *JobRecord) (cron.Job, error) {
+		return cron.FuncJob(func() {}), nil
+	})
+	ctx := context.Background()
+	store := newMemoryJobStore()
+	rd := rexDao.NewMemoryRedisDao()
+	defer rd.Close()
+
+	a := NewCrontabPool(WithJobStore(store), WithJobSync(rd, "", time.Second))
+	b := NewCrontabPool(WithJobStore(store), WithJobSync(rd, "", time.Second))
+	for _, pool := range []*CrontabPool{a, b} {
+		if err := pool.Load(ctx); err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		pool.Start()
+		defer pool.Shutdown(ctx)
+	}
+	// note: 等待订阅建立，错过通知时依赖定期同步
+	time.Sleep(20 * time.Millisecond)
+
+	if err := a.Add(ctx, &Task{TaskUuid: "job", Name: "report", Spec: "@every 1h", JobType: "missing"}); !errors.Is(err, ErrJobTypeNotRegistered) {
+		t.Errorf("Add() error = %v, want %v", err, ErrJobTypeNotRegistered)
+	}
+	if err := a.Add(ctx, &Task{TaskUuid: "job", Name: "report", Spec: "@every 1h", JobType: "noop", Payload: "{}"}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	waitTask(t, b, "job", func(info *TaskInfo) bool {
+		return info != nil && info.Version == 1 && !info.Paused
+	})
+
+	if err := a.Pause(ctx, "job"); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	waitTask(t, b, "job", func(info *TaskInfo) bool {
+		return info != nil && info.Paused
+	})
+
+	// note: 新节点启动时从 JobStore 加载
+	c := NewCrontabPool(WithJobStore(store))
+	if err := c.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if info, err := c.Get("job"); err != nil || !info.Paused || info.Name != "report" {
+		t.Errorf("Get() after Load = %+v, %v", info, err)
+	}
+
+	if err := a.Remove(ctx, "job"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	waitTask(t, b, "job", func(info *TaskInfo) bool {
+		return info == nil
+	})
+}
+
+func waitTask(t *testing.T, c *CrontabPool, taskUuid string, ok func(info *TaskInfo) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		info, _ := c.Get(taskUuid)
+		if ok(info) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	info, err := c.Get(taskUuid)
+	t.Fatalf("task %s not synced, info = %+v, err = %v", taskUuid, info, err)
+}
+
+func TestCrontabPoolUpdateConflict(t *testing.T) {
+	RegisterJobFactory("noop", func(record *JobRecord) (cron.Job, error) {
+		return cron.FuncJob(func() {}), nil
+	})
+	ctx := context.Background()
+	store := newMemoryJobStore()
+	c := NewCrontabPool(WithJobStore(store))
+	defer c.Shutdown(ctx)
+
+	task := &Task{TaskUuid: "job", Name: "report", Spec: "@every 1h", JobType: "noop"}
+	if err := c.Add(ctx, task); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	// note: 其他节点修改了任务，本地还是旧版本
+	record, _ := store.Get(ctx, "job")
+	record.Name = "other"
+	if err := store.Update(ctx, record); err != nil {
+		t.Fatalf("store.Update() error = %v", err)
+	}
+	task.Name = "local"
+	if err := c.Update(ctx, task); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Update() error = %v, want %v", err, ErrVersionConflict)
+	}
+	if err := c.Pause(ctx, "job"); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("Pause() error = %v, want %v", err, ErrVersionConflict)
+	}
+
+	if err := c.Load(ctx); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := c.Update(ctx, task); err != nil {
+		t.Fatalf("Update() after Load error = %v", err)
+	}
+	if info, _ := c.Get("job"); info.Name != "local" || info.Version != 3 {
+		t.Errorf("Get() = %+v", info)
+	}
+}
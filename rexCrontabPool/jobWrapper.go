@@ -0,0 +1,148 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+var (
+	ErrJobTimeout = errors.New("任务执行超时")
+	ErrJobPanic   = errors.New("任务执行 panic")
+	// ErrJobNoRetry job 返回的错误包含它时 RetryWrapper 不再重试，用于重试也不会成功的错误
+	ErrJobNoRetry = errors.New("任务失败，不再重试")
+)
+
+type (
+	// CtxJob 可以感知超时并返回错误的 job，任务池执行实现了 CtxJob 的 job 时优先调用 RunCtx
+	CtxJob interface {
+		RunCtx(ctx context.Context) error
+	}
+
+	// JobFunc 同时实现 cron.Job 和 CtxJob
+	JobFunc func(ctx context.Context) error
+
+	// JobWrapper 包装 CtxJob，和 cron.JobWrapper 一样先传入的在最外层
+	JobWrapper func(job CtxJob) CtxJob
+
+	// RetryPolicy 失败后的重试策略，第 n 次重试前等待 Backoff * 2^(n-1)，不超过 MaxBackoff
+	RetryPolicy struct {
+		// MaxAttempts 最多执行的次数，包含第一次，小于等于 1 时不重试
+		MaxAttempts int           `json:"max_attempts,omitempty"`
+		Backoff     time.Duration `json:"backoff,omitempty"`
+		MaxBackoff  time.Duration `json:"max_backoff,omitempty"`
+	}
+
+	cronJobAdapter struct {
+		job cron.Job
+	}
+)
+
+func (f JobFunc) Run() {
+	if err := f(context.Background()); err != nil {
+		logx.Errorf("job run failed, err = %v", err)
+	}
+}
+
+func (f JobFunc) RunCtx(ctx context.Context) error {
+	return f(ctx)
+}
+
+func (a cronJobAdapter) RunCtx(ctx context.Context) error {
+	a.job.Run()
+	return nil
+}
+
+// AsCtxJob 没有实现 CtxJob 的 cron.Job 总是返回 nil，也不会感知超时
+func AsCtxJob(job cron.Job) CtxJob {
+	if ctxJob, ok := job.(CtxJob); ok {
+		return ctxJob
+	}
+	return cronJobAdapter{job: job}
+}
+
+// Chain 依次用 wrappers 包装 job，Chain(job, a, b) 等同于 a(b(job))
+func Chain(job CtxJob, wrappers ...JobWrapper) CtxJob {
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		job = wrappers[i](job)
+	}
+	return job
+}
+
+// TimeoutWrapper 给每次执行设置超时，job 需要响应 ctx 才能提前结束，超时后返回 ErrJobTimeout
+func TimeoutWrapper(timeout time.Duration) JobWrapper {
+	return func(job CtxJob) CtxJob {
+		if timeout <= 0 {
+			return job
+		}
+		return JobFunc(func(ctx context.Context) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := job.RunCtx(ctx)
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return errors.Join(fmt.Errorf("%w: %s", ErrJobTimeout, timeout), err)
+			}
+			return err
+		})
+	}
+}
+
+// RecoverWrapper 把 panic 转换为 ErrJobPanic，并记录堆栈
+func RecoverWrapper() JobWrapper {
+	return func(job CtxJob) CtxJob {
+		return JobFunc(func(ctx context.Context) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logx.WithContext(ctx).Errorf("job panic: %v\n%s", r, debug.Stack())
+					err = fmt.Errorf("%w: %v", ErrJobPanic, r)
+				}
+			}()
+			return job.RunCtx(ctx)
+		})
+	}
+}
+
+// RetryWrapper 失败后按 policy 重试，ctx 结束或者错误包含 ErrJobNoRetry 时停止重试并返回最后一次的错误
+func RetryWrapper(policy RetryPolicy) JobWrapper {
+	return func(job CtxJob) CtxJob {
+		if policy.MaxAttempts <= 1 {
+			return job
+		}
+		return JobFunc(func(ctx context.Context) error {
+			var err error
+			for attempt := 1; ; attempt++ {
+				if err = job.RunCtx(ctx); err == nil || attempt >= policy.MaxAttempts || errors.Is(err, ErrJobNoRetry) {
+					return err
+				}
+				wait := policy.backoff(attempt)
+				logx.WithContext(ctx).Infof("job attempt %d failed, retry after %s, err = %v", attempt, wait, err)
+				timer := time.NewTimer(wait)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return err
+				case <-timer.C:
+				}
+			}
+		})
+	}
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.Backoff
+	for i := 1; i < attempt && wait > 0; i++ {
+		wait *= 2
+		if p.MaxBackoff > 0 && wait >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return wait
+}
@@ -0,0 +1,96 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	// onceSpecPrefix 一次性任务的 spec 格式为 "@at 2006-01-02T15:04:05Z07:00"
+	onceSpecPrefix    = "@at "
+	onceRemoveTimeout = 5 * time.Second
+)
+
+// onceSchedule 只在 at 触发一次，之后 Next 返回零值，cron 不会再调度
+type onceSchedule struct {
+	at time.Time
+}
+
+func (s onceSchedule) Next(t time.Time) time.Time {
+	if t.Before(s.at) {
+		return s.at
+	}
+	return time.Time{}
+}
+
+// RunAt 一次性任务的 spec，任务在 at 执行一次后自动删除；at 已经过去时添加后立即执行，持久化任务重启后同样会补执行
+func RunAt(at time.Time) string {
+	return onceSpecPrefix + at.Truncate(time.Second).Format(time.RFC3339)
+}
+
+// RunAfter 一次性任务的 spec，执行时间在调用时确定，重启不会重新计时
+func RunAfter(delay time.Duration) string {
+	return RunAt(time.Now().Add(delay))
+}
+
+func parseOnceSpec(spec string) (onceSchedule, bool, error) {
+	value, ok := strings.CutPrefix(spec, onceSpecPrefix)
+	if !ok {
+		return onceSchedule{}, false, nil
+	}
+	at, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return onceSchedule{}, true, fmt.Errorf("%w: %s: %v", ErrInvalidSpec, spec, err)
+	}
+	return onceSchedule{at: at}, true, nil
+}
+
+func isOnce(schedule interface{}) bool {
+	_, ok := schedule.(onceSchedule)
+	return ok
+}
+
+// runOverdue 一次性任务错过执行时间（停机或暂停期间）时立即执行，执行时间仍然记为 at，集群模式下不会重复执行
+func (c *CrontabPool) runOverdue(e *taskEntry) {
+	if !c.isStarted() {
+		return
+	}
+	if c.cluster != nil && c.cluster.Mode == ClusterModeLeader && !c.leader.Load() {
+		return
+	}
+	e.recoverLock.Lock()
+	defer e.recoverLock.Unlock()
+	c.lock.RLock()
+	schedule, _ := e.schedule.(onceSchedule)
+	taskUuid := e.task.TaskUuid
+	current := c.tasks[taskUuid]
+	paused := e.paused
+	c.lock.RUnlock()
+	// note: 已经执行完被删除的任务不再执行
+	if current != e || paused || schedule.at.After(time.Now()) {
+		return
+	}
+	logx.Infof("task overdue, and task uuid = %s, run at = %s", taskUuid, schedule.at.Format(time.RFC3339))
+	c.fireAt(e, schedule.at)
+}
+
+// finishOnce 一次性任务执行后删除，任务已经被修改时保留
+func (c *CrontabPool) finishOnce(e *taskEntry, fireAt time.Time) {
+	c.lock.RLock()
+	schedule, ok := e.schedule.(onceSchedule)
+	taskUuid := e.task.TaskUuid
+	same := ok && c.tasks[taskUuid] == e && schedule.at.Equal(fireAt)
+	c.lock.RUnlock()
+	if !same {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.stopCtx), onceRemoveTimeout)
+	defer cancel()
+	if err := c.Remove(ctx, taskUuid); err != nil {
+		logx.Errorf("task once remove failed, and task uuid = %s, err = %v", taskUuid, err)
+	}
+}
@@ -0,0 +1,71 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCrontabPoolRunAt(t *testing.T) {
+	ctx := context.Background()
+	c := NewCrontabPool()
+	c.Start()
+	defer c.Shutdown(ctx)
+
+	var soon, overdue atomic.Int32
+	if err := c.Add(ctx, &Task{TaskUuid: "soon", Spec: RunAfter(time.Second), Job: JobFunc(func(ctx context.Context) error {
+		soon.Add(1)
+		return nil
+	})}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if err := c.Add(ctx, &Task{TaskUuid: "overdue", Spec: RunAt(time.Now().Add(-time.Hour)), Job: JobFunc(func(ctx context.Context) error {
+		overdue.Add(1)
+		return nil
+	})}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if soon.Load() != 1 || overdue.Load() != 1 {
+		t.Errorf("runs soon = %d, overdue = %d, want 1 each", soon.Load(), overdue.Load())
+	}
+	for _, taskUuid := range []string{"soon", "overdue"} {
+		if _, err := c.Get(taskUuid); !errors.Is(err, ErrTaskNotFound) {
+			t.Errorf("Get(%s) error = %v, want the task removed after running", taskUuid, err)
+		}
+	}
+	if _, err := ParseSpec("@at tomorrow"); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("ParseSpec() error = %v, want ErrInvalidSpec", err)
+	}
+}
+
+func TestCrontabPoolOnceLockError(t *testing.T) {
+	ctx := context.Background()
+	locks := newMemoryLockStore()
+	c := NewCrontabPool(WithCluster(ClusterConf{Mode: ClusterModePerFire, Locks: locks}))
+	defer c.Shutdown(ctx)
+
+	var runs atomic.Int32
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := c.Add(ctx, &Task{TaskUuid: "once", Spec: RunAt(at), Job: JobFunc(func(ctx context.Context) error {
+		runs.Add(1)
+		return nil
+	})}); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	c.lock.RLock()
+	e := c.tasks["once"]
+	c.lock.RUnlock()
+
+	// note: 加锁失败时这次触发没有执行，之后补执行还可以重试
+	locks.setErr(errors.New("redis down"))
+	c.fireAt(e, at)
+	locks.setErr(nil)
+	c.fireAt(e, at)
+	c.fireAt(e, at)
+	if got := runs.Load(); got != 1 {
+		t.Errorf("runs = %d, want 1", got)
+	}
+}
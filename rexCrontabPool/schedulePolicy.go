@@ -0,0 +1,332 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type OverlapPolicy string
+
+const (
+	// OverlapAllow 上一次还没结束时照常执行，默认策略
+	OverlapAllow OverlapPolicy = "allow"
+	// OverlapSkip 上一次还没结束时跳过本次触发
+	OverlapSkip OverlapPolicy = "skip"
+	// OverlapQueue 上一次还没结束时排队一次，结束后立即执行，排队期间的其他触发会被跳过
+	OverlapQueue OverlapPolicy = "queue"
+)
+
+type MisfirePolicy string
+
+const (
+	// MisfireIgnore 忽略停机期间错过的触发，默认策略
+	MisfireIgnore MisfirePolicy = "ignore"
+	// MisfireRunOnce 启动后补执行一次，计划触发时间为最后一次错过的时间
+	MisfireRunOnce MisfirePolicy = "run_once"
+	// MisfireRunAll 启动后按顺序补执行每一次错过的触发，最多 MaxMisfireRuns 次
+	MisfireRunAll MisfirePolicy = "run_all"
+)
+
+const (
+	DefaultJobFireTableName = "crontab_job_fires"
+	// MaxMisfireRuns run_all 策略最多补执行的次数，超过时只补最近的
+	MaxMisfireRuns   = 100
+	fireStoreTimeout = 5 * time.Second
+	fireKeyFormat    = "%s:crontab-fire:%s"
+)
+
+var (
+	ErrInvalidTimezone = errors.New("时区错误")
+	ErrInvalidPolicy   = errors.New("任务策略错误")
+)
+
+type (
+	// FireStore 保存每个任务已经处理到的计划触发时间，用来在重启后计算错过的触发
+	FireStore interface {
+		// LastFire 没有记录时 ok 为 false
+		LastFire(ctx context.Context, taskUuid string) (fireAt time.Time, ok bool, err error)
+		// SaveFire 只会往后推进，fireAt 早于已保存的时间时忽略
+		SaveFire(ctx context.Context, taskUuid string, fireAt time.Time) error
+	}
+
+	gormFireStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	redisFireStore struct {
+		rd     rexDao.RedisDao
+		prefix string
+	}
+
+	CrontabJobFire struct {
+		rexDatabase.BaseModel
+		TaskUuid   string    `gorm:"uniqueIndex:idx_crontab_job_fire_uuid;column:task_uuid;comment:任务uuid;type: varchar(64)" json:"task_uuid"`
+		LastFireAt time.Time `gorm:"column:last_fire_at;comment:已处理的计划触发时间" json:"last_fire_at"`
+	}
+)
+
+func (CrontabJobFire) TableName() string {
+	return DefaultJobFireTableName
+}
+
+// WithFireStore 记录每个任务的触发时间，启动后按任务的 Misfire 策略补执行停机期间错过的触发；
+// 不配置时 Misfire 策略不生效
+func WithFireStore(store FireStore) PoolOption {
+	return func(c *CrontabPool) {
+		c.fires = store
+	}
+}
+
+func (o TaskOptions) validate() error {
+	switch o.Overlap {
+	case "", OverlapAllow, OverlapSkip, OverlapQueue:
+	default:
+		return fmt.Errorf("%w: overlap %s", ErrInvalidPolicy, o.Overlap)
+	}
+	switch o.Misfire {
+	case "", MisfireIgnore, MisfireRunOnce, MisfireRunAll:
+	default:
+		return fmt.Errorf("%w: misfire %s", ErrInvalidPolicy, o.Misfire)
+	}
+	if o.Jitter < 0 {
+		return fmt.Errorf("%w: jitter %s", ErrInvalidPolicy, o.Jitter)
+	}
+	return nil
+}
+
+// parseTaskSpec timezone 不为空时给 spec 加上 CRON_TZ 前缀，一次性任务的时间自带时区，忽略 timezone
+func parseTaskSpec(spec, timezone string) (cron.Schedule, error) {
+	if timezone == "" || strings.HasPrefix(spec, onceSpecPrefix) {
+		return ParseSpec(spec)
+	}
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		return nil, fmt.Errorf("%w: %s: 已经配置了 Timezone，不能再使用时区前缀", ErrInvalidSpec, spec)
+	}
+	if _, err := time.LoadLocation(timezone); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrInvalidTimezone, timezone, err)
+	}
+	return ParseSpec("CRON_TZ=" + timezone + " " + spec)
+}
+
+// waitJitter 随机等待 [0, jitter)，任务池关闭时返回 false
+func (c *CrontabPool) waitJitter(jitter time.Duration) bool {
+	if jitter <= 0 {
+		return true
+	}
+	timer := time.NewTimer(rand.N(jitter))
+	defer timer.Stop()
+	select {
+	case <-c.stopCtx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (c *CrontabPool) saveFire(taskUuid string, fireAt time.Time) {
+	if c.fires == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.stopCtx), fireStoreTimeout)
+	defer cancel()
+	if err := c.fires.SaveFire(ctx, taskUuid, fireAt); err != nil {
+		logx.Errorf("task fire save failed, and task uuid = %s, fire at = %s, err = %v", taskUuid, fireAt.Format(time.RFC3339), err)
+	}
+}
+
+// catchUpAll 补执行所有任务错过的触发并恢复未完成的工作流，启动时和当选 leader 时调用
+func (c *CrontabPool) catchUpAll() {
+	if c.cluster == nil || c.cluster.Mode == ClusterModeLeader {
+		go func() {
+			if err := c.ResumeWorkflows(c.stopCtx); err != nil {
+				logx.Errorf("workflow resume failed, err = %v", err)
+			}
+		}()
+	}
+	c.lock.RLock()
+	entries := make([]*taskEntry, 0, len(c.tasks))
+	for _, e := range c.tasks {
+		entries = append(entries, e)
+	}
+	c.lock.RUnlock()
+	for _, e := range entries {
+		go c.catchUp(e)
+	}
+}
+
+// catchUp 一次性任务执行时间已过时立即执行，周期任务按 Misfire 策略补执行
+func (c *CrontabPool) catchUp(e *taskEntry) {
+	c.lock.RLock()
+	schedule := e.schedule
+	c.lock.RUnlock()
+	if isOnce(schedule) {
+		c.runOverdue(e)
+		return
+	}
+	c.recoverMisfire(e)
+}
+
+// recoverMisfire 按任务的 Misfire 策略补执行上次记录之后到现在错过的触发，会阻塞到补执行结束；
+// 没有记录的任务只写入当前时间作为起点，暂停的任务不补执行
+func (c *CrontabPool) recoverMisfire(e *taskEntry) {
+	if c.fires == nil || !c.isStarted() {
+		return
+	}
+	if c.cluster != nil && c.cluster.Mode == ClusterModeLeader && !c.leader.Load() {
+		return
+	}
+	c.lock.RLock()
+	taskUuid := e.task.TaskUuid
+	policy := e.task.Options.Misfire
+	schedule := e.schedule
+	paused := e.paused
+	c.lock.RUnlock()
+	if paused {
+		return
+	}
+	e.recoverLock.Lock()
+	defer e.recoverLock.Unlock()
+
+	now := time.Now()
+	ctx, cancel := context.WithTimeout(c.stopCtx, fireStoreTimeout)
+	last, ok, err := c.fires.LastFire(ctx, taskUuid)
+	cancel()
+	if err != nil {
+		logx.Errorf("task misfire check failed, and task uuid = %s, err = %v", taskUuid, err)
+		return
+	}
+	if !ok {
+		c.saveFire(taskUuid, now)
+		return
+	}
+	missed, total := missedFires(schedule, last, now, MaxMisfireRuns)
+	if total == 0 {
+		return
+	}
+	logx.Infof("task misfired, and task uuid = %s, last fire = %s, missed = %d, policy = %s", taskUuid, last.Format(time.RFC3339), total, policy)
+	switch policy {
+	case MisfireRunOnce:
+		missed = missed[len(missed)-1:]
+	case MisfireRunAll:
+		if total > len(missed) {
+			logx.Errorf("task misfire truncated, and task uuid = %s, missed = %d, run = %d", taskUuid, total, len(missed))
+		}
+	default:
+		c.saveFire(taskUuid, missed[len(missed)-1])
+		return
+	}
+	for _, fireAt := range missed {
+		if c.stopCtx.Err() != nil {
+			return
+		}
+		// note: 顺序补执行，集群模式下同样按触发时间加锁，不会和其他节点重复
+		c.fireAt(e, fireAt)
+	}
+}
+
+// missedFires 返回 (last, now) 之间最近的 limit 次触发时间和错过的总次数
+func missedFires(schedule cron.Schedule, last, now time.Time, limit int) ([]time.Time, int) {
+	var missed []time.Time
+	total := 0
+	for next := schedule.Next(last); !next.IsZero() && next.Before(now); next = schedule.Next(next) {
+		total++
+		missed = append(missed, next)
+		if len(missed) > limit {
+			missed = missed[1:]
+		}
+	}
+	return missed, total
+}
+
+// NewGormFireStore tableName 为空时使用 crontab_job_fires，表结构见 CrontabJobFire
+func NewGormFireStore(dao rexDao.Dao, tableName string) FireStore {
+	if tableName == "" {
+		tableName = DefaultJobFireTableName
+	}
+	return &gormFireStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormFireStore) LastFire(ctx context.Context, taskUuid string) (time.Time, bool, error) {
+	var row CrontabJobFire
+	err := s.dao.First(ctx, s.tableName, &row, "task_uuid = ?", taskUuid)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return row.LastFireAt, true, nil
+}
+
+func (s *gormFireStore) SaveFire(ctx context.Context, taskUuid string, fireAt time.Time) error {
+	tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).
+		Where("task_uuid = ? AND last_fire_at < ?", taskUuid, fireAt).
+		Updates(map[string]interface{}{
+			"last_fire_at": fireAt,
+			"updated_at":   time.Now(),
+		})
+	if tx.Error != nil || tx.RowsAffected > 0 {
+		return tx.Error
+	}
+	// note: 记录不存在时新建，已存在说明保存的时间更晚，由唯一索引忽略
+	row := CrontabJobFire{TaskUuid: taskUuid, LastFireAt: fireAt}
+	return s.dao.GetDB().WithContext(ctx).Table(s.tableName).Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error
+}
+
+// NewRedisFireStore 触发时间以毫秒时间戳保存在 {prefix}:crontab-fire:{taskUuid}
+func NewRedisFireStore(rd rexDao.RedisDao, prefix string) FireStore {
+	return &redisFireStore{
+		rd:     rd,
+		prefix: prefix,
+	}
+}
+
+func (s *redisFireStore) LastFire(ctx context.Context, taskUuid string) (time.Time, bool, error) {
+	value, err := s.rd.GetCtx(ctx, fmt.Sprintf(fireKeyFormat, s.prefix, taskUuid))
+	if err != nil || value == "" {
+		return time.Time{}, false, err
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(ms), true, nil
+}
+
+func (s *redisFireStore) SaveFire(ctx context.Context, taskUuid string, fireAt time.Time) error {
+	key := fmt.Sprintf(fireKeyFormat, s.prefix, taskUuid)
+	value := strconv.FormatInt(fireAt.UnixMilli(), 10)
+	// note: 其他节点并发保存时 CompareAndSwap 失败，重新读取后比较，已保存的时间更晚时直接返回
+	for {
+		current, err := s.rd.GetCtx(ctx, key)
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			ms, err := strconv.ParseInt(current, 10, 64)
+			if err == nil && ms >= fireAt.UnixMilli() {
+				return nil
+			}
+		}
+		ok, err := s.rd.CompareAndSwapCtx(ctx, key, current, value, 0)
+		if err != nil || ok {
+			return err
+		}
+	}
+}
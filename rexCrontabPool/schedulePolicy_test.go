@@ -0,0 +1,187 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type memoryFireStore struct {
+	lock  sync.Mutex
+	fires map[string]time.Time
+}
+
+func (s *memoryFireStore) LastFire(ctx context.Context, taskUuid string) (time.Time, bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fireAt, ok := s.fires[taskUuid]
+	return fireAt, ok, nil
+}
+
+func (s *memoryFireStore) SaveFire(ctx context.Context, taskUuid string, fireAt time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if fireAt.After(s.fires[taskUuid]) {
+		s.fires[taskUuid] = fireAt
+	}
+	return nil
+}
+
+func TestParseTaskSpecTimezone(t *testing.T) {
+	schedule, err := parseTaskSpec("0 0 9 * * *", "Asia/Shanghai")
+	if err != nil {
+		t.Fatalf("parseTaskSpec() error = %v", err)
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got, want := schedule.Next(from).UTC(), time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next() = %s, want %s", got, want)
+	}
+	if _, err := parseTaskSpec("CRON_TZ=UTC 0 0 9 * * *", "Asia/Shanghai"); !errors.Is(err, ErrInvalidSpec) {
+		t.Errorf("parseTaskSpec() with prefix error = %v, want ErrInvalidSpec", err)
+	}
+	if _, err := parseTaskSpec("0 0 9 * * *", "Mars/Olympus"); !errors.Is(err, ErrInvalidTimezone) {
+		t.Errorf("parseTaskSpec() error = %v, want ErrInvalidTimezone", err)
+	}
+	c := NewCrontabPool()
+	err = c.Add(context.Background(), &Task{TaskUuid: "bad", Spec: "@every 1h", Job: JobFunc(func(ctx context.Context) error { return nil }),
+		Options: TaskOptions{Overlap: "wait"}})
+	if !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Add() error = %v, want ErrInvalidPolicy", err)
+	}
+}
+
+func TestCrontabPoolOverlap(t *testing.T) {
+	for policy, want := range map[OverlapPolicy]int32{OverlapAllow: 3, OverlapSkip: 1, OverlapQueue: 2} {
+		ctx := context.Background()
+		c := NewCrontabPool()
+		release := make(chan struct{})
+		var runs atomic.Int32
+		err := c.Add(ctx, &Task{TaskUuid: "slow", Spec: "@every 1h", Options: TaskOptions{Overlap: policy},
+			Job: JobFunc(func(ctx context.Context) error {
+				runs.Add(1)
+				<-release
+				return nil
+			})})
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		e := c.tasks["slow"]
+		fireAt := time.Now().Truncate(time.Second)
+		for i := 0; i < 3; i++ {
+			c.enterRun()
+			go c.execute(e, fireAt.Add(time.Duration(i)*time.Second))
+			time.Sleep(20 * time.Millisecond)
+		}
+		close(release)
+		time.Sleep(20 * time.Millisecond)
+		if err := c.Shutdown(ctx); err != nil {
+			t.Fatalf("Shutdown() error = %v", err)
+		}
+		if got := runs.Load(); got != want {
+			t.Errorf("overlap %s runs = %d, want %d", policy, got, want)
+		}
+	}
+}
+
+func TestCrontabPoolMisfire(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	fires := &memoryFireStore{fires: map[string]time.Time{
+		MisfireIgnore.taskUuid():  now.Add(-3*time.Hour - 30*time.Minute),
+		MisfireRunOnce.taskUuid(): now.Add(-3*time.Hour - 30*time.Minute),
+		MisfireRunAll.taskUuid():  now.Add(-3*time.Hour - 30*time.Minute),
+	}}
+	c := NewCrontabPool(WithFireStore(fires))
+	var mu sync.Mutex
+	runs := map[string][]time.Time{}
+	for _, policy := range []MisfirePolicy{MisfireIgnore, MisfireRunOnce, MisfireRunAll} {
+		taskUuid := policy.taskUuid()
+		err := c.Add(ctx, &Task{TaskUuid: taskUuid, Spec: "@every 1h", Options: TaskOptions{Misfire: policy},
+			Job: JobFunc(func(ctx context.Context) error {
+				info, _ := c.Get(taskUuid)
+				mu.Lock()
+				runs[taskUuid] = append(runs[taskUuid], info.Prev)
+				mu.Unlock()
+				return nil
+			})})
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	c.Add(ctx, &Task{TaskUuid: "new", Spec: "@every 1h", Job: JobFunc(func(ctx context.Context) error { return nil })})
+	c.Start()
+	time.Sleep(200 * time.Millisecond)
+	if err := c.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := map[MisfirePolicy]int{MisfireIgnore: 0, MisfireRunOnce: 1, MisfireRunAll: 3}
+	for policy, n := range want {
+		got := runs[policy.taskUuid()]
+		if len(got) != n {
+			t.Errorf("misfire %s runs = %v, want %d runs", policy, got, n)
+		}
+		if last, _, _ := fires.LastFire(ctx, policy.taskUuid()); now.Sub(last) > time.Hour {
+			t.Errorf("misfire %s last fire = %s, want within 1h", policy, last)
+		}
+	}
+	if _, ok, _ := fires.LastFire(ctx, "new"); !ok {
+		t.Error("new task has no baseline fire time")
+	}
+}
+
+func newTestGormFireStore(t *testing.T) FireStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&CrontabJobFire{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormFireStore(rexDao.NewDao(db), "")
+}
+
+func TestFireStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) FireStore{
+		"gorm":  newTestGormFireStore,
+		"redis": func(t *testing.T) FireStore { return NewRedisFireStore(rexDao.NewMemoryRedisDao(), "test") },
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			if _, ok, err := s.LastFire(ctx, "job"); ok || err != nil {
+				t.Fatalf("LastFire() before save = %v, %v, want not found", ok, err)
+			}
+			base := time.UnixMilli(1700000000000)
+			// note: 只往后推进，较早的时间不会覆盖已保存的时间
+			for _, fireAt := range []time.Time{base, base.Add(2 * time.Hour), base.Add(time.Hour)} {
+				if err := s.SaveFire(ctx, "job", fireAt); err != nil {
+					t.Fatalf("SaveFire(%s) error = %v", fireAt, err)
+				}
+			}
+			_ = s.SaveFire(ctx, "other", base)
+			if fireAt, ok, err := s.LastFire(ctx, "job"); !ok || err != nil || !fireAt.Equal(base.Add(2*time.Hour)) {
+				t.Errorf("LastFire() = %s, %v, %v, want %s", fireAt, ok, err, base.Add(2*time.Hour))
+			}
+			if fireAt, _, _ := s.LastFire(ctx, "other"); !fireAt.Equal(base) {
+				t.Errorf("LastFire(other) = %s, want %s", fireAt, base)
+			}
+		})
+	}
+}
+
+func (p MisfirePolicy) taskUuid() string {
+	return "misfire-" + string(p)
+}
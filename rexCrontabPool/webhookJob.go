@@ -0,0 +1,261 @@
+package rexCrontabPool
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexTaskSign"
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	// JobTypeWebhook 内置的 HTTP 回调任务，Payload 为 WebhookPayload 的 json
+	JobTypeWebhook = "webhook"
+
+	DefaultWebhookTimeout = 10 * time.Second
+	// webhookResponseSnippet 失败时记录的响应内容长度
+	webhookResponseSnippet = 512
+)
+
+var (
+	ErrWebhookPayload = errors.New("webhook 任务参数错误")
+	ErrWebhookStatus  = errors.New("webhook 响应状态码错误")
+	ErrWebhookSecrets = errors.New("webhook 未配置签名密钥")
+
+	// DefaultWebhookRetry NewWebhookTask 使用的重试策略，只有网络错误、429 和 5xx 会重试
+	DefaultWebhookRetry = RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Second,
+		MaxBackoff:  30 * time.Second,
+	}
+)
+
+type (
+	// WebhookPayload 持久化在 JobRecord.Payload 中，不包含密钥，密钥按 SourceId 从 WebhookConf.Secrets 查找
+	WebhookPayload struct {
+		Url    string `json:"url"`
+		Method string `json:"method,omitempty"`
+		// SourceId 签名使用的来源，接收方据此查找密钥，为空时使用任务 uuid
+		SourceId    string            `json:"source_id,omitempty"`
+		ContentType string            `json:"content_type,omitempty"`
+		Headers     map[string]string `json:"headers,omitempty"`
+		Body        string            `json:"body,omitempty"`
+		// Timeout 单次请求的超时时间，为空时使用 WebhookConf.Timeout
+		Timeout time.Duration `json:"timeout,omitempty"`
+	}
+
+	WebhookConf struct {
+		Secrets rexTaskSign.SecretStore
+		// Client 为空时使用 http.DefaultTransport，超时由 Timeout 通过 ctx 控制
+		Client     *http.Client
+		SourceType string
+		Timeout    time.Duration
+		// OnDelivery 每次请求结束后回调，可以用来保存投递记录
+		OnDelivery func(ctx context.Context, delivery *WebhookDelivery)
+	}
+
+	// WebhookDelivery 一次请求的结果，DeliveryId 为任务池的 RunId，同一次执行的重试相同，Nonce 每次都不同
+	WebhookDelivery struct {
+		DeliveryId string        `json:"delivery_id"`
+		TaskUuid   string        `json:"task_uuid"`
+		Url        string        `json:"url"`
+		Attempt    int           `json:"attempt"`
+		Nonce      string        `json:"nonce"`
+		StatusCode int           `json:"status_code"`
+		Duration   time.Duration `json:"duration"`
+		Error      string        `json:"error,omitempty"`
+	}
+
+	webhookJob struct {
+		conf     WebhookConf
+		taskUuid string
+		payload  WebhookPayload
+	}
+)
+
+func DefaultWebhookConf(secrets rexTaskSign.SecretStore) WebhookConf {
+	return WebhookConf{
+		Secrets:    secrets,
+		Client:     &http.Client{},
+		SourceType: rexTaskSign.DefaultSourceType,
+		Timeout:    DefaultWebhookTimeout,
+	}
+}
+
+// RegisterWebhookJob 注册 webhook 任务类型，之后只需要添加 JobType 为 webhook 的任务，见 NewWebhookTask
+func RegisterWebhookJob(conf WebhookConf) {
+	RegisterJobFactory(JobTypeWebhook, WebhookJobFactory(conf))
+}
+
+func WebhookJobFactory(conf WebhookConf) JobFactory {
+	if conf.Client == nil {
+		conf.Client = &http.Client{}
+	}
+	if conf.SourceType == "" {
+		conf.SourceType = rexTaskSign.DefaultSourceType
+	}
+	if conf.Timeout <= 0 {
+		conf.Timeout = DefaultWebhookTimeout
+	}
+	return func(record *JobRecord) (cron.Job, error) {
+		if conf.Secrets == nil {
+			return nil, ErrWebhookSecrets
+		}
+		payload, err := parseWebhookPayload(record.Payload)
+		if err != nil {
+			return nil, err
+		}
+		return &webhookJob{
+			conf:     conf,
+			taskUuid: record.TaskUuid,
+			payload:  payload,
+		}, nil
+	}
+}
+
+// NewWebhookTask 创建 webhook 任务，需要配置 JobStore 并调用 RegisterWebhookJob；
+// 重试由任务池按 Options.Retry 执行，默认为 DefaultWebhookRetry
+func NewWebhookTask(taskUuid, name, spec string, payload WebhookPayload) (*Task, error) {
+	data := mustJson(payload)
+	if _, err := parseWebhookPayload(data); err != nil {
+		return nil, err
+	}
+	return &Task{
+		TaskUuid: taskUuid,
+		Name:     name,
+		Spec:     spec,
+		JobType:  JobTypeWebhook,
+		Payload:  data,
+		Options:  TaskOptions{Retry: DefaultWebhookRetry},
+	}, nil
+}
+
+func mustJson(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+func parseWebhookPayload(data string) (WebhookPayload, error) {
+	var payload WebhookPayload
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return payload, fmt.Errorf("%w: %v", ErrWebhookPayload, err)
+	}
+	if !strings.HasPrefix(payload.Url, "http://") && !strings.HasPrefix(payload.Url, "https://") {
+		return payload, fmt.Errorf("%w: url %q", ErrWebhookPayload, payload.Url)
+	}
+	if payload.Method == "" {
+		payload.Method = http.MethodPost
+	}
+	payload.Method = strings.ToUpper(payload.Method)
+	if payload.ContentType == "" && payload.Body != "" {
+		payload.ContentType = "application/json"
+	}
+	return payload, nil
+}
+
+func (j *webhookJob) Run() {
+	if err := j.RunCtx(context.Background()); err != nil {
+		logx.Errorf("webhook job failed, and task uuid = %s, err = %v", j.taskUuid, err)
+	}
+}
+
+// RunCtx 发送一次请求，重试由任务池的 RetryWrapper 负责，不能重试的错误包含 ErrJobNoRetry；
+// 由任务池执行时 DeliveryId 使用 RunId，重试时保持不变
+func (j *webhookJob) RunCtx(ctx context.Context) error {
+	sourceId := j.payload.SourceId
+	if sourceId == "" {
+		sourceId = j.taskUuid
+	}
+	secret, err := j.conf.Secrets.Secret(ctx, sourceId)
+	if err != nil {
+		return err
+	}
+	deliveryId, attempt, ok := RunAttemptFromCtx(ctx)
+	if !ok {
+		deliveryId, attempt = uuid.NewString(), 1
+	}
+	ctx = logx.ContextWithFields(ctx, logx.Field("delivery_id", deliveryId))
+	retryable, err := j.deliver(ctx, secret, sourceId, deliveryId, attempt)
+	if err != nil && !retryable {
+		return fmt.Errorf("%w: %w", ErrJobNoRetry, err)
+	}
+	return err
+}
+
+// deliver 发送一次请求，返回错误是否可以重试
+func (j *webhookJob) deliver(ctx context.Context, secret, sourceId, deliveryId string, attempt int) (bool, error) {
+	timeout := j.payload.Timeout
+	if timeout <= 0 {
+		timeout = j.conf.Timeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	delivery := &WebhookDelivery{
+		DeliveryId: deliveryId,
+		TaskUuid:   j.taskUuid,
+		Url:        j.payload.Url,
+		Attempt:    attempt,
+	}
+	start := time.Now()
+	retryable, err := j.send(ctx, secret, sourceId, delivery)
+	delivery.Duration = time.Since(start)
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	logx.WithContext(ctx).Infof("webhook delivered, and task uuid = %s, url = %s, attempt = %d, status = %d, duration = %s",
+		j.taskUuid, j.payload.Url, attempt, delivery.StatusCode, delivery.Duration)
+	if j.conf.OnDelivery != nil {
+		j.conf.OnDelivery(ctx, delivery)
+	}
+	return retryable, err
+}
+
+func (j *webhookJob) send(ctx context.Context, secret, sourceId string, delivery *WebhookDelivery) (bool, error) {
+	body := []byte(j.payload.Body)
+	req, err := http.NewRequestWithContext(ctx, j.payload.Method, j.payload.Url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrWebhookPayload, err)
+	}
+	for k, v := range j.payload.Headers {
+		req.Header.Set(k, v)
+	}
+	if j.payload.ContentType != "" {
+		req.Header.Set(rexHeaders.HeaderContentType, j.payload.ContentType)
+	}
+	headers := &rexTaskSign.Headers{
+		DeliveryId: delivery.DeliveryId,
+		SourceType: j.conf.SourceType,
+		SourceId:   sourceId,
+	}
+	if err := rexTaskSign.SignRequest(req, secret, headers, body); err != nil {
+		return false, err
+	}
+	delivery.Nonce = headers.Nonce
+
+	resp, err := j.conf.Client.Do(req)
+	if err != nil {
+		// note: 外层 ctx 结束时由 RetryWrapper 停止重试
+		return true, err
+	}
+	defer resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseSnippet))
+	// note: 读完 body 才能复用连接
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+	return retryable, fmt.Errorf("%w: %d %s", ErrWebhookStatus, resp.StatusCode, snippet)
+}
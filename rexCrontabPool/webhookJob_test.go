@@ -0,0 +1,92 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexTaskSign"
+)
+
+func TestWebhookJob(t *testing.T) {
+	secrets := rexTaskSign.StaticSecrets{"billing": "s3cret"}
+	serverSecrets := rexTaskSign.StaticSecrets{"billing": "s3cret"}
+	var mu sync.Mutex
+	var received []rexTaskSign.Headers
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		h := rexTaskSign.ParseHeaders(r.Header)
+		secret, _ := serverSecrets.Secret(r.Context(), h.SourceId)
+		if !rexTaskSign.VerifyRequest(r, secret, &h, body) || r.URL.RawQuery != "month=2024-01" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		received = append(received, h)
+		first := len(received) == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var deliveries []*WebhookDelivery
+	conf := DefaultWebhookConf(secrets)
+	conf.OnDelivery = func(ctx context.Context, delivery *WebhookDelivery) {
+		deliveries = append(deliveries, delivery)
+	}
+	task, err := NewWebhookTask("invoice", "invoice", "@every 1h", WebhookPayload{
+		Url:      server.URL + "/hooks/invoice?month=2024-01",
+		SourceId: "billing",
+		Body:     `{"month":"2024-01"}`,
+	})
+	if err != nil {
+		t.Fatalf("NewWebhookTask() error = %v", err)
+	}
+	if task.Options.Retry != DefaultWebhookRetry {
+		t.Errorf("task retry = %+v, want DefaultWebhookRetry", task.Options.Retry)
+	}
+	task.Options.Retry.Backoff = time.Millisecond
+	task.Job, err = WebhookJobFactory(conf)(&JobRecord{TaskUuid: task.TaskUuid, JobType: task.JobType, Payload: task.Payload})
+	if err != nil {
+		t.Fatalf("WebhookJobFactory() error = %v", err)
+	}
+	// note: 重试只由任务池执行一层
+	c := NewCrontabPool()
+	defer c.Shutdown(context.Background())
+	run := c.run(context.Background(), *task, time.Time{})
+	if run.Status != RunStatusSuccess || run.Attempts != 2 {
+		t.Fatalf("run = %+v, want success after 2 attempts", run)
+	}
+
+	if len(received) != 2 || len(deliveries) != 2 {
+		t.Fatalf("received %d requests and %d deliveries, want 2", len(received), len(deliveries))
+	}
+	if received[0].DeliveryId != run.RunId || received[1].DeliveryId != run.RunId || received[0].Nonce == received[1].Nonce {
+		t.Errorf("retries = %+v, want delivery id %s and different nonces", received, run.RunId)
+	}
+	if received[0].SourceType != rexTaskSign.DefaultSourceType || received[0].SourceId != "billing" {
+		t.Errorf("source = %s/%s, want crontab/billing", received[0].SourceType, received[0].SourceId)
+	}
+	if deliveries[0].StatusCode != http.StatusBadGateway || deliveries[1].StatusCode != http.StatusNoContent || deliveries[1].Attempt != 2 {
+		t.Errorf("deliveries = %+v %+v", deliveries[0], deliveries[1])
+	}
+
+	// note: 401 不重试
+	secrets["billing"] = "rotated"
+	task.Job, _ = WebhookJobFactory(conf)(&JobRecord{TaskUuid: task.TaskUuid, JobType: task.JobType, Payload: task.Payload})
+	if run := c.run(context.Background(), *task, time.Time{}); run.Status != RunStatusFailed || run.Attempts != 1 || len(deliveries) != 3 {
+		t.Errorf("run with wrong secret = %+v, deliveries = %d, want one failed attempt", run, len(deliveries))
+	}
+	if err := task.Job.(CtxJob).RunCtx(context.Background()); !errors.Is(err, ErrWebhookStatus) || !errors.Is(err, ErrJobNoRetry) {
+		t.Errorf("RunCtx() with wrong secret error = %v, want ErrWebhookStatus and ErrJobNoRetry", err)
+	}
+}
@@ -0,0 +1,865 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"github.com/zeromicro/go-zero/core/logx"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type WorkflowStatus string
+
+const (
+	WorkflowStatusRunning   WorkflowStatus = "running"
+	WorkflowStatusSucceeded WorkflowStatus = "succeeded"
+	WorkflowStatusFailed    WorkflowStatus = "failed"
+	WorkflowStatusCancelled WorkflowStatus = "cancelled"
+)
+
+type StepStatus string
+
+const (
+	StepStatusPending StepStatus = "pending"
+	StepStatusRunning StepStatus = "running"
+	StepStatusSuccess StepStatus = "success"
+	StepStatusFailed  StepStatus = "failed"
+	// StepStatusSkipped 依赖的步骤没有成功
+	StepStatusSkipped   StepStatus = "skipped"
+	StepStatusCancelled StepStatus = "cancelled"
+)
+
+const (
+	DefaultWorkflowTableName = "crontab_workflows"
+	workflowSaveTimeout      = 5 * time.Second
+	// workflowSaveAttempts 保存工作流遇到版本冲突时的最多尝试次数
+	workflowSaveAttempts = 3
+	// keepFinishedWorkflows 没有 WorkflowStore 时内存中保留的已结束工作流数量，超过时删除最早结束的
+	keepFinishedWorkflows = 100
+)
+
+var (
+	ErrWorkflowNotFound = errors.New("工作流不存在")
+	ErrWorkflowExists   = errors.New("工作流已存在")
+	ErrInvalidWorkflow  = errors.New("工作流定义错误")
+	ErrWorkflowFinished = errors.New("工作流已结束")
+	ErrWorkflowConflict = errors.New("工作流已被修改，版本不一致")
+)
+
+type (
+	// Workflow 由多个步骤组成的有向无环图，步骤在依赖全部成功后执行，依赖失败时跳过
+	Workflow struct {
+		WorkflowId string          `json:"workflow_id"`
+		Name       string          `json:"name"`
+		Status     WorkflowStatus  `json:"status"`
+		Steps      []*WorkflowStep `json:"steps"`
+		CreatedAt  time.Time       `json:"created_at"`
+		EndAt      time.Time       `json:"end_at"`
+		// Version 由 WorkflowStore 维护，为 0 表示还没有保存过，每次保存加 1
+		Version int64 `json:"version"`
+	}
+
+	// WorkflowStep 步骤的 job 由 JobType 对应的 JobFactory 创建，重启后可以恢复
+	WorkflowStep struct {
+		Name      string      `json:"name"`
+		JobType   string      `json:"job_type"`
+		Payload   string      `json:"payload,omitempty"`
+		Options   TaskOptions `json:"options"`
+		DependsOn []string    `json:"depends_on,omitempty"`
+
+		Status   StepStatus `json:"status"`
+		RunId    string     `json:"run_id,omitempty"`
+		Attempts int        `json:"attempts"`
+		Error    string     `json:"error,omitempty"`
+		StartAt  time.Time  `json:"start_at"`
+		EndAt    time.Time  `json:"end_at"`
+	}
+
+	// WorkflowStore 保存工作流的定义和状态，每次状态变化都会整体保存
+	WorkflowStore interface {
+		// Save wf.Version 为 0 时新增，WorkflowId 已存在时返回 ErrWorkflowExists；
+		// 否则按 WorkflowId 和 wf.Version 更新，版本不一致时返回 ErrWorkflowConflict；成功后把新的 Version 写回 wf
+		Save(ctx context.Context, wf *Workflow) error
+		// Get 不存在时返回 ErrWorkflowNotFound
+		Get(ctx context.Context, workflowId string) (*Workflow, error)
+		// List statuses 为空时返回全部，按创建时间倒序
+		List(ctx context.Context, statuses ...WorkflowStatus) ([]*Workflow, error)
+	}
+
+	workflowRun struct {
+		wf     *Workflow
+		ctx    context.Context
+		cancel context.CancelFunc
+		// stopLease 停止续期并释放集群模式下的工作流租约，没有租约时为空
+		stopLease context.CancelFunc
+		// lost 租约已经失去，工作流可能已经由其他节点接管，本节点不再保存状态
+		lost bool
+		// saveLock 串行保存同一个工作流，后保存的总是更新的状态
+		saveLock sync.Mutex
+	}
+
+	gormWorkflowStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	CrontabWorkflow struct {
+		rexDatabase.BaseModel
+		WorkflowId string         `gorm:"uniqueIndex:idx_crontab_workflow_id;column:workflow_id;comment:工作流id;type: varchar(64)" json:"workflow_id"`
+		Name       string         `gorm:"column:name;comment:工作流名称;type: varchar(255)" json:"name"`
+		Status     WorkflowStatus `gorm:"index:idx_crontab_workflow_status;column:status;comment:状态 running|succeeded|failed|cancelled;type: varchar(16)" json:"status"`
+		Steps      string         `gorm:"column:steps;comment:步骤定义和状态json;type: text" json:"steps"`
+		StartAt    time.Time      `gorm:"column:start_at;comment:创建时间" json:"start_at"`
+		EndAt      *time.Time     `gorm:"column:end_at;comment:结束时间" json:"end_at"`
+		// note: 默认值为 1，升级前已经保存的工作流迁移后可以按版本号继续更新
+		Version int64 `gorm:"column:version;comment:版本号，每次保存加1;type: bigint;default:1" json:"version"`
+	}
+)
+
+func (CrontabWorkflow) TableName() string {
+	return DefaultWorkflowTableName
+}
+
+// WithWorkflowStore 持久化工作流，重启后由 ResumeWorkflows 继续执行未完成的工作流；不配置时工作流只保存在内存中
+func WithWorkflowStore(store WorkflowStore) PoolOption {
+	return func(c *CrontabPool) {
+		c.workflowStore = store
+	}
+}
+
+// StartWorkflow 校验并开始执行工作流，WorkflowId 为空时自动生成；步骤的状态会被重置
+func (c *CrontabPool) StartWorkflow(ctx context.Context, wf *Workflow) error {
+	if wf.WorkflowId == "" {
+		wf.WorkflowId = uuid.NewString()
+	}
+	if err := validateWorkflow(wf); err != nil {
+		return err
+	}
+	if c.isClosed() {
+		return ErrPoolClosed
+	}
+	c.wfLock.Lock()
+	if _, ok := c.workflows[wf.WorkflowId]; ok {
+		c.wfLock.Unlock()
+		return fmt.Errorf("%w: %s", ErrWorkflowExists, wf.WorkflowId)
+	}
+	wf.Status = WorkflowStatusRunning
+	wf.CreatedAt = time.Now()
+	wf.EndAt = time.Time{}
+	for _, step := range wf.Steps {
+		*step = WorkflowStep{Name: step.Name, JobType: step.JobType, Payload: step.Payload, Options: step.Options, DependsOn: step.DependsOn, Status: StepStatusPending}
+	}
+	run := c.newWorkflowRun(wf.clone())
+	c.wfLock.Unlock()
+
+	if ok, err := c.leaseWorkflow(ctx, run); err != nil || !ok {
+		c.dropWorkflowRun(run)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s", ErrWorkflowExists, wf.WorkflowId)
+	}
+	// note: Version 为 0，store 中已经存在同一个 WorkflowId 时返回 ErrWorkflowExists
+	if err := c.saveWorkflow(ctx, run); err != nil {
+		c.dropWorkflowRun(run)
+		return err
+	}
+	logx.WithContext(ctx).Infof("workflow start, and workflow id = %s, name = %s, steps = %d", wf.WorkflowId, wf.Name, len(wf.Steps))
+	c.advance(run)
+	return nil
+}
+
+// GetWorkflow 返回工作流的快照，正在本节点执行的工作流返回内存中的最新状态；没有 WorkflowStore 时只能查到最近结束的工作流
+func (c *CrontabPool) GetWorkflow(ctx context.Context, workflowId string) (*Workflow, error) {
+	c.wfLock.Lock()
+	run, ok := c.workflows[workflowId]
+	if ok {
+		wf := run.wf.clone()
+		c.wfLock.Unlock()
+		return wf, nil
+	}
+	c.wfLock.Unlock()
+	if c.workflowStore == nil {
+		return nil, fmt.Errorf("%w: %s", ErrWorkflowNotFound, workflowId)
+	}
+	return c.workflowStore.Get(ctx, workflowId)
+}
+
+// ListWorkflows 配置了 WorkflowStore 时从 store 查询，否则只返回本节点正在执行和最近结束的工作流
+func (c *CrontabPool) ListWorkflows(ctx context.Context, statuses ...WorkflowStatus) ([]*Workflow, error) {
+	if c.workflowStore != nil {
+		return c.workflowStore.List(ctx, statuses...)
+	}
+	c.wfLock.Lock()
+	defer c.wfLock.Unlock()
+	list := make([]*Workflow, 0, len(c.workflows))
+	for _, run := range c.workflows {
+		if len(statuses) > 0 && !slices.Contains(statuses, run.wf.Status) {
+			continue
+		}
+		list = append(list, run.wf.clone())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list, nil
+}
+
+// CancelWorkflow 取消工作流，未开始的步骤标记为 cancelled，正在执行的步骤会收到 ctx 取消；
+// 工作流由其他节点执行时只修改 store 中的状态，执行节点在当前步骤结束后读到取消状态，不再开始后续步骤
+func (c *CrontabPool) CancelWorkflow(ctx context.Context, workflowId string) error {
+	c.wfLock.Lock()
+	run, ok := c.workflows[workflowId]
+	if ok {
+		if run.wf.Status != WorkflowStatusRunning {
+			c.wfLock.Unlock()
+			return fmt.Errorf("%w: %s", ErrWorkflowFinished, workflowId)
+		}
+		run.wf.cancel()
+		run.cancel()
+		c.wfLock.Unlock()
+		logx.WithContext(ctx).Infof("workflow cancel, and workflow id = %s", workflowId)
+		// note: 正在执行的步骤结束后 advance 会再保存一次最终状态
+		return c.saveWorkflow(ctx, run)
+	}
+	c.wfLock.Unlock()
+
+	if c.workflowStore == nil {
+		return fmt.Errorf("%w: %s", ErrWorkflowNotFound, workflowId)
+	}
+	// note: 执行节点可能同时保存了新的步骤状态，版本不一致时重新读取后再取消
+	var err error
+	for i := 0; i < workflowSaveAttempts; i++ {
+		var wf *Workflow
+		wf, err = c.workflowStore.Get(ctx, workflowId)
+		if err != nil {
+			return err
+		}
+		if wf.Status != WorkflowStatusRunning {
+			return fmt.Errorf("%w: %s", ErrWorkflowFinished, workflowId)
+		}
+		wf.cancel()
+		if err = c.workflowStore.Save(ctx, wf); !errors.Is(err, ErrWorkflowConflict) {
+			break
+		}
+	}
+	if err == nil {
+		logx.WithContext(ctx).Infof("workflow cancel in store, and workflow id = %s", workflowId)
+	}
+	return err
+}
+
+// ResumeWorkflows 从 WorkflowStore 恢复未完成的工作流，中断时正在执行的步骤会重新执行；
+// 单机和 leader 模式下启动或当选时自动调用，per-fire 模式需要由业务选择一个节点调用；
+// 集群模式下每个工作流需要先获得租约，仍由其他节点执行的工作流会被跳过
+func (c *CrontabPool) ResumeWorkflows(ctx context.Context) error {
+	if c.workflowStore == nil {
+		return nil
+	}
+	list, err := c.workflowStore.List(ctx, WorkflowStatusRunning)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, wf := range list {
+		if err := validateWorkflow(wf); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		c.wfLock.Lock()
+		if _, ok := c.workflows[wf.WorkflowId]; ok {
+			c.wfLock.Unlock()
+			continue
+		}
+		run := c.newWorkflowRun(wf)
+		c.wfLock.Unlock()
+		latest, err := c.resumeWorkflow(ctx, run)
+		if err != nil {
+			c.dropWorkflowRun(run)
+			errs = append(errs, err)
+			continue
+		}
+		if latest == nil {
+			c.dropWorkflowRun(run)
+			continue
+		}
+		for _, step := range latest.Steps {
+			if step.Status == StepStatusRunning {
+				step.Status = StepStatusPending
+			}
+		}
+		c.wfLock.Lock()
+		run.wf = latest
+		c.wfLock.Unlock()
+		logx.WithContext(ctx).Infof("workflow resume, and workflow id = %s", wf.WorkflowId)
+		c.advance(run)
+	}
+	return errors.Join(errs...)
+}
+
+// resumeWorkflow 获得租约后重新读取工作流，租约被其他节点持有或者工作流已经结束时返回 nil
+func (c *CrontabPool) resumeWorkflow(ctx context.Context, run *workflowRun) (*Workflow, error) {
+	ok, err := c.leaseWorkflow(ctx, run)
+	if err != nil || !ok {
+		return nil, err
+	}
+	// note: List 之后原来的执行节点可能刚刚推进或结束了工作流
+	latest, err := c.workflowStore.Get(ctx, run.wf.WorkflowId)
+	if err != nil {
+		return nil, err
+	}
+	if latest.Status != WorkflowStatusRunning {
+		return nil, nil
+	}
+	return latest, nil
+}
+
+// leaseWorkflow 集群模式下获得工作流的租约并定期续期，租约被其他节点持有时返回 false；非集群模式总是返回 true
+func (c *CrontabPool) leaseWorkflow(ctx context.Context, run *workflowRun) (bool, error) {
+	if c.cluster == nil {
+		return true, nil
+	}
+	key := fmt.Sprintf(workflowKeyFormat, c.cluster.Prefix, run.wf.WorkflowId)
+	lockCtx, cancel := context.WithTimeout(ctx, clusterLockOpTimeout)
+	ok, err := c.cluster.Locks.Acquire(lockCtx, key, c.cluster.NodeId, c.cluster.LeaseTtl)
+	cancel()
+	if err != nil || !ok {
+		return false, err
+	}
+	leaseCtx, stopLease := context.WithCancel(c.stopCtx)
+	c.wfLock.Lock()
+	run.stopLease = stopLease
+	c.wfLock.Unlock()
+	go c.keepWorkflowLease(leaseCtx, run, key)
+	return true, nil
+}
+
+// keepWorkflowLease 续期工作流租约，续期一直失败直到租约快过期时停止执行；工作流结束或任务池关闭时释放
+func (c *CrontabPool) keepWorkflowLease(ctx context.Context, run *workflowRun, key string) {
+	ttl := c.cluster.LeaseTtl
+	ticker := time.NewTicker(ttl / leaderRenewDivisor)
+	defer ticker.Stop()
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			releaseCtx, cancel := context.WithTimeout(context.Background(), clusterReleaseTimeout)
+			if err := c.cluster.Locks.Release(releaseCtx, key, c.cluster.NodeId); err != nil {
+				logx.Errorf("workflow lease release failed, and workflow id = %s, err = %v", run.wf.WorkflowId, err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+		}
+		renewCtx, cancel := context.WithTimeout(ctx, clusterLockOpTimeout)
+		ok, err := c.cluster.Locks.Renew(renewCtx, key, c.cluster.NodeId, ttl)
+		cancel()
+		if err == nil && ok {
+			renewedAt = time.Now()
+			continue
+		}
+		if err != nil {
+			logx.Errorf("workflow lease renew failed, and workflow id = %s, err = %v", run.wf.WorkflowId, err)
+			if ctx.Err() != nil || time.Since(renewedAt) < ttl-ttl/leaderRenewDivisor {
+				continue
+			}
+		}
+		logx.Errorf("workflow lease lost, and workflow id = %s, node = %s", run.wf.WorkflowId, c.cluster.NodeId)
+		c.wfLock.Lock()
+		run.lost = true
+		if c.workflows[run.wf.WorkflowId] == run {
+			delete(c.workflows, run.wf.WorkflowId)
+		}
+		run.stopLease()
+		c.wfLock.Unlock()
+		run.cancel()
+		return
+	}
+}
+
+// newWorkflowRun 需要持有 c.wfLock
+func (c *CrontabPool) newWorkflowRun(wf *Workflow) *workflowRun {
+	ctx, cancel := context.WithCancel(c.stopCtx)
+	run := &workflowRun{wf: wf, ctx: ctx, cancel: cancel}
+	c.workflows[wf.WorkflowId] = run
+	return run
+}
+
+// pruneWorkflows 需要持有 c.wfLock
+func (c *CrontabPool) pruneWorkflows() {
+	var finished []*Workflow
+	for _, run := range c.workflows {
+		if run.wf.Status != WorkflowStatusRunning && !run.wf.active() {
+			finished = append(finished, run.wf)
+		}
+	}
+	if len(finished) <= keepFinishedWorkflows {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].EndAt.Before(finished[j].EndAt)
+	})
+	for _, wf := range finished[:len(finished)-keepFinishedWorkflows] {
+		delete(c.workflows, wf.WorkflowId)
+	}
+}
+
+func (c *CrontabPool) dropWorkflowRun(run *workflowRun) {
+	c.wfLock.Lock()
+	defer c.wfLock.Unlock()
+	if c.workflows[run.wf.WorkflowId] == run {
+		delete(c.workflows, run.wf.WorkflowId)
+	}
+	run.cancel()
+	if run.stopLease != nil {
+		run.stopLease()
+	}
+}
+
+// advance 跳过依赖失败的步骤，启动依赖全部成功的步骤，所有步骤结束后结束工作流；
+// 配置了 WorkflowStore 时先读取 store 中的状态，其他节点已经取消的工作流不再开始新的步骤
+func (c *CrontabPool) advance(run *workflowRun) {
+	cancelled := c.cancelledInStore(run)
+	c.wfLock.Lock()
+	wf := run.wf
+	if run.lost {
+		c.wfLock.Unlock()
+		return
+	}
+	if cancelled && wf.Status == WorkflowStatusRunning {
+		logx.Infof("workflow cancelled in store, and workflow id = %s", wf.WorkflowId)
+		wf.cancel()
+		run.cancel()
+	}
+	var ready []*WorkflowStep
+	if wf.Status == WorkflowStatusRunning {
+		ready = wf.schedule()
+	}
+	finished := !wf.active()
+	if finished {
+		if wf.Status == WorkflowStatusRunning {
+			wf.Status = WorkflowStatusSucceeded
+			for _, step := range wf.Steps {
+				if step.Status != StepStatusSuccess {
+					wf.Status = WorkflowStatusFailed
+				}
+			}
+		}
+		wf.EndAt = time.Now()
+		if c.workflowStore != nil && c.workflows[wf.WorkflowId] == run {
+			delete(c.workflows, wf.WorkflowId)
+		} else {
+			c.pruneWorkflows()
+		}
+		run.cancel()
+		if run.stopLease != nil {
+			run.stopLease()
+		}
+	}
+	wfId, status := wf.WorkflowId, wf.Status
+	c.wfLock.Unlock()
+
+	if err := c.saveWorkflow(context.WithoutCancel(c.stopCtx), run); err != nil {
+		logx.Errorf("workflow save failed, and workflow id = %s, err = %v", wfId, err)
+	}
+	if finished {
+		logx.Infof("workflow finished, and workflow id = %s, status = %s", wfId, status)
+		return
+	}
+	for _, step := range ready {
+		if !c.enterRun() {
+			// note: 任务池已关闭，步骤保持 running，重启后重新执行
+			return
+		}
+		go c.runStep(run, step)
+	}
+}
+
+func (c *CrontabPool) runStep(run *workflowRun, step *WorkflowStep) {
+	defer c.running.Done()
+	c.wfLock.Lock()
+	wfId := run.wf.WorkflowId
+	task := Task{
+		TaskUuid: wfId + ":" + step.Name,
+		Name:     step.Name,
+		JobType:  step.JobType,
+		Payload:  step.Payload,
+		Options:  step.Options,
+	}
+	c.wfLock.Unlock()
+
+	var result *JobRun
+	job, err := buildJob(&JobRecord{TaskUuid: task.TaskUuid, Name: task.Name, JobType: task.JobType, Payload: task.Payload, Options: task.Options})
+	switch {
+	case err != nil:
+	case run.ctx.Err() != nil:
+		// note: 保存时发现工作流已经被其他节点取消，已经标记为 running 的步骤不再执行
+		result = &JobRun{Status: RunStatusFailed, EndAt: time.Now()}
+	default:
+		task.Job = job
+		result = c.run(run.ctx, task, time.Now())
+	}
+
+	c.wfLock.Lock()
+	switch {
+	case err != nil:
+		step.Status = StepStatusFailed
+		step.Error = err.Error()
+		step.EndAt = time.Now()
+	case result.Status == RunStatusSuccess:
+		step.Status = StepStatusSuccess
+		step.applyRun(result)
+	case c.stopCtx.Err() != nil, run.lost:
+		// note: 任务池关闭或者失去租约导致的中断不记录结果，由重启后或者接管的节点重新执行
+		c.wfLock.Unlock()
+		return
+	case run.ctx.Err() != nil:
+		step.Status = StepStatusCancelled
+		step.applyRun(result)
+	default:
+		step.Status = StepStatusFailed
+		step.applyRun(result)
+	}
+	c.wfLock.Unlock()
+	c.advance(run)
+}
+
+// cancelledInStore 工作流在 store 中已经被其他节点取消，读取失败时按未取消处理
+func (c *CrontabPool) cancelledInStore(run *workflowRun) bool {
+	if c.workflowStore == nil {
+		return false
+	}
+	c.wfLock.Lock()
+	wfId := run.wf.WorkflowId
+	status := run.wf.Status
+	c.wfLock.Unlock()
+	if status != WorkflowStatusRunning {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.stopCtx), workflowSaveTimeout)
+	defer cancel()
+	stored, err := c.workflowStore.Get(ctx, wfId)
+	if err != nil {
+		if !errors.Is(err, ErrWorkflowNotFound) {
+			logx.Errorf("workflow load failed, and workflow id = %s, err = %v", wfId, err)
+		}
+		return false
+	}
+	return stored.Status == WorkflowStatusCancelled
+}
+
+// saveWorkflow 保存 run 当前的状态，同一个工作流的保存串行执行，不会用旧的快照覆盖新的状态；
+// 版本不一致时重新读取 store，其他节点已经取消时以取消为准，合并后再次保存，其他修改说明工作流已经被接管，返回 ErrWorkflowConflict
+func (c *CrontabPool) saveWorkflow(ctx context.Context, run *workflowRun) error {
+	if c.workflowStore == nil {
+		return nil
+	}
+	run.saveLock.Lock()
+	defer run.saveLock.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, workflowSaveTimeout)
+	defer cancel()
+	for i := 1; ; i++ {
+		c.wfLock.Lock()
+		if run.lost {
+			c.wfLock.Unlock()
+			return nil
+		}
+		snapshot := run.wf.clone()
+		c.wfLock.Unlock()
+
+		err := c.workflowStore.Save(ctx, snapshot)
+		if err == nil {
+			c.wfLock.Lock()
+			run.wf.Version = snapshot.Version
+			c.wfLock.Unlock()
+			return nil
+		}
+		if !errors.Is(err, ErrWorkflowConflict) || i >= workflowSaveAttempts {
+			return err
+		}
+		stored, err := c.workflowStore.Get(ctx, snapshot.WorkflowId)
+		if err != nil {
+			return err
+		}
+		if stored.Status != WorkflowStatusCancelled {
+			return fmt.Errorf("%w: %s, version %d", ErrWorkflowConflict, snapshot.WorkflowId, snapshot.Version)
+		}
+		c.wfLock.Lock()
+		// note: 本节点已经结束的工作流保留执行结果，只采用新的版本号
+		if run.wf.Status == WorkflowStatusRunning {
+			logx.Infof("workflow cancelled in store, and workflow id = %s", snapshot.WorkflowId)
+			run.wf.cancel()
+			run.cancel()
+		}
+		run.wf.Version = stored.Version
+		c.wfLock.Unlock()
+	}
+}
+
+func (s *WorkflowStep) applyRun(run *JobRun) {
+	s.RunId = run.RunId
+	s.Attempts = run.Attempts
+	s.Error = run.Error
+	s.EndAt = run.EndAt
+}
+
+// schedule 把依赖失败的步骤标记为 skipped，返回可以开始的步骤并标记为 running，需要持有 c.wfLock
+func (wf *Workflow) schedule() []*WorkflowStep {
+	steps := make(map[string]*WorkflowStep, len(wf.Steps))
+	for _, step := range wf.Steps {
+		steps[step.Name] = step
+	}
+	// note: 跳过会沿着依赖传递，重复到没有变化为止
+	for changed := true; changed; {
+		changed = false
+		for _, step := range wf.Steps {
+			if step.Status != StepStatusPending {
+				continue
+			}
+			for _, dep := range step.DependsOn {
+				switch steps[dep].Status {
+				case StepStatusFailed, StepStatusSkipped, StepStatusCancelled:
+					step.Status = StepStatusSkipped
+					step.EndAt = time.Now()
+					changed = true
+				}
+				if step.Status == StepStatusSkipped {
+					break
+				}
+			}
+		}
+	}
+	var ready []*WorkflowStep
+	for _, step := range wf.Steps {
+		if step.Status != StepStatusPending {
+			continue
+		}
+		ok := true
+		for _, dep := range step.DependsOn {
+			if steps[dep].Status != StepStatusSuccess {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			step.Status = StepStatusRunning
+			step.StartAt = time.Now()
+			ready = append(ready, step)
+		}
+	}
+	return ready
+}
+
+func (wf *Workflow) active() bool {
+	for _, step := range wf.Steps {
+		if step.Status == StepStatusPending || step.Status == StepStatusRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// cancel 标记为取消，未开始的步骤同时取消
+func (wf *Workflow) cancel() {
+	wf.Status = WorkflowStatusCancelled
+	for _, step := range wf.Steps {
+		if step.Status == StepStatusPending {
+			step.Status = StepStatusCancelled
+		}
+	}
+	if !wf.active() {
+		wf.EndAt = time.Now()
+	}
+}
+
+func (wf *Workflow) clone() *Workflow {
+	cloned := *wf
+	cloned.Steps = make([]*WorkflowStep, len(wf.Steps))
+	for i, step := range wf.Steps {
+		s := *step
+		cloned.Steps[i] = &s
+	}
+	return &cloned
+}
+
+// validateWorkflow 检查步骤名称唯一、依赖存在、没有环，并且 JobType 已经注册
+func validateWorkflow(wf *Workflow) error {
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("%w: %s: 没有步骤", ErrInvalidWorkflow, wf.WorkflowId)
+	}
+	deps := make(map[string][]string, len(wf.Steps))
+	for _, step := range wf.Steps {
+		if step == nil || step.Name == "" {
+			return fmt.Errorf("%w: %s: 步骤缺少名称", ErrInvalidWorkflow, wf.WorkflowId)
+		}
+		if _, ok := deps[step.Name]; ok {
+			return fmt.Errorf("%w: %s: 步骤 %s 重复", ErrInvalidWorkflow, wf.WorkflowId, step.Name)
+		}
+		if err := step.Options.validate(); err != nil {
+			return err
+		}
+		jobFactoryLock.RLock()
+		_, registered := jobFactories[step.JobType]
+		jobFactoryLock.RUnlock()
+		if !registered {
+			return fmt.Errorf("%w: %s", ErrJobTypeNotRegistered, step.JobType)
+		}
+		deps[step.Name] = step.DependsOn
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(deps))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %s: 步骤 %s 存在循环依赖", ErrInvalidWorkflow, wf.WorkflowId, name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("%w: %s: 步骤 %s 依赖的 %s 不存在", ErrInvalidWorkflow, wf.WorkflowId, name, dep)
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for name := range deps {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewGormWorkflowStore tableName 为空时使用 crontab_workflows，表结构见 CrontabWorkflow
+func NewGormWorkflowStore(dao rexDao.Dao, tableName string) WorkflowStore {
+	if tableName == "" {
+		tableName = DefaultWorkflowTableName
+	}
+	return &gormWorkflowStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormWorkflowStore) Save(ctx context.Context, wf *Workflow) error {
+	steps, err := json.Marshal(wf.Steps)
+	if err != nil {
+		return err
+	}
+	var endAt *time.Time
+	if !wf.EndAt.IsZero() {
+		endAt = &wf.EndAt
+	}
+	if wf.Version == 0 {
+		row := CrontabWorkflow{
+			WorkflowId: wf.WorkflowId,
+			Name:       wf.Name,
+			Status:     wf.Status,
+			Steps:      string(steps),
+			StartAt:    wf.CreatedAt,
+			EndAt:      endAt,
+			Version:    1,
+		}
+		// note: 并发创建时由唯一索引兜底
+		tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).Clauses(clause.OnConflict{DoNothing: true}).Create(&row)
+		if tx.Error != nil {
+			return tx.Error
+		}
+		if tx.RowsAffected == 0 {
+			return fmt.Errorf("%w: %s", ErrWorkflowExists, wf.WorkflowId)
+		}
+		wf.Version = row.Version
+		return nil
+	}
+	// note: 按版本号更新，版本号在同一条语句中加 1，旧的快照和并发的取消只有一个成功
+	tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).
+		Where("workflow_id = ? AND version = ?", wf.WorkflowId, wf.Version).
+		Updates(map[string]interface{}{
+			"name":       wf.Name,
+			"status":     wf.Status,
+			"steps":      string(steps),
+			"end_at":     endAt,
+			"version":    wf.Version + 1,
+			"updated_at": time.Now(),
+		})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected == 0 {
+		if _, err := s.Get(ctx, wf.WorkflowId); err != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %s, version %d", ErrWorkflowConflict, wf.WorkflowId, wf.Version)
+	}
+	wf.Version++
+	return nil
+}
+
+func (s *gormWorkflowStore) Get(ctx context.Context, workflowId string) (*Workflow, error) {
+	var row CrontabWorkflow
+	err := s.dao.First(ctx, s.tableName, &row, "workflow_id = ?", workflowId)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrWorkflowNotFound, workflowId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return row.workflow()
+}
+
+func (s *gormWorkflowStore) List(ctx context.Context, statuses ...WorkflowStatus) ([]*Workflow, error) {
+	var rows []CrontabWorkflow
+	tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).Order("start_at desc")
+	if len(statuses) > 0 {
+		tx = tx.Where("status IN ?", statuses)
+	}
+	if err := tx.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	list := make([]*Workflow, 0, len(rows))
+	for i := range rows {
+		wf, err := rows[i].workflow()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, wf)
+	}
+	return list, nil
+}
+
+func (w *CrontabWorkflow) workflow() (*Workflow, error) {
+	wf := &Workflow{
+		WorkflowId: w.WorkflowId,
+		Name:       w.Name,
+		Status:     w.Status,
+		CreatedAt:  w.StartAt,
+		Version:    w.Version,
+	}
+	if w.EndAt != nil {
+		wf.EndAt = *w.EndAt
+	}
+	if err := json.Unmarshal([]byte(w.Steps), &wf.Steps); err != nil {
+		return nil, fmt.Errorf("workflow %s steps: %w", w.WorkflowId, err)
+	}
+	return wf, nil
+}
@@ -0,0 +1,326 @@
+package rexCrontabPool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/robfig/cron/v3"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type memoryWorkflowStore struct {
+	lock      sync.Mutex
+	workflows map[string]*Workflow
+}
+
+func newMemoryWorkflowStore() *memoryWorkflowStore {
+	return &memoryWorkflowStore{workflows: map[string]*Workflow{}}
+}
+
+func (s *memoryWorkflowStore) Save(ctx context.Context, wf *Workflow) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	current, ok := s.workflows[wf.WorkflowId]
+	switch {
+	case wf.Version == 0 && ok:
+		return fmt.Errorf("%w: %s", ErrWorkflowExists, wf.WorkflowId)
+	case wf.Version != 0 && !ok:
+		return fmt.Errorf("%w: %s", ErrWorkflowNotFound, wf.WorkflowId)
+	case ok && current.Version != wf.Version:
+		return fmt.Errorf("%w: %s, version %d", ErrWorkflowConflict, wf.WorkflowId, wf.Version)
+	}
+	wf.Version++
+	s.workflows[wf.WorkflowId] = wf.clone()
+	return nil
+}
+
+func (s *memoryWorkflowStore) Get(ctx context.Context, workflowId string) (*Workflow, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	wf, ok := s.workflows[workflowId]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrWorkflowNotFound, workflowId)
+	}
+	return wf.clone(), nil
+}
+
+func (s *memoryWorkflowStore) List(ctx context.Context, statuses ...WorkflowStatus) ([]*Workflow, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var list []*Workflow
+	for _, wf := range s.workflows {
+		if len(statuses) == 0 || slices.Contains(statuses, wf.Status) {
+			list = append(list, wf.clone())
+		}
+	}
+	return list, nil
+}
+
+func waitWorkflow(t *testing.T, c *CrontabPool, workflowId string) *Workflow {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		wf, err := c.GetWorkflow(context.Background(), workflowId)
+		if err != nil {
+			t.Fatalf("GetWorkflow() error = %v", err)
+		}
+		if wf.Status != WorkflowStatusRunning && !wf.active() {
+			return wf
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("workflow %s did not finish", workflowId)
+	return nil
+}
+
+func TestCrontabPoolWorkflow(t *testing.T) {
+	var lock sync.Mutex
+	var order []string
+	RegisterJobFactory("wf-step", func(record *JobRecord) (cron.Job, error) {
+		return JobFunc(func(ctx context.Context) error {
+			lock.Lock()
+			order = append(order, record.Name)
+			lock.Unlock()
+			switch record.Payload {
+			case "fail":
+				return errors.New("step failed")
+			case "block":
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return nil
+		}), nil
+	})
+	ctx := context.Background()
+	c := NewCrontabPool()
+	c.Start()
+	defer c.Shutdown(ctx)
+
+	wf := &Workflow{Name: "report", Steps: []*WorkflowStep{
+		{Name: "extract", JobType: "wf-step"},
+		{Name: "transform", JobType: "wf-step", Payload: "fail", DependsOn: []string{"extract"}},
+		{Name: "notify", JobType: "wf-step", DependsOn: []string{"extract"}},
+		{Name: "load", JobType: "wf-step", DependsOn: []string{"transform", "notify"}},
+	}}
+	if err := c.StartWorkflow(ctx, wf); err != nil {
+		t.Fatalf("StartWorkflow() error = %v", err)
+	}
+	got := waitWorkflow(t, c, wf.WorkflowId)
+	want := map[string]StepStatus{"extract": StepStatusSuccess, "transform": StepStatusFailed, "notify": StepStatusSuccess, "load": StepStatusSkipped}
+	for _, step := range got.Steps {
+		if step.Status != want[step.Name] {
+			t.Errorf("step %s status = %s, want %s", step.Name, step.Status, want[step.Name])
+		}
+	}
+	if got.Status != WorkflowStatusFailed || order[0] != "extract" || len(order) != 3 {
+		t.Errorf("workflow status = %s, order = %v", got.Status, order)
+	}
+
+	blocked := &Workflow{Steps: []*WorkflowStep{
+		{Name: "wait", JobType: "wf-step", Payload: "block"},
+		{Name: "after", JobType: "wf-step", DependsOn: []string{"wait"}},
+	}}
+	if err := c.StartWorkflow(ctx, blocked); err != nil {
+		t.Fatalf("StartWorkflow() error = %v", err)
+	}
+	if err := c.CancelWorkflow(ctx, blocked.WorkflowId); err != nil {
+		t.Fatalf("CancelWorkflow() error = %v", err)
+	}
+	got = waitWorkflow(t, c, blocked.WorkflowId)
+	if got.Status != WorkflowStatusCancelled || got.Steps[0].Status != StepStatusCancelled || got.Steps[1].Status != StepStatusCancelled {
+		t.Errorf("cancelled workflow = %s, steps = %s, %s", got.Status, got.Steps[0].Status, got.Steps[1].Status)
+	}
+	if err := c.CancelWorkflow(ctx, blocked.WorkflowId); !errors.Is(err, ErrWorkflowFinished) {
+		t.Errorf("CancelWorkflow() again error = %v, want ErrWorkflowFinished", err)
+	}
+
+	cyclic := &Workflow{Steps: []*WorkflowStep{
+		{Name: "a", JobType: "wf-step", DependsOn: []string{"b"}},
+		{Name: "b", JobType: "wf-step", DependsOn: []string{"a"}},
+	}}
+	if err := c.StartWorkflow(ctx, cyclic); !errors.Is(err, ErrInvalidWorkflow) {
+		t.Errorf("StartWorkflow() cyclic error = %v, want ErrInvalidWorkflow", err)
+	}
+}
+
+func TestCrontabPoolWorkflowCluster(t *testing.T) {
+	release := make(chan struct{})
+	var runs sync.Map
+	RegisterJobFactory("wf-cluster-step", func(record *JobRecord) (cron.Job, error) {
+		return JobFunc(func(ctx context.Context) error {
+			n, _ := runs.LoadOrStore(record.TaskUuid, new(atomic.Int32))
+			n.(*atomic.Int32).Add(1)
+			if record.Payload == "hold" {
+				<-release
+			}
+			return nil
+		}), nil
+	})
+	ctx := context.Background()
+	store := newMemoryWorkflowStore()
+	locks := newMemoryLockStore()
+	a := NewCrontabPool(WithWorkflowStore(store), WithCluster(ClusterConf{Mode: ClusterModePerFire, Locks: locks, NodeId: "a"}))
+	b := NewCrontabPool(WithWorkflowStore(store), WithCluster(ClusterConf{Mode: ClusterModePerFire, Locks: locks, NodeId: "b"}))
+	defer a.Shutdown(ctx)
+	defer b.Shutdown(ctx)
+
+	wf := &Workflow{WorkflowId: "report", Steps: []*WorkflowStep{
+		{Name: "wait", JobType: "wf-cluster-step", Payload: "hold"},
+		{Name: "after", JobType: "wf-cluster-step", DependsOn: []string{"wait"}},
+	}}
+	if err := a.StartWorkflow(ctx, wf); err != nil {
+		t.Fatalf("StartWorkflow() error = %v", err)
+	}
+	// note: a 持有租约，b 恢复时跳过，不会重复执行正在执行的步骤
+	if err := b.ResumeWorkflows(ctx); err != nil {
+		t.Fatalf("ResumeWorkflows() error = %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := runs.Load("report:wait"); n.(*atomic.Int32).Load() != 1 {
+		t.Errorf("step wait ran %d times, want 1", n.(*atomic.Int32).Load())
+	}
+
+	// note: 由 b 取消，a 在当前步骤结束后读到取消状态，不会覆盖
+	if err := b.CancelWorkflow(ctx, "report"); err != nil {
+		t.Fatalf("CancelWorkflow() error = %v", err)
+	}
+	close(release)
+	got := waitWorkflow(t, a, "report")
+	if got.Status != WorkflowStatusCancelled || got.Steps[1].Status != StepStatusCancelled {
+		t.Errorf("workflow = %s, steps = %s, %s", got.Status, got.Steps[0].Status, got.Steps[1].Status)
+	}
+	if _, ok := runs.Load("report:after"); ok {
+		t.Error("step after ran after the workflow was cancelled")
+	}
+	key := fmt.Sprintf(workflowKeyFormat, DefaultClusterPrefix, "report")
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(5 * time.Millisecond) {
+		locks.lock.Lock()
+		owner, leased := locks.owners[key]
+		locks.lock.Unlock()
+		if !leased {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("workflow lease still held by %s", owner)
+		}
+	}
+}
+
+// cancelRaceStore 在 armed 之后的第一次 Get 返回后取消工作流，模拟其他节点的取消发生在执行节点读取之后、保存之前
+type cancelRaceStore struct {
+	*memoryWorkflowStore
+	armed atomic.Bool
+}
+
+func (s *cancelRaceStore) Get(ctx context.Context, workflowId string) (*Workflow, error) {
+	wf, err := s.memoryWorkflowStore.Get(ctx, workflowId)
+	if err == nil && s.armed.CompareAndSwap(true, false) {
+		cancelled := wf.clone()
+		cancelled.cancel()
+		if err := s.memoryWorkflowStore.Save(ctx, cancelled); err != nil {
+			return nil, err
+		}
+	}
+	return wf, err
+}
+
+func TestCrontabPoolWorkflowCancelRace(t *testing.T) {
+	release := make(chan struct{})
+	var after atomic.Bool
+	RegisterJobFactory("wf-race-step", func(record *JobRecord) (cron.Job, error) {
+		return JobFunc(func(ctx context.Context) error {
+			if record.Name == "after" {
+				after.Store(true)
+			} else {
+				<-release
+			}
+			return nil
+		}), nil
+	})
+	ctx := context.Background()
+	store := &cancelRaceStore{memoryWorkflowStore: newMemoryWorkflowStore()}
+	c := NewCrontabPool(WithWorkflowStore(store))
+	defer c.Shutdown(ctx)
+
+	wf := &Workflow{WorkflowId: "report", Steps: []*WorkflowStep{
+		{Name: "wait", JobType: "wf-race-step"},
+		{Name: "after", JobType: "wf-race-step", DependsOn: []string{"wait"}},
+	}}
+	if err := c.StartWorkflow(ctx, wf); err != nil {
+		t.Fatalf("StartWorkflow() error = %v", err)
+	}
+	if err := c.StartWorkflow(ctx, &Workflow{WorkflowId: "report", Steps: wf.Steps}); !errors.Is(err, ErrWorkflowExists) {
+		t.Errorf("StartWorkflow() duplicate error = %v, want ErrWorkflowExists", err)
+	}
+	store.armed.Store(true)
+	close(release)
+
+	// note: advance 读到的还是 running，保存时版本不一致，重新读取后以取消为准，不会用旧的快照覆盖取消
+	got := waitWorkflow(t, c, "report")
+	if got.Status != WorkflowStatusCancelled || got.Steps[0].Status != StepStatusSuccess || got.Steps[1].Status != StepStatusCancelled {
+		t.Errorf("workflow = %s, steps = %s, %s", got.Status, got.Steps[0].Status, got.Steps[1].Status)
+	}
+	if after.Load() {
+		t.Error("step after ran after the workflow was cancelled")
+	}
+}
+
+func newTestGormWorkflowStore(t *testing.T) WorkflowStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&CrontabWorkflow{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormWorkflowStore(rexDao.NewDao(db), "")
+}
+
+func TestGormWorkflowStore(t *testing.T) {
+	ctx := context.Background()
+	store := newTestGormWorkflowStore(t)
+
+	wf := &Workflow{WorkflowId: "report", Name: "report", Status: WorkflowStatusRunning, CreatedAt: time.UnixMilli(1700000000000),
+		Steps: []*WorkflowStep{{Name: "extract", JobType: "noop", Status: StepStatusRunning}}}
+	if err := store.Save(ctx, wf); err != nil || wf.Version != 1 {
+		t.Fatalf("Save() create = %v, version %d, want version 1", err, wf.Version)
+	}
+	if err := store.Save(ctx, &Workflow{WorkflowId: "report"}); !errors.Is(err, ErrWorkflowExists) {
+		t.Errorf("Save() duplicate error = %v, want ErrWorkflowExists", err)
+	}
+
+	stale := wf.clone()
+	wf.Steps[0].Status = StepStatusSuccess
+	wf.Status = WorkflowStatusSucceeded
+	wf.EndAt = wf.CreatedAt.Add(time.Minute)
+	if err := store.Save(ctx, wf); err != nil || wf.Version != 2 {
+		t.Fatalf("Save() update = %v, version %d, want version 2", err, wf.Version)
+	}
+	// note: 旧的快照不会覆盖已经结束的状态
+	if err := store.Save(ctx, stale); !errors.Is(err, ErrWorkflowConflict) {
+		t.Errorf("Save() stale error = %v, want ErrWorkflowConflict", err)
+	}
+	if err := store.Save(ctx, &Workflow{WorkflowId: "missing", Version: 1}); !errors.Is(err, ErrWorkflowNotFound) {
+		t.Errorf("Save() missing error = %v, want ErrWorkflowNotFound", err)
+	}
+
+	got, err := store.Get(ctx, "report")
+	if err != nil || got.Version != 2 || got.Status != WorkflowStatusSucceeded || got.Steps[0].Status != StepStatusSuccess || !got.EndAt.Equal(wf.EndAt) {
+		t.Fatalf("Get() = %+v, %v", got, err)
+	}
+	if list, err := store.List(ctx, WorkflowStatusRunning); err != nil || len(list) != 0 {
+		t.Errorf("List(running) = %d, %v, want none", len(list), err)
+	}
+	if _, err := store.Get(ctx, "missing"); !errors.Is(err, ErrWorkflowNotFound) {
+		t.Errorf("Get() missing error = %v, want ErrWorkflowNotFound", err)
+	}
+}
@@ -52,4 +52,8 @@ type (
 	CtxDeviceModel     struct{}
 
 	CtxFingerprint struct{}
+
+	CtxTaskSourceType struct{}
+	CtxTaskSourceId   struct{}
+	CtxTaskDeliveryId struct{}
 )
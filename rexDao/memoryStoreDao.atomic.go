@@ -0,0 +1,245 @@
+package rexDao
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func (d *memoryRedisDao) HMGet(key string, fields ...string) ([]string, error) {
+	return d.HMGetCtx(context.Background(), key, fields...)
+}
+
+func (d *memoryRedisDao) HMGetCtx(ctx context.Context, key string, fields ...string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := make([]string, len(fields))
+	v := d.live(key)
+	if v == nil {
+		return result, nil
+	}
+	if v.kind != memoryKindHash {
+		return nil, ErrMemoryWrongType
+	}
+	for i, f := range fields {
+		result[i] = v.hash[f]
+	}
+	return result, nil
+}
+
+func (d *memoryRedisDao) Scan(match string, count int64) ([]string, error) {
+	return d.ScanCtx(context.Background(), match, count)
+}
+
+// ScanCtx 一次返回全部匹配的 key，count 没有作用
+func (d *memoryRedisDao) ScanCtx(ctx context.Context, match string, count int64) ([]string, error) {
+	if match == "" {
+		match = "*"
+	}
+	return d.KeysCtx(ctx, match)
+}
+
+func (d *memoryRedisDao) ZAdd(key string, score float64, member string) (int, error) {
+	return d.ZAddCtx(context.Background(), key, score, member)
+}
+
+func (d *memoryRedisDao) ZAddCtx(ctx context.Context, key string, score float64, member string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.liveOrCreate(key, memoryKindZSet)
+	if err != nil {
+		return 0, err
+	}
+	_, exists := v.zset[member]
+	v.zset[member] = score
+	if exists {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (d *memoryRedisDao) ZRem(key string, members ...string) (int, error) {
+	return d.ZRemCtx(context.Background(), key, members...)
+}
+
+func (d *memoryRedisDao) ZRemCtx(ctx context.Context, key string, members ...string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.zrem(key, members...)
+}
+
+func (d *memoryRedisDao) ZRangeByScore(key string, min, max float64, limit int64) ([]redis.Z, error) {
+	return d.ZRangeByScoreCtx(context.Background(), key, min, max, limit)
+}
+
+// ZRangeByScoreCtx 和 redis 一致，分数相同时按成员字典序排列
+func (d *memoryRedisDao) ZRangeByScoreCtx(ctx context.Context, key string, min, max float64, limit int64) ([]redis.Z, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return []redis.Z{}, nil
+	}
+	if v.kind != memoryKindZSet {
+		return nil, ErrMemoryWrongType
+	}
+	result := []redis.Z{}
+	for m, score := range v.zset {
+		if score >= min && score <= max {
+			result = append(result, redis.Z{Score: score, Member: m})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score < result[j].Score
+		}
+		return result[i].Member.(string) < result[j].Member.(string)
+	})
+	if limit > 0 && int64(len(result)) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (d *memoryRedisDao) ZCard(key string) (int, error) {
+	return d.ZCardCtx(context.Background(), key)
+}
+
+func (d *memoryRedisDao) ZCardCtx(ctx context.Context, key string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return 0, nil
+	}
+	if v.kind != memoryKindZSet {
+		return 0, ErrMemoryWrongType
+	}
+	return len(v.zset), nil
+}
+
+func (d *memoryRedisDao) ZCount(key string, min, max float64) (int, error) {
+	return d.ZCountCtx(context.Background(), key, min, max)
+}
+
+func (d *memoryRedisDao) ZCountCtx(ctx context.Context, key string, min, max float64) (int, error) {
+	list, err := d.ZRangeByScoreCtx(ctx, key, min, max, 0)
+	if err != nil {
+		return 0, err
+	}
+	return len(list), nil
+}
+
+func (d *memoryRedisDao) ZMove(src, dst, member string, score float64) (bool, error) {
+	return d.ZMoveCtx(context.Background(), src, dst, member, score)
+}
+
+func (d *memoryRedisDao) ZMoveCtx(ctx context.Context, src, dst, member string, score float64) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if v := d.live(dst); v != nil && v.kind != memoryKindZSet {
+		return false, ErrMemoryWrongType
+	}
+	n, err := d.zrem(src, member)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	v, err := d.liveOrCreate(dst, memoryKindZSet)
+	if err != nil {
+		return false, err
+	}
+	v.zset[member] = score
+	return true, nil
+}
+
+func (d *memoryRedisDao) CompareAndSwap(key, expect, value string, seconds int) (bool, error) {
+	return d.CompareAndSwapCtx(context.Background(), key, expect, value, seconds)
+}
+
+func (d *memoryRedisDao) CompareAndSwapCtx(ctx context.Context, key, expect, value string, seconds int) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	current, exists, err := d.str(key)
+	if err != nil {
+		return false, err
+	}
+	// note: expect 为空时 key 不存在也算匹配
+	if !(exists && current == expect) && !(!exists && expect == "") {
+		return false, nil
+	}
+	v := &memoryValue{kind: memoryKindString, str: value}
+	if seconds > 0 {
+		v.expireAt = d.now().Add(time.Duration(seconds) * time.Second)
+	}
+	d.data[key] = v
+	return true, nil
+}
+
+func (d *memoryRedisDao) CompareAndDelete(key, expect string) (bool, error) {
+	return d.CompareAndDeleteCtx(context.Background(), key, expect)
+}
+
+func (d *memoryRedisDao) CompareAndDeleteCtx(ctx context.Context, key, expect string) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	current, exists, err := d.str(key)
+	if err != nil || !exists || current != expect {
+		return false, err
+	}
+	delete(d.data, key)
+	return true, nil
+}
+
+func (d *memoryRedisDao) CompareAndExpire(key, expect string, seconds int) (bool, error) {
+	return d.CompareAndExpireCtx(context.Background(), key, expect, seconds)
+}
+
+func (d *memoryRedisDao) CompareAndExpireCtx(ctx context.Context, key, expect string, seconds int) (bool, error) {
+	if seconds <= 0 {
+		return false, ErrInvalidExpireTime
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	current, exists, err := d.str(key)
+	if err != nil || !exists || current != expect {
+		return false, err
+	}
+	d.data[key].expireAt = d.now().Add(time.Duration(seconds) * time.Second)
+	return true, nil
+}
+
+// str 读取字符串值，调用方需要持有锁
+func (d *memoryRedisDao) str(key string) (string, bool, error) {
+	v := d.live(key)
+	if v == nil {
+		return "", false, nil
+	}
+	if v.kind != memoryKindString {
+		return "", false, ErrMemoryWrongType
+	}
+	return v.str, true, nil
+}
+
+// zrem 调用方需要持有锁
+func (d *memoryRedisDao) zrem(key string, members ...string) (int, error) {
+	v := d.live(key)
+	if v == nil {
+		return 0, nil
+	}
+	if v.kind != memoryKindZSet {
+		return 0, ErrMemoryWrongType
+	}
+	count := 0
+	for _, m := range members {
+		if _, ok := v.zset[m]; ok {
+			delete(v.zset, m)
+			count++
+		}
+	}
+	if len(v.zset) == 0 {
+		delete(d.data, key)
+	}
+	return count, nil
+}
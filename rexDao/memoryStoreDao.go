@@ -0,0 +1,774 @@
+package rexDao
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrMemoryWrongType mimics the redis WRONGTYPE error.
+	ErrMemoryWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// ErrMemoryClosed is an error that indicates the memory store has been closed.
+	ErrMemoryClosed = errors.New("memory redis dao closed")
+	// ErrMemoryNoClient is returned by every command sent through MemoryRedisDao.GetRD.
+	ErrMemoryNoClient = errors.New("memory redis dao has no redis client, use the RedisDao methods")
+)
+
+type memoryKind int
+
+const (
+	memoryKindString memoryKind = iota + 1
+	memoryKindHash
+	memoryKindSet
+	memoryKindZSet
+)
+
+const memorySubscriberBuffer = 128
+
+type (
+	// MemoryRedisDao 是 RedisDao 的进程内实现，用于单元测试，不需要启动真实的 redis
+	MemoryRedisDao interface {
+		RedisDao
+		// Now 返回当前使用的时钟
+		Now() time.Time
+		// SetNow 冻结时钟到指定时间
+		SetNow(t time.Time)
+		// Advance 拨动时钟，未冻结时会以当前时间为起点冻结
+		Advance(d time.Duration)
+		// FlushAll 清空所有数据
+		FlushAll()
+	}
+	memoryRedisDao struct {
+		lock     sync.Mutex
+		frozen   bool
+		frozenAt time.Time
+		data     map[string]*memoryValue
+		subs     map[string]map[*memorySubscriber]struct{}
+		closed   bool
+		rd       *redis.Client
+	}
+	memoryValue struct {
+		kind     memoryKind
+		str      string
+		hash     map[string]string
+		set      map[string]struct{}
+		zset     map[string]float64
+		expireAt time.Time
+	}
+	memorySubscriber struct {
+		ch   chan *redis.Message
+		done chan struct{}
+		once sync.Once
+	}
+)
+
+func NewMemoryRedisDao() MemoryRedisDao {
+	return &memoryRedisDao{
+		data: make(map[string]*memoryValue),
+		subs: make(map[string]map[*memorySubscriber]struct{}),
+		// note: 直接使用 *redis.Client 的代码不会 panic，每个命令都返回 ErrMemoryNoClient
+		rd: redis.NewClient(&redis.Options{
+			Addr:       "memory",
+			MaxRetries: -1,
+			Dialer: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return nil, ErrMemoryNoClient
+			},
+		}),
+	}
+}
+
+func (d *memoryRedisDao) Now() time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.now()
+}
+
+func (d *memoryRedisDao) SetNow(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.frozen = true
+	d.frozenAt = t
+}
+
+func (d *memoryRedisDao) Advance(dur time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.frozen {
+		d.frozen = true
+		d.frozenAt = time.Now()
+	}
+	d.frozenAt = d.frozenAt.Add(dur)
+}
+
+func (d *memoryRedisDao) FlushAll() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.data = make(map[string]*memoryValue)
+}
+
+// GetRD 返回的 client 不连接任何 redis，所有命令都返回 ErrMemoryNoClient
+func (d *memoryRedisDao) GetRD() *redis.Client {
+	return d.rd
+}
+
+func (d *memoryRedisDao) Ping() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if d.closed {
+		return ErrMemoryClosed
+	}
+	return nil
+}
+
+func (d *memoryRedisDao) Close() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.closed = true
+	for _, subs := range d.subs {
+		for sub := range subs {
+			sub.close()
+		}
+	}
+	d.subs = make(map[string]map[*memorySubscriber]struct{})
+	return nil
+}
+
+func (d *memoryRedisDao) Set(key string, value interface{}) error {
+	return d.SetCtx(context.Background(), key, value)
+}
+
+func (d *memoryRedisDao) SetCtx(ctx context.Context, key string, value interface{}) error {
+	return d.set(ctx, key, value, 0, false)
+}
+
+func (d *memoryRedisDao) SetEx(key string, value interface{}, seconds int) error {
+	return d.SetExCtx(context.Background(), key, value, seconds)
+}
+
+func (d *memoryRedisDao) SetExCtx(ctx context.Context, key string, value interface{}, seconds int) error {
+	if seconds <= 0 {
+		return ErrInvalidExpireTime
+	}
+	return d.set(ctx, key, value, time.Duration(seconds)*time.Second, false)
+}
+
+func (d *memoryRedisDao) SetNxEx(key string, value interface{}, seconds int) (bool, error) {
+	return d.SetNxExCtx(context.Background(), key, value, seconds)
+}
+
+func (d *memoryRedisDao) SetNxExCtx(ctx context.Context, key string, value interface{}, seconds int) (bool, error) {
+	err := d.set(ctx, key, value, time.Duration(seconds)*time.Second, true)
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (d *memoryRedisDao) Get(key string) (string, error) {
+	return d.GetCtx(context.Background(), key)
+}
+
+func (d *memoryRedisDao) GetCtx(ctx context.Context, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return "", nil
+	}
+	if v.kind != memoryKindString {
+		return "", ErrMemoryWrongType
+	}
+	return v.str, nil
+}
+
+func (d *memoryRedisDao) NewWatcher(channel string, fn func(msg *redis.Message)) error {
+	return d.NewWatcherCtx(context.Background(), channel, fn)
+}
+
+// NewWatcherCtx 与 redis 实现一致，会阻塞直到 ctx 结束或者 Close 被调用
+func (d *memoryRedisDao) NewWatcherCtx(ctx context.Context, channel string, fn func(msg *redis.Message)) error {
+	sub := &memorySubscriber{
+		ch:   make(chan *redis.Message, memorySubscriberBuffer),
+		done: make(chan struct{}),
+	}
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return ErrMemoryClosed
+	}
+	if d.subs[channel] == nil {
+		d.subs[channel] = make(map[*memorySubscriber]struct{})
+	}
+	d.subs[channel][sub] = struct{}{}
+	d.lock.Unlock()
+
+	defer func() {
+		d.lock.Lock()
+		delete(d.subs[channel], sub)
+		d.lock.Unlock()
+		sub.close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-sub.done:
+			return nil
+		case msg := <-sub.ch:
+			fn(msg)
+		}
+	}
+}
+
+func (d *memoryRedisDao) Publish(channel string, msg string) error {
+	return d.PublishCtx(context.Background(), channel, msg)
+}
+
+func (d *memoryRedisDao) PublishCtx(ctx context.Context, channel string, msg string) error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return ErrMemoryClosed
+	}
+	subs := make([]*memorySubscriber, 0, len(d.subs[channel]))
+	for sub := range d.subs[channel] {
+		subs = append(subs, sub)
+	}
+	d.lock.Unlock()
+
+	// note: 和 redis 一样不等待订阅者，缓冲区满时丢弃这条消息
+	for _, sub := range subs {
+		select {
+		case sub.ch <- &redis.Message{Channel: channel, Payload: msg}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (d *memoryRedisDao) Ttl(key string) (int, error) {
+	return d.TtlCtx(context.Background(), key)
+}
+
+func (d *memoryRedisDao) TtlCtx(ctx context.Context, key string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil || v.expireAt.IsZero() {
+		return 0, nil
+	}
+	return int(v.expireAt.Sub(d.now()) / time.Second), nil
+}
+
+func (d *memoryRedisDao) Del(keys ...string) (int, error) {
+	return d.DelCtx(context.Background(), keys...)
+}
+
+func (d *memoryRedisDao) DelCtx(ctx context.Context, keys ...string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	count := 0
+	for _, key := range keys {
+		if d.live(key) != nil {
+			delete(d.data, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (d *memoryRedisDao) Keys(pattern string) ([]string, error) {
+	return d.KeysCtx(context.Background(), pattern)
+}
+
+func (d *memoryRedisDao) KeysCtx(ctx context.Context, pattern string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := []string{}
+	for key := range d.data {
+		if d.live(key) == nil {
+			continue
+		}
+		if globMatch(pattern, key) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (d *memoryRedisDao) MGet(keys []string) ([]string, error) {
+	return d.MGetCtx(context.Background(), keys)
+}
+
+// MGetCtx 与 redis 实现一致，会去除不存在或者不是字符串的 key
+func (d *memoryRedisDao) MGetCtx(ctx context.Context, keys []string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := []string{}
+	for _, key := range keys {
+		v := d.live(key)
+		if v == nil || v.kind != memoryKindString {
+			continue
+		}
+		result = append(result, v.str)
+	}
+	return result, nil
+}
+
+func (d *memoryRedisDao) Expire(key string, seconds int) (bool, error) {
+	return d.ExpireCtx(context.Background(), key, seconds)
+}
+
+func (d *memoryRedisDao) ExpireCtx(ctx context.Context, key string, seconds int) (bool, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return false, nil
+	}
+	if seconds <= 0 {
+		delete(d.data, key)
+		return true, nil
+	}
+	v.expireAt = d.now().Add(time.Duration(seconds) * time.Second)
+	return true, nil
+}
+
+func (d *memoryRedisDao) SAdd(key string, members ...interface{}) (int, error) {
+	return d.SAddCtx(context.Background(), key, members...)
+}
+
+func (d *memoryRedisDao) SAddCtx(ctx context.Context, key string, members ...interface{}) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.liveOrCreate(key, memoryKindSet)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, member := range members {
+		m, err := memoryArg(member)
+		if err != nil {
+			return count, err
+		}
+		if _, ok := v.set[m]; !ok {
+			v.set[m] = struct{}{}
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (d *memoryRedisDao) SRem(key string, members ...interface{}) (int, error) {
+	return d.SRemCtx(context.Background(), key, members...)
+}
+
+func (d *memoryRedisDao) SRemCtx(ctx context.Context, key string, members ...interface{}) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return 0, nil
+	}
+	if v.kind != memoryKindSet {
+		return 0, ErrMemoryWrongType
+	}
+	count := 0
+	for _, member := range members {
+		m, err := memoryArg(member)
+		if err != nil {
+			return count, err
+		}
+		if _, ok := v.set[m]; ok {
+			delete(v.set, m)
+			count++
+		}
+	}
+	if len(v.set) == 0 {
+		delete(d.data, key)
+	}
+	return count, nil
+}
+
+func (d *memoryRedisDao) SMembers(key string) ([]string, error) {
+	return d.SMembersCtx(context.Background(), key)
+}
+
+func (d *memoryRedisDao) SMembersCtx(ctx context.Context, key string) ([]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return []string{}, nil
+	}
+	if v.kind != memoryKindSet {
+		return nil, ErrMemoryWrongType
+	}
+	result := make([]string, 0, len(v.set))
+	for m := range v.set {
+		result = append(result, m)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func (d *memoryRedisDao) HSet(key string, values ...interface{}) (int, error) {
+	return d.HSetCtx(context.Background(), key, values...)
+}
+
+func (d *memoryRedisDao) HSetCtx(ctx context.Context, key string, values ...interface{}) (int, error) {
+	pairs, err := memoryHashPairs(values)
+	if err != nil {
+		return 0, err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v, err := d.liveOrCreate(key, memoryKindHash)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := 0; i < len(pairs); i += 2 {
+		if _, ok := v.hash[pairs[i]]; !ok {
+			count++
+		}
+		v.hash[pairs[i]] = pairs[i+1]
+	}
+	return count, nil
+}
+
+func (d *memoryRedisDao) HGet(key, field string) (string, error) {
+	return d.HGetCtx(context.Background(), key, field)
+}
+
+func (d *memoryRedisDao) HGetCtx(ctx context.Context, key, field string) (string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return "", nil
+	}
+	if v.kind != memoryKindHash {
+		return "", ErrMemoryWrongType
+	}
+	return v.hash[field], nil
+}
+
+func (d *memoryRedisDao) HGetAll(key string) (map[string]string, error) {
+	return d.HGetAllCtx(context.Background(), key)
+}
+
+func (d *memoryRedisDao) HGetAllCtx(ctx context.Context, key string) (map[string]string, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	result := map[string]string{}
+	v := d.live(key)
+	if v == nil {
+		return result, nil
+	}
+	if v.kind != memoryKindHash {
+		return nil, ErrMemoryWrongType
+	}
+	for f, val := range v.hash {
+		result[f] = val
+	}
+	return result, nil
+}
+
+func (d *memoryRedisDao) HDel(key string, fields ...string) (int, error) {
+	return d.HDelCtx(context.Background(), key, fields...)
+}
+
+func (d *memoryRedisDao) HDelCtx(ctx context.Context, key string, fields ...string) (int, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	v := d.live(key)
+	if v == nil {
+		return 0, nil
+	}
+	if v.kind != memoryKindHash {
+		return 0, ErrMemoryWrongType
+	}
+	count := 0
+	for _, f := range fields {
+		if _, ok := v.hash[f]; ok {
+			delete(v.hash, f)
+			count++
+		}
+	}
+	if len(v.hash) == 0 {
+		delete(d.data, key)
+	}
+	return count, nil
+}
+
+// set 在 nx 为 true 且 key 已存在时返回 redis.Nil
+func (d *memoryRedisDao) set(ctx context.Context, key string, value interface{}, ttl time.Duration, nx bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	str, err := memoryArg(value)
+	if err != nil {
+		return err
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if nx && d.live(key) != nil {
+		return redis.Nil
+	}
+	v := &memoryValue{kind: memoryKindString, str: str}
+	if ttl > 0 {
+		v.expireAt = d.now().Add(ttl)
+	}
+	d.data[key] = v
+	return nil
+}
+
+// live 返回未过期的值，过期的值会被惰性删除，调用方需要持有锁
+func (d *memoryRedisDao) live(key string) *memoryValue {
+	v, ok := d.data[key]
+	if !ok {
+		return nil
+	}
+	if !v.expireAt.IsZero() && !d.now().Before(v.expireAt) {
+		delete(d.data, key)
+		return nil
+	}
+	return v
+}
+
+func (d *memoryRedisDao) liveOrCreate(key string, kind memoryKind) (*memoryValue, error) {
+	v := d.live(key)
+	if v == nil {
+		v = &memoryValue{kind: kind}
+		switch kind {
+		case memoryKindHash:
+			v.hash = make(map[string]string)
+		case memoryKindSet:
+			v.set = make(map[string]struct{})
+		case memoryKindZSet:
+			v.zset = make(map[string]float64)
+		}
+		d.data[key] = v
+		return v, nil
+	}
+	if v.kind != kind {
+		return nil, ErrMemoryWrongType
+	}
+	return v, nil
+}
+
+func (d *memoryRedisDao) now() time.Time {
+	if d.frozen {
+		return d.frozenAt
+	}
+	return time.Now()
+}
+
+func (s *memorySubscriber) close() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// memoryArg 按 go-redis 的规则把参数转换成字符串
+func memoryArg(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int8:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int16:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 64), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		if v {
+			return "1", nil
+		}
+		return "0", nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatInt(v.Nanoseconds(), 10), nil
+	case encoding.BinaryMarshaler:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	default:
+		return "", fmt.Errorf("redis: can't marshal %T (implement encoding.BinaryMarshaler)", v)
+	}
+}
+
+func memoryHashPairs(values []interface{}) ([]string, error) {
+	if len(values) == 1 {
+		switch m := values[0].(type) {
+		case map[string]interface{}:
+			pairs := make([]string, 0, len(m)*2)
+			for f, v := range m {
+				s, err := memoryArg(v)
+				if err != nil {
+					return nil, err
+				}
+				pairs = append(pairs, f, s)
+			}
+			return pairs, nil
+		case map[string]string:
+			pairs := make([]string, 0, len(m)*2)
+			for f, v := range m {
+				pairs = append(pairs, f, v)
+			}
+			return pairs, nil
+		}
+	}
+	if len(values) == 0 || len(values)%2 != 0 {
+		return nil, errors.New("ERR wrong number of arguments for 'hset' command")
+	}
+	pairs := make([]string, 0, len(values))
+	for _, v := range values {
+		s, err := memoryArg(v)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, s)
+	}
+	return pairs, nil
+}
+
+// globMatch 实现 redis KEYS 命令的 glob 规则: * ? [abc] [^a] [a-z] 以及 \ 转义
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' && end+1 < len(pattern) {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				// note: 没有闭合的 [ 按普通字符处理
+				if s[0] != '[' {
+					return false
+				}
+				s = s[1:]
+				pattern = pattern[1:]
+				continue
+			}
+			if !globClassMatch(pattern[1:end], s[0]) {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[end+1:]
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+			s = s[1:]
+			pattern = pattern[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+func globClassMatch(class string, c byte) bool {
+	negate := false
+	if len(class) > 0 && class[0] == '^' {
+		negate = true
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		if class[i] == '\\' && i+1 < len(class) {
+			i++
+			if class[i] == c {
+				matched = true
+			}
+			continue
+		}
+		if i+2 < len(class) && class[i+1] == '-' {
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 2
+			continue
+		}
+		if class[i] == c {
+			matched = true
+		}
+	}
+	return matched != negate
+}
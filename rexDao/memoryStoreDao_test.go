@@ -0,0 +1,261 @@
+package rexDao
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func TestMemoryRedisDaoString(t *testing.T) {
+	d := NewMemoryRedisDao()
+	d.SetNow(time.Unix(1700000000, 0))
+
+	if err := d.SetEx("a", 1, 10); err != nil {
+		t.Fatalf("SetEx() error = %v", err)
+	}
+	if got, _ := d.Get("a"); got != "1" {
+		t.Errorf("Get(a) = %v, want 1", got)
+	}
+	if ttl, _ := d.Ttl("a"); ttl != 10 {
+		t.Errorf("Ttl(a) = %v, want 10", ttl)
+	}
+	if ok, _ := d.SetNxEx("a", "2", 10); ok {
+		t.Errorf("SetNxEx(a) should fail while key exists")
+	}
+
+	d.Advance(10 * time.Second)
+	if got, _ := d.Get("a"); got != "" {
+		t.Errorf("Get(a) = %v, want expired", got)
+	}
+	if ok, _ := d.SetNxEx("a", "2", 10); !ok {
+		t.Errorf("SetNxEx(a) should succeed after expiry")
+	}
+
+	_ = d.Set("b", true)
+	if got, _ := d.MGet([]string{"a", "missing", "b"}); !reflect.DeepEqual(got, []string{"2", "1"}) {
+		t.Errorf("MGet() = %v, want [2 1]", got)
+	}
+	if n, _ := d.Del("a", "missing"); n != 1 {
+		t.Errorf("Del() = %v, want 1", n)
+	}
+}
+
+func TestMemoryRedisDaoKeys(t *testing.T) {
+	d := NewMemoryRedisDao()
+	for _, k := range []string{"svc:user-1", "svc:user-2", "svc:order-1", "other:user-1", "svc:user-x"} {
+		_ = d.Set(k, "v")
+	}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{pattern: "svc:*", want: []string{"svc:order-1", "svc:user-1", "svc:user-2", "svc:user-x"}},
+		{pattern: "svc:user-?", want: []string{"svc:user-1", "svc:user-2", "svc:user-x"}},
+		{pattern: "svc:user-[0-9]", want: []string{"svc:user-1", "svc:user-2"}},
+		{pattern: "svc:user-[^1]", want: []string{"svc:user-2", "svc:user-x"}},
+		{pattern: "*user-1", want: []string{"other:user-1", "svc:user-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got, err := d.Keys(tt.pattern)
+			if err != nil {
+				t.Fatalf("Keys() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRedisDaoHashAndSet(t *testing.T) {
+	d := NewMemoryRedisDao()
+	if n, _ := d.HSet("h", "f1", "v1", "f2", 2); n != 2 {
+		t.Errorf("HSet() = %v, want 2", n)
+	}
+	if n, _ := d.HSet("h", map[string]interface{}{"f2": "x", "f3": "y"}); n != 1 {
+		t.Errorf("HSet(map) = %v, want 1", n)
+	}
+	if got, _ := d.HGetAll("h"); !reflect.DeepEqual(got, map[string]string{"f1": "v1", "f2": "x", "f3": "y"}) {
+		t.Errorf("HGetAll() = %v", got)
+	}
+	if _, err := d.Get("h"); err != ErrMemoryWrongType {
+		t.Errorf("Get(hash) error = %v, want %v", err, ErrMemoryWrongType)
+	}
+
+	if n, _ := d.SAdd("s", "a", "b", "a"); n != 2 {
+		t.Errorf("SAdd() = %v, want 2", n)
+	}
+	if n, _ := d.SRem("s", "a", "c"); n != 1 {
+		t.Errorf("SRem() = %v, want 1", n)
+	}
+	if got, _ := d.SMembers("s"); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("SMembers() = %v, want [b]", got)
+	}
+}
+
+func TestMemoryRedisDaoPubSub(t *testing.T) {
+	d := NewMemoryRedisDao()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	got := []string{}
+	received := make(chan struct{}, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = d.NewWatcherCtx(ctx, "ch", func(msg *redis.Message) {
+			mu.Lock()
+			got = append(got, msg.Payload)
+			mu.Unlock()
+			received <- struct{}{}
+		})
+	}()
+
+	// note: 等待订阅建立
+	for i := 0; i < 100; i++ {
+		impl := d.(*memoryRedisDao)
+		impl.lock.Lock()
+		n := len(impl.subs["ch"])
+		impl.lock.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_ = d.Publish("ch", "m1")
+	_ = d.Publish("other", "skip")
+	_ = d.Publish("ch", "m2")
+	<-received
+	<-received
+	cancel()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(got, []string{"m1", "m2"}) {
+		t.Errorf("received = %v, want [m1 m2]", got)
+	}
+}
+
+func TestMemoryRedisDaoCompareAndSwap(t *testing.T) {
+	d := NewMemoryRedisDao()
+	d.SetNow(time.Unix(1700000000, 0))
+
+	if ok, _ := d.CompareAndSwap("lock", "", "n1", 10); !ok {
+		t.Error("CompareAndSwap() on a missing key with empty expect = false")
+	}
+	if ok, _ := d.CompareAndSwap("lock", "n2", "n2", 10); ok {
+		t.Error("CompareAndSwap() with a stale expect = true")
+	}
+	if ok, _ := d.CompareAndSwap("lock", "n1", "n2", 10); !ok {
+		t.Error("CompareAndSwap() with the current value = false")
+	}
+	if got, _ := d.Get("lock"); got != "n2" {
+		t.Errorf("Get(lock) = %q, want n2", got)
+	}
+}
+
+func TestMemoryRedisDaoNoClient(t *testing.T) {
+	d := NewMemoryRedisDao()
+	if err := d.SetEx("a", "1", 0); !errors.Is(err, ErrInvalidExpireTime) {
+		t.Errorf("SetEx(0) error = %v, want ErrInvalidExpireTime", err)
+	}
+	if err := d.GetRD().Get(context.Background(), "a").Err(); !errors.Is(err, ErrMemoryNoClient) {
+		t.Errorf("GetRD().Get() error = %v, want ErrMemoryNoClient", err)
+	}
+}
+
+func TestMemoryRedisDaoPublishDoesNotBlock(t *testing.T) {
+	d := NewMemoryRedisDao()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	go func() {
+		_ = d.NewWatcherCtx(ctx, "ch", func(msg *redis.Message) { <-block })
+	}()
+	for i := 0; i < 100; i++ {
+		impl := d.(*memoryRedisDao)
+		impl.lock.Lock()
+		n := len(impl.subs["ch"])
+		impl.lock.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	finished := make(chan struct{})
+	go func() {
+		for i := 0; i < memorySubscriberBuffer*2; i++ {
+			_ = d.Publish("ch", "m")
+		}
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("Publish() blocked on a slow subscriber")
+	}
+}
+
+func TestMemoryRedisDaoScan(t *testing.T) {
+	d := NewMemoryRedisDao()
+	_ = d.Set("q", "1")
+	_ = d.Set("p", "1")
+	if got, _ := d.Scan("q*", 10); !reflect.DeepEqual(got, []string{"q"}) {
+		t.Errorf("Scan(q*) = %v", got)
+	}
+}
+
+func TestMemoryRedisDaoZSet(t *testing.T) {
+	d := NewMemoryRedisDao()
+	_, _ = d.ZAdd("q", 3, "c")
+	_, _ = d.ZAdd("q", 1, "a")
+	_, _ = d.ZAdd("q", 2, "b")
+	if got, _ := d.ZRangeByScore("q", math.Inf(-1), 2, 0); len(got) != 2 || got[0].Member != "a" || got[1].Member != "b" {
+		t.Errorf("ZRangeByScore() = %v, want a, b", got)
+	}
+	if ok, _ := d.ZMove("q", "claimed", "a", 9); !ok {
+		t.Error("ZMove(a) = false")
+	}
+	if ok, _ := d.ZMove("q", "claimed", "a", 9); ok {
+		t.Error("ZMove(a) twice = true")
+	}
+	if n, _ := d.ZCard("q"); n != 2 {
+		t.Errorf("ZCard(q) = %d, want 2", n)
+	}
+	if n, _ := d.ZCount("claimed", 9, 9); n != 1 {
+		t.Errorf("ZCount(claimed) = %d, want 1", n)
+	}
+	_, _ = d.HSet("h", "f1", "v1")
+	if got, _ := d.HMGet("h", "f1", "missing"); !reflect.DeepEqual(got, []string{"v1", ""}) {
+		t.Errorf("HMGet() = %v", got)
+	}
+}
+
+func TestMemoryRedisDaoCompareAndExpire(t *testing.T) {
+	d := NewMemoryRedisDao()
+	d.SetNow(time.Unix(1700000000, 0))
+
+	_ = d.SetEx("lock", "n1", 10)
+	if ok, _ := d.CompareAndExpire("lock", "n1", 30); !ok {
+		t.Error("CompareAndExpire() by the owner = false")
+	}
+	if ttl, _ := d.Ttl("lock"); ttl != 30 {
+		t.Errorf("Ttl(lock) = %d, want 30", ttl)
+	}
+	if ok, _ := d.CompareAndDelete("lock", "n2"); ok {
+		t.Error("CompareAndDelete() by another owner = true")
+	}
+	if ok, _ := d.CompareAndDelete("lock", "n1"); !ok {
+		t.Error("CompareAndDelete() by the owner = false")
+	}
+}
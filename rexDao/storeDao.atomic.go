@@ -0,0 +1,239 @@
+package rexDao
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrInvalidExpireTime mimics the redis error for a non-positive expire time.
+	ErrInvalidExpireTime = errors.New("ERR invalid expire time")
+)
+
+var (
+	// note: expect 为空字符串表示 key 不存在，seconds 小于等于 0 表示不过期
+	compareAndSwapScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if (current == false and ARGV[1] == "") or current == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call("SET", KEYS[1], ARGV[2], "EX", ARGV[3])
+	else
+		redis.call("SET", KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+	compareAndDeleteScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+	compareAndExpireScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("EXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	zMoveScript = redis.NewScript(`
+if redis.call("ZREM", KEYS[1], ARGV[1]) == 1 then
+	redis.call("ZADD", KEYS[2], ARGV[2], ARGV[1])
+	return 1
+end
+return 0`)
+)
+
+func (d *defaultRedisDao) HMGet(key string, fields ...string) ([]string, error) {
+	return d.HMGetCtx(context.Background(), key, fields...)
+}
+
+// HMGetCtx 返回值和 fields 一一对应，不存在的字段为空字符串
+func (d *defaultRedisDao) HMGetCtx(ctx context.Context, key string, fields ...string) ([]string, error) {
+	if len(fields) == 0 {
+		return []string{}, nil
+	}
+	values, err := d.rd.HMGet(ctx, key, fields...).Result()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, len(values))
+	for i, v := range values {
+		if s, ok := v.(string); ok {
+			result[i] = s
+		}
+	}
+	return result, nil
+}
+
+func (d *defaultRedisDao) Scan(match string, count int64) ([]string, error) {
+	return d.ScanCtx(context.Background(), match, count)
+}
+
+// ScanCtx 用 SCAN 遍历所有匹配的 key，不会像 KEYS 一样阻塞 redis，count 是每次迭代的提示数量
+func (d *defaultRedisDao) ScanCtx(ctx context.Context, match string, count int64) ([]string, error) {
+	seen := make(map[string]struct{})
+	result := []string{}
+	var cursor uint64
+	for {
+		keys, next, err := d.rd.Scan(ctx, cursor, match, count).Result()
+		if err != nil {
+			return nil, err
+		}
+		// note: SCAN 可能重复返回同一个 key
+		for _, key := range keys {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				result = append(result, key)
+			}
+		}
+		if next == 0 {
+			return result, nil
+		}
+		cursor = next
+	}
+}
+
+func (d *defaultRedisDao) ZAdd(key string, score float64, member string) (int, error) {
+	return d.ZAddCtx(context.Background(), key, score, member)
+}
+
+func (d *defaultRedisDao) ZAddCtx(ctx context.Context, key string, score float64, member string) (int, error) {
+	v, err := d.rd.ZAdd(ctx, key, redis.Z{Score: score, Member: member}).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) ZRem(key string, members ...string) (int, error) {
+	return d.ZRemCtx(context.Background(), key, members...)
+}
+
+func (d *defaultRedisDao) ZRemCtx(ctx context.Context, key string, members ...string) (int, error) {
+	if len(members) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(members))
+	for i, m := range members {
+		args[i] = m
+	}
+	v, err := d.rd.ZRem(ctx, key, args...).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) ZRangeByScore(key string, min, max float64, limit int64) ([]redis.Z, error) {
+	return d.ZRangeByScoreCtx(context.Background(), key, min, max, limit)
+}
+
+// ZRangeByScoreCtx 按分数升序返回 [min, max] 之间的成员，limit 小于等于 0 时返回全部
+func (d *defaultRedisDao) ZRangeByScoreCtx(ctx context.Context, key string, min, max float64, limit int64) ([]redis.Z, error) {
+	by := &redis.ZRangeBy{Min: formatScore(min), Max: formatScore(max)}
+	if limit > 0 {
+		by.Count = limit
+	}
+	return d.rd.ZRangeByScoreWithScores(ctx, key, by).Result()
+}
+
+func (d *defaultRedisDao) ZCard(key string) (int, error) {
+	return d.ZCardCtx(context.Background(), key)
+}
+
+func (d *defaultRedisDao) ZCardCtx(ctx context.Context, key string) (int, error) {
+	v, err := d.rd.ZCard(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) ZCount(key string, min, max float64) (int, error) {
+	return d.ZCountCtx(context.Background(), key, min, max)
+}
+
+func (d *defaultRedisDao) ZCountCtx(ctx context.Context, key string, min, max float64) (int, error) {
+	v, err := d.rd.ZCount(ctx, key, formatScore(min), formatScore(max)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) ZMove(src, dst, member string, score float64) (bool, error) {
+	return d.ZMoveCtx(context.Background(), src, dst, member, score)
+}
+
+// ZMoveCtx 原子地把 member 从 src 移到 dst 并设置新的分数，member 不在 src 中时返回 false
+func (d *defaultRedisDao) ZMoveCtx(ctx context.Context, src, dst, member string, score float64) (bool, error) {
+	n, err := zMoveScript.Run(ctx, d.rd, []string{src, dst}, member, formatScore(score)).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (d *defaultRedisDao) CompareAndSwap(key, expect, value string, seconds int) (bool, error) {
+	return d.CompareAndSwapCtx(context.Background(), key, expect, value, seconds)
+}
+
+// CompareAndSwapCtx 当前值等于 expect 时写入 value，expect 为空表示 key 不存在，seconds 小于等于 0 表示不过期
+func (d *defaultRedisDao) CompareAndSwapCtx(ctx context.Context, key, expect, value string, seconds int) (bool, error) {
+	n, err := compareAndSwapScript.Run(ctx, d.rd, []string{key}, expect, value, seconds).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (d *defaultRedisDao) CompareAndDelete(key, expect string) (bool, error) {
+	return d.CompareAndDeleteCtx(context.Background(), key, expect)
+}
+
+// CompareAndDeleteCtx 当前值等于 expect 时删除，常用于释放锁
+func (d *defaultRedisDao) CompareAndDeleteCtx(ctx context.Context, key, expect string) (bool, error) {
+	n, err := compareAndDeleteScript.Run(ctx, d.rd, []string{key}, expect).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (d *defaultRedisDao) CompareAndExpire(key, expect string, seconds int) (bool, error) {
+	return d.CompareAndExpireCtx(context.Background(), key, expect, seconds)
+}
+
+// CompareAndExpireCtx 当前值等于 expect 时重新设置过期时间，常用于续期锁
+func (d *defaultRedisDao) CompareAndExpireCtx(ctx context.Context, key, expect string, seconds int) (bool, error) {
+	if seconds <= 0 {
+		return false, ErrInvalidExpireTime
+	}
+	n, err := compareAndExpireScript.Run(ctx, d.rd, []string{key}, expect, seconds).Int()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// TtlSeconds 把 time.Duration 向上取整成 redis 使用的秒数，最少 1 秒
+func TtlSeconds(ttl time.Duration) int {
+	seconds := int((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
@@ -11,6 +11,8 @@ import (
 )
 
 type (
+	// RedisDao 是公开接口，新增方法会让外部自行实现的 RedisDao 无法编译，这一版本新增了 Hash、CompareAndSwap、Scan、ZSet 等约 40 个方法；
+	// 外部实现可以嵌入一个 RedisDao 只覆盖需要的方法，测试中请使用 NewMemoryRedisDao
 	RedisDao interface {
 		GetRD() *redis.Client
 		Ping() error
@@ -19,6 +21,8 @@ type (
 		SetCtx(ctx context.Context, key string, value interface{}) error
 		SetEx(key string, value interface{}, seconds int) error
 		SetExCtx(ctx context.Context, key string, value interface{}, seconds int) error
+		SetNxEx(key string, value interface{}, seconds int) (bool, error)
+		SetNxExCtx(ctx context.Context, key string, value interface{}, seconds int) (bool, error)
 		Get(key string) (string, error)
 		GetCtx(ctx context.Context, key string) (string, error)
 		NewWatcher(channel string, fn func(msg *redis.Message)) error
@@ -33,6 +37,44 @@ type (
 		KeysCtx(ctx context.Context, pattern string) ([]string, error)
 		MGet(keys []string) ([]string, error)
 		MGetCtx(ctx context.Context, keys []string) ([]string, error)
+		Expire(key string, seconds int) (bool, error)
+		ExpireCtx(ctx context.Context, key string, seconds int) (bool, error)
+		SAdd(key string, members ...interface{}) (int, error)
+		SAddCtx(ctx context.Context, key string, members ...interface{}) (int, error)
+		SRem(key string, members ...interface{}) (int, error)
+		SRemCtx(ctx context.Context, key string, members ...interface{}) (int, error)
+		SMembers(key string) ([]string, error)
+		SMembersCtx(ctx context.Context, key string) ([]string, error)
+		HSet(key string, values ...interface{}) (int, error)
+		HSetCtx(ctx context.Context, key string, values ...interface{}) (int, error)
+		HGet(key, field string) (string, error)
+		HGetCtx(ctx context.Context, key, field string) (string, error)
+		HGetAll(key string) (map[string]string, error)
+		HGetAllCtx(ctx context.Context, key string) (map[string]string, error)
+		HDel(key string, fields ...string) (int, error)
+		HDelCtx(ctx context.Context, key string, fields ...string) (int, error)
+		HMGet(key string, fields ...string) ([]string, error)
+		HMGetCtx(ctx context.Context, key string, fields ...string) ([]string, error)
+		Scan(match string, count int64) ([]string, error)
+		ScanCtx(ctx context.Context, match string, count int64) ([]string, error)
+		ZAdd(key string, score float64, member string) (int, error)
+		ZAddCtx(ctx context.Context, key string, score float64, member string) (int, error)
+		ZRem(key string, members ...string) (int, error)
+		ZRemCtx(ctx context.Context, key string, members ...string) (int, error)
+		ZRangeByScore(key string, min, max float64, limit int64) ([]redis.Z, error)
+		ZRangeByScoreCtx(ctx context.Context, key string, min, max float64, limit int64) ([]redis.Z, error)
+		ZCard(key string) (int, error)
+		ZCardCtx(ctx context.Context, key string) (int, error)
+		ZCount(key string, min, max float64) (int, error)
+		ZCountCtx(ctx context.Context, key string, min, max float64) (int, error)
+		ZMove(src, dst, member string, score float64) (bool, error)
+		ZMoveCtx(ctx context.Context, src, dst, member string, score float64) (bool, error)
+		CompareAndSwap(key, expect, value string, seconds int) (bool, error)
+		CompareAndSwapCtx(ctx context.Context, key, expect, value string, seconds int) (bool, error)
+		CompareAndDelete(key, expect string) (bool, error)
+		CompareAndDeleteCtx(ctx context.Context, key, expect string) (bool, error)
+		CompareAndExpire(key, expect string, seconds int) (bool, error)
+		CompareAndExpireCtx(ctx context.Context, key, expect string, seconds int) (bool, error)
 	}
 	defaultRedisDao struct {
 		rd *redis.Client
@@ -99,6 +141,19 @@ func (d *defaultRedisDao) SetExCtx(ctx context.Context, key string, value interf
 	return nil
 }
 
+func (d *defaultRedisDao) SetNxEx(key string, value interface{}, seconds int) (bool, error) {
+	return d.SetNxExCtx(context.Background(), key, value, seconds)
+}
+
+// SetNxExCtx 仅在 key 不存在时写入，返回是否写入成功，可用于抢占式加锁
+func (d *defaultRedisDao) SetNxExCtx(ctx context.Context, key string, value interface{}, seconds int) (bool, error) {
+	ok, err := d.rd.SetNX(ctx, key, value, time.Second*time.Duration(seconds)).Result()
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
 func (d *defaultRedisDao) GetCtx(ctx context.Context, key string) (string, error) {
 	if val, err := d.rd.Get(ctx, key).Result(); errors.Is(err, redis.Nil) {
 		return "", nil
@@ -119,6 +174,19 @@ func (d *defaultRedisDao) NewWatcher(channel string, fn func(msg *redis.Message)
 
 func (d *defaultRedisDao) NewWatcherCtx(ctx context.Context, channel string, fn func(msg *redis.Message)) error {
 	sub := d.rd.Subscribe(ctx, channel)
+	defer sub.Close()
+	// note: ctx 取消时关闭订阅，让下面的循环退出；订阅先结束时 done 让 goroutine 一起退出，不会泄漏
+	if ctx.Done() != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				_ = sub.Close()
+			case <-done:
+			}
+		}()
+	}
 	ch := sub.Channel()
 	for msg := range ch {
 		// 处理消息
@@ -199,3 +267,90 @@ func (d *defaultRedisDao) MGetCtx(ctx context.Context, keys []string) ([]string,
 	}
 	return result, nil
 }
+
+func (d *defaultRedisDao) Expire(key string, seconds int) (bool, error) {
+	return d.ExpireCtx(context.Background(), key, seconds)
+}
+
+func (d *defaultRedisDao) ExpireCtx(ctx context.Context, key string, seconds int) (bool, error) {
+	return d.rd.Expire(ctx, key, time.Second*time.Duration(seconds)).Result()
+}
+
+func (d *defaultRedisDao) SAdd(key string, members ...interface{}) (int, error) {
+	return d.SAddCtx(context.Background(), key, members...)
+}
+
+func (d *defaultRedisDao) SAddCtx(ctx context.Context, key string, members ...interface{}) (int, error) {
+	v, err := d.rd.SAdd(ctx, key, members...).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) SRem(key string, members ...interface{}) (int, error) {
+	return d.SRemCtx(context.Background(), key, members...)
+}
+
+func (d *defaultRedisDao) SRemCtx(ctx context.Context, key string, members ...interface{}) (int, error) {
+	v, err := d.rd.SRem(ctx, key, members...).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) SMembers(key string) ([]string, error) {
+	return d.SMembersCtx(context.Background(), key)
+}
+
+func (d *defaultRedisDao) SMembersCtx(ctx context.Context, key string) ([]string, error) {
+	return d.rd.SMembers(ctx, key).Result()
+}
+
+func (d *defaultRedisDao) HSet(key string, values ...interface{}) (int, error) {
+	return d.HSetCtx(context.Background(), key, values...)
+}
+
+// HSetCtx 支持 "f1", "v1", "f2", "v2" 或 map[string]interface{} 两种传参方式，返回新增字段数
+func (d *defaultRedisDao) HSetCtx(ctx context.Context, key string, values ...interface{}) (int, error) {
+	v, err := d.rd.HSet(ctx, key, values...).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func (d *defaultRedisDao) HGet(key, field string) (string, error) {
+	return d.HGetCtx(context.Background(), key, field)
+}
+
+func (d *defaultRedisDao) HGetCtx(ctx context.Context, key, field string) (string, error) {
+	if val, err := d.rd.HGet(ctx, key, field).Result(); errors.Is(err, redis.Nil) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	} else {
+		return val, nil
+	}
+}
+
+func (d *defaultRedisDao) HGetAll(key string) (map[string]string, error) {
+	return d.HGetAllCtx(context.Background(), key)
+}
+
+func (d *defaultRedisDao) HGetAllCtx(ctx context.Context, key string) (map[string]string, error) {
+	return d.rd.HGetAll(ctx, key).Result()
+}
+
+func (d *defaultRedisDao) HDel(key string, fields ...string) (int, error) {
+	return d.HDelCtx(context.Background(), key, fields...)
+}
+
+func (d *defaultRedisDao) HDelCtx(ctx context.Context, key string, fields ...string) (int, error) {
+	v, err := d.rd.HDel(ctx, key, fields...).Result()
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
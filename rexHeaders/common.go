@@ -24,6 +24,9 @@ const (
 	HeaderContentType    = "Content-Type"
 	HeaderXCSRFToken     = "X-CSRF-Token"
 
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
 	// note: 模仿aws签名算法实现的头
 	HeaderXRExDate          = "X-REx-Date"
 	HeaderXRExContentSha256 = "X-REx-Content-Sha256"
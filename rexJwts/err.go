@@ -3,13 +3,20 @@ package rexJwts
 import "errors"
 
 var (
-	ErrorTokenInvalid              = errors.New("invalid token")
-	ErrorTokenInvalidSignature     = errors.New("invalid signature")
-	ErrorJwtClaimsInvalid          = errors.New("invalid jwt claims")
-	ErrorTokenInvalidAudience      = errors.New("invalid audience")
-	ErrorTokenHasExpired           = errors.New("token has expired")
-	ErrorTokenInvalidIssuer        = errors.New("invalid issuer")
-	ErrorTokenNotActiveYet         = errors.New("token not active yet")
-	ErrorInvalidPublicKeyPEMFormat = errors.New("invalid public key PEM format")
-	ErrorPublicKeyNotECDSA         = errors.New("public key not ECDSA type")
+	ErrorTokenInvalid               = errors.New("invalid token")
+	ErrorTokenInvalidSignature      = errors.New("invalid signature")
+	ErrorJwtClaimsInvalid           = errors.New("invalid jwt claims")
+	ErrorTokenInvalidAudience       = errors.New("invalid audience")
+	ErrorTokenHasExpired            = errors.New("token has expired")
+	ErrorTokenInvalidIssuer         = errors.New("invalid issuer")
+	ErrorTokenNotActiveYet          = errors.New("token not active yet")
+	ErrorTokenUsedBeforeIssued      = errors.New("token used before issued")
+	ErrorTokenMissingExpiresAt      = errors.New("token missing exp")
+	ErrorTokenUnknownKey            = errors.New("unknown token key id")
+	ErrorTokenInvalidAlgorithm      = errors.New("unexpected signing algorithm")
+	ErrorUnsupportedAlgorithm       = errors.New("unsupported signing algorithm")
+	ErrorInvalidKey                 = errors.New("key does not match signing algorithm")
+	ErrorInvalidPublicKeyPEMFormat  = errors.New("invalid public key PEM format")
+	ErrorInvalidPrivateKeyPEMFormat = errors.New("invalid private key PEM format")
+	ErrorPublicKeyNotECDSA          = errors.New("public key not ECDSA type")
 )
@@ -6,7 +6,7 @@ import (
 
 const (
 	MapClaimsAudience  = "aud"
-	MapClaimsExpiresAt = `exp"`
+	MapClaimsExpiresAt = "exp"
 	MapClaimsId        = "jti"
 	MapClaimsIssuedAt  = "iat"
 	MapClaimsIssuer    = "iss"
@@ -7,12 +7,18 @@ import (
 	"time"
 )
 
+// Deprecated: 使用 Signer
 func JwtECDSACommonCreateToken(claims *jwt.StandardClaims, privateKey string) (string, int64, error) {
 	key, err := rexCrypto.ParseECDSAPrivateKeyFromPEM(privateKey)
 	if err != nil {
 		return "", -1, err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	// note: 算法按私钥的曲线选择，P-384 的私钥签发 ES384
+	alg, err := ecdsaAlgorithm(key)
+	if err != nil {
+		return "", -1, err
+	}
+	token := jwt.NewWithClaims(signingMethods[alg], claims)
 	tokenString, err := token.SignedString(key)
 	if err != nil {
 		return "", -1, err
@@ -20,6 +26,7 @@ func JwtECDSACommonCreateToken(claims *jwt.StandardClaims, privateKey string) (s
 	return tokenString, claims.ExpiresAt, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtECDSACommonParseAndVerifyToken(tokenString, certPem string) (*jwt.StandardClaims, error) {
 	key, err := rexCrypto.ParseECDSAPublicKeyFromCert(certPem)
 	if err != nil {
@@ -31,23 +38,25 @@ func JwtECDSACommonParseAndVerifyToken(tokenString, certPem string) (*jwt.Standa
 		}
 		return key, nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtECDSACommonParse(tokenString, certPem string) (*jwt.Token, error) {
 	key, err := rexCrypto.ParseECDSAPublicKeyFromCert(certPem)
 	if err != nil {
@@ -65,30 +74,27 @@ func JwtECDSACommonParse(tokenString, certPem string) (*jwt.Token, error) {
 	return token, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtECDSACommonVerify(token *jwt.Token, Audience string) (*jwt.StandardClaims, error) {
-	var err error
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证受众
 		if claims.Audience != Audience {
-			fmt.Println("Invalid audience")
 			return nil, ErrorTokenInvalidAudience
 		}
 
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 
 		// 验证tokenId和Subject, 无法验证, 返回标准结构体
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
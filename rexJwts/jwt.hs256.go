@@ -6,6 +6,7 @@ import (
 	"time"
 )
 
+// Deprecated: 使用 Signer
 func JwtCommonCreateToken(claims *jwt.StandardClaims, key string) (string, int64, error) {
 	//采用 	HMAC-sha256 加密算法
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -16,6 +17,7 @@ func JwtCommonCreateToken(claims *jwt.StandardClaims, key string) (string, int64
 	return tokenString, claims.ExpiresAt, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtCommonParseAndVerifyToken(tokenString, key string) (*jwt.StandardClaims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -23,23 +25,25 @@ func JwtCommonParseAndVerifyToken(tokenString, key string) (*jwt.StandardClaims,
 		}
 		return []byte(key), nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtCommonParse(tokenString, key string) (*jwt.Token, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.StandardClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -53,30 +57,27 @@ func JwtCommonParse(tokenString, key string) (*jwt.Token, error) {
 	return token, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtCommonVerify(token *jwt.Token, Audience string) (*jwt.StandardClaims, error) {
-	var err error
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证受众
 		if claims.Audience != Audience {
-			fmt.Println("Invalid audience")
 			return nil, ErrorTokenInvalidAudience
 		}
 
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 
 		// 验证tokenId和Subject, 无法验证, 返回标准结构体
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
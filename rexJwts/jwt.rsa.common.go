@@ -6,6 +6,7 @@ import (
 	"time"
 )
 
+// Deprecated: 使用 Signer
 func JwtRSACommonCreateToken(claims *jwt.StandardClaims, privateKey string) (string, int64, error) {
 	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(privateKey))
 	if err != nil {
@@ -20,6 +21,7 @@ func JwtRSACommonCreateToken(claims *jwt.StandardClaims, privateKey string) (str
 	return tokenString, claims.ExpiresAt, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtRSACommonParseAndVerifyToken(tokenString, pubKey string) (*jwt.StandardClaims, error) {
 	key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pubKey))
 	if err != nil {
@@ -31,23 +33,25 @@ func JwtRSACommonParseAndVerifyToken(tokenString, pubKey string) (*jwt.StandardC
 		}
 		return key, nil
 	})
+	if err != nil {
+		return nil, err
+	}
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtRSACommonParse(tokenString, pubKey string) (*jwt.Token, error) {
 	key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(pubKey))
 	if err != nil {
@@ -65,30 +69,27 @@ func JwtRSACommonParse(tokenString, pubKey string) (*jwt.Token, error) {
 	return token, nil
 }
 
+// Deprecated: 使用 NewVerifier
 func JwtRSACommonVerify(token *jwt.Token, Audience string) (*jwt.StandardClaims, error) {
-	var err error
 	if claims, ok := token.Claims.(*jwt.StandardClaims); ok && token.Valid {
 		// 验证受众
 		if claims.Audience != Audience {
-			fmt.Println("Invalid audience")
 			return nil, ErrorTokenInvalidAudience
 		}
 
 		// 验证过期时间
 		if time.Now().Unix() > claims.ExpiresAt {
-			fmt.Println("Token has expired")
 			return nil, ErrorTokenHasExpired
 		}
 
 		// 验证开始时间
 		if time.Now().Unix() < claims.NotBefore {
-			fmt.Println("Token not active yet")
 			return nil, ErrorTokenNotActiveYet
 		}
 
 		// 验证tokenId和Subject, 无法验证, 返回标准结构体
 		return claims, nil
 	} else {
-		return nil, err
+		return nil, ErrorTokenInvalid
 	}
 }
@@ -0,0 +1,338 @@
+package rexJwts
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rootexit/rexLib/rexCrypto"
+)
+
+// HeaderKeyId jwt header 中的 kid，Verifier 用它选择验签的 key
+const HeaderKeyId = "kid"
+
+// signingMethods 支持的算法，ES256K 和 none 不支持
+var signingMethods = map[rexCrypto.SignatureAlgorithm]jwt.SigningMethod{
+	rexCrypto.SignatureAlgorithmHS256: jwt.SigningMethodHS256,
+	rexCrypto.SignatureAlgorithmHS384: jwt.SigningMethodHS384,
+	rexCrypto.SignatureAlgorithmHS512: jwt.SigningMethodHS512,
+	rexCrypto.SignatureAlgorithmRS256: jwt.SigningMethodRS256,
+	rexCrypto.SignatureAlgorithmRS384: jwt.SigningMethodRS384,
+	rexCrypto.SignatureAlgorithmRS512: jwt.SigningMethodRS512,
+	rexCrypto.SignatureAlgorithmPS256: jwt.SigningMethodPS256,
+	rexCrypto.SignatureAlgorithmPS384: jwt.SigningMethodPS384,
+	rexCrypto.SignatureAlgorithmPS512: jwt.SigningMethodPS512,
+	rexCrypto.SignatureAlgorithmES256: jwt.SigningMethodES256,
+	rexCrypto.SignatureAlgorithmES384: jwt.SigningMethodES384,
+	rexCrypto.SignatureAlgorithmES512: jwt.SigningMethodES512,
+	rexCrypto.SignatureAlgorithmEdDSA: jwt.SigningMethodEdDSA,
+}
+
+type (
+	// Claims 自定义 claims 嵌入 RegisteredClaims 即可实现
+	Claims interface {
+		jwt.Claims
+		GetRegisteredClaims() *jwt.RegisteredClaims
+	}
+
+	// RegisteredClaims 标准字段 iss/sub/aud/exp/nbf/iat/jti
+	RegisteredClaims struct {
+		jwt.RegisteredClaims
+	}
+
+	// Signer 使用一个 key 签发 token，kid 不为空时写入 header
+	Signer struct {
+		alg    rexCrypto.SignatureAlgorithm
+		kid    string
+		method jwt.SigningMethod
+		key    interface{}
+	}
+
+	// VerifyKey 验签的 key，Key 为 HS 的密钥（[]byte 或 string）、RSA/ECDSA/Ed25519 公钥，也可以直接传私钥
+	VerifyKey struct {
+		Kid       string
+		Algorithm rexCrypto.SignatureAlgorithm
+		Key       interface{}
+	}
+
+	VerifyOptions struct {
+		// Issuer 不为空时 iss 必须相同
+		Issuer string `json:",optional"`
+		// Audience 不为空时 aud 必须包含它
+		Audience string `json:",optional"`
+		// Leeway 校验 exp/nbf/iat 时允许的时钟误差
+		Leeway time.Duration `json:",optional"`
+		// RequireExpiresAt 为 true 时没有 exp 的 token 无效
+		RequireExpiresAt bool `json:",optional"`
+	}
+
+	// Verifier 按 header 中的 kid 选择 key 验签，token 的 alg 必须和 key 的算法一致，避免算法混淆
+	Verifier[T any, PT interface {
+		*T
+		Claims
+	}] struct {
+		keys   map[string]VerifyKey
+		opts   VerifyOptions
+		parser *jwt.Parser
+		now    func() time.Time
+	}
+)
+
+func (c *RegisteredClaims) GetRegisteredClaims() *jwt.RegisteredClaims {
+	return &c.RegisteredClaims
+}
+
+// NewSigner key 为 HS 的密钥（[]byte 或 string）或者和算法匹配的私钥，ES 算法还要求曲线一致
+func NewSigner(alg rexCrypto.SignatureAlgorithm, kid string, key interface{}) (*Signer, error) {
+	method, ok := signingMethods[alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrorUnsupportedAlgorithm, alg)
+	}
+	key, err := checkKey(alg, key, true)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{alg: alg, kid: kid, method: method, key: key}, nil
+}
+
+// NewSignerFromPEM 私钥支持 PKCS8、PKCS1 和 SEC1 格式，HS 算法请使用 NewSigner
+func NewSignerFromPEM(alg rexCrypto.SignatureAlgorithm, kid, privateKeyPEM string) (*Signer, error) {
+	key, err := ParsePrivateKeyPEM(privateKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewSigner(alg, kid, key)
+}
+
+func (s *Signer) Algorithm() rexCrypto.SignatureAlgorithm {
+	return s.alg
+}
+
+func (s *Signer) Kid() string {
+	return s.kid
+}
+
+// Sign 签发 token，IssuedAt 为空时填写当前时间
+func (s *Signer) Sign(claims Claims) (string, error) {
+	registered := claims.GetRegisteredClaims()
+	if registered.IssuedAt == nil {
+		registered.IssuedAt = jwt.NewNumericDate(time.Now())
+	}
+	token := jwt.NewWithClaims(s.method, claims)
+	if s.kid != "" {
+		token.Header[HeaderKeyId] = s.kid
+	}
+	return token.SignedString(s.key)
+}
+
+// NewVerifier 创建验签器，T 为自定义 claims 的结构体类型，例如 NewVerifier[UserClaims](opts, keys...)；
+// kid 为空的 key 用来验证 header 中没有 kid 的 token
+func NewVerifier[T any, PT interface {
+	*T
+	Claims
+}](opts VerifyOptions, keys ...VerifyKey) (*Verifier[T, PT], error) {
+	v := &Verifier[T, PT]{
+		keys: make(map[string]VerifyKey, len(keys)),
+		opts: opts,
+		now:  time.Now,
+	}
+	for _, k := range keys {
+		if _, ok := signingMethods[k.Algorithm]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrorUnsupportedAlgorithm, k.Algorithm)
+		}
+		key, err := checkKey(k.Algorithm, k.Key, false)
+		if err != nil {
+			return nil, fmt.Errorf("kid %s: %w", k.Kid, err)
+		}
+		k.Key = key
+		v.keys[k.Kid] = k
+	}
+	// note: 算法在 keyfunc 中按 kid 校验，exp/nbf/iat 由 validate 带 leeway 校验
+	v.parser = jwt.NewParser(jwt.WithoutClaimsValidation())
+	return v, nil
+}
+
+// Verify 验签并校验 exp/nbf/iat/iss/aud，返回解析出的 claims
+func (v *Verifier[T, PT]) Verify(tokenString string) (*T, error) {
+	claims := PT(new(T))
+	_, err := v.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header[HeaderKeyId].(string)
+		k, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrorTokenUnknownKey, kid)
+		}
+		if token.Method.Alg() != string(k.Algorithm) {
+			return nil, fmt.Errorf("%w: %s", ErrorTokenInvalidAlgorithm, token.Method.Alg())
+		}
+		return k.Key, nil
+	})
+	if err != nil {
+		return nil, parseError(err)
+	}
+	if err := v.validate(claims.GetRegisteredClaims()); err != nil {
+		return nil, err
+	}
+	return (*T)(claims), nil
+}
+
+func (v *Verifier[T, PT]) validate(claims *jwt.RegisteredClaims) error {
+	now := v.now()
+	leeway := v.opts.Leeway
+	if claims.ExpiresAt == nil {
+		if v.opts.RequireExpiresAt {
+			return ErrorTokenMissingExpiresAt
+		}
+	} else if now.After(claims.ExpiresAt.Add(leeway)) {
+		return ErrorTokenHasExpired
+	}
+	if claims.NotBefore != nil && now.Add(leeway).Before(claims.NotBefore.Time) {
+		return ErrorTokenNotActiveYet
+	}
+	if claims.IssuedAt != nil && now.Add(leeway).Before(claims.IssuedAt.Time) {
+		return ErrorTokenUsedBeforeIssued
+	}
+	if v.opts.Issuer != "" && claims.Issuer != v.opts.Issuer {
+		return ErrorTokenInvalidIssuer
+	}
+	if v.opts.Audience != "" && !slices.Contains(claims.Audience, v.opts.Audience) {
+		return ErrorTokenInvalidAudience
+	}
+	return nil
+}
+
+// parseError 把 jwt 库的错误转换成 err.go 中的错误，原始错误保留在信息中
+func parseError(err error) error {
+	for _, typed := range []error{ErrorTokenUnknownKey, ErrorTokenInvalidAlgorithm} {
+		if errors.Is(err, typed) {
+			return err
+		}
+	}
+	var ve *jwt.ValidationError
+	if errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorSignatureInvalid != 0 {
+		return fmt.Errorf("%w: %v", ErrorTokenInvalidSignature, err)
+	}
+	return fmt.Errorf("%w: %v", ErrorTokenInvalid, err)
+}
+
+// ParsePrivateKeyPEM 解析 PKCS8、PKCS1（RSA）或 SEC1（ECDSA）格式的私钥
+func ParsePrivateKeyPEM(privateKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return nil, ErrorInvalidPrivateKeyPEMFormat
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, ErrorInvalidPrivateKeyPEMFormat
+}
+
+// ParsePublicKeyPEM 解析 X.509 证书、PKIX 或 PKCS1（RSA）格式的公钥
+func ParsePublicKeyPEM(publicKeyPEM string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(publicKeyPEM))
+	if block == nil {
+		return nil, ErrorInvalidPublicKeyPEMFormat
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, ErrorInvalidPublicKeyPEMFormat
+}
+
+// checkKey 检查 key 的类型和算法匹配，private 为 false 时私钥会转换成公钥
+func checkKey(alg rexCrypto.SignatureAlgorithm, key interface{}, private bool) (interface{}, error) {
+	invalid := fmt.Errorf("%w: %s: %T", ErrorInvalidKey, alg, key)
+	switch alg {
+	case rexCrypto.SignatureAlgorithmHS256, rexCrypto.SignatureAlgorithmHS384, rexCrypto.SignatureAlgorithmHS512:
+		switch k := key.(type) {
+		case []byte:
+			if len(k) > 0 {
+				return k, nil
+			}
+		case string:
+			if k != "" {
+				return []byte(k), nil
+			}
+		}
+	case rexCrypto.SignatureAlgorithmRS256, rexCrypto.SignatureAlgorithmRS384, rexCrypto.SignatureAlgorithmRS512,
+		rexCrypto.SignatureAlgorithmPS256, rexCrypto.SignatureAlgorithmPS384, rexCrypto.SignatureAlgorithmPS512:
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			if private {
+				return k, nil
+			}
+			return &k.PublicKey, nil
+		case *rsa.PublicKey:
+			if !private {
+				return k, nil
+			}
+		}
+	case rexCrypto.SignatureAlgorithmES256, rexCrypto.SignatureAlgorithmES384, rexCrypto.SignatureAlgorithmES512:
+		bits := signingMethods[alg].(*jwt.SigningMethodECDSA).CurveBits
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			if k.Curve.Params().BitSize != bits {
+				return nil, fmt.Errorf("%w: %s: curve %s", ErrorInvalidKey, alg, k.Curve.Params().Name)
+			}
+			if private {
+				return k, nil
+			}
+			return &k.PublicKey, nil
+		case *ecdsa.PublicKey:
+			if k.Curve.Params().BitSize != bits {
+				return nil, fmt.Errorf("%w: %s: curve %s", ErrorInvalidKey, alg, k.Curve.Params().Name)
+			}
+			if !private {
+				return k, nil
+			}
+		}
+	case rexCrypto.SignatureAlgorithmEdDSA:
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			if private {
+				return k, nil
+			}
+			return k.Public(), nil
+		case ed25519.PublicKey:
+			if !private {
+				return k, nil
+			}
+		}
+	}
+	return nil, invalid
+}
+
+// ecdsaAlgorithm 按曲线选择 ES 算法
+func ecdsaAlgorithm(key *ecdsa.PrivateKey) (rexCrypto.SignatureAlgorithm, error) {
+	switch key.Curve.Params().BitSize {
+	case 256:
+		return rexCrypto.SignatureAlgorithmES256, nil
+	case 384:
+		return rexCrypto.SignatureAlgorithmES384, nil
+	case 521:
+		return rexCrypto.SignatureAlgorithmES512, nil
+	}
+	return "", fmt.Errorf("%w: curve %s", ErrorInvalidKey, key.Curve.Params().Name)
+}
@@ -0,0 +1,123 @@
+package rexJwts
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rootexit/rexLib/rexCrypto"
+)
+
+type userClaims struct {
+	RegisteredClaims
+	Uid  int64  `json:"uid"`
+	Role string `json:"role"`
+}
+
+func TestSignerVerifier(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	p521, _ := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := map[rexCrypto.SignatureAlgorithm]interface{}{
+		rexCrypto.SignatureAlgorithmHS256: "secret",
+		rexCrypto.SignatureAlgorithmHS384: []byte("secret"),
+		rexCrypto.SignatureAlgorithmHS512: "secret",
+		rexCrypto.SignatureAlgorithmRS256: rsaKey,
+		rexCrypto.SignatureAlgorithmRS384: rsaKey,
+		rexCrypto.SignatureAlgorithmRS512: rsaKey,
+		rexCrypto.SignatureAlgorithmPS256: rsaKey,
+		rexCrypto.SignatureAlgorithmPS384: rsaKey,
+		rexCrypto.SignatureAlgorithmPS512: rsaKey,
+		rexCrypto.SignatureAlgorithmES256: p256,
+		rexCrypto.SignatureAlgorithmES384: p384,
+		rexCrypto.SignatureAlgorithmES512: p521,
+		rexCrypto.SignatureAlgorithmEdDSA: edKey,
+	}
+	opts := VerifyOptions{Issuer: "passport", Audience: "console", Leeway: time.Minute, RequireExpiresAt: true}
+	for alg, key := range keys {
+		signer, err := NewSigner(alg, string(alg)+"-1", key)
+		if err != nil {
+			t.Fatalf("NewSigner(%s) error = %v", alg, err)
+		}
+		claims := &userClaims{Uid: 7, Role: "admin"}
+		claims.Issuer = "passport"
+		claims.Audience = jwt.ClaimStrings{"console"}
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		token, err := signer.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign(%s) error = %v", alg, err)
+		}
+		verifier, err := NewVerifier[userClaims](opts, VerifyKey{Kid: signer.Kid(), Algorithm: alg, Key: key})
+		if err != nil {
+			t.Fatalf("NewVerifier(%s) error = %v", alg, err)
+		}
+		got, err := verifier.Verify(token)
+		if err != nil || got.Uid != 7 || got.Role != "admin" || got.IssuedAt == nil {
+			t.Errorf("Verify(%s) = %+v, %v", alg, got, err)
+		}
+	}
+}
+
+func TestVerifierErrors(t *testing.T) {
+	signer, _ := NewSigner(rexCrypto.SignatureAlgorithmHS256, "k1", "secret")
+	verifier, err := NewVerifier[userClaims](VerifyOptions{Issuer: "passport", Audience: "console", Leeway: 30 * time.Second},
+		VerifyKey{Kid: "k1", Algorithm: rexCrypto.SignatureAlgorithmHS256, Key: "secret"})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	sign := func(s *Signer, edit func(c *userClaims)) string {
+		claims := &userClaims{}
+		claims.Issuer = "passport"
+		claims.Audience = jwt.ClaimStrings{"console", "app"}
+		claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(time.Hour))
+		if edit != nil {
+			edit(claims)
+		}
+		token, err := s.Sign(claims)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		return token
+	}
+	otherKid, _ := NewSigner(rexCrypto.SignatureAlgorithmHS256, "k2", "secret")
+	wrongSecret, _ := NewSigner(rexCrypto.SignatureAlgorithmHS256, "k1", "guess")
+	wrongAlg, _ := NewSigner(rexCrypto.SignatureAlgorithmHS512, "k1", "secret")
+
+	cases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"within leeway", sign(signer, func(c *userClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second)) }), nil},
+		{"expired", sign(signer, func(c *userClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }), ErrorTokenHasExpired},
+		{"not before", sign(signer, func(c *userClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute)) }), ErrorTokenNotActiveYet},
+		{"issued in future", sign(signer, func(c *userClaims) { c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Minute)) }), ErrorTokenUsedBeforeIssued},
+		{"issuer", sign(signer, func(c *userClaims) { c.Issuer = "other" }), ErrorTokenInvalidIssuer},
+		{"audience", sign(signer, func(c *userClaims) { c.Audience = jwt.ClaimStrings{"app"} }), ErrorTokenInvalidAudience},
+		{"unknown kid", sign(otherKid, nil), ErrorTokenUnknownKey},
+		{"signature", sign(wrongSecret, nil), ErrorTokenInvalidSignature},
+		{"algorithm", sign(wrongAlg, nil), ErrorTokenInvalidAlgorithm},
+		{"malformed", "not.a.token", ErrorTokenInvalid},
+	}
+	for _, c := range cases {
+		if _, err := verifier.Verify(c.token); !errors.Is(err, c.want) {
+			t.Errorf("%s: Verify() error = %v, want %v", c.name, err, c.want)
+		}
+	}
+
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewSigner(rexCrypto.SignatureAlgorithmES384, "", p256); !errors.Is(err, ErrorInvalidKey) {
+		t.Errorf("NewSigner() with P-256 key for ES384 error = %v, want ErrorInvalidKey", err)
+	}
+	if _, err := NewSigner(rexCrypto.SignatureAlgorithmES256K, "", p256); !errors.Is(err, ErrorUnsupportedAlgorithm) {
+		t.Errorf("NewSigner(ES256K) error = %v, want ErrorUnsupportedAlgorithm", err)
+	}
+}
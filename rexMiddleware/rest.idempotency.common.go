@@ -0,0 +1,303 @@
+package rexMiddleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+type IdempotencyScope string
+
+const (
+	IdempotencyScopeGlobal IdempotencyScope = "global"
+	IdempotencyScopeUser   IdempotencyScope = "user"
+	IdempotencyScopeTenant IdempotencyScope = "tenant"
+
+	idempotencyStateProcessing = "processing"
+	idempotencyStateCompleted  = "completed"
+)
+
+// ErrIdempotencyScopeMissing user/tenant 作用域下 ctx 中没有对应的 id
+var ErrIdempotencyScopeMissing = errors.New("幂等作用域缺少用户或租户")
+
+// idempotencySuccessCodes 响应体中的业务码是这些值时才缓存，CommonErrResponse 总是返回 http 200，只能按业务码判断
+var idempotencySuccessCodes = map[int32]struct{}{
+	rexCodes.OK:             {},
+	rexCodes.StatusOK:       {},
+	rexCodes.EngineStatusOK: {},
+	rexCodes.BsStatusOK:     {},
+}
+
+type (
+	IdempotencyConf struct {
+		Prefix string `json:",default=rex"`
+		// Ttl 已完成请求的响应保留时间，单位秒
+		Ttl int `json:",default=86400"`
+		// LockTtl 首个请求处理中的占位时间，单位秒，超时后允许重新执行
+		LockTtl      int              `json:",default=60"`
+		Scope        IdempotencyScope `json:",default=user,options=global|user|tenant"`
+		Methods      []string         `json:",default=[POST,PUT,PATCH,DELETE]"`
+		Required     bool             `json:",optional"`
+		MaxBodyBytes int64            `json:",default=1048576"`
+	}
+	IdempotencyMiddleware struct {
+		store   rexDao.RedisDao
+		conf    IdempotencyConf
+		methods map[string]struct{}
+		debug   bool
+	}
+	idempotencyRecord struct {
+		State       string      `json:"state"`
+		Fingerprint string      `json:"fingerprint"`
+		Status      int         `json:"status,omitempty"`
+		Header      http.Header `json:"header,omitempty"`
+		Body        []byte      `json:"body,omitempty"`
+		CreatedAt   int64       `json:"created_at"`
+	}
+	idempotencyRecorder struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+	}
+	idempotencyBusinessCode struct {
+		Code *int32 `json:"code"`
+	}
+)
+
+func DefaultIdempotencyConf() IdempotencyConf {
+	return IdempotencyConf{
+		Prefix:       "rex",
+		Ttl:          86400,
+		LockTtl:      60,
+		Scope:        IdempotencyScopeUser,
+		Methods:      []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete},
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// NewIdempotencyMiddleware conf 中为零值的字段使用 DefaultIdempotencyConf 的值
+func NewIdempotencyMiddleware(store rexDao.RedisDao, conf IdempotencyConf, isDebug bool) *IdempotencyMiddleware {
+	defaults := DefaultIdempotencyConf()
+	if conf.Prefix == "" {
+		conf.Prefix = defaults.Prefix
+	}
+	if conf.Ttl <= 0 {
+		conf.Ttl = defaults.Ttl
+	}
+	if conf.LockTtl <= 0 {
+		conf.LockTtl = defaults.LockTtl
+	}
+	if conf.Scope == "" {
+		conf.Scope = defaults.Scope
+	}
+	if len(conf.Methods) == 0 {
+		conf.Methods = defaults.Methods
+	}
+	if conf.MaxBodyBytes <= 0 {
+		conf.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+	methods := make(map[string]struct{}, len(conf.Methods))
+	for _, method := range conf.Methods {
+		methods[strings.ToUpper(method)] = struct{}{}
+	}
+	return &IdempotencyMiddleware{
+		store:   store,
+		conf:    conf,
+		methods: methods,
+		debug:   isDebug,
+	}
+}
+
+func (m *IdempotencyMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if _, ok := m.methods[r.Method]; !ok {
+			next(w, r)
+			return
+		}
+
+		idempotencyKey := r.Header.Get(rexHeaders.HeaderIdempotencyKey)
+		if idempotencyKey == "" {
+			if m.conf.Required {
+				CommonErrResponse(w, r, rexCodes.StatusBadRequest, "missing "+rexHeaders.HeaderIdempotencyKey)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, m.conf.MaxBodyBytes+1))
+		if err != nil {
+			logc.Errorf(ctx, "IdempotencyMiddleware read body err: %s", err)
+			CommonErrResponse(w, r, rexCodes.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > m.conf.MaxBodyBytes {
+			CommonErrResponse(w, r, rexCodes.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		fingerprint := m.fingerprint(r, body)
+		cacheKey, err := m.cacheKey(ctx, idempotencyKey)
+		if err != nil {
+			// note: 退回全局作用域会让不同用户拿到彼此的响应
+			CommonErrResponse(w, r, rexCodes.StatusUnauthorized, err.Error())
+			return
+		}
+		placeholder, _ := json.Marshal(&idempotencyRecord{
+			State:       idempotencyStateProcessing,
+			Fingerprint: fingerprint,
+			CreatedAt:   time.Now().Unix(),
+		})
+		ok, err := m.store.SetNxExCtx(ctx, cacheKey, string(placeholder), m.conf.LockTtl)
+		if err != nil {
+			logc.Errorf(ctx, "IdempotencyMiddleware store err: %s", err)
+			CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			m.replay(w, r, cacheKey, fingerprint)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		// note: 服务端错误和业务错误都不缓存，删除占位让客户端可以重试；占位过期后可能已经被其他请求占用，只删除自己的占位
+		if !rec.cacheable() {
+			if _, err := m.store.CompareAndDeleteCtx(context.WithoutCancel(ctx), cacheKey, string(placeholder)); err != nil {
+				logc.Errorf(ctx, "IdempotencyMiddleware release key err: %s", err)
+			}
+			return
+		}
+		completed, err := json.Marshal(&idempotencyRecord{
+			State:       idempotencyStateCompleted,
+			Fingerprint: fingerprint,
+			Status:      rec.status,
+			Header:      w.Header().Clone(),
+			Body:        rec.body.Bytes(),
+			CreatedAt:   time.Now().Unix(),
+		})
+		if err != nil {
+			logc.Errorf(ctx, "IdempotencyMiddleware marshal record err: %s", err)
+			return
+		}
+		// note: 处理时间超过 LockTtl 时占位可能已经被其他请求替换，不覆盖别人的记录
+		ok, err = m.store.CompareAndSwapCtx(context.WithoutCancel(ctx), cacheKey, string(placeholder), string(completed), m.conf.Ttl)
+		if err != nil {
+			logc.Errorf(ctx, "IdempotencyMiddleware save record err: %s", err)
+			return
+		}
+		if !ok {
+			logc.Infof(ctx, "IdempotencyMiddleware placeholder expired before completion, key: %s", cacheKey)
+		}
+	}
+}
+
+func (m *IdempotencyMiddleware) replay(w http.ResponseWriter, r *http.Request, cacheKey, fingerprint string) {
+	ctx := r.Context()
+	val, err := m.store.GetCtx(ctx, cacheKey)
+	if err != nil {
+		logc.Errorf(ctx, "IdempotencyMiddleware load record err: %s", err)
+		CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+		return
+	}
+	if val == "" {
+		// note: 占位刚好过期，让客户端稍后重试
+		CommonErrResponse(w, r, rexCodes.StatusConflict)
+		return
+	}
+	var record idempotencyRecord
+	if err := json.Unmarshal([]byte(val), &record); err != nil {
+		logc.Errorf(ctx, "IdempotencyMiddleware unmarshal record err: %s", err)
+		CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+		return
+	}
+	if record.Fingerprint != fingerprint {
+		CommonErrResponse(w, r, rexCodes.StatusUnprocessableEntity, rexHeaders.HeaderIdempotencyKey+" reused with a different payload")
+		return
+	}
+	if record.State != idempotencyStateCompleted {
+		CommonErrResponse(w, r, rexCodes.StatusConflict, "request with the same "+rexHeaders.HeaderIdempotencyKey+" is still in progress")
+		return
+	}
+	if m.debug {
+		logc.Infof(ctx, "IdempotencyMiddleware replay key: %s", cacheKey)
+	}
+	for k, vals := range record.Header {
+		w.Header()[k] = vals
+	}
+	w.Header().Set(rexHeaders.HeaderIdempotentReplayed, "true")
+	w.WriteHeader(record.Status)
+	_, _ = w.Write(record.Body)
+}
+
+func (m *IdempotencyMiddleware) fingerprint(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(r.URL.Path))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(r.URL.RawQuery))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheKey user/tenant 作用域下 ctx 中没有对应的 id 时返回 ErrIdempotencyScopeMissing
+func (m *IdempotencyMiddleware) cacheKey(ctx context.Context, idempotencyKey string) (string, error) {
+	scope := string(IdempotencyScopeGlobal)
+	switch m.conf.Scope {
+	case IdempotencyScopeUser:
+		v := ctx.Value(rexCtx.CtxUserId{})
+		if v == nil || fmt.Sprint(v) == "" {
+			return "", ErrIdempotencyScopeMissing
+		}
+		scope = fmt.Sprintf("u-%v", v)
+	case IdempotencyScopeTenant:
+		v := ctx.Value(rexCtx.CtxTenantId{})
+		if v == nil || fmt.Sprint(v) == "" {
+			return "", ErrIdempotencyScopeMissing
+		}
+		scope = fmt.Sprintf("t-%v", v)
+	}
+	return fmt.Sprintf("%s:idempotency:%s:%s", m.conf.Prefix, scope, idempotencyKey), nil
+}
+
+// cacheable http 状态码小于 500，并且 json 响应体中的业务码表示成功；没有业务码的响应按状态码判断
+func (r *idempotencyRecorder) cacheable() bool {
+	if r.status >= http.StatusInternalServerError {
+		return false
+	}
+	var body idempotencyBusinessCode
+	if err := json.Unmarshal(r.body.Bytes(), &body); err != nil || body.Code == nil {
+		return r.status < http.StatusBadRequest
+	}
+	_, ok := idempotencySuccessCodes[*body.Code]
+	return ok
+}
+
+func (r *idempotencyRecorder) WriteHeader(statusCode int) {
+	r.status = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *idempotencyRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
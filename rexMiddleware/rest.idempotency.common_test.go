@@ -0,0 +1,112 @@
+package rexMiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexHeaders"
+)
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	calls := 0
+	// note: 零值配置使用默认值
+	m := NewIdempotencyMiddleware(rexDao.NewMemoryRedisDao(), IdempotencyConf{}, false)
+	handler := m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if strings.Contains(r.URL.Path, "fail") {
+			CommonErrResponse(w, r, rexCodes.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"msg":"ok"}`))
+	})
+	call := func(path, key, body string, userId any) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set(rexHeaders.HeaderIdempotencyKey, key)
+		// note: CommonErrResponse 没有 RequestID 时会用 sonyflake 生成，测试环境可能拿不到机器 id
+		ctx := context.WithValue(r.Context(), "RequestID", key)
+		if userId != nil {
+			ctx = context.WithValue(ctx, rexCtx.CtxUserId{}, userId)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r.WithContext(ctx))
+		return w
+	}
+	code := func(w *httptest.ResponseRecorder) int32 {
+		var resp rexCodes.CommonResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Code
+	}
+
+	call("/orders", "k1", `{"sku":1}`, 7)
+	w := call("/orders", "k1", `{"sku":1}`, 7)
+	if calls != 1 || w.Header().Get(rexHeaders.HeaderIdempotentReplayed) != "true" {
+		t.Errorf("calls = %d, replayed = %q, want the second request replayed", calls, w.Header().Get(rexHeaders.HeaderIdempotentReplayed))
+	}
+	if w := call("/orders", "k1", `{"sku":2}`, 7); code(w) != rexCodes.StatusUnprocessableEntity {
+		t.Errorf("reused key with another body code = %d, want %d", code(w), rexCodes.StatusUnprocessableEntity)
+	}
+	// note: 不同用户使用相同的 key 互不影响
+	if call("/orders", "k1", `{"sku":1}`, 8); calls != 2 {
+		t.Errorf("calls = %d, want another user to run the handler", calls)
+	}
+	if w := call("/orders", "k2", `{"sku":1}`, nil); code(w) != rexCodes.StatusUnauthorized || calls != 2 {
+		t.Errorf("missing user code = %d, calls = %d, want %d without running the handler", code(w), calls, rexCodes.StatusUnauthorized)
+	}
+
+	call("/orders/fail", "k3", `{}`, 7)
+	if w := call("/orders/fail", "k3", `{}`, 7); calls != 4 || w.Header().Get(rexHeaders.HeaderIdempotentReplayed) != "" {
+		t.Errorf("calls = %d, want business errors not to be cached", calls)
+	}
+}
+
+func TestIdempotencyMiddlewareInProgress(t *testing.T) {
+	store := rexDao.NewMemoryRedisDao()
+	now := time.Unix(1700000000, 0)
+	store.SetNow(now)
+	m := NewIdempotencyMiddleware(store, IdempotencyConf{Scope: IdempotencyScopeGlobal, LockTtl: 5}, false)
+
+	var handler http.HandlerFunc
+	call := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"sku":1}`))
+		r.Header.Set(rexHeaders.HeaderIdempotencyKey, "k1")
+		w := httptest.NewRecorder()
+		handler(w, r.WithContext(context.WithValue(r.Context(), "RequestID", "k1")))
+		return w
+	}
+	var inProgress, takeover *httptest.ResponseRecorder
+	depth := 0
+	handler = m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		depth++
+		if depth == 1 {
+			inProgress = call()
+			// note: 占位过期后另一个请求取得了 key，先开始的请求完成时不能覆盖它的记录
+			store.SetNow(now.Add(10 * time.Second))
+			takeover = call()
+			_, _ = w.Write([]byte(`{"code":0,"msg":"first"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"code":0,"msg":"second"}`))
+	})
+
+	call()
+	var resp rexCodes.CommonResponse
+	_ = json.Unmarshal(inProgress.Body.Bytes(), &resp)
+	if resp.Code != rexCodes.StatusConflict {
+		t.Errorf("in-progress code = %d, want %d", resp.Code, rexCodes.StatusConflict)
+	}
+	if takeover.Header().Get(rexHeaders.HeaderIdempotentReplayed) != "" || depth != 2 {
+		t.Errorf("depth = %d, want the request after the placeholder expired to run the handler", depth)
+	}
+	w := call()
+	if w.Header().Get(rexHeaders.HeaderIdempotentReplayed) != "true" || !strings.Contains(w.Body.String(), "second") {
+		t.Errorf("replayed body = %s, want the record of the request that owns the key", w.Body.String())
+	}
+}
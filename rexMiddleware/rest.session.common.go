@@ -0,0 +1,89 @@
+package rexMiddleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexSession"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+type SessionInterceptorMiddleware struct {
+	manager    rexSession.SessionManager
+	cookieName string
+	required   bool
+	debug      bool
+}
+
+// NewSessionInterceptorMiddleware 从 X-SessionId-For 头（或 cookieName 指定的 cookie）加载会话，
+// required 为 true 时没有有效会话直接返回未授权
+func NewSessionInterceptorMiddleware(manager rexSession.SessionManager, cookieName string, required, isDebug bool) *SessionInterceptorMiddleware {
+	return &SessionInterceptorMiddleware{
+		manager:    manager,
+		cookieName: cookieName,
+		required:   required,
+		debug:      isDebug,
+	}
+}
+
+func (m *SessionInterceptorMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		sessionId := r.Header.Get(rexHeaders.HeaderXSessionIdFor)
+		if sessionId == "" && m.cookieName != "" {
+			if cookie, err := r.Cookie(m.cookieName); err == nil {
+				sessionId = cookie.Value
+			}
+		}
+		if sessionId == "" {
+			if m.required {
+				CommonErrResponse(w, r, rexCodes.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		session, err := m.manager.Touch(ctx, sessionId)
+		if err != nil {
+			if m.debug {
+				logc.Infof(ctx, "SessionInterceptorMiddleware load session err: %s", err)
+			}
+			switch {
+			case errors.Is(err, rexSession.ErrSessionExpired):
+				if m.required {
+					CommonErrResponse(w, r, rexCodes.EngineStatusAccessExpired)
+					return
+				}
+			case errors.Is(err, rexSession.ErrSessionRevoked), errors.Is(err, rexSession.ErrSessionNotFound):
+				if m.required {
+					CommonErrResponse(w, r, rexCodes.EngineStatusAccessTokenInvalid)
+					return
+				}
+			default:
+				logc.Errorf(ctx, "SessionInterceptorMiddleware load session err: %s", err)
+				CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		ctx = context.WithValue(ctx, rexCtx.CtxSessionIDFor{}, session.SessionId)
+		ctx = context.WithValue(ctx, rexCtx.CtxSessionFor{}, session)
+		if session.UserId != "" && ctx.Value(rexCtx.CtxUserId{}) == nil {
+			ctx = context.WithValue(ctx, rexCtx.CtxUserId{}, session.UserId)
+		}
+		if session.TenantId != "" && ctx.Value(rexCtx.CtxTenantId{}) == nil {
+			ctx = context.WithValue(ctx, rexCtx.CtxTenantId{}, session.TenantId)
+		}
+
+		r = r.WithContext(ctx)
+		next(w, r)
+	}
+}
@@ -0,0 +1,101 @@
+package rexMiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexSession"
+	"github.com/rootexit/rexLib/rexUserAgent"
+)
+
+// touchCounter 统计 Touch 次数，sessionId 为 expired/broken 时返回对应的错误
+type touchCounter struct {
+	rexSession.SessionManager
+	touched int
+}
+
+func (m *touchCounter) Touch(ctx context.Context, sessionId string) (*rexSession.Session, error) {
+	m.touched++
+	switch sessionId {
+	case "expired":
+		return nil, rexSession.ErrSessionExpired
+	case "broken":
+		return nil, errors.New("redis down")
+	}
+	return m.SessionManager.Touch(ctx, sessionId)
+}
+
+func TestSessionInterceptorMiddleware(t *testing.T) {
+	ctx := context.Background()
+	manager := &touchCounter{SessionManager: rexSession.NewSessionManager(rexSession.SessionConf{}, rexDao.NewMemoryRedisDao())}
+	active, err := manager.Create(ctx, "u1", "t1", rexUserAgent.Client{}, nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	revoked, _ := manager.Create(ctx, "u2", "t1", rexUserAgent.Client{}, nil)
+	if err := manager.Revoke(ctx, revoked.SessionId); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		userId, _ := r.Context().Value(rexCtx.CtxUserId{}).(string)
+		sessionId, _ := r.Context().Value(rexCtx.CtxSessionIDFor{}).(string)
+		_, _ = w.Write([]byte("next:" + userId + ":" + sessionId))
+	}
+	required := NewSessionInterceptorMiddleware(manager, "sid", true, false).Handle(next)
+	optional := NewSessionInterceptorMiddleware(manager, "sid", false, false).Handle(next)
+
+	cases := []struct {
+		name    string
+		handler http.HandlerFunc
+		header  string
+		cookie  string
+		code    int32
+		body    string
+		touched int
+	}{
+		{name: "header", handler: required, header: active.SessionId, body: "next:u1:" + active.SessionId, touched: 1},
+		{name: "cookie", handler: required, cookie: active.SessionId, body: "next:u1:" + active.SessionId, touched: 1},
+		{name: "missing required", handler: required, code: rexCodes.StatusUnauthorized},
+		{name: "missing optional", handler: optional, body: "next::"},
+		{name: "unknown", handler: required, header: "nope", code: rexCodes.EngineStatusAccessTokenInvalid, touched: 1},
+		{name: "revoked", handler: required, header: revoked.SessionId, code: rexCodes.EngineStatusAccessTokenInvalid, touched: 1},
+		{name: "revoked optional", handler: optional, header: revoked.SessionId, body: "next::", touched: 1},
+		{name: "expired", handler: required, cookie: "expired", code: rexCodes.EngineStatusAccessExpired, touched: 1},
+		{name: "store error", handler: optional, header: "broken", code: rexCodes.StatusInternalServerError, touched: 1},
+	}
+	for _, c := range cases {
+		manager.touched = 0
+		r := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if c.header != "" {
+			r.Header.Set(rexHeaders.HeaderXSessionIdFor, c.header)
+		}
+		if c.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "sid", Value: c.cookie})
+		}
+		w := httptest.NewRecorder()
+		// note: CommonErrResponse 没有 RequestID 时会用 sonyflake 生成，测试环境可能拿不到机器 id
+		c.handler(w, r.WithContext(context.WithValue(r.Context(), "RequestID", c.name)))
+		if manager.touched != c.touched {
+			t.Errorf("%s: touched = %d, want %d", c.name, manager.touched, c.touched)
+		}
+		if c.code == 0 {
+			if w.Body.String() != c.body {
+				t.Errorf("%s: body = %s, want %s", c.name, w.Body.String(), c.body)
+			}
+			continue
+		}
+		var resp rexCodes.CommonResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != c.code {
+			t.Errorf("%s: response = %s, want code %d", c.name, w.Body.String(), c.code)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package rexMiddleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCacheKey"
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexHeaders"
+	"github.com/rootexit/rexLib/rexTaskSign"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+type (
+	TaskSignConf struct {
+		Prefix string `json:",default=rex"`
+		// MaxSkew 允许的时钟偏差，单位秒
+		MaxSkew int `json:",default=300"`
+		// NonceTtl nonce 的保留时间，单位秒，不能小于 2*MaxSkew，否则过期的 nonce 可以在时间窗口内重放
+		NonceTtl     int   `json:",default=600"`
+		MaxBodyBytes int64 `json:",default=1048576"`
+		// SourceTypes 允许的来源类型，为空时不限制
+		SourceTypes []string `json:",optional"`
+	}
+	// TaskSignMiddleware 校验 rexCrontabPool webhook 任务的 X-REx-Task-* 签名，签名规则见 rexTaskSign
+	TaskSignMiddleware struct {
+		store       rexDao.RedisDao
+		secrets     rexTaskSign.SecretStore
+		conf        TaskSignConf
+		sourceTypes map[string]struct{}
+		debug       bool
+	}
+)
+
+func DefaultTaskSignConf() TaskSignConf {
+	return TaskSignConf{
+		Prefix:       "rex",
+		MaxSkew:      300,
+		NonceTtl:     600,
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// NewTaskSignMiddleware conf 中为零值的字段使用 DefaultTaskSignConf 的值
+func NewTaskSignMiddleware(store rexDao.RedisDao, secrets rexTaskSign.SecretStore, conf TaskSignConf, isDebug bool) *TaskSignMiddleware {
+	def := DefaultTaskSignConf()
+	if conf.Prefix == "" {
+		conf.Prefix = def.Prefix
+	}
+	if conf.MaxSkew <= 0 {
+		conf.MaxSkew = def.MaxSkew
+	}
+	if conf.NonceTtl <= 0 {
+		conf.NonceTtl = def.NonceTtl
+	}
+	if conf.MaxBodyBytes <= 0 {
+		conf.MaxBodyBytes = def.MaxBodyBytes
+	}
+	if conf.NonceTtl < 2*conf.MaxSkew {
+		conf.NonceTtl = 2 * conf.MaxSkew
+	}
+	sourceTypes := make(map[string]struct{}, len(conf.SourceTypes))
+	for _, sourceType := range conf.SourceTypes {
+		sourceTypes[sourceType] = struct{}{}
+	}
+	return &TaskSignMiddleware{
+		store:       store,
+		secrets:     secrets,
+		conf:        conf,
+		sourceTypes: sourceTypes,
+		debug:       isDebug,
+	}
+}
+
+func (m *TaskSignMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		h := rexTaskSign.ParseHeaders(r.Header)
+		if h.SourceId == "" || h.Timestamp == "" || h.Nonce == "" || h.Signature == "" {
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskSignatureMissing)
+			return
+		}
+		if len(m.sourceTypes) > 0 {
+			if _, ok := m.sourceTypes[h.SourceType]; !ok {
+				CommonErrResponse(w, r, rexCodes.EngineStatusTaskSourceUnknown)
+				return
+			}
+		}
+
+		signedAt, err := rexTaskSign.ParseTimestamp(h.Timestamp)
+		if err != nil {
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskSignatureInvalid, "invalid "+rexHeaders.HeaderXRExTaskTimestamp)
+			return
+		}
+		if skew := time.Since(signedAt); skew > m.maxSkew() || skew < -m.maxSkew() {
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskTimestampSkewed)
+			return
+		}
+
+		secret, err := m.secrets.Secret(ctx, h.SourceId)
+		if errors.Is(err, rexTaskSign.ErrSecretNotFound) {
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskSourceUnknown)
+			return
+		}
+		if err != nil {
+			logc.Errorf(ctx, "TaskSignMiddleware load secret err: %s", err)
+			CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, m.conf.MaxBodyBytes+1))
+		if err != nil {
+			logc.Errorf(ctx, "TaskSignMiddleware read body err: %s", err)
+			CommonErrResponse(w, r, rexCodes.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > m.conf.MaxBodyBytes {
+			CommonErrResponse(w, r, rexCodes.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if !rexTaskSign.VerifyRequest(r, secret, &h, body) {
+			if m.debug {
+				logc.Infof(ctx, "TaskSignMiddleware string to sign: %q", rexTaskSign.StringToSign(r.Method, r.URL.EscapedPath(), r.URL.RawQuery, &h, rexTaskSign.BodySha256(body)))
+			}
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskSignatureInvalid)
+			return
+		}
+
+		// note: 签名通过后再占用 nonce，避免伪造的请求消耗掉正常的 nonce
+		ok, err := m.store.SetNxExCtx(ctx, m.nonceKey(&h), h.DeliveryId, m.conf.NonceTtl)
+		if err != nil {
+			logc.Errorf(ctx, "TaskSignMiddleware store nonce err: %s", err)
+			CommonErrResponse(w, r, rexCodes.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			CommonErrResponse(w, r, rexCodes.EngineStatusTaskNonceReplayed)
+			return
+		}
+
+		ctx = context.WithValue(ctx, rexCtx.CtxTaskSourceType{}, h.SourceType)
+		ctx = context.WithValue(ctx, rexCtx.CtxTaskSourceId{}, h.SourceId)
+		ctx = context.WithValue(ctx, rexCtx.CtxTaskDeliveryId{}, h.DeliveryId)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+func (m *TaskSignMiddleware) maxSkew() time.Duration {
+	return time.Duration(m.conf.MaxSkew) * time.Second
+}
+
+// nonceKey 只使用参与签名的字段，请求方不能通过修改未签名的头绕过重放检查
+func (m *TaskSignMiddleware) nonceKey(h *rexTaskSign.Headers) string {
+	return fmt.Sprintf("%s:task-nonce:%s:%s:%s", m.conf.Prefix,
+		rexCacheKey.EscapeArg(h.SourceType), rexCacheKey.EscapeArg(h.SourceId), rexCacheKey.EscapeArg(h.Nonce))
+}
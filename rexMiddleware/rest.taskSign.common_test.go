@@ -0,0 +1,88 @@
+package rexMiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCodes"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexTaskSign"
+)
+
+func TestTaskSignMiddleware(t *testing.T) {
+	secrets := rexTaskSign.StaticSecrets{"billing": "s3cret"}
+	m := NewTaskSignMiddleware(rexDao.NewMemoryRedisDao(), secrets, DefaultTaskSignConf(), false)
+	handler := m.Handle(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Context().Value(rexCtx.CtxTaskSourceId{}).(string)))
+	})
+
+	newRequest := func(sourceId, secret, nonce string, signedAt time.Time) *http.Request {
+		body := `{"month":"2024-01"}`
+		r := httptest.NewRequest(http.MethodPost, "/hooks/invoice", strings.NewReader(body))
+		h := &rexTaskSign.Headers{DeliveryId: "d-1", SourceId: sourceId, Nonce: nonce, Timestamp: strconv.FormatInt(signedAt.Unix(), 10)}
+		if err := rexTaskSign.SignRequest(r, secret, h, []byte(body)); err != nil {
+			t.Fatalf("SignRequest() error = %v", err)
+		}
+		return r
+	}
+	tampered := newRequest("billing", "s3cret", "n-3", time.Now())
+	tampered.Body = http.NoBody
+	missing := newRequest("billing", "s3cret", "n-4", time.Now())
+	missing.Header.Del("X-REx-Task-Signature")
+	// note: query 和投递信息都参与签名
+	query := newRequest("billing", "s3cret", "n-7", time.Now())
+	query.URL.RawQuery = "month=2024-02"
+	delivery := newRequest("billing", "s3cret", "n-8", time.Now())
+	delivery.Header.Set("X-REx-Task-Delivery-Id", "d-2")
+
+	cases := []struct {
+		name string
+		r    *http.Request
+		code int32
+	}{
+		{"valid", newRequest("billing", "s3cret", "n-1", time.Now()), 0},
+		{"replayed", newRequest("billing", "s3cret", "n-1", time.Now()), rexCodes.EngineStatusTaskNonceReplayed},
+		{"wrong secret", newRequest("billing", "guess", "n-2", time.Now()), rexCodes.EngineStatusTaskSignatureInvalid},
+		{"tampered body", tampered, rexCodes.EngineStatusTaskSignatureInvalid},
+		{"tampered query", query, rexCodes.EngineStatusTaskSignatureInvalid},
+		{"tampered delivery id", delivery, rexCodes.EngineStatusTaskSignatureInvalid},
+		{"missing signature", missing, rexCodes.EngineStatusTaskSignatureMissing},
+		{"skewed", newRequest("billing", "s3cret", "n-5", time.Now().Add(-time.Hour)), rexCodes.EngineStatusTaskTimestampSkewed},
+		{"unknown source", newRequest("payroll", "s3cret", "n-6", time.Now()), rexCodes.EngineStatusTaskSourceUnknown},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		// note: CommonErrResponse 没有 RequestID 时会用 sonyflake 生成，测试环境可能拿不到机器 id
+		handler(w, c.r.WithContext(context.WithValue(c.r.Context(), "RequestID", c.name)))
+		if c.code == 0 {
+			if w.Body.String() != "billing" {
+				t.Errorf("%s: body = %s, want the next handler to run", c.name, w.Body.String())
+			}
+			continue
+		}
+		var resp rexCodes.CommonResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Code != c.code {
+			t.Errorf("%s: response = %s, want code %d", c.name, w.Body.String(), c.code)
+		}
+	}
+}
+
+func TestTaskSignMiddlewareDefaults(t *testing.T) {
+	m := NewTaskSignMiddleware(rexDao.NewMemoryRedisDao(), rexTaskSign.StaticSecrets{}, TaskSignConf{MaxSkew: 400}, false)
+	want := DefaultTaskSignConf()
+	want.MaxSkew = 400
+	want.NonceTtl = 800
+	if m.conf.Prefix != want.Prefix || m.conf.MaxSkew != want.MaxSkew || m.conf.NonceTtl != want.NonceTtl || m.conf.MaxBodyBytes != want.MaxBodyBytes {
+		t.Errorf("conf = %+v, want %+v", m.conf, want)
+	}
+	if key := m.nonceKey(&rexTaskSign.Headers{SourceType: "crontab", SourceId: "a:b", Nonce: "n"}); key != "rex:task-nonce:crontab:a%3Ab:n" {
+		t.Errorf("nonceKey() = %s", key)
+	}
+}
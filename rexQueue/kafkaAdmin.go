@@ -0,0 +1,187 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	TopicConfigRetentionMs    = "retention.ms"
+	TopicConfigCleanupPolicy  = "cleanup.policy"
+	TopicDriftPartitions      = "partitions"
+	TopicDriftReplication     = "replication.factor"
+	defaultEnsureTopicTimeout = 30 * time.Second
+)
+
+type (
+	// TopicSpec 声明式的 topic 配置，可以从 go-zero 配置文件加载
+	TopicSpec struct {
+		Name string
+		// Partitions 为 0 时不检查已存在 topic 的分区数，创建时使用 1
+		Partitions int32 `json:",optional"`
+		// ReplicationFactor 为 0 时不检查已存在 topic 的副本数，创建时使用 1
+		ReplicationFactor int16 `json:",optional"`
+		// Retention 对应 retention.ms，为 0 时使用 broker 默认值
+		Retention time.Duration `json:",optional"`
+		// CleanupPolicy 对应 cleanup.policy，例如 delete、compact、compact,delete
+		CleanupPolicy string `json:",optional"`
+		// Configs 其他 topic 配置，和 Retention、CleanupPolicy 冲突时以 Retention、CleanupPolicy 为准
+		Configs map[string]string `json:",optional"`
+	}
+
+	// TopicDrift 已存在的 topic 和声明不一致的配置项
+	TopicDrift struct {
+		Topic  string
+		Field  string
+		Want   string
+		Actual string
+	}
+
+	TopicReport struct {
+		Created []string
+		Drifts  []TopicDrift
+	}
+)
+
+func (s TopicSpec) configs() map[string]string {
+	configs := make(map[string]string, len(s.Configs)+2)
+	for k, v := range s.Configs {
+		configs[k] = v
+	}
+	if s.Retention > 0 {
+		configs[TopicConfigRetentionMs] = strconv.FormatInt(s.Retention.Milliseconds(), 10)
+	}
+	if s.CleanupPolicy != "" {
+		configs[TopicConfigCleanupPolicy] = s.CleanupPolicy
+	}
+	return configs
+}
+
+func (d TopicDrift) String() string {
+	return fmt.Sprintf("topic %s %s: want %s, actual %s", d.Topic, d.Field, d.Want, d.Actual)
+}
+
+// EnsureTopics 按 conf.TopicSpecs 创建不存在的 topic，已存在的 topic 只报告差异不做修改，
+// 分区数只能增加、副本数需要重新分配，这类变更需要人工确认后执行
+func EnsureTopics(ctx context.Context, conf *KafkaConfig) (*TopicReport, error) {
+	if len(conf.TopicSpecs) == 0 {
+		return &TopicReport{}, nil
+	}
+	if err := conf.Build(); err != nil {
+		return nil, fmt.Errorf("kafka 配置错误: %w", err)
+	}
+	admin, err := sarama.NewClusterAdmin(conf.Brokers, conf.Config)
+	if err != nil {
+		return nil, fmt.Errorf("cluster admin 初始化失败: %w", err)
+	}
+	defer admin.Close()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultEnsureTopicTimeout)
+		defer cancel()
+	}
+
+	var report *TopicReport
+	err = runWithCtx(ctx, func() error {
+		var err error
+		report, err = EnsureTopicsWithAdmin(admin, conf.TopicSpecs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, topic := range report.Created {
+		logc.Infof(ctx, "kafka topic created: %s", topic)
+	}
+	for _, drift := range report.Drifts {
+		logc.Errorf(ctx, "kafka topic drift: %s", drift)
+	}
+	return report, nil
+}
+
+// EnsureTopicsWithAdmin 使用已有的 ClusterAdmin 执行 EnsureTopics，调用方负责关闭 admin
+func EnsureTopicsWithAdmin(admin sarama.ClusterAdmin, specs []TopicSpec) (*TopicReport, error) {
+	existing, err := admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("list topics failed: %w", err)
+	}
+
+	report := &TopicReport{}
+	for _, spec := range specs {
+		configs := spec.configs()
+		detail, ok := existing[spec.Name]
+		if !ok {
+			entries := make(map[string]*string, len(configs))
+			for k, v := range configs {
+				entries[k] = &v
+			}
+			create := &sarama.TopicDetail{
+				NumPartitions:     spec.Partitions,
+				ReplicationFactor: spec.ReplicationFactor,
+				ConfigEntries:     entries,
+			}
+			if create.NumPartitions <= 0 {
+				create.NumPartitions = 1
+			}
+			if create.ReplicationFactor <= 0 {
+				create.ReplicationFactor = 1
+			}
+			err := admin.CreateTopic(spec.Name, create, false)
+			// note: 多个实例同时启动时 topic 可能已经被其他实例创建
+			if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+				return report, fmt.Errorf("create topic %s failed: %w", spec.Name, err)
+			}
+			if err == nil {
+				report.Created = append(report.Created, spec.Name)
+			}
+			continue
+		}
+
+		if spec.Partitions > 0 && detail.NumPartitions != spec.Partitions {
+			report.Drifts = append(report.Drifts, TopicDrift{
+				Topic:  spec.Name,
+				Field:  TopicDriftPartitions,
+				Want:   strconv.Itoa(int(spec.Partitions)),
+				Actual: strconv.Itoa(int(detail.NumPartitions)),
+			})
+		}
+		if spec.ReplicationFactor > 0 && detail.ReplicationFactor != spec.ReplicationFactor {
+			report.Drifts = append(report.Drifts, TopicDrift{
+				Topic:  spec.Name,
+				Field:  TopicDriftReplication,
+				Want:   strconv.Itoa(int(spec.ReplicationFactor)),
+				Actual: strconv.Itoa(int(detail.ReplicationFactor)),
+			})
+		}
+		if len(configs) == 0 {
+			continue
+		}
+		// note: ListTopics 不返回默认值，和默认值相同的声明需要 DescribeConfig 才能比较
+		entries, err := admin.DescribeConfig(sarama.ConfigResource{Type: sarama.TopicResource, Name: spec.Name})
+		if err != nil {
+			return report, fmt.Errorf("describe topic %s config failed: %w", spec.Name, err)
+		}
+		actual := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			actual[entry.Name] = entry.Value
+		}
+		keys := make([]string, 0, len(configs))
+		for k := range configs {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if actual[k] != configs[k] {
+				report.Drifts = append(report.Drifts, TopicDrift{Topic: spec.Name, Field: k, Want: configs[k], Actual: actual[k]})
+			}
+		}
+	}
+	return report, nil
+}
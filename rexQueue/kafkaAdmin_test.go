@@ -0,0 +1,76 @@
+package rexQueue
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeClusterAdmin 只实现 EnsureTopicsWithAdmin 用到的方法，created 记录 CreateTopic 的参数
+type fakeClusterAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	configs map[string][]sarama.ConfigEntry
+	// racing CreateTopic 返回 ErrTopicAlreadyExists，模拟其他实例先创建了 topic
+	racing  map[string]bool
+	created map[string]*sarama.TopicDetail
+}
+
+func (a *fakeClusterAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return a.topics, nil
+}
+
+func (a *fakeClusterAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, validateOnly bool) error {
+	if a.racing[topic] {
+		return sarama.ErrTopicAlreadyExists
+	}
+	a.created[topic] = detail
+	return nil
+}
+
+func (a *fakeClusterAdmin) DescribeConfig(resource sarama.ConfigResource) ([]sarama.ConfigEntry, error) {
+	return a.configs[resource.Name], nil
+}
+
+func TestEnsureTopicsWithAdmin(t *testing.T) {
+	admin := &fakeClusterAdmin{
+		topics: map[string]sarama.TopicDetail{
+			"order":   {NumPartitions: 3, ReplicationFactor: 3},
+			"payment": {NumPartitions: 6, ReplicationFactor: 3},
+		},
+		configs: map[string][]sarama.ConfigEntry{
+			"order":   {{Name: TopicConfigRetentionMs, Value: "86400000"}},
+			"payment": {{Name: TopicConfigCleanupPolicy, Value: "delete"}},
+		},
+		racing:  map[string]bool{"refund": true},
+		created: make(map[string]*sarama.TopicDetail),
+	}
+	specs := []TopicSpec{
+		{Name: "invoice", Retention: time.Hour},
+		{Name: "refund", Partitions: 4},
+		// note: 没有声明分区数和副本数时不检查，已存在的 topic 不会因为默认值产生差异
+		{Name: "order", Retention: 24 * time.Hour},
+		{Name: "payment", Partitions: 12, ReplicationFactor: 3, CleanupPolicy: "compact"},
+	}
+
+	report, err := EnsureTopicsWithAdmin(admin, specs)
+	if err != nil {
+		t.Fatalf("EnsureTopicsWithAdmin() error = %v", err)
+	}
+	if !reflect.DeepEqual(report.Created, []string{"invoice"}) {
+		t.Errorf("Created = %v, want [invoice]", report.Created)
+	}
+	invoice := admin.created["invoice"]
+	if invoice == nil || invoice.NumPartitions != 1 || invoice.ReplicationFactor != 1 || *invoice.ConfigEntries[TopicConfigRetentionMs] != "3600000" {
+		t.Errorf("created invoice = %+v, want 1 partition, 1 replica and retention.ms", invoice)
+	}
+	want := []TopicDrift{
+		{Topic: "payment", Field: TopicDriftPartitions, Want: "12", Actual: "6"},
+		{Topic: "payment", Field: TopicConfigCleanupPolicy, Want: "compact", Actual: "delete"},
+	}
+	if !reflect.DeepEqual(report.Drifts, want) {
+		t.Errorf("Drifts = %v, want %v", report.Drifts, want)
+	}
+}
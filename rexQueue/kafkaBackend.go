@@ -0,0 +1,162 @@
+package rexQueue
+
+import (
+	"context"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+type (
+	kafkaProducer struct {
+		q KafkaQueue
+	}
+
+	kafkaConsumer struct {
+		q               KafkaQueue
+		policy          *RetryPolicy
+		maxRedeliveries int
+	}
+
+	KafkaConsumerOption func(c *kafkaConsumer)
+
+	// kafkaConsumerGroupHandler nack 时在当前会话内重新投递，超过 maxRedeliveries 后记录错误并提交，不会阻塞分区
+	kafkaConsumerGroupHandler struct {
+		handler         Handler
+		maxRedeliveries int
+	}
+)
+
+// WithMaxRedeliveries 没有 RetryPolicy 时 nack 后最多重新投递多少次，默认 DefaultMaxRedeliveries
+func WithMaxRedeliveries(n int) KafkaConsumerOption {
+	return func(c *kafkaConsumer) {
+		if n > 0 {
+			c.maxRedeliveries = n
+		}
+	}
+}
+
+// NewKafkaProducer 把 KafkaQueue 包装成 Producer，KafkaQueue 的生命周期由调用方管理
+func NewKafkaProducer(q KafkaQueue) Producer {
+	return &kafkaProducer{q: q}
+}
+
+// NewKafkaConsumer 把 KafkaQueue 的消费者组包装成 Consumer，policy 不为空时 nack 的消息进入重试 topic 和死信 topic，
+// 为空时在本地重新投递，次数耗尽后记录错误日志并提交，与 MemoryQueue 的 MaxRedeliveries 语义一致
+func NewKafkaConsumer(q KafkaQueue, policy *RetryPolicy, opts ...KafkaConsumerOption) Consumer {
+	c := &kafkaConsumer{q: q, policy: policy, maxRedeliveries: DefaultMaxRedeliveries}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (p *kafkaProducer) Send(ctx context.Context, msg *Message) error {
+	if msg.Topic == "" {
+		return ErrEmptyTopic
+	}
+	return sendMessage(ctx, p.q, toProducerMessage(msg))
+}
+
+// Subscribe ctx 结束或 KafkaQueue 关闭时返回 nil，队列已经关闭、消费者组异常关闭或者遇到配置、授权等不可重试的错误时返回错误
+func (c *kafkaConsumer) Subscribe(ctx context.Context, topics []string, handler Handler) error {
+	cg := c.q.GetConsumerGroup()
+	if cg == nil {
+		return ErrConsumerGroupNotInit
+	}
+	q, ok := c.q.(*defaultKafkaQueue)
+	if c.policy != nil {
+		retryHandler := func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			return handler(ctx, fromConsumerMessage(msg, 0))
+		}
+		if !ok {
+			c.q.RetryConsume(ctx, topics, c.policy, retryHandler)
+			return nil
+		}
+		return q.retryConsume(ctx, topics, c.policy, retryHandler)
+	}
+	h := &kafkaConsumerGroupHandler{handler: handler, maxRedeliveries: c.maxRedeliveries}
+	if !ok {
+		c.q.Consume(ctx, cg, topics, h)
+		return nil
+	}
+	return q.consume(ctx, cg, topics, h)
+}
+
+func (h *kafkaConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *kafkaConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *kafkaConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if !h.handle(ctx, msg) {
+				// note: 会话结束时不提交，消息由下一个消费者重新投递
+				return nil
+			}
+			session.MarkMessage(msg, "")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// handle nack 后立即重新投递，最多 maxRedeliveries 次，返回 false 表示 ctx 已经结束，消息不能提交
+func (h *kafkaConsumerGroupHandler) handle(ctx context.Context, msg *sarama.ConsumerMessage) bool {
+	for nacked := 0; ; nacked++ {
+		err := h.handler(ctx, fromConsumerMessage(msg, nacked))
+		if err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if nacked >= h.maxRedeliveries {
+			logc.Errorf(ctx, "consume msg dead letter after %d redeliveries, topic: %s, partition: %d, offset: %d, err: %v", nacked, msg.Topic, msg.Partition, msg.Offset, err)
+			return true
+		}
+		logc.Errorf(ctx, "consume msg nack, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+	}
+}
+
+func toProducerMessage(msg *Message) *sarama.ProducerMessage {
+	pm := &sarama.ProducerMessage{
+		Topic:   msg.Topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: make([]sarama.RecordHeader, 0, len(msg.Headers)),
+	}
+	if msg.Key != "" {
+		pm.Key = sarama.StringEncoder(msg.Key)
+	}
+	if !msg.Timestamp.IsZero() {
+		pm.Timestamp = msg.Timestamp
+	}
+	for k, v := range msg.Headers {
+		pm.Headers = append(pm.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	return pm
+}
+
+// fromConsumerMessage Attempt 由重试 topic 记录的重试次数加上本地 nack 的次数得到
+func fromConsumerMessage(msg *sarama.ConsumerMessage, nacked int) *Message {
+	m := &Message{
+		Topic:     msg.Topic,
+		Key:       string(msg.Key),
+		Value:     msg.Value,
+		Headers:   make(map[string]string, len(msg.Headers)),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Attempt:   int(headerInt64(msg.Headers, HeaderRetryCount)) + nacked + 1,
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			m.Headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	return m
+}
@@ -0,0 +1,89 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/IBM/sarama"
+)
+
+// fakeConsumerGroup Consume 直接返回 err
+type fakeConsumerGroup struct {
+	err error
+}
+
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	return g.err
+}
+func (g *fakeConsumerGroup) Errors() <-chan error                 { return nil }
+func (g *fakeConsumerGroup) Close() error                         { return nil }
+func (g *fakeConsumerGroup) Pause(partitions map[string][]int32)  {}
+func (g *fakeConsumerGroup) Resume(partitions map[string][]int32) {}
+func (g *fakeConsumerGroup) PauseAll()                            {}
+func (g *fakeConsumerGroup) ResumeAll()                           {}
+
+func TestKafkaConsumerAttempt(t *testing.T) {
+	var attempts []int
+	h := &kafkaConsumerGroupHandler{maxRedeliveries: DefaultMaxRedeliveries, handler: func(ctx context.Context, msg *Message) error {
+		attempts = append(attempts, msg.Attempt)
+		switch {
+		case msg.Offset == 7 && msg.Attempt < 3:
+			return errors.New("flaky")
+		case msg.Offset == 8:
+			return errors.New("bad")
+		}
+		return nil
+	}}
+	session := &fakeSession{ctx: context.Background()}
+	msgs := []*sarama.ConsumerMessage{
+		{Topic: "order", Partition: 1, Offset: 7},
+		{Topic: "order", Partition: 1, Offset: 8},
+		{Topic: "order", Partition: 1, Offset: 9},
+	}
+	if err := h.ConsumeClaim(session, newFakeClaim(msgs...)); err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	// note: 8 重新投递 3 次后进入死信并提交，不会阻塞 9
+	if want := []int{1, 2, 3, 1, 2, 3, 4, 1}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("attempts = %v, want %v", attempts, want)
+	}
+	if got := session.Marked(); !reflect.DeepEqual(got, []int64{7, 8, 9}) {
+		t.Errorf("marked = %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.handler = func(ctx context.Context, msg *Message) error {
+		cancel()
+		return ctx.Err()
+	}
+	session = &fakeSession{ctx: ctx}
+	if err := h.ConsumeClaim(session, newFakeClaim(msgs[0])); err != nil || len(session.Marked()) != 0 {
+		t.Errorf("ConsumeClaim() = %v, marked = %v, want nothing committed after the session ends", err, session.Marked())
+	}
+
+	retried := &sarama.ConsumerMessage{Headers: []*sarama.RecordHeader{{Key: []byte(HeaderRetryCount), Value: []byte(strconv.Itoa(2))}}}
+	if got := fromConsumerMessage(retried, 0).Attempt; got != 3 {
+		t.Errorf("Attempt from retry headers = %d, want 3", got)
+	}
+}
+
+func TestKafkaConsumerSubscribeErrors(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	handler := func(ctx context.Context, msg *Message) error { return nil }
+
+	q := &defaultKafkaQueue{conf: conf, consumerGroup: &fakeConsumerGroup{err: sarama.ErrClosedConsumerGroup}, life: newLifecycleState()}
+	if err := NewKafkaConsumer(q, nil).Subscribe(context.Background(), []string{"order"}, handler); !errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		t.Errorf("Subscribe() error = %v, want ErrClosedConsumerGroup", err)
+	}
+	if err := NewKafkaConsumer(q, NewRetryPolicy()).Subscribe(context.Background(), []string{"order"}, handler); !errors.Is(err, sarama.ErrClosedConsumerGroup) {
+		t.Errorf("Subscribe(policy) error = %v, want ErrClosedConsumerGroup", err)
+	}
+
+	_ = q.Shutdown(context.Background())
+	if err := NewKafkaConsumer(q, nil).Subscribe(context.Background(), []string{"order"}, handler); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Subscribe() after Shutdown error = %v, want ErrQueueClosed", err)
+	}
+}
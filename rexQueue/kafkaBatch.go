@@ -0,0 +1,213 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	defaultBatchMaxSize = 500
+	defaultBatchMaxWait = time.Second
+)
+
+type (
+	BatchConsumeOptions struct {
+		// MaxSize 每批最多多少条，达到后立即处理
+		MaxSize int
+		// MaxWait 从一批的第一条消息开始最多等待多久，超时后即使不满也会处理
+		MaxWait time.Duration
+		// RetryPolicy 不为空时同时消费重试 topic，失败的消息投递到重试 topic 或死信 topic；
+		// 为空时只提交第一条失败消息之前的 offset，然后退出本次会话，从第一条失败的消息开始重新投递
+		RetryPolicy *RetryPolicy
+	}
+
+	// BatchError 批处理部分失败时由 handler 返回，有 RetryPolicy 时只有 Failed 中的消息进入重试，其余消息视为成功
+	BatchError struct {
+		Failed []BatchItemError
+	}
+	BatchItemError struct {
+		Message *sarama.ConsumerMessage
+		Err     error
+	}
+
+	batchConsumerGroupHandler struct {
+		q       *defaultKafkaQueue
+		opts    BatchConsumeOptions
+		handler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error
+	}
+)
+
+func NewBatchError() *BatchError {
+	return &BatchError{}
+}
+
+// Add 记录一条失败的消息
+func (e *BatchError) Add(msg *sarama.ConsumerMessage, err error) {
+	e.Failed = append(e.Failed, BatchItemError{Message: msg, Err: err})
+}
+
+// ErrorOrNil 没有失败的消息时返回 nil，方便 handler 直接返回
+func (e *BatchError) ErrorOrNil() error {
+	if e == nil || len(e.Failed) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *BatchError) Error() string {
+	items := make([]string, 0, len(e.Failed))
+	for _, item := range e.Failed {
+		items = append(items, fmt.Sprintf("%s/%d/%d: %v", item.Message.Topic, item.Message.Partition, item.Message.Offset, item.Err))
+	}
+	return fmt.Sprintf("%d msgs failed in batch: %s", len(e.Failed), strings.Join(items, "; "))
+}
+
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, item := range e.Failed {
+		errs = append(errs, item.Err)
+	}
+	return errs
+}
+
+// BatchConsume 以消费者组按分区批量消费 topics，整批处理成功（或失败的消息都已投递到重试路径）后才提交 offset
+func (q *defaultKafkaQueue) BatchConsume(ctx context.Context, topics []string, opts BatchConsumeOptions, handler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error) {
+	if q.consumerGroup == nil {
+		logc.Errorf(ctx, "BatchConsume err: %v", ErrConsumerGroupNotInit)
+		return
+	}
+	if opts.MaxSize <= 0 {
+		opts.MaxSize = defaultBatchMaxSize
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = defaultBatchMaxWait
+	}
+	if opts.RetryPolicy != nil {
+		topics = opts.RetryPolicy.Topics(topics...)
+	}
+	q.Consume(ctx, q.consumerGroup, topics, &batchConsumerGroupHandler{
+		q:       q,
+		opts:    opts,
+		handler: handler,
+	})
+}
+
+func (h *batchConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *batchConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *batchConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	batch := make([]*sarama.ConsumerMessage, 0, h.opts.MaxSize)
+	timer := time.NewTimer(h.opts.MaxWait)
+	timer.Stop()
+	defer timer.Stop()
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		timer.Stop()
+		if done, err := h.process(ctx, batch); err != nil {
+			logc.Errorf(ctx, "batch consume failed, topic: %s, partition: %d, offsets: [%d, %d], err: %v", claim.Topic(), claim.Partition(), batch[0].Offset, batch[len(batch)-1].Offset, err)
+			if done > 0 {
+				session.MarkMessage(batch[done-1], "")
+			}
+			return err
+		}
+		session.MarkMessage(batch[len(batch)-1], "")
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return flush()
+			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(h.opts.MaxWait)
+			}
+			if len(batch) >= h.opts.MaxSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		case <-timer.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			// note: 未处理的一批不提交，由下一个消费者重新消费
+			return nil
+		}
+	}
+}
+
+// process 处理一批消息，返回错误时同时返回开头有多少条消息已经处理完成，可以提交 offset
+func (h *batchConsumerGroupHandler) process(ctx context.Context, batch []*sarama.ConsumerMessage) (int, error) {
+	// note: 重试 topic 中的消息等到最后一条可以处理时再一起处理
+	if h.opts.RetryPolicy != nil {
+		if err := waitNotBefore(ctx, headerInt64(batch[len(batch)-1].Headers, HeaderRetryNotBefore)); err != nil {
+			return 0, err
+		}
+	}
+
+	// note: handler 可能持有切片，传入副本
+	msgs := make([]*sarama.ConsumerMessage, len(batch))
+	copy(msgs, batch)
+	handleErr := h.handler(ctx, msgs)
+	if handleErr == nil {
+		return 0, nil
+	}
+
+	var batchErr *BatchError
+	if h.opts.RetryPolicy == nil {
+		// note: 没有重试路径时不能跳过失败的消息，只提交第一条失败消息之前的部分
+		if errors.As(handleErr, &batchErr) {
+			return firstFailed(batch, batchErr.Failed), handleErr
+		}
+		return 0, handleErr
+	}
+
+	var failed []BatchItemError
+	if errors.As(handleErr, &batchErr) {
+		failed = batchErr.Failed
+	} else {
+		for _, msg := range msgs {
+			failed = append(failed, BatchItemError{Message: msg, Err: handleErr})
+		}
+	}
+	for _, item := range failed {
+		if err := h.q.forwardFailed(ctx, h.opts.RetryPolicy, item.Message, item.Err); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// firstFailed 返回 batch 中第一条失败消息的下标，没有找到时返回 0，整批都需要重新投递
+func firstFailed(batch []*sarama.ConsumerMessage, failed []BatchItemError) int {
+	offsets := make(map[int64]struct{}, len(failed))
+	for _, item := range failed {
+		if item.Message != nil {
+			offsets[item.Message.Offset] = struct{}{}
+		}
+	}
+	if len(offsets) == 0 {
+		return 0
+	}
+	for i, msg := range batch {
+		if _, ok := offsets[msg.Offset]; ok {
+			return i
+		}
+	}
+	return 0
+}
@@ -0,0 +1,101 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+func testBatch(offsets ...int64) []*sarama.ConsumerMessage {
+	msgs := make([]*sarama.ConsumerMessage, 0, len(offsets))
+	for _, offset := range offsets {
+		msgs = append(msgs, &sarama.ConsumerMessage{Topic: "order", Offset: offset, Value: []byte("v")})
+	}
+	return msgs
+}
+
+func failOffsets(offsets ...int64) func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+	return func(ctx context.Context, msgs []*sarama.ConsumerMessage) error {
+		batchErr := NewBatchError()
+		for _, msg := range msgs {
+			for _, offset := range offsets {
+				if msg.Offset == offset {
+					batchErr.Add(msg, errors.New("boom"))
+				}
+			}
+		}
+		return batchErr.ErrorOrNil()
+	}
+}
+
+func TestBatchConsumeWithoutPolicy(t *testing.T) {
+	opts := BatchConsumeOptions{MaxSize: 4, MaxWait: time.Minute}
+	tests := []struct {
+		name    string
+		handler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error
+		wantErr bool
+		marked  []int64
+	}{
+		{name: "success", handler: failOffsets(), marked: []int64{4}},
+		{name: "partial", handler: failOffsets(3, 4), wantErr: true, marked: []int64{2}},
+		{name: "first failed", handler: failOffsets(1), wantErr: true},
+		{name: "plain error", handler: func(ctx context.Context, msgs []*sarama.ConsumerMessage) error { return errors.New("boom") }, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &batchConsumerGroupHandler{opts: opts, handler: tt.handler}
+			session := &fakeSession{ctx: context.Background()}
+			err := h.ConsumeClaim(session, newFakeClaim(testBatch(1, 2, 3, 4)...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ConsumeClaim() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := session.Marked(); !reflect.DeepEqual(got, tt.marked) && !(len(got) == 0 && len(tt.marked) == 0) {
+				t.Errorf("marked = %v, want %v", got, tt.marked)
+			}
+		})
+	}
+}
+
+func TestBatchConsumeWithPolicy(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	producer := mocks.NewSyncProducer(t, conf.Config)
+	q := &defaultKafkaQueue{conf: conf, syncProducer: producer, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(context.Background()) }()
+
+	forwarded := []string{}
+	checker := func(msg *sarama.ProducerMessage) error {
+		forwarded = append(forwarded, msg.Topic)
+		return nil
+	}
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(checker)
+	h := &batchConsumerGroupHandler{
+		q:       q,
+		opts:    BatchConsumeOptions{MaxSize: 3, MaxWait: time.Minute, RetryPolicy: NewRetryPolicy(time.Second)},
+		handler: failOffsets(2),
+	}
+	session := &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(session, newFakeClaim(testBatch(1, 2, 3)...)); err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	if !reflect.DeepEqual(forwarded, []string{"order.retry.1s"}) {
+		t.Errorf("forwarded = %v", forwarded)
+	}
+	if got := session.Marked(); !reflect.DeepEqual(got, []int64{3}) {
+		t.Errorf("marked = %v, want [3]", got)
+	}
+
+	// note: 转发失败时整批都不提交
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	session = &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(session, newFakeClaim(testBatch(4, 2, 5)...)); err == nil {
+		t.Error("ConsumeClaim() error = nil, want forward error")
+	}
+	if got := session.Marked(); len(got) != 0 {
+		t.Errorf("marked = %v, want none", got)
+	}
+}
@@ -1,8 +1,15 @@
 package rexQueue
 
 import (
-	"github.com/IBM/sarama"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
 	"time"
+
+	"github.com/IBM/sarama"
 )
 
 type ConsumerMode string
@@ -18,16 +25,71 @@ const (
 	ProducerModeAsync ProducerMode = "async"
 )
 
+var (
+	ErrUnknownSaslMechanism = errors.New("未知 sasl mechanism")
+	ErrUnknownCompression   = errors.New("未知压缩类型")
+)
+
 type KafkaConfig struct {
-	ConsumerMode ConsumerMode
-	ProducerMode ProducerMode
-	Brokers      []string `json:",default=[localhost:29092]"`
-	Topics       []string `json:",default=[]"`
-	GroupId      string   `json:",default=default_group"`
+	ConsumerMode ConsumerMode `json:",default=group"`
+	ProducerMode ProducerMode `json:",default=sync"`
+	Brokers      []string     `json:",default=[localhost:29092]"`
+	Topics       []string     `json:",default=[]"`
+	GroupId      string       `json:",default=default_group"`
+	// 以下字段可以从 go-zero 配置文件加载，不为空时覆盖 sarama.Config 中对应的配置
+	ClientId string `json:",optional"`
+	// KafkaVersion kafka 版本，例如 3.6.0
+	KafkaVersion string `json:",optional"`
+	// Compression none|gzip|snappy|lz4|zstd
+	Compression string `json:",optional"`
+	// Idempotent 开启幂等 producer，见 WithIdempotent
+	Idempotent bool `json:",optional"`
+	// TransactionalId 不为空时开启事务 producer，同时开启幂等，见 WithTransactionalId
+	TransactionalId string        `json:",optional"`
+	Sasl            KafkaSaslConf `json:",optional"`
+	Tls             KafkaTlsConf  `json:",optional"`
+	TopicSpecs      []TopicSpec   `json:",optional"`
+	saramaConfig    `json:"-"`
+}
+
+// saramaConfig 不导出，go-zero 加载配置时会跳过，sarama.Config 中的函数等字段无法从配置文件加载；
+// go-zero 不会跳过带 json:"-" 的匿名字段，所以不能直接嵌入 *sarama.Config。
+// c.Config 以及 sarama.Config 的字段仍然可以通过 KafkaConfig 直接访问，但是 KafkaConfig{Config: c} 这样的字面量无法再编译，
+// 请改用 FromSaramaConfig、NewConfig 或者 With
+type saramaConfig struct {
 	*sarama.Config
 }
 
+type KafkaSaslConf struct {
+	// Mechanism PLAIN|SCRAM-SHA-256|SCRAM-SHA-512，为空时不开启 sasl
+	Mechanism string `json:",optional"`
+	User      string `json:",optional"`
+	Password  string `json:",optional"`
+}
+
+type KafkaTlsConf struct {
+	Enable bool `json:",optional"`
+	// CaFile 为空时使用系统证书
+	CaFile string `json:",optional"`
+	// CertFile 和 KeyFile 同时配置时开启双向认证
+	CertFile           string `json:",optional"`
+	KeyFile            string `json:",optional"`
+	ServerName         string `json:",optional"`
+	InsecureSkipVerify bool   `json:",optional"`
+}
+
 func Default(brokers []string, groupId string, topics []string) *KafkaConfig {
+	return &KafkaConfig{
+		ConsumerMode: ModeConsumerGroup,
+		ProducerMode: ProducerModeSync,
+		Brokers:      brokers,
+		Topics:       topics,
+		GroupId:      groupId,
+		saramaConfig: saramaConfig{Config: defaultSaramaConfig()},
+	}
+}
+
+func defaultSaramaConfig() *sarama.Config {
 	c := sarama.NewConfig()
 	c.Version = sarama.V4_0_0_0
 
@@ -45,14 +107,7 @@ func Default(brokers []string, groupId string, topics []string) *KafkaConfig {
 	c.Net.DialTimeout = 5 * time.Second
 	c.Net.ReadTimeout = 5 * time.Second
 
-	return &KafkaConfig{
-		ConsumerMode: ModeConsumerGroup,
-		ProducerMode: ProducerModeSync,
-		Brokers:      brokers,
-		Topics:       topics,
-		GroupId:      groupId,
-		Config:       c,
-	}
+	return c
 }
 
 func NewConfig(consumerMode ConsumerMode, producerMode ProducerMode, brokers []string, groupId string, topics []string, c *sarama.Config) *KafkaConfig {
@@ -62,7 +117,17 @@ func NewConfig(consumerMode ConsumerMode, producerMode ProducerMode, brokers []s
 		Brokers:      brokers,
 		Topics:       topics,
 		GroupId:      groupId,
-		Config:       c,
+		saramaConfig: saramaConfig{Config: c},
+	}
+}
+
+// FromSaramaConfig 使用已有的 sarama.Config 创建 KafkaConfig，替代 KafkaConfig{Config: c} 字面量，
+// 其他字段可以直接赋值或者通过 With 系列方法设置
+func FromSaramaConfig(c *sarama.Config) *KafkaConfig {
+	return &KafkaConfig{
+		ConsumerMode: ModeConsumerGroup,
+		ProducerMode: ProducerModeSync,
+		saramaConfig: saramaConfig{Config: c},
 	}
 }
 
@@ -80,3 +145,128 @@ func (c *KafkaConfig) WithProducerMode(mode ProducerMode) *KafkaConfig {
 	c.ProducerMode = mode
 	return c
 }
+
+// WithIdempotent 开启幂等 producer，broker 按 producer id 和序号去重，重试不会产生重复消息；在 Build 时生效
+func (c *KafkaConfig) WithIdempotent() *KafkaConfig {
+	c.Idempotent = true
+	return c
+}
+
+// WithTransactionalId 开启事务 producer，同一个 transactionalId 同时只能有一个实例，
+// 重启后会隔离旧实例未完成的事务；消费端只读取已提交的消息；在 Build 时生效
+func (c *KafkaConfig) WithTransactionalId(transactionalId string) *KafkaConfig {
+	c.TransactionalId = transactionalId
+	return c
+}
+
+func (c *KafkaConfig) applyIdempotent() {
+	if c.TransactionalId != "" {
+		c.Config.Producer.Transaction.ID = c.TransactionalId
+		c.Config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+	if !c.Idempotent && c.TransactionalId == "" {
+		return
+	}
+	c.Config.Producer.Idempotent = true
+	c.Config.Producer.RequiredAcks = sarama.WaitForAll
+	if c.Config.Producer.Retry.Max < 1 {
+		c.Config.Producer.Retry.Max = 1
+	}
+	// note: 幂等 producer 要求每个连接只有一个未完成的请求
+	c.Config.Net.MaxOpenRequests = 1
+}
+
+// Validate 覆盖 sarama.Config.Validate，go-zero 加载配置后会调用，此时 Config 还为空，在 Build 中校验
+func (c *KafkaConfig) Validate() error {
+	if c.Config == nil {
+		return nil
+	}
+	return c.Config.Validate()
+}
+
+// Build 把配置文件中的平铺字段写入 sarama.Config，Config 为空时使用 Default 的参数，NewKafkaQueue 会自动调用
+func (c *KafkaConfig) Build() error {
+	if c.Config == nil {
+		c.Config = defaultSaramaConfig()
+	}
+	if c.ClientId != "" {
+		c.Config.ClientID = c.ClientId
+	}
+	if c.KafkaVersion != "" {
+		version, err := sarama.ParseKafkaVersion(c.KafkaVersion)
+		if err != nil {
+			return err
+		}
+		c.Config.Version = version
+	}
+	if c.Compression != "" {
+		var codec sarama.CompressionCodec
+		if err := codec.UnmarshalText([]byte(strings.ToLower(c.Compression))); err != nil {
+			return fmt.Errorf("%w: %s", ErrUnknownCompression, c.Compression)
+		}
+		c.Config.Producer.Compression = codec
+	}
+	c.applyIdempotent()
+	if err := c.Sasl.apply(c.Config); err != nil {
+		return err
+	}
+	if err := c.Tls.apply(c.Config); err != nil {
+		return err
+	}
+	return c.Config.Validate()
+}
+
+func (s KafkaSaslConf) apply(c *sarama.Config) error {
+	if s.Mechanism == "" {
+		return nil
+	}
+	switch strings.ToUpper(s.Mechanism) {
+	case SaslMechanismPlain:
+		c.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SaslMechanismScramSha256:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		c.Net.SASL.SCRAMClientGeneratorFunc = newScramClient(SaslMechanismScramSha256)
+	case SaslMechanismScramSha512:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		c.Net.SASL.SCRAMClientGeneratorFunc = newScramClient(SaslMechanismScramSha512)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownSaslMechanism, s.Mechanism)
+	}
+	c.Net.SASL.Enable = true
+	c.Net.SASL.Handshake = true
+	c.Net.SASL.User = s.User
+	c.Net.SASL.Password = s.Password
+	return nil
+}
+
+func (t KafkaTlsConf) apply(c *sarama.Config) error {
+	if !t.Enable {
+		return nil
+	}
+	tlsConf := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CaFile != "" {
+		ca, err := os.ReadFile(t.CaFile)
+		if err != nil {
+			return fmt.Errorf("读取 ca 证书失败: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("ca 证书格式错误: %s", t.CaFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return fmt.Errorf("读取客户端证书失败: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	c.Net.TLS.Enable = true
+	c.Net.TLS.Config = tlsConf
+	return nil
+}
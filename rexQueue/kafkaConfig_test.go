@@ -0,0 +1,56 @@
+package rexQueue
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/conf"
+)
+
+func TestKafkaConfigTransactionalId(t *testing.T) {
+	// note: 从配置文件加载时 sarama.Config 为空
+	c := KafkaConfig{Brokers: []string{"localhost:9092"}}
+	c.WithTransactionalId("tx-1")
+	if err := c.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if !c.Producer.Idempotent || c.Producer.Transaction.ID != "tx-1" || c.Net.MaxOpenRequests != 1 {
+		t.Errorf("producer config = %+v", c.Producer)
+	}
+	if c.Producer.RequiredAcks != sarama.WaitForAll || c.Consumer.IsolationLevel != sarama.ReadCommitted {
+		t.Errorf("acks = %v, isolation = %v", c.Producer.RequiredAcks, c.Consumer.IsolationLevel)
+	}
+
+	plain := Default([]string{"localhost:9092"}, "g", nil)
+	if err := plain.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if plain.Producer.Idempotent || plain.Producer.Transaction.ID != "" {
+		t.Errorf("idempotent enabled without being configured")
+	}
+}
+
+func TestKafkaConfigLoad(t *testing.T) {
+	var c KafkaConfig
+	body := `{"Brokers":["localhost:9092"],"KafkaVersion":"3.6.0","Compression":"zstd","Idempotent":true}`
+	if err := conf.LoadFromJsonBytes([]byte(body), &c); err != nil {
+		t.Fatalf("LoadFromJsonBytes() error = %v", err)
+	}
+	if c.Config != nil {
+		t.Fatalf("Config = %v, want nil before Build", c.Config)
+	}
+	if err := c.Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if c.Version != sarama.V3_6_0_0 {
+		t.Errorf("Version = %v, want %v", c.Version, sarama.V3_6_0_0)
+	}
+	if c.Producer.Compression != sarama.CompressionZSTD || !c.Producer.Idempotent {
+		t.Errorf("producer config = %+v", c.Producer)
+	}
+
+	custom := sarama.NewConfig()
+	if got := FromSaramaConfig(custom); got.Config != custom || got.ConsumerMode != ModeConsumerGroup {
+		t.Errorf("FromSaramaConfig() = %+v, want the given sarama.Config", got)
+	}
+}
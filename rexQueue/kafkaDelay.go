@@ -0,0 +1,307 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/google/uuid"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	HeaderDelayId = "x-rex-delay-id"
+
+	defaultDelayPollInterval = time.Second
+	defaultDelayBatchSize    = 100
+	defaultDelayClaimLease   = time.Minute
+)
+
+var (
+	ErrDelayStoreNotInit = errors.New("delay store 未初始化")
+)
+
+type (
+	// DelayedMessage 等待投递的消息，Value 和 Headers 原样发送到 Topic
+	DelayedMessage struct {
+		Id        string            `json:"id"`
+		Topic     string            `json:"topic"`
+		Key       string            `json:"key"`
+		Value     []byte            `json:"value"`
+		Headers   map[string]string `json:"headers"`
+		DeliverAt time.Time         `json:"deliverAt"`
+		CreatedAt time.Time         `json:"createdAt"`
+	}
+
+	// DelayStore 保存延迟消息，多个副本共享同一个 store，Claim 保证同一时间每条消息只会被一个副本认领
+	DelayStore interface {
+		Add(ctx context.Context, msg *DelayedMessage) error
+		// Due 返回到期的消息，按投递时间排序，返回的消息可能已经被其他副本认领
+		Due(ctx context.Context, now time.Time, limit int) ([]*DelayedMessage, error)
+		// Claim 认领消息并记录认领时间 now，只有一个调用方会得到 true
+		Claim(ctx context.Context, id string, now time.Time) (bool, error)
+		// Ack 消息已投递，删除消息
+		Ack(ctx context.Context, id string) error
+		// Release 投递失败，放回等待队列
+		Release(ctx context.Context, msg *DelayedMessage) error
+		// Reap 把认领时间早于 now-lease 的消息放回等待队列，最多处理 limit 条，返回放回的数量；
+		// 认领后进程退出的消息由此恢复，所以投递是至少一次，消费端可以按 HeaderDelayId 去重
+		Reap(ctx context.Context, now time.Time, lease time.Duration, limit int) (int, error)
+		// Cancel 取消还没有被认领的消息，返回是否取消成功
+		Cancel(ctx context.Context, id string) (bool, error)
+		// Backlog 返回等待中的消息数量、已到期的消息数量和最早的投递时间
+		Backlog(ctx context.Context, now time.Time) (DelayBacklog, error)
+	}
+
+	DelayBacklog struct {
+		Pending int64
+		Due     int64
+		// Claimed 已认领但还没有确认投递的消息数量
+		Claimed int64
+		// OldestDeliverAt 最早一条等待消息的投递时间，没有等待消息时为零值
+		OldestDeliverAt time.Time
+	}
+
+	DelayConf struct {
+		// PollInterval 轮询间隔，默认 1s
+		PollInterval time.Duration
+		// BatchSize 每次轮询最多处理多少条，默认 100
+		BatchSize int
+		// ClaimLease 认领后多久没有确认投递就放回等待队列，需要大于一次投递的最长耗时，默认 1m
+		ClaimLease time.Duration
+	}
+
+	DelayStats struct {
+		DelayBacklog
+		// Lag 最早一条到期消息已经超时多久，用于发现轮询跟不上的情况
+		Lag       time.Duration
+		Published uint64
+		Forwarded uint64
+		Failed    uint64
+		Cancelled uint64
+		// Reaped 认领超时被放回等待队列的数量
+		Reaped uint64
+	}
+
+	DelayQueue interface {
+		PublishAt(ctx context.Context, topic, key, value string, deliverAt time.Time) (id string, err error)
+		PublishAfter(ctx context.Context, topic, key, value string, delay time.Duration) (id string, err error)
+		// PublishMessageAt 延迟发送任意消息，可以配合 NewEnvelopeMessage 使用
+		PublishMessageAt(ctx context.Context, msg *sarama.ProducerMessage, deliverAt time.Time) (id string, err error)
+		Cancel(ctx context.Context, id string) (bool, error)
+		Stats(ctx context.Context) (DelayStats, error)
+		// Run 阻塞轮询到期消息并投递，直到 ctx 结束，每个副本都可以运行
+		Run(ctx context.Context)
+		// Poll 执行一次轮询，返回投递成功的数量
+		Poll(ctx context.Context) (int, error)
+	}
+
+	defaultDelayQueue struct {
+		q     KafkaQueue
+		store DelayStore
+		conf  DelayConf
+		now   func() time.Time
+
+		published atomic.Uint64
+		forwarded atomic.Uint64
+		failed    atomic.Uint64
+		cancelled atomic.Uint64
+		reaped    atomic.Uint64
+	}
+)
+
+func NewDelayQueue(q KafkaQueue, store DelayStore, conf DelayConf) DelayQueue {
+	if conf.PollInterval <= 0 {
+		conf.PollInterval = defaultDelayPollInterval
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = defaultDelayBatchSize
+	}
+	if conf.ClaimLease <= 0 {
+		conf.ClaimLease = defaultDelayClaimLease
+	}
+	return &defaultDelayQueue{
+		q:     q,
+		store: store,
+		conf:  conf,
+		now:   time.Now,
+	}
+}
+
+func (d *defaultDelayQueue) PublishAt(ctx context.Context, topic, key, value string, deliverAt time.Time) (string, error) {
+	return d.add(ctx, &DelayedMessage{
+		Topic:     topic,
+		Key:       key,
+		Value:     []byte(value),
+		DeliverAt: deliverAt,
+	})
+}
+
+func (d *defaultDelayQueue) PublishAfter(ctx context.Context, topic, key, value string, delay time.Duration) (string, error) {
+	return d.PublishAt(ctx, topic, key, value, d.now().Add(delay))
+}
+
+func (d *defaultDelayQueue) PublishMessageAt(ctx context.Context, msg *sarama.ProducerMessage, deliverAt time.Time) (string, error) {
+	delayed := &DelayedMessage{
+		Topic:     msg.Topic,
+		DeliverAt: deliverAt,
+		Headers:   make(map[string]string, len(msg.Headers)),
+	}
+	if msg.Key != nil {
+		key, err := msg.Key.Encode()
+		if err != nil {
+			return "", err
+		}
+		delayed.Key = string(key)
+	}
+	if msg.Value != nil {
+		value, err := msg.Value.Encode()
+		if err != nil {
+			return "", err
+		}
+		delayed.Value = value
+	}
+	for _, h := range msg.Headers {
+		delayed.Headers[string(h.Key)] = string(h.Value)
+	}
+	return d.add(ctx, delayed)
+}
+
+func (d *defaultDelayQueue) add(ctx context.Context, msg *DelayedMessage) (string, error) {
+	if d.store == nil {
+		return "", ErrDelayStoreNotInit
+	}
+	msg.Id = uuid.NewString()
+	msg.CreatedAt = d.now()
+	if err := d.store.Add(ctx, msg); err != nil {
+		return "", err
+	}
+	d.published.Add(1)
+	return msg.Id, nil
+}
+
+func (d *defaultDelayQueue) Cancel(ctx context.Context, id string) (bool, error) {
+	if d.store == nil {
+		return false, ErrDelayStoreNotInit
+	}
+	ok, err := d.store.Cancel(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		d.cancelled.Add(1)
+	}
+	return ok, nil
+}
+
+func (d *defaultDelayQueue) Stats(ctx context.Context) (DelayStats, error) {
+	stats := DelayStats{
+		Published: d.published.Load(),
+		Forwarded: d.forwarded.Load(),
+		Failed:    d.failed.Load(),
+		Cancelled: d.cancelled.Load(),
+		Reaped:    d.reaped.Load(),
+	}
+	if d.store == nil {
+		return stats, ErrDelayStoreNotInit
+	}
+	now := d.now()
+	backlog, err := d.store.Backlog(ctx, now)
+	if err != nil {
+		return stats, err
+	}
+	stats.DelayBacklog = backlog
+	if backlog.Due > 0 && !backlog.OldestDeliverAt.IsZero() {
+		stats.Lag = now.Sub(backlog.OldestDeliverAt)
+	}
+	return stats, nil
+}
+
+func (d *defaultDelayQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.conf.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := d.Poll(ctx)
+			if err != nil {
+				logc.Errorf(ctx, "delay queue poll err: %v", err)
+				break
+			}
+			// note: 一次没有处理完时立即继续，避免积压
+			if n < d.conf.BatchSize {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *defaultDelayQueue) Poll(ctx context.Context) (int, error) {
+	if d.store == nil {
+		return 0, ErrDelayStoreNotInit
+	}
+	now := d.now()
+	reaped, err := d.store.Reap(ctx, now, d.conf.ClaimLease, d.conf.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if reaped > 0 {
+		d.reaped.Add(uint64(reaped))
+		logc.Infof(ctx, "delay queue reaped %d expired claims", reaped)
+	}
+	msgs, err := d.store.Due(ctx, now, d.conf.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	forwarded := 0
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			return forwarded, ctx.Err()
+		}
+		ok, err := d.store.Claim(ctx, msg.Id, d.now())
+		if err != nil {
+			return forwarded, err
+		}
+		if !ok {
+			// note: 已经被其他副本认领或者被取消
+			continue
+		}
+		if err := d.forward(ctx, msg); err != nil {
+			d.failed.Add(1)
+			logc.Errorf(ctx, "delay queue forward msg %s to %s failed: %v", msg.Id, msg.Topic, err)
+			if releaseErr := d.store.Release(context.WithoutCancel(ctx), msg); releaseErr != nil {
+				return forwarded, errors.Join(err, releaseErr)
+			}
+			continue
+		}
+		d.forwarded.Add(1)
+		forwarded++
+		if err := d.store.Ack(ctx, msg.Id); err != nil {
+			return forwarded, err
+		}
+	}
+	return forwarded, nil
+}
+
+func (d *defaultDelayQueue) forward(ctx context.Context, msg *DelayedMessage) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderDelayId), Value: []byte(msg.Id)})
+	pm := &sarama.ProducerMessage{
+		Topic:   msg.Topic,
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if msg.Key != "" {
+		pm.Key = sarama.StringEncoder(msg.Key)
+	}
+	return sendMessage(ctx, d.q, pm)
+}
@@ -0,0 +1,325 @@
+package rexQueue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+)
+
+const (
+	DefaultDelayTableName = "kafka_delayed_messages"
+)
+
+type DelayStatus int8
+
+const (
+	DelayStatusPending DelayStatus = iota + 1
+	DelayStatusClaimed
+	DelayStatusSent
+	DelayStatusCancelled
+)
+
+type (
+	// redisDelayStore 使用 zset 保存投递时间和认领时间，hash 保存消息内容
+	redisDelayStore struct {
+		rd         rexDao.RedisDao
+		queueKey   string
+		claimedKey string
+		msgKey     string
+	}
+
+	gormDelayStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	KafkaDelayedMessage struct {
+		rexDatabase.BaseModel
+		MessageId string      `gorm:"uniqueIndex:idx_message_id;column:message_id;comment:消息id;type: varchar(64)" json:"message_id"`
+		Topic     string      `gorm:"column:topic;comment:目标topic;type: varchar(255)" json:"topic"`
+		MsgKey    string      `gorm:"column:msg_key;comment:消息key;type: varchar(255)" json:"msg_key"`
+		Value     []byte      `gorm:"column:value;comment:消息内容" json:"value"`
+		Headers   string      `gorm:"column:headers;comment:消息头json;type: text" json:"headers"`
+		DeliverAt time.Time   `gorm:"index:idx_status_deliver_at,priority:2;column:deliver_at;comment:投递时间" json:"deliver_at"`
+		Status    DelayStatus `gorm:"index:idx_status_deliver_at,priority:1;index:idx_status_claimed_at,priority:1;column:status;comment:状态 1->等待,2->已认领,3->已投递,4->已取消;type: tinyint" json:"status"`
+		ClaimedAt *time.Time  `gorm:"index:idx_status_claimed_at,priority:2;column:claimed_at;comment:认领时间" json:"claimed_at"`
+	}
+)
+
+func (KafkaDelayedMessage) TableName() string {
+	return DefaultDelayTableName
+}
+
+// NewRedisDelayStore 等待队列保存在 {prefix}:kafka-delay:queue，已认领的消息保存在 {prefix}:kafka-delay:claimed，
+// 消息内容保存在 {prefix}:kafka-delay:msg
+func NewRedisDelayStore(rd rexDao.RedisDao, prefix string) DelayStore {
+	return &redisDelayStore{
+		rd:         rd,
+		queueKey:   fmt.Sprintf("%s:kafka-delay:queue", prefix),
+		claimedKey: fmt.Sprintf("%s:kafka-delay:claimed", prefix),
+		msgKey:     fmt.Sprintf("%s:kafka-delay:msg", prefix),
+	}
+}
+
+func (s *redisDelayStore) Add(ctx context.Context, msg *DelayedMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	// note: 先写内容再入队，中间失败只会留下没有入队的内容，不会出现入队但没有内容的消息
+	if _, err := s.rd.HSetCtx(ctx, s.msgKey, msg.Id, string(data)); err != nil {
+		return err
+	}
+	_, err = s.rd.ZAddCtx(ctx, s.queueKey, float64(msg.DeliverAt.UnixMilli()), msg.Id)
+	return err
+}
+
+func (s *redisDelayStore) Due(ctx context.Context, now time.Time, limit int) ([]*DelayedMessage, error) {
+	due, err := s.rd.ZRangeByScoreCtx(ctx, s.queueKey, math.Inf(-1), float64(now.UnixMilli()), int64(limit))
+	if err != nil {
+		return nil, err
+	}
+	if len(due) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(due))
+	for _, z := range due {
+		ids = append(ids, fmt.Sprint(z.Member))
+	}
+	values, err := s.rd.HMGetCtx(ctx, s.msgKey, ids...)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*DelayedMessage, 0, len(values))
+	for i, data := range values {
+		if data == "" {
+			// note: 消息内容已经不存在，清理队列中的残留 id
+			if _, err := s.rd.ZRemCtx(ctx, s.queueKey, ids[i]); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		msg := &DelayedMessage{}
+		if err := json.Unmarshal([]byte(data), msg); err != nil {
+			return nil, err
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+func (s *redisDelayStore) Claim(ctx context.Context, id string, now time.Time) (bool, error) {
+	return s.rd.ZMoveCtx(ctx, s.queueKey, s.claimedKey, id, float64(now.UnixMilli()))
+}
+
+func (s *redisDelayStore) Ack(ctx context.Context, id string) error {
+	if _, err := s.rd.ZRemCtx(ctx, s.claimedKey, id); err != nil {
+		return err
+	}
+	_, err := s.rd.HDelCtx(ctx, s.msgKey, id)
+	return err
+}
+
+func (s *redisDelayStore) Release(ctx context.Context, msg *DelayedMessage) error {
+	_, err := s.rd.ZMoveCtx(ctx, s.claimedKey, s.queueKey, msg.Id, float64(msg.DeliverAt.UnixMilli()))
+	return err
+}
+
+func (s *redisDelayStore) Reap(ctx context.Context, now time.Time, lease time.Duration, limit int) (int, error) {
+	expired, err := s.rd.ZRangeByScoreCtx(ctx, s.claimedKey, math.Inf(-1), float64(now.Add(-lease).UnixMilli()), int64(limit))
+	if err != nil {
+		return 0, err
+	}
+	reaped := 0
+	for _, z := range expired {
+		// note: 放回时按当前时间排队，消息本来就已经到期
+		ok, err := s.rd.ZMoveCtx(ctx, s.claimedKey, s.queueKey, fmt.Sprint(z.Member), float64(now.UnixMilli()))
+		if err != nil {
+			return reaped, err
+		}
+		if ok {
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+func (s *redisDelayStore) Cancel(ctx context.Context, id string) (bool, error) {
+	n, err := s.rd.ZRemCtx(ctx, s.queueKey, id)
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = s.rd.HDelCtx(ctx, s.msgKey, id)
+	return true, err
+}
+
+func (s *redisDelayStore) Backlog(ctx context.Context, now time.Time) (DelayBacklog, error) {
+	var backlog DelayBacklog
+	claimed, err := s.rd.ZCardCtx(ctx, s.claimedKey)
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Claimed = int64(claimed)
+	pending, err := s.rd.ZCardCtx(ctx, s.queueKey)
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Pending = int64(pending)
+	if pending == 0 {
+		return backlog, nil
+	}
+	due, err := s.rd.ZCountCtx(ctx, s.queueKey, math.Inf(-1), float64(now.UnixMilli()))
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Due = int64(due)
+	oldest, err := s.rd.ZRangeByScoreCtx(ctx, s.queueKey, math.Inf(-1), math.Inf(1), 1)
+	if err != nil {
+		return backlog, err
+	}
+	if len(oldest) > 0 {
+		backlog.OldestDeliverAt = time.UnixMilli(int64(oldest[0].Score))
+	}
+	return backlog, nil
+}
+
+// NewGormDelayStore tableName 为空时使用 kafka_delayed_messages，表结构见 KafkaDelayedMessage，投递后的消息保留为已投递状态
+func NewGormDelayStore(dao rexDao.Dao, tableName string) DelayStore {
+	if tableName == "" {
+		tableName = DefaultDelayTableName
+	}
+	return &gormDelayStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormDelayStore) Add(ctx context.Context, msg *DelayedMessage) error {
+	headers, err := json.Marshal(msg.Headers)
+	if err != nil {
+		return err
+	}
+	return s.dao.Create(ctx, s.tableName, &KafkaDelayedMessage{
+		MessageId: msg.Id,
+		Topic:     msg.Topic,
+		MsgKey:    msg.Key,
+		Value:     msg.Value,
+		Headers:   string(headers),
+		DeliverAt: msg.DeliverAt,
+		Status:    DelayStatusPending,
+	})
+}
+
+func (s *gormDelayStore) Due(ctx context.Context, now time.Time, limit int) ([]*DelayedMessage, error) {
+	var rows []KafkaDelayedMessage
+	if err := s.dao.FindAndLimitOrder(ctx, s.tableName, "deliver_at ASC", limit, 0, &rows, "status = ? AND deliver_at <= ?", DelayStatusPending, now); err != nil {
+		return nil, err
+	}
+	result := make([]*DelayedMessage, 0, len(rows))
+	for _, row := range rows {
+		msg := &DelayedMessage{
+			Id:        row.MessageId,
+			Topic:     row.Topic,
+			Key:       row.MsgKey,
+			Value:     row.Value,
+			DeliverAt: row.DeliverAt,
+			CreatedAt: row.CreatedAt,
+		}
+		if row.Headers != "" {
+			if err := json.Unmarshal([]byte(row.Headers), &msg.Headers); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, msg)
+	}
+	return result, nil
+}
+
+// transit 按状态条件更新，依赖影响行数判断是否抢到
+func (s *gormDelayStore) transit(ctx context.Context, id string, from, to DelayStatus) (bool, error) {
+	return s.update(ctx, map[string]interface{}{"status": to, "updated_at": time.Now()}, "message_id = ? AND status = ?", id, from)
+}
+
+// update 按条件更新，返回是否有且只有一行被更新
+func (s *gormDelayStore) update(ctx context.Context, updates map[string]interface{}, query interface{}, args ...interface{}) (bool, error) {
+	tx := s.dao.GetDB().WithContext(ctx).Table(s.tableName).Where(query, args...).Updates(updates)
+	if tx.Error != nil {
+		return false, tx.Error
+	}
+	return tx.RowsAffected == 1, nil
+}
+
+func (s *gormDelayStore) Claim(ctx context.Context, id string, now time.Time) (bool, error) {
+	return s.update(ctx, map[string]interface{}{"status": DelayStatusClaimed, "claimed_at": now, "updated_at": time.Now()},
+		"message_id = ? AND status = ?", id, DelayStatusPending)
+}
+
+func (s *gormDelayStore) Ack(ctx context.Context, id string) error {
+	_, err := s.transit(ctx, id, DelayStatusClaimed, DelayStatusSent)
+	return err
+}
+
+func (s *gormDelayStore) Release(ctx context.Context, msg *DelayedMessage) error {
+	_, err := s.update(ctx, map[string]interface{}{"status": DelayStatusPending, "claimed_at": nil, "updated_at": time.Now()},
+		"message_id = ? AND status = ?", msg.Id, DelayStatusClaimed)
+	return err
+}
+
+func (s *gormDelayStore) Reap(ctx context.Context, now time.Time, lease time.Duration, limit int) (int, error) {
+	var rows []KafkaDelayedMessage
+	if err := s.dao.FindAndLimitOrder(ctx, s.tableName, "claimed_at ASC", limit, 0, &rows, "status = ? AND claimed_at <= ?", DelayStatusClaimed, now.Add(-lease)); err != nil {
+		return 0, err
+	}
+	reaped := 0
+	for _, row := range rows {
+		// note: 条件里带上认领时间，期间被重新认领的消息不会被放回
+		ok, err := s.update(ctx, map[string]interface{}{"status": DelayStatusPending, "claimed_at": nil, "updated_at": time.Now()},
+			"message_id = ? AND status = ? AND claimed_at = ?", row.MessageId, DelayStatusClaimed, row.ClaimedAt)
+		if err != nil {
+			return reaped, err
+		}
+		if ok {
+			reaped++
+		}
+	}
+	return reaped, nil
+}
+
+func (s *gormDelayStore) Cancel(ctx context.Context, id string) (bool, error) {
+	return s.transit(ctx, id, DelayStatusPending, DelayStatusCancelled)
+}
+
+func (s *gormDelayStore) Backlog(ctx context.Context, now time.Time) (DelayBacklog, error) {
+	var backlog DelayBacklog
+	claimed, err := s.dao.Count(ctx, s.tableName, "status = ?", DelayStatusClaimed)
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Claimed = claimed
+	pending, err := s.dao.Count(ctx, s.tableName, "status = ?", DelayStatusPending)
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Pending = pending
+	if pending == 0 {
+		return backlog, nil
+	}
+	due, err := s.dao.Count(ctx, s.tableName, "status = ? AND deliver_at <= ?", DelayStatusPending, now)
+	if err != nil {
+		return backlog, err
+	}
+	backlog.Due = due
+	var oldest []KafkaDelayedMessage
+	if err := s.dao.FindAndLimitOrder(ctx, s.tableName, "deliver_at ASC", 1, 0, &oldest, "status = ?", DelayStatusPending); err != nil {
+		return backlog, err
+	}
+	if len(oldest) > 0 {
+		backlog.OldestDeliverAt = oldest[0].DeliverAt
+	}
+	return backlog, nil
+}
@@ -0,0 +1,135 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/glebarez/sqlite"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestGormDelayStore(t *testing.T) DelayStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&KafkaDelayedMessage{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormDelayStore(rexDao.NewDao(db), "")
+}
+
+func TestDelayStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) DelayStore{
+		"redis": func(t *testing.T) DelayStore { return NewRedisDelayStore(rexDao.NewMemoryRedisDao(), "test") },
+		"gorm":  newTestGormDelayStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			now := time.UnixMilli(1700000000000)
+			for i, id := range []string{"a", "b", "c"} {
+				msg := &DelayedMessage{Id: id, Topic: "order", Value: []byte(id), DeliverAt: now.Add(time.Duration(i) * time.Second)}
+				if err := s.Add(ctx, msg); err != nil {
+					t.Fatalf("Add(%s) error = %v", id, err)
+				}
+			}
+
+			due, err := s.Due(ctx, now.Add(time.Second), 10)
+			if err != nil || len(due) != 2 || due[0].Id != "a" || string(due[1].Value) != "b" {
+				t.Fatalf("Due() = %v, %v, want a, b", due, err)
+			}
+			if ok, _ := s.Claim(ctx, "a", now); !ok {
+				t.Fatal("Claim(a) = false")
+			}
+			if ok, _ := s.Claim(ctx, "a", now); ok {
+				t.Error("Claim(a) twice = true")
+			}
+			if ok, _ := s.Cancel(ctx, "a"); ok {
+				t.Error("Cancel(claimed) = true")
+			}
+
+			// note: 租约没有过期时不会放回
+			if n, err := s.Reap(ctx, now.Add(30*time.Second), time.Minute, 10); err != nil || n != 0 {
+				t.Errorf("Reap() before lease = %d, %v, want 0", n, err)
+			}
+			if n, err := s.Reap(ctx, now.Add(2*time.Minute), time.Minute, 10); err != nil || n != 1 {
+				t.Errorf("Reap() after lease = %d, %v, want 1", n, err)
+			}
+			if ok, _ := s.Claim(ctx, "a", now.Add(2*time.Minute)); !ok {
+				t.Fatal("Claim(a) after reap = false")
+			}
+			if err := s.Ack(ctx, "a"); err != nil {
+				t.Errorf("Ack(a) error = %v", err)
+			}
+
+			if ok, _ := s.Claim(ctx, "b", now); !ok {
+				t.Fatal("Claim(b) = false")
+			}
+			if err := s.Release(ctx, &DelayedMessage{Id: "b", DeliverAt: now.Add(time.Second)}); err != nil {
+				t.Errorf("Release(b) error = %v", err)
+			}
+			if ok, _ := s.Cancel(ctx, "c"); !ok {
+				t.Error("Cancel(c) = false")
+			}
+
+			backlog, err := s.Backlog(ctx, now.Add(time.Hour))
+			if err != nil {
+				t.Fatalf("Backlog() error = %v", err)
+			}
+			if backlog.Pending != 1 || backlog.Due != 1 || backlog.Claimed != 0 || !backlog.OldestDeliverAt.Equal(now.Add(time.Second)) {
+				t.Errorf("Backlog() = %+v", backlog)
+			}
+		})
+	}
+}
+
+func TestDelayQueuePoll(t *testing.T) {
+	ctx := context.Background()
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	producer := mocks.NewSyncProducer(t, conf.Config)
+	q := &defaultKafkaQueue{conf: conf, syncProducer: producer, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(ctx) }()
+
+	store := NewRedisDelayStore(rexDao.NewMemoryRedisDao(), "test")
+	d := NewDelayQueue(q, store, DelayConf{ClaimLease: time.Minute}).(*defaultDelayQueue)
+	now := time.UnixMilli(1700000000000)
+	d.now = func() time.Time { return now }
+
+	if _, err := d.PublishAt(ctx, "order", "k", "ok", now); err != nil {
+		t.Fatalf("PublishAt() error = %v", err)
+	}
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if headerString(toHeaderPtrs(msg.Headers), HeaderDelayId) == "" {
+			return errors.New("missing delay id header")
+		}
+		return nil
+	})
+	if n, err := d.Poll(ctx); err != nil || n != 1 {
+		t.Fatalf("Poll() = %d, %v, want 1", n, err)
+	}
+
+	// note: 投递失败时放回等待队列
+	if _, err := d.PublishAt(ctx, "order", "k", "fail", now); err != nil {
+		t.Fatalf("PublishAt() error = %v", err)
+	}
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	if n, err := d.Poll(ctx); err != nil || n != 0 {
+		t.Fatalf("Poll() = %d, %v, want 0", n, err)
+	}
+	stats, err := d.Stats(ctx)
+	if err != nil {
+		t.Fatalf("Stats() error = %v", err)
+	}
+	if stats.Forwarded != 1 || stats.Failed != 1 || stats.Pending != 1 || stats.Claimed != 0 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
@@ -0,0 +1,381 @@
+package rexQueue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/rootexit/rexLib/rexCtx"
+	"github.com/zeromicro/go-zero/core/logc"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	HeaderContentType   = "content-type"
+	HeaderEventType     = "x-rex-event-type"
+	HeaderSchemaVersion = "x-rex-schema-version"
+	HeaderProducedAt    = "x-rex-produced-at"
+	HeaderRequestId     = "x-rex-request-id"
+	HeaderTenantId      = "x-rex-tenant-id"
+	HeaderUserId        = "x-rex-user-id"
+
+	ContentTypeJson     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+var (
+	ErrNotProtoMessage = errors.New("payload does not implement proto.Message")
+	ErrUnknownCodec    = errors.New("unknown codec")
+	// ErrEnvelopeDecode 消息无法解码，重试不会成功，RetryConsume 和 Subscribe 会直接投递到死信 topic
+	ErrEnvelopeDecode = errors.New("envelope decode failed")
+)
+
+type (
+	// Codec 负责 payload 的序列化，ContentType 会写入消息头，消费时按消息头选择 Codec
+	Codec interface {
+		ContentType() string
+		Marshal(v any) ([]byte, error)
+		Unmarshal(data []byte, v any) error
+	}
+	JsonCodec  struct{}
+	ProtoCodec struct{}
+
+	// Envelope 是带元数据的消息，元数据都放在 kafka 消息头里，消息体只有 payload
+	Envelope[T any] struct {
+		EventType     string
+		SchemaVersion int
+		ProducedAt    time.Time
+		RequestId     string
+		TenantId      string
+		UserId        string
+		Topic         string
+		Key           string
+		Partition     int32
+		Offset        int64
+		Headers       map[string]string
+		Payload       T
+	}
+
+	PublishOption  func(o *publishOptions)
+	publishOptions struct {
+		eventType     string
+		schemaVersion int
+		codec         Codec
+		headers       map[string]string
+	}
+
+	headerCarrier struct {
+		headers *[]sarama.RecordHeader
+	}
+	consumerHeaderCarrier []*sarama.RecordHeader
+
+	// subscribeConsumerGroupHandler handler 返回错误时投递到死信 topic，投递成功后才提交 offset
+	subscribeConsumerGroupHandler struct {
+		q       KafkaQueue
+		handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+	}
+)
+
+var (
+	codecLock sync.RWMutex
+	codecs    = map[string]Codec{
+		ContentTypeJson:     JsonCodec{},
+		ContentTypeProtobuf: ProtoCodec{},
+	}
+)
+
+// RegisterCodec 注册自定义 Codec，相同 ContentType 会被覆盖
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+	codecs[c.ContentType()] = c
+}
+
+func lookupCodec(contentType string) (Codec, error) {
+	if contentType == "" {
+		return JsonCodec{}, nil
+	}
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+	c, ok := codecs[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, contentType)
+	}
+	return c, nil
+}
+
+func (JsonCodec) ContentType() string {
+	return ContentTypeJson
+}
+
+func (JsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (ProtoCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (ProtoCodec) Marshal(v any) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, ErrNotProtoMessage
+	}
+	return proto.Marshal(m)
+}
+
+func (ProtoCodec) Unmarshal(data []byte, v any) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return proto.Unmarshal(data, m)
+}
+
+func WithEventType(eventType string) PublishOption {
+	return func(o *publishOptions) {
+		o.eventType = eventType
+	}
+}
+
+func WithSchemaVersion(version int) PublishOption {
+	return func(o *publishOptions) {
+		o.schemaVersion = version
+	}
+}
+
+func WithCodec(codec Codec) PublishOption {
+	return func(o *publishOptions) {
+		o.codec = codec
+	}
+}
+
+func WithHeaders(headers map[string]string) PublishOption {
+	return func(o *publishOptions) {
+		o.headers = headers
+	}
+}
+
+// NewEnvelopeMessage 把 payload 编码成 kafka 消息，并把 ctx 中的请求id、租户、用户和链路信息写入消息头
+func NewEnvelopeMessage[T any](ctx context.Context, topic, key string, payload T, opts ...PublishOption) (*sarama.ProducerMessage, error) {
+	o := publishOptions{
+		schemaVersion: 1,
+		codec:         JsonCodec{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.eventType == "" {
+		o.eventType = fmt.Sprintf("%T", payload)
+	}
+	// note: proto 消息使用指针类型，这里传入 payload 本身
+	body, err := o.codec.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(o.headers)+10)
+	for k, v := range o.headers {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderContentType), Value: []byte(o.codec.ContentType())},
+		sarama.RecordHeader{Key: []byte(HeaderEventType), Value: []byte(o.eventType)},
+		sarama.RecordHeader{Key: []byte(HeaderSchemaVersion), Value: []byte(strconv.Itoa(o.schemaVersion))},
+		sarama.RecordHeader{Key: []byte(HeaderProducedAt), Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	)
+	for _, item := range []struct {
+		header string
+		ctxKey any
+	}{
+		{header: HeaderRequestId, ctxKey: rexCtx.CtxRequestId{}},
+		{header: HeaderTenantId, ctxKey: rexCtx.CtxTenantId{}},
+		{header: HeaderUserId, ctxKey: rexCtx.CtxUserId{}},
+	} {
+		if v := ctx.Value(item.ctxKey); v != nil {
+			headers = append(headers, sarama.RecordHeader{Key: []byte(item.header), Value: []byte(fmt.Sprint(v))})
+		}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier{headers: &headers})
+
+	msg := &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(body),
+		Headers: headers,
+	}
+	if key != "" {
+		msg.Key = sarama.StringEncoder(key)
+	}
+	return msg, nil
+}
+
+// Publish 发送一条带元数据的消息，同步模式下等待 broker 确认
+func Publish[T any](ctx context.Context, q KafkaQueue, topic, key string, payload T, opts ...PublishOption) error {
+	msg, err := NewEnvelopeMessage(ctx, topic, key, payload, opts...)
+	if err != nil {
+		return err
+	}
+	return sendMessage(ctx, q, msg)
+}
+
+// DecodeEnvelope 解码消息，并返回恢复了请求id、租户、用户和链路信息的 ctx
+func DecodeEnvelope[T any](ctx context.Context, msg *sarama.ConsumerMessage) (context.Context, *Envelope[T], error) {
+	env := &Envelope[T]{
+		EventType: headerString(msg.Headers, HeaderEventType),
+		RequestId: headerString(msg.Headers, HeaderRequestId),
+		TenantId:  headerString(msg.Headers, HeaderTenantId),
+		UserId:    headerString(msg.Headers, HeaderUserId),
+		Topic:     msg.Topic,
+		Key:       string(msg.Key),
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Headers:   make(map[string]string, len(msg.Headers)),
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			env.Headers[string(h.Key)] = string(h.Value)
+		}
+	}
+	env.SchemaVersion = int(headerInt64(msg.Headers, HeaderSchemaVersion))
+	if producedAt := headerInt64(msg.Headers, HeaderProducedAt); producedAt > 0 {
+		env.ProducedAt = time.UnixMilli(producedAt)
+	}
+
+	codec, err := lookupCodec(headerString(msg.Headers, HeaderContentType))
+	if err != nil {
+		return ctx, env, fmt.Errorf("%w: %w", ErrEnvelopeDecode, err)
+	}
+	// note: T 是指针类型时（比如 proto 消息），需要先分配内存再解码
+	if err := codec.Unmarshal(msg.Value, newPayloadTarget(&env.Payload)); err != nil {
+		return ctx, env, fmt.Errorf("%w: %w", ErrEnvelopeDecode, err)
+	}
+
+	ctx = otel.GetTextMapPropagator().Extract(ctx, consumerHeaderCarrier(msg.Headers))
+	if env.RequestId != "" {
+		ctx = context.WithValue(ctx, rexCtx.CtxRequestId{}, env.RequestId)
+	}
+	if env.TenantId != "" {
+		ctx = context.WithValue(ctx, rexCtx.CtxTenantId{}, env.TenantId)
+	}
+	if env.UserId != "" {
+		ctx = context.WithValue(ctx, rexCtx.CtxUserId{}, env.UserId)
+	}
+	return ctx, env, nil
+}
+
+// EnvelopeHandler 把类型化的处理函数转换成 RetryConsume 使用的处理函数，解码失败返回 ErrEnvelopeDecode，直接进入死信 topic
+func EnvelopeHandler[T any](handler func(ctx context.Context, env *Envelope[T]) error) func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	return func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		ctx, env, err := DecodeEnvelope[T](ctx, msg)
+		if err != nil {
+			return err
+		}
+		return handler(ctx, env)
+	}
+}
+
+// Subscribe 以消费者组消费类型化消息，无法解码的消息投递到 {topic}.dlq，投递失败时不提交 offset；
+// handler 返回的错误只记录日志，需要失败重试时请使用 RetryConsume 配合 EnvelopeHandler
+func Subscribe[T any](ctx context.Context, q KafkaQueue, topics []string, handler func(ctx context.Context, env *Envelope[T]) error) {
+	h := EnvelopeHandler(handler)
+	q.Consume(ctx, q.GetConsumerGroup(), topics, &subscribeConsumerGroupHandler{
+		q: q,
+		handler: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			err := h(ctx, msg)
+			if err != nil && !errors.Is(err, ErrEnvelopeDecode) {
+				logc.Errorf(ctx, "consume msg failed, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+				return nil
+			}
+			return err
+		},
+	})
+}
+
+func (h *subscribeConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *subscribeConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *subscribeConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.handler(ctx, msg); err != nil {
+				if err := forwardFailed(ctx, h.q, &RetryPolicy{}, msg, err); err != nil {
+					// note: 投递死信失败时不提交 offset，退出本次会话，等待重新投递
+					logc.Errorf(ctx, "forward undecodable msg failed, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+					return err
+				}
+			}
+			session.MarkMessage(msg, "")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func newPayloadTarget[T any](payload *T) any {
+	var zero T
+	if m, ok := any(zero).(proto.Message); ok {
+		// note: T 本身是 proto 指针类型，zero 为 nil，需要创建新实例
+		msg := m.ProtoReflect().New().Interface()
+		*payload = msg.(T)
+		return msg
+	}
+	return payload
+}
+
+func (c headerCarrier) Get(key string) string {
+	for _, h := range *c.headers {
+		if string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c headerCarrier) Set(key, value string) {
+	for i, h := range *c.headers {
+		if string(h.Key) == key {
+			(*c.headers)[i].Value = []byte(value)
+			return
+		}
+	}
+	*c.headers = append(*c.headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(*c.headers))
+	for _, h := range *c.headers {
+		keys = append(keys, string(h.Key))
+	}
+	return keys
+}
+
+func (c consumerHeaderCarrier) Get(key string) string {
+	return headerString(c, key)
+}
+
+func (c consumerHeaderCarrier) Set(string, string) {}
+
+func (c consumerHeaderCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for _, h := range c {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,169 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/rootexit/rexLib/rexCtx"
+)
+
+type testOrder struct {
+	Id     string `json:"id"`
+	Amount int    `json:"amount"`
+}
+
+// fakeSession 记录 MarkMessage 的消息和 MarkOffset 的 offset，用于测试 ConsumerGroupHandler
+type fakeSession struct {
+	ctx     context.Context
+	lock    sync.Mutex
+	marked  []int64
+	offsets []int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32                                               { return nil }
+func (s *fakeSession) MemberID() string                                                         { return "member" }
+func (s *fakeSession) GenerationID() int32                                                      { return 1 }
+func (s *fakeSession) Commit()                                                                  {}
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {}
+func (s *fakeSession) Context() context.Context                                                 { return s.ctx }
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *fakeSession) Marked() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.offsets = append(s.offsets, offset)
+}
+
+func (s *fakeSession) Offsets() []int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]int64(nil), s.offsets...)
+}
+
+// fakeClaim 按顺序返回 msgs，然后关闭消息通道
+type fakeClaim struct {
+	msgs chan *sarama.ConsumerMessage
+}
+
+func newFakeClaim(msgs ...*sarama.ConsumerMessage) *fakeClaim {
+	c := &fakeClaim{msgs: make(chan *sarama.ConsumerMessage, len(msgs))}
+	for _, msg := range msgs {
+		c.msgs <- msg
+	}
+	close(c.msgs)
+	return c
+}
+
+func (c *fakeClaim) Topic() string                            { return "order" }
+func (c *fakeClaim) Partition() int32                         { return 0 }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+func toConsumerMessage(msg *sarama.ProducerMessage, offset int64) *sarama.ConsumerMessage {
+	value, _ := msg.Value.Encode()
+	var key []byte
+	if msg.Key != nil {
+		key, _ = msg.Key.Encode()
+	}
+	return &sarama.ConsumerMessage{Topic: msg.Topic, Key: key, Value: value, Offset: offset, Headers: toHeaderPtrs(msg.Headers)}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	ctx := context.WithValue(context.Background(), rexCtx.CtxRequestId{}, "req-1")
+	ctx = context.WithValue(ctx, rexCtx.CtxTenantId{}, "tenant-1")
+	ctx = context.WithValue(ctx, rexCtx.CtxUserId{}, "user-1")
+	pm, err := NewEnvelopeMessage(ctx, "order", "k1", testOrder{Id: "o1", Amount: 3},
+		WithEventType("order.created"), WithSchemaVersion(2), WithHeaders(map[string]string{"x-custom": "v"}))
+	if err != nil {
+		t.Fatalf("NewEnvelopeMessage() error = %v", err)
+	}
+
+	decodedCtx, env, err := DecodeEnvelope[testOrder](context.Background(), toConsumerMessage(pm, 5))
+	if err != nil {
+		t.Fatalf("DecodeEnvelope() error = %v", err)
+	}
+	if env.Payload != (testOrder{Id: "o1", Amount: 3}) {
+		t.Errorf("Payload = %+v", env.Payload)
+	}
+	if env.EventType != "order.created" || env.SchemaVersion != 2 || env.Key != "k1" || env.Offset != 5 {
+		t.Errorf("Envelope = %+v", env)
+	}
+	if env.ProducedAt.IsZero() || env.Headers["x-custom"] != "v" || env.Headers[HeaderContentType] != ContentTypeJson {
+		t.Errorf("Envelope metadata = %+v", env)
+	}
+	for key, want := range map[any]string{
+		rexCtx.CtxRequestId{}: "req-1",
+		rexCtx.CtxTenantId{}:  "tenant-1",
+		rexCtx.CtxUserId{}:    "user-1",
+	} {
+		if got := decodedCtx.Value(key); got != want {
+			t.Errorf("ctx value %T = %v, want %v", key, got, want)
+		}
+	}
+
+	bad := &sarama.ConsumerMessage{Topic: "order", Value: []byte("{"), Headers: toHeaderPtrs(pm.Headers)}
+	if _, _, err := DecodeEnvelope[testOrder](context.Background(), bad); !errors.Is(err, ErrEnvelopeDecode) {
+		t.Errorf("DecodeEnvelope(bad) error = %v, want ErrEnvelopeDecode", err)
+	}
+}
+
+func TestSubscribeForwardsUndecodable(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	producer := mocks.NewSyncProducer(t, conf.Config)
+	q := &defaultKafkaQueue{conf: conf, syncProducer: producer, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(context.Background()) }()
+
+	pm, _ := NewEnvelopeMessage(context.Background(), "order", "", testOrder{Id: "o1"})
+	good := toConsumerMessage(pm, 1)
+	bad := &sarama.ConsumerMessage{Topic: "order", Offset: 2, Value: []byte("{")}
+	failed := toConsumerMessage(pm, 3)
+
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		if msg.Topic != "order.dlq" {
+			return errors.New("undecodable msg should go to order.dlq, got " + msg.Topic)
+		}
+		return nil
+	})
+	var handled []int64
+	h := &subscribeConsumerGroupHandler{q: q, handler: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+		_, _, err := DecodeEnvelope[testOrder](ctx, msg)
+		if err != nil {
+			return err
+		}
+		handled = append(handled, msg.Offset)
+		return nil
+	}}
+	session := &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(session, newFakeClaim(good, bad)); err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	if got := session.Marked(); len(got) != 2 || len(handled) != 1 {
+		t.Errorf("marked = %v, handled = %v", got, handled)
+	}
+
+	// note: 死信投递失败时不提交 offset
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	session = &fakeSession{ctx: context.Background()}
+	if err := h.ConsumeClaim(session, newFakeClaim(&sarama.ConsumerMessage{Topic: "order", Offset: 4, Value: []byte("{")}, failed)); err == nil {
+		t.Error("ConsumeClaim() error = nil, want forward error")
+	}
+	if got := session.Marked(); len(got) != 0 {
+		t.Errorf("marked = %v, want none", got)
+	}
+}
@@ -0,0 +1,553 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+const (
+	DefaultShutdownTimeout = 30 * time.Second
+
+	// note: 消费者组 Consume 返回非关闭错误时重新加入前的等待时间
+	consumeRetryBackoff = time.Second
+
+	GroupStateNone    = "none"
+	GroupStateJoining = "joining"
+	GroupStateMember  = "member"
+	GroupStateStopped = "stopped"
+	// GroupStateFailed 消费者组遇到重试也不会成功的错误（配置错误、没有权限等）后停止消费，错误见 KafkaHealth.LastConsumeErr
+	GroupStateFailed   = "failed"
+	maxRecordedErrors  = 100
+	healthProbeTimeout = 5 * time.Second
+)
+
+var (
+	// ErrAsyncAckUnavailable async producer 没有开启 Producer.Return.Successes，无法等待 broker 确认
+	ErrAsyncAckUnavailable = errors.New("async producer 未开启 Return.Successes，无法等待发送确认")
+)
+
+type (
+	BrokerHealth struct {
+		Id        int32  `json:"id"`
+		Addr      string `json:"addr"`
+		Connected bool   `json:"connected"`
+	}
+
+	GroupHealth struct {
+		GroupId      string             `json:"groupId"`
+		State        string             `json:"state"`
+		MemberId     string             `json:"memberId"`
+		GenerationId int32              `json:"generationId"`
+		Claims       map[string][]int32 `json:"claims"`
+	}
+
+	PartitionLag struct {
+		Topic     string `json:"topic"`
+		Partition int32  `json:"partition"`
+		// Committed 消费者组已提交的 offset，-1 表示还没有提交过
+		Committed int64 `json:"committed"`
+		HighWater int64 `json:"highWater"`
+		Lag       int64 `json:"lag"`
+	}
+
+	KafkaHealth struct {
+		// Ready 没有在关闭、能连接到 broker，且消费者组模式下已经加入消费者组
+		Ready          bool           `json:"ready"`
+		Closing        bool           `json:"closing"`
+		Brokers        []BrokerHealth `json:"brokers"`
+		Group          *GroupHealth   `json:"group,omitempty"`
+		Lag            []PartitionLag `json:"lag,omitempty"`
+		LastConsumeErr string         `json:"lastConsumeErr,omitempty"`
+		AsyncErrCount  uint64         `json:"asyncErrCount"`
+		Err            string         `json:"err,omitempty"`
+	}
+
+	// lifecycleState 管理 KafkaQueue 的关闭流程和健康状态
+	lifecycleState struct {
+		stopCtx  context.Context
+		stop     context.CancelFunc
+		closing  atomic.Bool
+		intakeWg sync.WaitGroup
+		// intakeLock 保证关闭开始后不再有新的消费登记到 intakeWg，同时保护 shutdownCtx
+		intakeLock  sync.Mutex
+		shutdownCtx context.Context
+
+		asyncWg   sync.WaitGroup
+		asyncOnce sync.Once
+		catching  atomic.Bool
+		flushOnce sync.Once
+		// producerStop flush 开始时关闭，唤醒阻塞在 Input 上的异步发送，让它们释放读锁
+		producerStop chan struct{}
+		// flushed flush 完成后关闭
+		flushed       chan struct{}
+		asyncErrCount atomic.Uint64
+		// producerLock 发送持有读锁，关闭 producer 前持有写锁，避免向已关闭的 Input 发送
+		producerLock   sync.RWMutex
+		producerClosed bool
+
+		stateLock      sync.Mutex
+		errs           []error
+		lastConsumeErr error
+		group          GroupHealth
+
+		healthLock  sync.Mutex
+		healthAdmin sarama.ClusterAdmin
+		healthCli   sarama.Client
+	}
+
+	// asyncAck 放在 ProducerMessage.Metadata 中，drainAsync 收到发送结果后写入 done
+	asyncAck struct {
+		done chan error
+	}
+
+	// lifecycleHandler 包装业务 handler，记录消费者组状态，关闭时在提交 offset 之前先 flush producer
+	lifecycleHandler struct {
+		q *defaultKafkaQueue
+		sarama.ConsumerGroupHandler
+	}
+)
+
+func newLifecycleState() *lifecycleState {
+	l := &lifecycleState{
+		producerStop: make(chan struct{}),
+		flushed:      make(chan struct{}),
+	}
+	l.stopCtx, l.stop = context.WithCancel(context.Background())
+	l.group.State = GroupStateNone
+	return l
+}
+
+// enterIntake 登记一个消费循环，返回合并了关闭信号的 ctx，关闭开始后返回 false
+func (q *defaultKafkaQueue) enterIntake(ctx context.Context) (context.Context, func(), bool) {
+	q.life.intakeLock.Lock()
+	defer q.life.intakeLock.Unlock()
+	if q.life.closing.Load() {
+		return ctx, func() {}, false
+	}
+	q.life.intakeWg.Add(1)
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(q.life.stopCtx, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+		q.life.intakeWg.Done()
+	}, true
+}
+
+func (q *defaultKafkaQueue) recordErr(err error) {
+	q.life.stateLock.Lock()
+	defer q.life.stateLock.Unlock()
+	if len(q.life.errs) < maxRecordedErrors {
+		q.life.errs = append(q.life.errs, err)
+	}
+}
+
+func (q *defaultKafkaQueue) recordConsumeErr(err error) {
+	q.life.stateLock.Lock()
+	q.life.lastConsumeErr = err
+	q.life.stateLock.Unlock()
+}
+
+// isFatalConsumeErr 配置错误和授权错误重新加入消费者组也不会成功，不再重试
+func isFatalConsumeErr(err error) bool {
+	var confErr sarama.ConfigurationError
+	if errors.As(err, &confErr) {
+		return true
+	}
+	var kerr sarama.KError
+	if errors.As(err, &kerr) {
+		switch kerr {
+		case sarama.ErrTopicAuthorizationFailed, sarama.ErrGroupAuthorizationFailed, sarama.ErrClusterAuthorizationFailed,
+			sarama.ErrSASLAuthenticationFailed, sarama.ErrInvalidTopic, sarama.ErrInvalidGroupId, sarama.ErrInconsistentGroupProtocol:
+			return true
+		}
+	}
+	return false
+}
+
+func (q *defaultKafkaQueue) setGroupState(state string, session sarama.ConsumerGroupSession) {
+	q.life.stateLock.Lock()
+	defer q.life.stateLock.Unlock()
+	q.life.group.State = state
+	if session != nil {
+		q.life.group.MemberId = session.MemberID()
+		q.life.group.GenerationId = session.GenerationID()
+		q.life.group.Claims = session.Claims()
+	} else if state != GroupStateMember {
+		q.life.group.Claims = nil
+	}
+}
+
+func (h *lifecycleHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.q.setGroupState(GroupStateMember, session)
+	return h.ConsumerGroupHandler.Setup(session)
+}
+
+func (h *lifecycleHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	err := h.ConsumerGroupHandler.Cleanup(session)
+	if h.q.life.closing.Load() {
+		// note: 关闭时先把 producer 中的消息发出去，再提交 offset，保证至少一次；flush 超时时不提交
+		if ferr := h.q.flushProducers(h.q.getShutdownCtx()); ferr != nil {
+			h.q.recordErr(fmt.Errorf("flush producer 超时: %w", ferr))
+			h.q.setGroupState(GroupStateJoining, nil)
+			return err
+		}
+		if !h.q.conf.Consumer.Offsets.AutoCommit.Enable {
+			session.Commit()
+		}
+	}
+	h.q.setGroupState(GroupStateJoining, nil)
+	return err
+}
+
+// startDrainAsync 启动读取 async producer 结果的协程，只启动一次，producer 已经关闭时不再启动
+func (q *defaultKafkaQueue) startDrainAsync() {
+	q.life.asyncOnce.Do(func() {
+		q.life.catching.Store(true)
+		q.life.asyncWg.Add(1)
+		go q.drainAsync()
+	})
+}
+
+func (q *defaultKafkaQueue) getAsyncProducerErrFunc() func(err error) {
+	q.life.stateLock.Lock()
+	defer q.life.stateLock.Unlock()
+	return q.asyncProducerErrFunc
+}
+
+func (q *defaultKafkaQueue) setAsyncProducerErrFunc(fn func(err error)) {
+	q.life.stateLock.Lock()
+	defer q.life.stateLock.Unlock()
+	q.asyncProducerErrFunc = fn
+}
+
+// drainAsync 唯一读取 async producer 结果的协程，producer 关闭后退出
+func (q *defaultKafkaQueue) drainAsync() {
+	defer q.life.asyncWg.Done()
+	errs := q.asyncProducer.Errors()
+	var successes <-chan *sarama.ProducerMessage
+	if q.conf.Producer.Return.Successes {
+		successes = q.asyncProducer.Successes()
+	}
+	for errs != nil || successes != nil {
+		select {
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			q.life.asyncErrCount.Add(1)
+			q.recordErr(err)
+			if err.Msg != nil {
+				notifyAsyncAck(err.Msg, err.Err)
+			}
+			if fn := q.getAsyncProducerErrFunc(); fn != nil {
+				fn(err)
+			}
+		case msg, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			notifyAsyncAck(msg, nil)
+		}
+	}
+}
+
+func notifyAsyncAck(msg *sarama.ProducerMessage, err error) {
+	if ack, ok := msg.Metadata.(*asyncAck); ok {
+		ack.done <- err
+	}
+}
+
+// asyncSendAcked 通过 async producer 发送并等待 broker 的发送结果，需要开启 Producer.Return.Successes；
+// 会启动读取发送结果的协程，msg.Metadata 会被覆盖
+func (q *defaultKafkaQueue) asyncSendAcked(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if q.asyncProducer == nil {
+		return fmt.Errorf("async producer 未初始化")
+	}
+	if !q.conf.Producer.Return.Successes {
+		return ErrAsyncAckUnavailable
+	}
+	q.startDrainAsync()
+	ack := &asyncAck{done: make(chan error, 1)}
+	msg.Metadata = ack
+	if err := q.AsyncSendMessageCtx(ctx, msg); err != nil {
+		return err
+	}
+	select {
+	case err := <-ack.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushProducers 关闭 async producer 并等待缓冲中的消息发送完成，只执行一次；
+// ctx 结束时不再等待并返回 ctx 的错误，关闭在后台继续完成
+func (q *defaultKafkaQueue) flushProducers(ctx context.Context) error {
+	q.life.flushOnce.Do(func() {
+		close(q.life.producerStop)
+		go func() {
+			defer close(q.life.flushed)
+			q.flushAsync()
+		}()
+	})
+	select {
+	case <-q.life.flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *defaultKafkaQueue) flushAsync() {
+	if q.asyncProducer == nil {
+		return
+	}
+	q.markProducerClosed()
+	// note: 已经有协程在读取结果时，只能 AsyncClose 并等待该协程退出
+	q.life.asyncOnce.Do(func() {})
+	if q.life.catching.Load() {
+		q.asyncProducer.AsyncClose()
+		q.life.asyncWg.Wait()
+		return
+	}
+	if err := q.asyncProducer.Close(); err != nil {
+		var producerErrs sarama.ProducerErrors
+		if errors.As(err, &producerErrs) {
+			q.life.asyncErrCount.Add(uint64(len(producerErrs)))
+			for _, e := range producerErrs {
+				q.recordErr(e)
+			}
+		} else {
+			q.recordErr(err)
+		}
+	}
+}
+
+// markProducerClosed 等待进行中的发送结束后标记 producer 已关闭，之后的发送直接返回 ErrQueueClosed
+func (q *defaultKafkaQueue) markProducerClosed() {
+	q.life.producerLock.Lock()
+	q.life.producerClosed = true
+	q.life.producerLock.Unlock()
+}
+
+func (q *defaultKafkaQueue) getShutdownCtx() context.Context {
+	q.life.intakeLock.Lock()
+	defer q.life.intakeLock.Unlock()
+	if q.life.shutdownCtx == nil {
+		return context.Background()
+	}
+	return q.life.shutdownCtx
+}
+
+// Shutdown 依次停止消费、等待处理中的消息（最多到 ctx 截止）、flush producer、提交 offset，
+// 然后按 消费者组 -> consumer -> producer -> client 的顺序关闭，返回过程中的所有错误
+func (q *defaultKafkaQueue) Shutdown(ctx context.Context) error {
+	q.life.intakeLock.Lock()
+	if q.life.closing.Swap(true) {
+		q.life.intakeLock.Unlock()
+		return nil
+	}
+	q.life.shutdownCtx = ctx
+	q.life.intakeLock.Unlock()
+	q.life.stop()
+
+	var errs []error
+	waited := make(chan struct{})
+	go func() {
+		q.life.intakeWg.Wait()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+	case <-ctx.Done():
+		errs = append(errs, fmt.Errorf("等待处理中的消息超时: %w", ctx.Err()))
+	}
+
+	if err := q.flushProducers(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("关闭 async producer 超时: %w", err))
+	}
+
+	if q.consumerGroup != nil {
+		if err := q.consumerGroup.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("关闭 consumer group 失败: %w", err))
+		}
+	}
+	if q.consumer != nil {
+		if err := q.consumer.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("关闭 consumer 失败: %w", err))
+		}
+	}
+	if q.syncProducer != nil {
+		// note: 同步发送可能阻塞在 broker 上，ctx 结束时不再等待，关闭在后台继续完成
+		if err := runWithCtx(ctx, func() error {
+			q.markProducerClosed()
+			return q.syncProducer.Close()
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("关闭 sync producer 失败: %w", err))
+		}
+	}
+	if q.client != nil {
+		if err := q.client.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("关闭 client 失败: %w", err))
+		}
+	}
+	q.life.healthLock.Lock()
+	if q.life.healthAdmin != nil {
+		// note: admin 关闭时会一起关闭 health client
+		if err := q.life.healthAdmin.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("关闭 health client 失败: %w", err))
+		}
+		q.life.healthAdmin = nil
+	}
+	q.life.healthLock.Unlock()
+
+	q.life.stateLock.Lock()
+	errs = append(errs, q.life.errs...)
+	q.life.group.State = GroupStateStopped
+	q.life.stateLock.Unlock()
+	return errors.Join(errs...)
+}
+
+func (q *defaultKafkaQueue) healthAdmin() (sarama.Client, sarama.ClusterAdmin, error) {
+	q.life.healthLock.Lock()
+	defer q.life.healthLock.Unlock()
+	if q.life.closing.Load() {
+		return nil, nil, ErrQueueClosed
+	}
+	if q.life.healthAdmin != nil {
+		return q.life.healthCli, q.life.healthAdmin, nil
+	}
+	client, err := sarama.NewClient(q.conf.Brokers, q.conf.Config)
+	if err != nil {
+		return nil, nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		_ = client.Close()
+		return nil, nil, err
+	}
+	q.life.healthCli = client
+	q.life.healthAdmin = admin
+	return client, admin, nil
+}
+
+// Health 返回 broker 连接、消费者组成员状态和已分配分区的消费积压，用于健康检查和就绪检查
+func (q *defaultKafkaQueue) Health(ctx context.Context) *KafkaHealth {
+	q.life.stateLock.Lock()
+	group := q.life.group
+	group.GroupId = q.conf.GroupId
+	lastConsumeErr := q.life.lastConsumeErr
+	q.life.stateLock.Unlock()
+
+	health := &KafkaHealth{
+		Closing:       q.life.closing.Load(),
+		AsyncErrCount: q.life.asyncErrCount.Load(),
+	}
+	if lastConsumeErr != nil {
+		health.LastConsumeErr = lastConsumeErr.Error()
+	}
+	if q.consumerGroup != nil {
+		health.Group = &group
+	}
+	if health.Closing {
+		return health
+	}
+
+	client, admin, err := q.healthAdmin()
+	if err != nil {
+		health.Err = err.Error()
+		return health
+	}
+	probeCtx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+	if err := runWithCtx(probeCtx, func() error { return client.RefreshMetadata() }); err != nil {
+		health.Err = err.Error()
+	}
+	for _, b := range client.Brokers() {
+		connected, _ := b.Connected()
+		health.Brokers = append(health.Brokers, BrokerHealth{Id: b.ID(), Addr: b.Addr(), Connected: connected})
+	}
+
+	if q.consumerGroup != nil {
+		claims := group.Claims
+		if len(claims) == 0 {
+			claims = make(map[string][]int32, len(q.conf.Topics))
+			for _, topic := range q.conf.Topics {
+				partitions, err := client.Partitions(topic)
+				if err != nil {
+					continue
+				}
+				claims[topic] = partitions
+			}
+		}
+		if len(claims) > 0 {
+			lag, err := q.partitionLag(probeCtx, client, admin, claims)
+			if err != nil && health.Err == "" {
+				health.Err = err.Error()
+			}
+			health.Lag = lag
+		}
+	}
+
+	health.Ready = health.Err == "" && len(health.Brokers) > 0
+	if q.consumerGroup != nil && group.State != GroupStateMember {
+		health.Ready = false
+	}
+	return health
+}
+
+func (q *defaultKafkaQueue) partitionLag(ctx context.Context, client sarama.Client, admin sarama.ClusterAdmin, claims map[string][]int32) ([]PartitionLag, error) {
+	var resp *sarama.OffsetFetchResponse
+	if err := runWithCtx(ctx, func() (err error) {
+		resp, err = admin.ListConsumerGroupOffsets(q.conf.GroupId, claims)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	var result []PartitionLag
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			hwm, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return result, err
+			}
+			item := PartitionLag{Topic: topic, Partition: partition, Committed: -1, HighWater: hwm, Lag: hwm}
+			if block := resp.GetBlock(topic, partition); block != nil && block.Offset >= 0 {
+				item.Committed = block.Offset
+				item.Lag = hwm - block.Offset
+			}
+			result = append(result, item)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Topic != result[j].Topic {
+			return result[i].Topic < result[j].Topic
+		}
+		return result[i].Partition < result[j].Partition
+	})
+	return result, nil
+}
+
+// runWithCtx sarama 的同步调用不支持 ctx，超时后直接返回，调用本身在后台结束
+func runWithCtx(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-done:
+		return err
+	}
+}
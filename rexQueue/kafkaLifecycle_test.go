@@ -0,0 +1,180 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+// blockingAsyncProducer Input 没有协程读取，发送会一直阻塞
+type blockingAsyncProducer struct {
+	sarama.AsyncProducer
+	input  chan *sarama.ProducerMessage
+	closed chan struct{}
+}
+
+func newBlockingAsyncProducer() *blockingAsyncProducer {
+	return &blockingAsyncProducer{input: make(chan *sarama.ProducerMessage), closed: make(chan struct{})}
+}
+
+func (p *blockingAsyncProducer) Input() chan<- *sarama.ProducerMessage { return p.input }
+func (p *blockingAsyncProducer) AsyncClose()                           { close(p.closed) }
+func (p *blockingAsyncProducer) Close() error {
+	close(p.closed)
+	return nil
+}
+
+// blockingSyncProducer SendMessage 阻塞到 release 关闭
+type blockingSyncProducer struct {
+	sarama.SyncProducer
+	release chan struct{}
+}
+
+func (p *blockingSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	<-p.release
+	return 0, 0, nil
+}
+func (p *blockingSyncProducer) Close() error { return nil }
+
+func TestShutdownUnblocksAsyncSend(t *testing.T) {
+	producer := newBlockingAsyncProducer()
+	q := &defaultKafkaQueue{conf: Default([]string{"localhost:9092"}, "g", nil), asyncProducer: producer, life: newLifecycleState()}
+
+	sent := make(chan error, 1)
+	go func() {
+		sent <- q.AsyncSendMessage(&sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("v")})
+	}()
+	// note: 等待发送协程阻塞在 Input 上
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	select {
+	case err := <-sent:
+		if !errors.Is(err, ErrQueueClosed) {
+			t.Errorf("AsyncSendMessage() error = %v, want ErrQueueClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("AsyncSendMessage() still blocked after Shutdown")
+	}
+	select {
+	case <-producer.closed:
+	default:
+		t.Error("async producer not closed")
+	}
+	if err := q.AsyncSendMessage(&sarama.ProducerMessage{Topic: "t"}); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("AsyncSendMessage() after Shutdown error = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestShutdownHonoursCtx(t *testing.T) {
+	producer := &blockingSyncProducer{release: make(chan struct{})}
+	defer close(producer.release)
+	q := &defaultKafkaQueue{conf: Default([]string{"localhost:9092"}, "g", nil), syncProducer: producer, life: newLifecycleState()}
+
+	go func() {
+		_, _, _ = q.SyncSendMessage(&sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("v")})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := q.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown() took %v, want bounded by ctx", elapsed)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	conf := Default([]string{"127.0.0.1:1"}, "g", nil)
+	conf.Metadata.Retry.Max = 0
+	conf.Net.DialTimeout = 100 * time.Millisecond
+	q := &defaultKafkaQueue{conf: conf, consumerGroup: &fakeConsumerGroup{}, life: newLifecycleState()}
+
+	health := q.Health(context.Background())
+	if health.Ready || health.Closing {
+		t.Errorf("health = %+v, want not ready and not closing", health)
+	}
+	if health.Err == "" {
+		t.Error("health.Err is empty with unreachable broker")
+	}
+	if health.Group == nil || health.Group.GroupId != "g" || health.Group.State != GroupStateNone {
+		t.Errorf("health.Group = %+v", health.Group)
+	}
+
+	q.recordConsumeErr(errors.New("boom"))
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	health = q.Health(context.Background())
+	if health.Ready || !health.Closing {
+		t.Errorf("health after Shutdown = %+v, want closing", health)
+	}
+	if health.Group.State != GroupStateStopped {
+		t.Errorf("group state = %s, want %s", health.Group.State, GroupStateStopped)
+	}
+	if health.LastConsumeErr != "boom" {
+		t.Errorf("LastConsumeErr = %q, want boom", health.LastConsumeErr)
+	}
+}
+
+func TestAsyncSendAcked(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	producer := mocks.NewAsyncProducer(t, conf.Config)
+	q := &defaultKafkaQueue{conf: conf, asyncProducer: producer, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(context.Background()) }()
+
+	producer.ExpectInputAndSucceed()
+	if err := sendMessage(context.Background(), q, &sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("ok")}); err != nil {
+		t.Errorf("sendMessage() error = %v", err)
+	}
+	producer.ExpectInputAndFail(sarama.ErrNotLeaderForPartition)
+	if err := sendMessage(context.Background(), q, &sarama.ProducerMessage{Topic: "t", Value: sarama.StringEncoder("fail")}); !errors.Is(err, sarama.ErrNotLeaderForPartition) {
+		t.Errorf("sendMessage() error = %v, want %v", err, sarama.ErrNotLeaderForPartition)
+	}
+}
+
+func TestConsumeFatalErr(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	fatal := fmt.Errorf("join group: %w", sarama.ErrGroupAuthorizationFailed)
+	q := &defaultKafkaQueue{conf: conf, consumerGroup: &fakeConsumerGroup{err: fatal}, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(context.Background()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.consume(ctx, q.consumerGroup, []string{"order"}, &EasyConsumerGroupHandler{}); !errors.Is(err, sarama.ErrGroupAuthorizationFailed) {
+		t.Fatalf("consume() error = %v, want ErrGroupAuthorizationFailed", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("consume() retried a non-retryable error until ctx ended")
+	}
+	q.life.stateLock.Lock()
+	state, lastErr := q.life.group.State, q.life.lastConsumeErr
+	q.life.stateLock.Unlock()
+	if state != GroupStateFailed || !errors.Is(lastErr, sarama.ErrGroupAuthorizationFailed) {
+		t.Errorf("group state = %s, last consume err = %v, want %s", state, lastErr, GroupStateFailed)
+	}
+
+	// note: broker 暂时不可用时继续重试，直到 ctx 结束
+	q.consumerGroup = &fakeConsumerGroup{err: sarama.ErrOutOfBrokers}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.consume(ctx, q.consumerGroup, []string{"order"}, &EasyConsumerGroupHandler{}); err != nil {
+		t.Errorf("consume() with a retryable error = %v, want nil after ctx ends", err)
+	}
+	if q.life.group.State != GroupStateStopped {
+		t.Errorf("group state = %s, want %s", q.life.group.State, GroupStateStopped)
+	}
+}
@@ -0,0 +1,114 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	DefaultOffsetTableName = "kafka_partition_offsets"
+)
+
+type (
+	// OffsetStore 保存分区消费的检查点，offset 为下一条要消费的消息
+	OffsetStore interface {
+		LoadOffset(ctx context.Context, name, topic string, partition int32) (offset int64, ok bool, err error)
+		SaveOffset(ctx context.Context, name, topic string, partition int32, offset int64) error
+	}
+
+	redisOffsetStore struct {
+		store  rexDao.RedisDao
+		prefix string
+	}
+
+	gormOffsetStore struct {
+		dao       rexDao.Dao
+		tableName string
+	}
+
+	KafkaPartitionOffset struct {
+		rexDatabase.BaseModel
+		ConsumerName string `gorm:"uniqueIndex:idx_kafka_partition_offset;column:consumer_name;comment:检查点名称;type: varchar(191)" json:"consumer_name"`
+		Topic        string `gorm:"uniqueIndex:idx_kafka_partition_offset;column:topic;comment:topic;type: varchar(191)" json:"topic"`
+		PartitionId  int32  `gorm:"uniqueIndex:idx_kafka_partition_offset;column:partition_id;comment:分区;type: int" json:"partition_id"`
+		NextOffset   int64  `gorm:"column:next_offset;comment:下一条要消费的offset;type: bigint" json:"next_offset"`
+	}
+)
+
+func (KafkaPartitionOffset) TableName() string {
+	return DefaultOffsetTableName
+}
+
+// NewRedisOffsetStore 检查点保存在 {prefix}:kafka-offset:{name}:{topic}:{partition}
+func NewRedisOffsetStore(store rexDao.RedisDao, prefix string) OffsetStore {
+	return &redisOffsetStore{
+		store:  store,
+		prefix: prefix,
+	}
+}
+
+func (s *redisOffsetStore) key(name, topic string, partition int32) string {
+	return fmt.Sprintf("%s:kafka-offset:%s:%s:%d", s.prefix, name, topic, partition)
+}
+
+func (s *redisOffsetStore) LoadOffset(ctx context.Context, name, topic string, partition int32) (int64, bool, error) {
+	val, err := s.store.GetCtx(ctx, s.key(name, topic, partition))
+	if err != nil {
+		return 0, false, err
+	}
+	if val == "" {
+		return 0, false, nil
+	}
+	offset, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+func (s *redisOffsetStore) SaveOffset(ctx context.Context, name, topic string, partition int32, offset int64) error {
+	return s.store.SetCtx(ctx, s.key(name, topic, partition), offset)
+}
+
+// NewGormOffsetStore tableName 为空时使用 kafka_partition_offsets，表结构见 KafkaPartitionOffset
+func NewGormOffsetStore(dao rexDao.Dao, tableName string) OffsetStore {
+	if tableName == "" {
+		tableName = DefaultOffsetTableName
+	}
+	return &gormOffsetStore{
+		dao:       dao,
+		tableName: tableName,
+	}
+}
+
+func (s *gormOffsetStore) LoadOffset(ctx context.Context, name, topic string, partition int32) (int64, bool, error) {
+	var row KafkaPartitionOffset
+	err := s.dao.First(ctx, s.tableName, &row, "consumer_name = ? AND topic = ? AND partition_id = ?", name, topic, partition)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return row.NextOffset, true, nil
+}
+
+func (s *gormOffsetStore) SaveOffset(ctx context.Context, name, topic string, partition int32, offset int64) error {
+	row := KafkaPartitionOffset{
+		ConsumerName: name,
+		Topic:        topic,
+		PartitionId:  partition,
+		NextOffset:   offset,
+	}
+	return s.dao.GetDB().WithContext(ctx).Table(s.tableName).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "consumer_name"}, {Name: "topic"}, {Name: "partition_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"next_offset", "updated_at"}),
+	}).Create(&row).Error
+}
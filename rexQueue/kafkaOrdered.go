@@ -0,0 +1,194 @@
+package rexQueue
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	defaultOrderedWorkers      = 8
+	defaultOrderedQueueSize    = 64
+	defaultOrderedDrainTimeout = 30 * time.Second
+)
+
+type (
+	OrderedConsumeOptions struct {
+		// Workers 每个分区的并发数，相同 key 的消息总是由同一个 worker 按顺序处理
+		Workers int
+		// QueueSize 每个 worker 的队列长度，队列满时停止拉取，形成背压
+		QueueSize int
+		// DrainTimeout 分区被回收或 ctx 结束时等待队列处理完的最长时间，超时后未处理的消息不提交，由下一个消费者重新消费
+		DrainTimeout time.Duration
+		// OnError handler 返回错误时调用，为空时只记录日志，消息都会被提交，需要重试请在 handler 中处理
+		OnError func(ctx context.Context, msg *sarama.ConsumerMessage, err error)
+	}
+
+	orderedConsumerGroupHandler struct {
+		opts    OrderedConsumeOptions
+		handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+	}
+
+	// offsetTracker 记录已派发的消息，只有当前面的消息都处理完成时才推进可提交的 offset
+	offsetTracker struct {
+		lock    sync.Mutex
+		pending []int64
+		done    map[int64]struct{}
+		commit  func(next int64)
+	}
+)
+
+// OrderedConsume 以消费者组消费 topics，每个分区的消息按 key 哈希分发给多个 worker 并发处理，
+// 同一个 key 内保持顺序，offset 只提交到连续处理完成的位置
+func (q *defaultKafkaQueue) OrderedConsume(ctx context.Context, topics []string, opts OrderedConsumeOptions, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) {
+	if q.consumerGroup == nil {
+		logc.Errorf(ctx, "OrderedConsume err: %v", ErrConsumerGroupNotInit)
+		return
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = defaultOrderedWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultOrderedQueueSize
+	}
+	if opts.DrainTimeout <= 0 {
+		opts.DrainTimeout = defaultOrderedDrainTimeout
+	}
+	q.Consume(ctx, q.consumerGroup, topics, &orderedConsumerGroupHandler{
+		opts:    opts,
+		handler: handler,
+	})
+}
+
+func (h *orderedConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *orderedConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *orderedConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	sessionCtx := session.Context()
+	// note: 回收分区时 session ctx 会先被取消，worker 使用独立的 ctx 才能把队列中的消息处理完
+	workerCtx, cancel := context.WithCancel(context.WithoutCancel(sessionCtx))
+	defer cancel()
+
+	tracker := newOffsetTracker(func(next int64) {
+		session.MarkOffset(claim.Topic(), claim.Partition(), next, "")
+	})
+	queues := make([]chan *sarama.ConsumerMessage, h.opts.Workers)
+	var wg sync.WaitGroup
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, h.opts.QueueSize)
+		wg.Add(1)
+		go func(queue chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for msg := range queue {
+				if workerCtx.Err() != nil {
+					// note: 排空超时，剩余消息不提交
+					continue
+				}
+				if err := h.handler(workerCtx, msg); err != nil {
+					if h.opts.OnError != nil {
+						h.opts.OnError(workerCtx, msg, err)
+					} else {
+						logc.Errorf(workerCtx, "ordered consume msg failed, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+					}
+				}
+				tracker.Done(msg.Offset)
+			}
+		}(queues[i])
+	}
+
+	h.dispatch(sessionCtx, claim, tracker, queues)
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	drained := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(drained)
+	}()
+	timer := time.NewTimer(h.opts.DrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+	case <-timer.C:
+		logc.Errorf(sessionCtx, "ordered consume drain timeout, topic: %s, partition: %d, pending: %d", claim.Topic(), claim.Partition(), tracker.Pending())
+		cancel()
+		<-drained
+	}
+	return nil
+}
+
+func (h *orderedConsumerGroupHandler) dispatch(ctx context.Context, claim sarama.ConsumerGroupClaim, tracker *offsetTracker, queues []chan *sarama.ConsumerMessage) {
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return
+			}
+			tracker.Add(msg.Offset)
+			select {
+			case queues[workerIndex(msg, len(queues))] <- msg:
+			case <-ctx.Done():
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// workerIndex 相同 key 的消息分到同一个 worker，没有 key 的消息不需要保证顺序，按 offset 轮询
+func workerIndex(msg *sarama.ConsumerMessage, workers int) int {
+	if len(msg.Key) == 0 {
+		return int(msg.Offset % int64(workers))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write(msg.Key)
+	return int(h.Sum32() % uint32(workers))
+}
+
+func newOffsetTracker(commit func(next int64)) *offsetTracker {
+	return &offsetTracker{
+		done:   make(map[int64]struct{}),
+		commit: commit,
+	}
+}
+
+// Add 按拉取顺序登记消息，offset 必须递增，允许不连续（压缩或事务 topic）
+func (t *offsetTracker) Add(offset int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pending = append(t.pending, offset)
+}
+
+// Done 标记消息处理完成，如果最早的消息都已完成，提交最后一条连续完成的消息的下一个 offset
+func (t *offsetTracker) Done(offset int64) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done[offset] = struct{}{}
+	next := int64(-1)
+	for len(t.pending) > 0 {
+		head := t.pending[0]
+		if _, ok := t.done[head]; !ok {
+			break
+		}
+		delete(t.done, head)
+		t.pending = t.pending[1:]
+		next = head + 1
+	}
+	// note: 在锁内提交，保证提交的 offset 单调递增
+	if next >= 0 && t.commit != nil {
+		t.commit(next)
+	}
+}
+
+// Pending 返回已派发但还不能提交的消息数量
+func (t *offsetTracker) Pending() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.pending)
+}
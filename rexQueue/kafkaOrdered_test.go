@@ -0,0 +1,158 @@
+package rexQueue
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestOffsetTracker(t *testing.T) {
+	var commits []int64
+	tracker := newOffsetTracker(func(next int64) {
+		commits = append(commits, next)
+	})
+	// note: 7 被压缩掉了，offset 不连续
+	for _, offset := range []int64{5, 6, 8, 9} {
+		tracker.Add(offset)
+	}
+
+	tracker.Done(6)
+	tracker.Done(8)
+	if len(commits) != 0 {
+		t.Fatalf("commits = %v, want none before offset 5 is done", commits)
+	}
+	tracker.Done(5)
+	tracker.Done(9)
+	if want := []int64{9, 10}; !reflect.DeepEqual(commits, want) {
+		t.Errorf("commits = %v, want %v", commits, want)
+	}
+	if n := tracker.Pending(); n != 0 {
+		t.Errorf("Pending() = %v, want 0", n)
+	}
+}
+
+func TestWorkerIndex(t *testing.T) {
+	a := workerIndex(&sarama.ConsumerMessage{Key: []byte("user-1"), Offset: 1}, 8)
+	b := workerIndex(&sarama.ConsumerMessage{Key: []byte("user-1"), Offset: 2}, 8)
+	if a != b {
+		t.Errorf("same key dispatched to different workers: %v, %v", a, b)
+	}
+	if got := workerIndex(&sarama.ConsumerMessage{Offset: 11}, 8); got != 3 {
+		t.Errorf("workerIndex(no key) = %v, want 3", got)
+	}
+}
+
+func keyedMessages(keys ...string) []*sarama.ConsumerMessage {
+	msgs := make([]*sarama.ConsumerMessage, 0, len(keys))
+	for i, key := range keys {
+		msgs = append(msgs, &sarama.ConsumerMessage{Topic: "order", Offset: int64(i), Key: []byte(key)})
+	}
+	return msgs
+}
+
+func TestOrderedConsumeClaim(t *testing.T) {
+	// note: Workers 为 2 时 user-1 和 user-2 分到不同的 worker
+	msgs := keyedMessages("user-1", "user-2", "user-1", "user-2", "user-1")
+	release := make(chan struct{})
+	laterDone := make(chan struct{})
+	var lock sync.Mutex
+	seen := map[string][]int64{}
+	h := &orderedConsumerGroupHandler{
+		opts: OrderedConsumeOptions{Workers: 2, QueueSize: 8, DrainTimeout: time.Minute},
+		handler: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			if msg.Offset == 0 {
+				<-release
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			seen[string(msg.Key)] = append(seen[string(msg.Key)], msg.Offset)
+			if msg.Offset == 3 {
+				close(laterDone)
+			}
+			return nil
+		},
+	}
+	session := &fakeSession{ctx: context.Background()}
+	done := make(chan error, 1)
+	go func() { done <- h.ConsumeClaim(session, newFakeClaim(msgs...)) }()
+
+	<-laterDone
+	// note: user-2 的消息都处理完了，但 offset 0 还没有完成，不能提交
+	if got := session.Offsets(); len(got) != 0 {
+		t.Errorf("offsets = %v, want none before offset 0 is done", got)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+
+	want := map[string][]int64{"user-1": {0, 2, 4}, "user-2": {1, 3}}
+	if !reflect.DeepEqual(seen, want) {
+		t.Errorf("handled = %v, want %v", seen, want)
+	}
+	offsets := session.Offsets()
+	if len(offsets) == 0 || offsets[len(offsets)-1] != 5 {
+		t.Fatalf("offsets = %v, want the last commit to be 5", offsets)
+	}
+	for i := 1; i < len(offsets); i++ {
+		if offsets[i] <= offsets[i-1] {
+			t.Errorf("offsets = %v, want strictly increasing commits", offsets)
+		}
+	}
+}
+
+func TestOrderedConsumeClaimDrainsOnCancel(t *testing.T) {
+	// note: 消息通道不关闭，只有 session ctx 取消时才停止拉取
+	claim := &fakeClaim{msgs: make(chan *sarama.ConsumerMessage, 3)}
+	for _, msg := range keyedMessages("user-1", "user-1", "user-1") {
+		claim.msgs <- msg
+	}
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var handled []int64
+	h := &orderedConsumerGroupHandler{
+		opts: OrderedConsumeOptions{Workers: 2, QueueSize: 8, DrainTimeout: time.Minute},
+		handler: func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+			if msg.Offset == 0 {
+				close(started)
+				<-release
+			}
+			if ctx.Err() != nil {
+				t.Errorf("handler ctx error = %v, want the worker ctx to outlive the session", ctx.Err())
+			}
+			handled = append(handled, msg.Offset)
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &fakeSession{ctx: ctx}
+	done := make(chan error, 1)
+	go func() { done <- h.ConsumeClaim(session, claim) }()
+
+	<-started
+	for len(claim.msgs) > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	// note: 等待最后一条消息进入 worker 队列
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("ConsumeClaim() returned before the queue was drained")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("ConsumeClaim() error = %v", err)
+	}
+	if want := []int64{0, 1, 2}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled = %v, want %v", handled, want)
+	}
+	if offsets := session.Offsets(); len(offsets) == 0 || offsets[len(offsets)-1] != 3 {
+		t.Errorf("offsets = %v, want queued messages committed after the drain", offsets)
+	}
+}
@@ -0,0 +1,216 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	ErrPartitionConsumerNotInit = errors.New("partition consumer 未初始化")
+)
+
+type (
+	startKind int
+
+	// StartPosition 分区消费的起点，零值表示从最早开始
+	StartPosition struct {
+		kind   startKind
+		offset int64
+		at     time.Time
+	}
+
+	PartitionConsumeOptions struct {
+		Topic     string
+		Partition int32
+		Start     StartPosition
+		// StopOffset 大于 0 时消费到该 offset 为止（不含）
+		StopOffset int64
+		// StopAtEnd 为 true 时消费到开始时分区的末尾为止，否则一直消费直到 ctx 结束
+		StopAtEnd bool
+		// OffsetStore 不为空时优先从检查点恢复，处理成功后保存下一个 offset
+		OffsetStore OffsetStore
+		// CheckpointName 检查点名称，同一个 topic 分区可以被多个工具独立消费
+		CheckpointName string
+		// CheckpointEvery 每处理多少条保存一次检查点，默认每条都保存，退出时总会保存
+		CheckpointEvery int
+	}
+
+	PartitionResult struct {
+		Consumed int64
+		// StartOffset 实际开始消费的 offset
+		StartOffset int64
+		// NextOffset 下一次消费的起点
+		NextOffset int64
+	}
+)
+
+const (
+	startOldest startKind = iota
+	startNewest
+	startOffset
+	startTime
+)
+
+func StartOldest() StartPosition {
+	return StartPosition{kind: startOldest}
+}
+
+func StartNewest() StartPosition {
+	return StartPosition{kind: startNewest}
+}
+
+func StartAtOffset(offset int64) StartPosition {
+	return StartPosition{kind: startOffset, offset: offset}
+}
+
+// StartAtTime 从时间戳不早于 t 的第一条消息开始，没有这样的消息时从末尾开始
+func StartAtTime(t time.Time) StartPosition {
+	return StartPosition{kind: startTime, at: t}
+}
+
+func (p StartPosition) String() string {
+	switch p.kind {
+	case startNewest:
+		return "newest"
+	case startOffset:
+		return fmt.Sprintf("offset(%d)", p.offset)
+	case startTime:
+		return fmt.Sprintf("time(%s)", p.at.Format(time.RFC3339))
+	default:
+		return "oldest"
+	}
+}
+
+// ConsumePartition 按指定的起点消费单个分区，handler 返回错误时停止消费并返回该错误，检查点停在失败的消息上
+func (q *defaultKafkaQueue) ConsumePartition(ctx context.Context, opts PartitionConsumeOptions, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) (*PartitionResult, error) {
+	if q.client == nil || q.consumer == nil {
+		return nil, ErrPartitionConsumerNotInit
+	}
+	ctx, leave, ok := q.enterIntake(ctx)
+	if !ok {
+		return nil, ErrQueueClosed
+	}
+	defer leave()
+	oldest, err := q.client.GetOffset(opts.Topic, opts.Partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	newest, err := q.client.GetOffset(opts.Topic, opts.Partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := q.resolveStart(ctx, opts, oldest, newest)
+	if err != nil {
+		return nil, err
+	}
+	result := &PartitionResult{StartOffset: start, NextOffset: start}
+
+	stop := int64(-1)
+	if opts.StopAtEnd {
+		stop = newest
+	}
+	if opts.StopOffset > 0 && (stop < 0 || opts.StopOffset < stop) {
+		stop = opts.StopOffset
+	}
+	if stop >= 0 && start >= stop {
+		return result, nil
+	}
+
+	pc, err := q.consumer.ConsumePartition(opts.Topic, opts.Partition, start)
+	if err != nil {
+		return result, err
+	}
+	defer pc.Close()
+
+	checkpointEvery := opts.CheckpointEvery
+	if checkpointEvery <= 0 {
+		checkpointEvery = 1
+	}
+	pending := 0
+	checkpoint := func() error {
+		if opts.OffsetStore == nil || pending == 0 {
+			return nil
+		}
+		pending = 0
+		// note: ctx 可能已经结束，检查点使用独立的 ctx 保存
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		return opts.OffsetStore.SaveOffset(saveCtx, opts.CheckpointName, opts.Topic, opts.Partition, result.NextOffset)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return result, errors.Join(ctx.Err(), checkpoint())
+		case consumeErr := <-pc.Errors():
+			if consumeErr != nil {
+				return result, errors.Join(consumeErr, checkpoint())
+			}
+		case msg := <-pc.Messages():
+			if msg == nil {
+				return result, checkpoint()
+			}
+			if err := handler(ctx, msg); err != nil {
+				return result, errors.Join(err, checkpoint())
+			}
+			result.Consumed++
+			result.NextOffset = msg.Offset + 1
+			pending++
+			if pending >= checkpointEvery {
+				if err := checkpoint(); err != nil {
+					return result, err
+				}
+			}
+			// note: 事务 topic 末尾的控制消息不会投递，这种情况下请使用 StopOffset 或 ctx 结束消费
+			if stop >= 0 && result.NextOffset >= stop {
+				return result, checkpoint()
+			}
+		}
+	}
+}
+
+func (q *defaultKafkaQueue) resolveStart(ctx context.Context, opts PartitionConsumeOptions, oldest, newest int64) (int64, error) {
+	if opts.OffsetStore != nil {
+		offset, ok, err := opts.OffsetStore.LoadOffset(ctx, opts.CheckpointName, opts.Topic, opts.Partition)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			if offset < oldest {
+				// note: 检查点对应的消息已经被清理，从现存最早的消息继续
+				return oldest, nil
+			}
+			return offset, nil
+		}
+	}
+
+	switch opts.Start.kind {
+	case startNewest:
+		return newest, nil
+	case startOffset:
+		if opts.Start.offset < oldest || opts.Start.offset > newest {
+			return 0, fmt.Errorf("offset %d 超出分区范围 [%d, %d]: %w", opts.Start.offset, oldest, newest, sarama.ErrOffsetOutOfRange)
+		}
+		return opts.Start.offset, nil
+	case startTime:
+		offset, err := q.client.GetOffset(opts.Topic, opts.Partition, opts.Start.at.UnixMilli())
+		if err != nil {
+			return 0, err
+		}
+		if offset < 0 {
+			return newest, nil
+		}
+		return offset, nil
+	default:
+		return oldest, nil
+	}
+}
+
+func (q *defaultKafkaQueue) GetClient() sarama.Client {
+	return q.client
+}
@@ -0,0 +1,171 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/glebarez/sqlite"
+	"github.com/rootexit/rexLib/rexDao"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeOffsetClient 只实现 GetOffset，offsets 的 key 为 OffsetOldest/OffsetNewest 或者毫秒时间戳，没有的时间戳返回 -1
+type fakeOffsetClient struct {
+	sarama.Client
+	offsets map[int64]int64
+}
+
+func (c *fakeOffsetClient) GetOffset(topic string, partition int32, at int64) (int64, error) {
+	if offset, ok := c.offsets[at]; ok {
+		return offset, nil
+	}
+	return -1, nil
+}
+
+func newTestOffsetClient(at time.Time) *fakeOffsetClient {
+	return &fakeOffsetClient{offsets: map[int64]int64{
+		sarama.OffsetOldest: 10,
+		sarama.OffsetNewest: 20,
+		at.UnixMilli():      17,
+	}}
+}
+
+func newTestGormOffsetStore(t *testing.T) OffsetStore {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open sqlite error = %v", err)
+	}
+	if err := db.AutoMigrate(&KafkaPartitionOffset{}); err != nil {
+		t.Fatalf("AutoMigrate() error = %v", err)
+	}
+	return NewGormOffsetStore(rexDao.NewDao(db), "")
+}
+
+func TestResolveStart(t *testing.T) {
+	at := time.UnixMilli(1700000000000)
+	checkpoint := func(offset int64) OffsetStore {
+		s := NewRedisOffsetStore(rexDao.NewMemoryRedisDao(), "test")
+		_ = s.SaveOffset(context.Background(), "tool", "order", 0, offset)
+		return s
+	}
+	cases := []struct {
+		name    string
+		start   StartPosition
+		store   OffsetStore
+		want    int64
+		wantErr error
+	}{
+		{name: "oldest", start: StartOldest(), want: 10},
+		{name: "zero value", start: StartPosition{}, want: 10},
+		{name: "newest", start: StartNewest(), want: 20},
+		{name: "offset", start: StartAtOffset(15), want: 15},
+		{name: "offset below oldest", start: StartAtOffset(5), wantErr: sarama.ErrOffsetOutOfRange},
+		{name: "offset past newest", start: StartAtOffset(21), wantErr: sarama.ErrOffsetOutOfRange},
+		{name: "time", start: StartAtTime(at), want: 17},
+		{name: "time after the last message", start: StartAtTime(at.Add(time.Hour)), want: 20},
+		{name: "checkpoint wins", start: StartNewest(), store: checkpoint(12), want: 12},
+		{name: "checkpoint cleaned up", start: StartNewest(), store: checkpoint(3), want: 10},
+		{name: "no checkpoint", start: StartAtOffset(15), store: NewRedisOffsetStore(rexDao.NewMemoryRedisDao(), "test"), want: 15},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			q := &defaultKafkaQueue{client: newTestOffsetClient(at)}
+			got, err := q.resolveStart(context.Background(), PartitionConsumeOptions{
+				Topic: "order", Start: c.start, OffsetStore: c.store, CheckpointName: "tool",
+			}, 10, 20)
+			if c.wantErr != nil {
+				if !errors.Is(err, c.wantErr) {
+					t.Errorf("resolveStart() error = %v, want %v", err, c.wantErr)
+				}
+				return
+			}
+			if err != nil || got != c.want {
+				t.Errorf("resolveStart() = %d, %v, want %d", got, err, c.want)
+			}
+		})
+	}
+}
+
+func TestConsumePartitionStop(t *testing.T) {
+	cases := []struct {
+		name         string
+		start        StartPosition
+		stopOffset   int64
+		stopAtEnd    bool
+		wantConsumed int64
+		wantNext     int64
+	}{
+		{name: "stop at end", start: StartOldest(), stopAtEnd: true, wantConsumed: 10, wantNext: 20},
+		{name: "stop offset", start: StartOldest(), stopOffset: 13, wantConsumed: 3, wantNext: 13},
+		{name: "stop offset past end", start: StartAtOffset(15), stopOffset: 25, stopAtEnd: true, wantConsumed: 5, wantNext: 20},
+		{name: "stop offset before end", start: StartAtOffset(15), stopOffset: 18, stopAtEnd: true, wantConsumed: 3, wantNext: 18},
+		{name: "nothing to consume", start: StartNewest(), stopAtEnd: true, wantConsumed: 0, wantNext: 20},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client := newTestOffsetClient(time.Now())
+			consumer := mocks.NewConsumer(t, nil)
+			q := &defaultKafkaQueue{client: client, consumer: consumer, life: newLifecycleState()}
+			opts := PartitionConsumeOptions{
+				Topic: "order", Start: c.start, StopOffset: c.stopOffset, StopAtEnd: c.stopAtEnd,
+				OffsetStore: NewRedisOffsetStore(rexDao.NewMemoryRedisDao(), "test"), CheckpointName: "tool", CheckpointEvery: 4,
+			}
+			if start, _ := q.resolveStart(context.Background(), opts, 10, 20); start < 20 {
+				pc := consumer.ExpectConsumePartition("order", 0, start)
+				for offset := start; offset < 20; offset++ {
+					pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte("m")})
+				}
+			}
+
+			result, err := q.ConsumePartition(context.Background(), opts, func(ctx context.Context, msg *sarama.ConsumerMessage) error {
+				return nil
+			})
+			if err != nil || result.Consumed != c.wantConsumed || result.NextOffset != c.wantNext {
+				t.Fatalf("ConsumePartition() = %+v, %v, want consumed %d next %d", result, err, c.wantConsumed, c.wantNext)
+			}
+			// note: 退出时总会保存检查点，CheckpointEvery 没有整除也不会丢失进度
+			offset, ok, err := opts.OffsetStore.LoadOffset(context.Background(), "tool", "order", 0)
+			if c.wantConsumed > 0 && (err != nil || !ok || offset != c.wantNext) {
+				t.Errorf("LoadOffset() = %d, %v, %v, want %d", offset, ok, err, c.wantNext)
+			}
+		})
+	}
+}
+
+func TestOffsetStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) OffsetStore{
+		"redis": func(t *testing.T) OffsetStore { return NewRedisOffsetStore(rexDao.NewMemoryRedisDao(), "test") },
+		"gorm":  newTestGormOffsetStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+			if _, ok, err := s.LoadOffset(ctx, "tool", "order", 0); ok || err != nil {
+				t.Fatalf("LoadOffset() before save = %v, %v, want not found", ok, err)
+			}
+			for _, offset := range []int64{5, 42} {
+				if err := s.SaveOffset(ctx, "tool", "order", 0, offset); err != nil {
+					t.Fatalf("SaveOffset(%d) error = %v", offset, err)
+				}
+			}
+			_ = s.SaveOffset(ctx, "other", "order", 0, 7)
+			_ = s.SaveOffset(ctx, "tool", "order", 1, 9)
+			if offset, ok, err := s.LoadOffset(ctx, "tool", "order", 0); !ok || err != nil || offset != 42 {
+				t.Errorf("LoadOffset() = %d, %v, %v, want 42", offset, ok, err)
+			}
+			if offset, _, _ := s.LoadOffset(ctx, "other", "order", 0); offset != 7 {
+				t.Errorf("LoadOffset(other) = %d, want checkpoints isolated by name", offset)
+			}
+			if offset, _, _ := s.LoadOffset(ctx, "tool", "order", 1); offset != 9 {
+				t.Errorf("LoadOffset(partition 1) = %d, want checkpoints isolated by partition", offset)
+			}
+		})
+	}
+}
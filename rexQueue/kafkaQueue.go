@@ -2,9 +2,12 @@ package rexQueue
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/IBM/sarama"
 	"github.com/zeromicro/go-zero/core/logc"
+	"sync"
+	"time"
 )
 
 type (
@@ -14,10 +17,21 @@ type (
 		GetSyncProducer() sarama.SyncProducer
 		GetConsumer() sarama.Consumer
 		GetConsumerGroup() sarama.ConsumerGroup
+		GetClient() sarama.Client
+		// Close 等同于 Shutdown，最多等待 DefaultShutdownTimeout
 		Close() error
+		Shutdown(ctx context.Context) error
+		Health(ctx context.Context) *KafkaHealth
 		CatchAsyncErr(asyncProducerErrFunc func(err error))
 		Consume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, consumerGroupHandler sarama.ConsumerGroupHandler)
-		EasyConsume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, readMsgFunc func(msg *sarama.ConsumerMessage))
+		EasyConsume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, readMsgFunc func(msg *sarama.ConsumerMessage) error)
+		RetryConsume(ctx context.Context, topics []string, policy *RetryPolicy, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error)
+		OrderedConsume(ctx context.Context, topics []string, opts OrderedConsumeOptions, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error)
+		BatchConsume(ctx context.Context, topics []string, opts BatchConsumeOptions, handler func(ctx context.Context, msgs []*sarama.ConsumerMessage) error)
+		ReplayDlq(ctx context.Context, dlqTopic string, opts ReplayOptions) (*ReplayResult, error)
+		ConsumePartition(ctx context.Context, opts PartitionConsumeOptions, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) (*PartitionResult, error)
+		Transaction(fn func(tx KafkaTx) error) error
+		TransactionCtx(ctx context.Context, fn func(tx KafkaTx) error) error
 		SyncSendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
 		SyncSendMessageCtx(ctx context.Context, msg *sarama.ProducerMessage) (partition int32, offset int64, err error)
 		EasySyncSendMessage(topic, key, value string) (partition int32, offset int64, err error)
@@ -36,14 +50,21 @@ type (
 		asyncProducerErrFunc func(err error)
 		consumer             sarama.Consumer
 		consumerGroup        sarama.ConsumerGroup
+		client               sarama.Client
+		txLock               sync.Mutex
+		life                 *lifecycleState
 	}
 )
 
 func NewKafkaQueue(conf *KafkaConfig) (KafkaQueue, error) {
+	if err := conf.Build(); err != nil {
+		return nil, fmt.Errorf("kafka 配置错误: %w", err)
+	}
 	q := defaultKafkaQueue{
 		consumerMode: conf.ConsumerMode,
 		producerMode: conf.ProducerMode,
 		conf:         conf,
+		life:         newLifecycleState(),
 	}
 
 	// 初始化 consumer
@@ -79,6 +100,19 @@ func NewKafkaQueue(conf *KafkaConfig) (KafkaQueue, error) {
 			return nil, fmt.Errorf("consumer 初始化失败: %w", err)
 		}
 		q.consumer = c
+	case ModePartitionConsumer:
+		// note: 分区消费需要 client 查询 offset，consumer 复用同一个 client
+		client, err := sarama.NewClient(conf.Brokers, conf.Config)
+		if err != nil {
+			return nil, fmt.Errorf("client 初始化失败: %w", err)
+		}
+		c, err := sarama.NewConsumerFromClient(client)
+		if err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("consumer 初始化失败: %w", err)
+		}
+		q.client = client
+		q.consumer = c
 	case ModeProducerOnly:
 		// do nothing
 	default:
@@ -88,7 +122,7 @@ func NewKafkaQueue(conf *KafkaConfig) (KafkaQueue, error) {
 }
 
 func (q *defaultKafkaQueue) WithAsyncProducerErrFunc(asyncProducerErrFunc func(err error)) *defaultKafkaQueue {
-	q.asyncProducerErrFunc = asyncProducerErrFunc
+	q.setAsyncProducerErrFunc(asyncProducerErrFunc)
 	return q
 }
 
@@ -109,72 +143,95 @@ func (q *defaultKafkaQueue) GetConsumerGroup() sarama.ConsumerGroup {
 }
 
 func (q *defaultKafkaQueue) Close() error {
-	if q.consumer != nil {
-		if err := q.consumer.Close(); err != nil {
-			return fmt.Errorf("关闭 consumer 失败: %w", err)
-		}
-	}
-	if q.consumerGroup != nil {
-		if err := q.consumerGroup.Close(); err != nil {
-			return fmt.Errorf("关闭 consumer group 失败: %w", err)
-		}
-	}
-	if q.syncProducer != nil {
-		if err := q.syncProducer.Close(); err != nil {
-			return err
-		}
-	}
-	if q.asyncProducer != nil {
-		if err := q.asyncProducer.Close(); err != nil {
-			return err
-		}
-	}
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
+	defer cancel()
+	return q.Shutdown(ctx)
 }
 
+// CatchAsyncErr 启动一个协程读取 async producer 的发送结果，协程在 Shutdown 时随 producer 关闭退出，重复调用只替换回调
 func (q *defaultKafkaQueue) CatchAsyncErr(asyncProducerErrFunc func(err error)) {
-	q.asyncProducerErrFunc = asyncProducerErrFunc
-	go func() {
-		for err := range q.asyncProducer.Errors() {
-			if q.asyncProducerErrFunc != nil {
-				q.asyncProducerErrFunc(err)
-			}
-		}
-	}()
+	q.setAsyncProducerErrFunc(asyncProducerErrFunc)
+	if q.asyncProducer == nil {
+		return
+	}
+	q.startDrainAsync()
 }
 
+// Consume 阻塞消费直到 ctx 结束或 Shutdown，出错时记录错误并重新加入消费者组；
+// 配置错误和授权错误不再重试，记录错误后返回，Health 中的消费者组状态为 GroupStateFailed
 func (q *defaultKafkaQueue) Consume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, consumerGroupHandler sarama.ConsumerGroupHandler) {
-	for {
-		if err := consumerGroup.Consume(ctx, topics, consumerGroupHandler); err != nil {
-			logc.Errorf(ctx, "Error consuming: %v", err)
-			break
-		}
-		if ctx.Err() != nil {
-			logc.Errorf(ctx, "ctx exit: %v", ctx.Err())
-			break
-		}
+	if err := q.consume(ctx, consumerGroup, topics, consumerGroupHandler); err != nil {
+		logc.Errorf(ctx, "Consume err: %v", err)
 	}
 }
 
-func (q *defaultKafkaQueue) EasyConsume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, readMsgFunc func(msg *sarama.ConsumerMessage)) {
+// consume 同 Consume，ctx 结束或 Shutdown 时返回 nil，已经关闭、消费者组在 Shutdown 之外被关闭或者遇到不可重试的错误时返回错误
+func (q *defaultKafkaQueue) consume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, consumerGroupHandler sarama.ConsumerGroupHandler) (err error) {
+	ctx, leave, ok := q.enterIntake(ctx)
+	if !ok {
+		return ErrQueueClosed
+	}
+	defer leave()
+	defer func() {
+		if err != nil && isFatalConsumeErr(err) {
+			q.setGroupState(GroupStateFailed, nil)
+			return
+		}
+		q.setGroupState(GroupStateStopped, nil)
+	}()
+
+	handler := &lifecycleHandler{q: q, ConsumerGroupHandler: consumerGroupHandler}
 	for {
-		if err := consumerGroup.Consume(ctx, topics, &EasyConsumerGroupHandler{
-			readMsgFunc: readMsgFunc,
-		}); err != nil {
+		q.setGroupState(GroupStateJoining, nil)
+		if err := consumerGroup.Consume(ctx, topics, handler); err != nil {
+			if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				if q.life.closing.Load() {
+					return nil
+				}
+				return err
+			}
 			logc.Errorf(ctx, "Error consuming: %v", err)
-			break
+			q.recordConsumeErr(err)
+			if isFatalConsumeErr(err) {
+				return err
+			}
+			timer := time.NewTimer(consumeRetryBackoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
 		}
 		if ctx.Err() != nil {
-			logc.Errorf(ctx, "ctx exit: %v", ctx.Err())
-			break
+			logc.Infof(ctx, "ctx exit: %v", ctx.Err())
+			return nil
 		}
 	}
 }
 
+func (q *defaultKafkaQueue) EasyConsume(ctx context.Context, consumerGroup sarama.ConsumerGroup, topics []string, readMsgFunc func(msg *sarama.ConsumerMessage) error) {
+	q.Consume(ctx, consumerGroup, topics, &EasyConsumerGroupHandler{
+		readMsgFunc: readMsgFunc,
+	})
+}
+
+// SyncSendMessage 事务 producer 返回 ErrSendOutsideTransaction，请在 TransactionCtx 中使用 KafkaTx.Send
 func (q *defaultKafkaQueue) SyncSendMessage(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
+	if q.transactional() {
+		return 0, 0, ErrSendOutsideTransaction
+	}
+	return q.syncSend(msg)
+}
+
+func (q *defaultKafkaQueue) syncSend(msg *sarama.ProducerMessage) (partition int32, offset int64, err error) {
 	if q.syncProducer == nil {
 		return 0, 0, fmt.Errorf("sync producer 未初始化")
 	}
+	q.life.producerLock.RLock()
+	defer q.life.producerLock.RUnlock()
+	if q.life.producerClosed {
+		return 0, 0, ErrQueueClosed
+	}
 	partition, offset, err = q.syncProducer.SendMessage(msg)
 	if err != nil {
 		return 0, 0, fmt.Errorf("send msg failed: %w", err)
@@ -228,49 +285,45 @@ func (q *defaultKafkaQueue) EasySyncSendMessageCtx(ctx context.Context, topic, k
 }
 
 func (q *defaultKafkaQueue) AsyncSendMessage(msg *sarama.ProducerMessage) (err error) {
-	if q.asyncProducer == nil {
-		return fmt.Errorf("async producer 未初始化")
-	}
-	q.asyncProducer.Input() <- msg
-	return nil
+	return q.AsyncSendMessageCtx(context.Background(), msg)
 }
 
+// AsyncSendMessageCtx 事务 producer 返回 ErrSendOutsideTransaction，请在 TransactionCtx 中使用 KafkaTx.Send
 func (q *defaultKafkaQueue) AsyncSendMessageCtx(ctx context.Context, msg *sarama.ProducerMessage) (err error) {
+	if q.transactional() {
+		return ErrSendOutsideTransaction
+	}
+	return q.asyncSend(ctx, msg)
+}
+
+func (q *defaultKafkaQueue) asyncSend(ctx context.Context, msg *sarama.ProducerMessage) (err error) {
 	if q.asyncProducer == nil {
 		return fmt.Errorf("async producer 未初始化")
 	}
+	q.life.producerLock.RLock()
+	defer q.life.producerLock.RUnlock()
+	if q.life.producerClosed {
+		return ErrQueueClosed
+	}
+	// note: Input 阻塞时持有读锁等待，flush 开始后立即返回，避免 Shutdown 拿不到写锁
 	select {
 	case q.asyncProducer.Input() <- msg:
 		return nil
 	case <-ctx.Done():
 		return ctx.Err()
+	case <-q.life.producerStop:
+		return ErrQueueClosed
 	}
 }
 
 func (q *defaultKafkaQueue) EasyAsyncSendMessage(topic, key, value string) (err error) {
-	if q.asyncProducer == nil {
-		return fmt.Errorf("async producer 未初始化")
-	}
-	q.asyncProducer.Input() <- &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.StringEncoder(value),
-	}
-	return nil
+	return q.EasyAsyncSendMessageCtx(context.Background(), topic, key, value)
 }
 
 func (q *defaultKafkaQueue) EasyAsyncSendMessageCtx(ctx context.Context, topic, key, value string) (err error) {
-	if q.asyncProducer == nil {
-		return fmt.Errorf("async producer 未初始化")
-	}
-	select {
-	case q.asyncProducer.Input() <- &sarama.ProducerMessage{
+	return q.AsyncSendMessageCtx(ctx, &sarama.ProducerMessage{
 		Topic: topic,
 		Key:   sarama.StringEncoder(key),
 		Value: sarama.StringEncoder(value),
-	}:
-		return nil
-	case <-ctx.Done():
-		return ctx.Err()
-	}
+	})
 }
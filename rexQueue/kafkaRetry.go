@@ -0,0 +1,430 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
+)
+
+const (
+	HeaderRetryCount        = "x-rex-retry-count"
+	HeaderRetryNotBefore    = "x-rex-retry-not-before"
+	HeaderOriginalTopic     = "x-rex-original-topic"
+	HeaderOriginalPartition = "x-rex-original-partition"
+	HeaderOriginalOffset    = "x-rex-original-offset"
+	HeaderFailureReason     = "x-rex-failure-reason"
+	HeaderFailedAt          = "x-rex-failed-at"
+	HeaderReplayedFrom      = "x-rex-replayed-from"
+
+	// note: 失败原因写入消息头时的最大长度，防止错误信息过大
+	maxFailureReasonLen = 1024
+)
+
+var (
+	ErrConsumerGroupNotInit = errors.New("consumer group 未初始化")
+)
+
+type (
+	// asyncAcker 能够等待 async producer 发送结果的队列实现这个接口，sendMessage 通过它等待 broker 确认
+	asyncAcker interface {
+		asyncSendAcked(ctx context.Context, msg *sarama.ProducerMessage) error
+	}
+
+	RetryTier struct {
+		Delay time.Duration
+		// Topic 为空时使用 {topic}.retry.{delay}，例如 order.retry.30s
+		Topic string
+	}
+	RetryPolicy struct {
+		Tiers []RetryTier
+		// DlqTopic 为空时使用 {topic}.dlq
+		DlqTopic string
+	}
+	ReplayOptions struct {
+		// StartOffsets 每个分区开始回放的位置，没有设置的分区从最早开始
+		StartOffsets map[int32]int64
+		// Limit 最多回放多少条，0 表示不限制
+		Limit int
+		// Filter 返回 false 的消息跳过
+		Filter func(msg *sarama.ConsumerMessage) bool
+		// TargetTopic 为空时发送回消息头中记录的原始 topic
+		TargetTopic string
+	}
+	ReplayResult struct {
+		Replayed int
+		Skipped  int
+		// NextOffsets 下一次回放的起点，可以直接作为下一次的 StartOffsets
+		NextOffsets map[int32]int64
+	}
+	retryConsumerGroupHandler struct {
+		q       *defaultKafkaQueue
+		policy  *RetryPolicy
+		handler func(ctx context.Context, msg *sarama.ConsumerMessage) error
+	}
+)
+
+// NewRetryPolicy 按延迟创建重试梯度，例如 NewRetryPolicy(30*time.Second, 5*time.Minute)
+func NewRetryPolicy(delays ...time.Duration) *RetryPolicy {
+	tiers := make([]RetryTier, 0, len(delays))
+	for _, d := range delays {
+		tiers = append(tiers, RetryTier{Delay: d})
+	}
+	return &RetryPolicy{Tiers: tiers}
+}
+
+func (p *RetryPolicy) RetryTopic(baseTopic string, tier int) string {
+	t := p.Tiers[tier]
+	if t.Topic != "" {
+		return t.Topic
+	}
+	return fmt.Sprintf("%s.retry.%s", baseTopic, formatDelay(t.Delay))
+}
+
+func (p *RetryPolicy) Dlq(baseTopic string) string {
+	if p.DlqTopic != "" {
+		return p.DlqTopic
+	}
+	return baseTopic + ".dlq"
+}
+
+// Topics 返回需要订阅的所有 topic，包括原始 topic 和每一级重试 topic
+func (p *RetryPolicy) Topics(baseTopics ...string) []string {
+	result := make([]string, 0, len(baseTopics)*(len(p.Tiers)+1))
+	seen := make(map[string]struct{})
+	add := func(topic string) {
+		if _, ok := seen[topic]; ok {
+			return
+		}
+		seen[topic] = struct{}{}
+		result = append(result, topic)
+	}
+	for _, base := range baseTopics {
+		add(base)
+		for i := range p.Tiers {
+			add(p.RetryTopic(base, i))
+		}
+	}
+	return result
+}
+
+// RetryConsume 以消费者组消费 topics 及其重试 topic，handler 返回错误时投递到下一级重试 topic，
+// 重试耗尽后投递到死信 topic
+func (q *defaultKafkaQueue) RetryConsume(ctx context.Context, topics []string, policy *RetryPolicy, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) {
+	if err := q.retryConsume(ctx, topics, policy, handler); err != nil {
+		logc.Errorf(ctx, "RetryConsume err: %v", err)
+	}
+}
+
+func (q *defaultKafkaQueue) retryConsume(ctx context.Context, topics []string, policy *RetryPolicy, handler func(ctx context.Context, msg *sarama.ConsumerMessage) error) error {
+	if q.consumerGroup == nil {
+		return ErrConsumerGroupNotInit
+	}
+	if policy == nil {
+		policy = &RetryPolicy{}
+	}
+	return q.consume(ctx, q.consumerGroup, policy.Topics(topics...), &retryConsumerGroupHandler{
+		q:       q,
+		policy:  policy,
+		handler: handler,
+	})
+}
+
+func (h *retryConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
+func (h *retryConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (h *retryConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	for {
+		select {
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := h.process(ctx, msg); err != nil {
+				// note: 转发失败时不提交 offset，退出本次会话，等待重新投递
+				logc.Errorf(ctx, "retry consume msg failed, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+				return err
+			}
+			session.MarkMessage(msg, "")
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (h *retryConsumerGroupHandler) process(ctx context.Context, msg *sarama.ConsumerMessage) error {
+	// note: 重试 topic 中的消息需要等到指定时间之后再处理，同一级的消息延迟相同，所以是有序的
+	if err := waitNotBefore(ctx, headerInt64(msg.Headers, HeaderRetryNotBefore)); err != nil {
+		return err
+	}
+
+	handleErr := h.handler(ctx, msg)
+	if handleErr == nil {
+		return nil
+	}
+	return forwardFailed(ctx, h.q, h.policy, msg, handleErr)
+}
+
+// forwardFailed 把处理失败的消息投递到下一级重试 topic，重试耗尽或者无法解码时直接投递到死信 topic
+func forwardFailed(ctx context.Context, q KafkaQueue, policy *RetryPolicy, msg *sarama.ConsumerMessage, handleErr error) error {
+	retryCount := int(headerInt64(msg.Headers, HeaderRetryCount))
+	baseTopic := headerString(msg.Headers, HeaderOriginalTopic)
+	if baseTopic == "" {
+		baseTopic = msg.Topic
+	}
+
+	var target string
+	var notBefore time.Time
+	// note: 解码失败重试也不会成功，不进入重试 topic
+	if retryCount < len(policy.Tiers) && !errors.Is(handleErr, ErrEnvelopeDecode) {
+		target = policy.RetryTopic(baseTopic, retryCount)
+		notBefore = time.Now().Add(policy.Tiers[retryCount].Delay)
+		retryCount++
+	} else {
+		target = policy.Dlq(baseTopic)
+	}
+
+	headers := failureHeaders(msg, baseTopic, retryCount, notBefore, handleErr)
+	logc.Infof(ctx, "forward failed msg to %s, topic: %s, partition: %d, offset: %d, retry: %d, err: %v", target, msg.Topic, msg.Partition, msg.Offset, retryCount, handleErr)
+	return sendMessage(ctx, q, &sarama.ProducerMessage{
+		Topic:   target,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	})
+}
+
+// forwardFailed 批量消费使用，见 forwardFailed 函数
+func (q *defaultKafkaQueue) forwardFailed(ctx context.Context, policy *RetryPolicy, msg *sarama.ConsumerMessage, handleErr error) error {
+	return forwardFailed(ctx, q, policy, msg, handleErr)
+}
+
+// ReplayDlq 把死信 topic 中的消息重新投递回原始 topic，只回放调用时已经存在的消息
+func (q *defaultKafkaQueue) ReplayDlq(ctx context.Context, dlqTopic string, opts ReplayOptions) (*ReplayResult, error) {
+	client, err := sarama.NewClient(q.conf.Brokers, q.conf.Config)
+	if err != nil {
+		return nil, fmt.Errorf("client 初始化失败: %w", err)
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, fmt.Errorf("consumer 初始化失败: %w", err)
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(dlqTopic)
+	if err != nil {
+		return nil, err
+	}
+	result := &ReplayResult{NextOffsets: make(map[int32]int64, len(partitions))}
+	for _, partition := range partitions {
+		start, ok := opts.StartOffsets[partition]
+		if !ok {
+			start = sarama.OffsetOldest
+		}
+		end, err := client.GetOffset(dlqTopic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return result, err
+		}
+		result.NextOffsets[partition] = end
+		// note: OffsetOldest/OffsetNewest 需要换算成实际的 offset，否则空分区会一直等待新消息
+		switch start {
+		case sarama.OffsetNewest:
+			start = end
+		case sarama.OffsetOldest:
+			if start, err = client.GetOffset(dlqTopic, partition, sarama.OffsetOldest); err != nil {
+				return result, err
+			}
+		}
+		if start >= end {
+			continue
+		}
+		if err := q.replayPartition(ctx, consumer, dlqTopic, partition, start, end, opts, result); err != nil {
+			return result, err
+		}
+		if opts.Limit > 0 && result.Replayed >= opts.Limit {
+			break
+		}
+	}
+	return result, nil
+}
+
+func (q *defaultKafkaQueue) replayPartition(ctx context.Context, consumer sarama.Consumer, dlqTopic string, partition int32, start, end int64, opts ReplayOptions, result *ReplayResult) error {
+	pc, err := consumer.ConsumePartition(dlqTopic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case consumeErr := <-pc.Errors():
+			if consumeErr != nil {
+				return consumeErr
+			}
+		case msg := <-pc.Messages():
+			if msg == nil {
+				return nil
+			}
+			result.NextOffsets[partition] = msg.Offset + 1
+			if opts.Filter != nil && !opts.Filter(msg) {
+				result.Skipped++
+			} else {
+				target := opts.TargetTopic
+				if target == "" {
+					target = headerString(msg.Headers, HeaderOriginalTopic)
+				}
+				if target == "" {
+					result.Skipped++
+				} else {
+					if err := q.send(ctx, &sarama.ProducerMessage{
+						Topic:   target,
+						Key:     sarama.ByteEncoder(msg.Key),
+						Value:   sarama.ByteEncoder(msg.Value),
+						Headers: replayHeaders(msg),
+					}); err != nil {
+						result.NextOffsets[partition] = msg.Offset
+						return err
+					}
+					result.Replayed++
+				}
+			}
+			if msg.Offset+1 >= end || (opts.Limit > 0 && result.Replayed >= opts.Limit) {
+				return nil
+			}
+		}
+	}
+}
+
+func (q *defaultKafkaQueue) send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	return sendMessage(ctx, q, msg)
+}
+
+// sendMessage 发送并等待 broker 确认，调用方在返回成功后才可以提交 offset 或确认来源消息；
+// 有同步 producer 时同步发送，否则通过 async producer 发送，队列实现了 asyncAcker 时等待发送结果
+func sendMessage(ctx context.Context, q KafkaQueue, msg *sarama.ProducerMessage) error {
+	if q.GetSyncProducer() != nil {
+		_, _, err := q.SyncSendMessageCtx(ctx, msg)
+		return err
+	}
+	if acker, ok := q.(asyncAcker); ok {
+		return acker.asyncSendAcked(ctx, msg)
+	}
+	return q.AsyncSendMessageCtx(ctx, msg)
+}
+
+func failureHeaders(msg *sarama.ConsumerMessage, baseTopic string, retryCount int, notBefore time.Time, cause error) []sarama.RecordHeader {
+	originalPartition := strconv.FormatInt(int64(msg.Partition), 10)
+	originalOffset := strconv.FormatInt(msg.Offset, 10)
+	if headerString(msg.Headers, HeaderOriginalTopic) != "" {
+		originalPartition = headerString(msg.Headers, HeaderOriginalPartition)
+		originalOffset = headerString(msg.Headers, HeaderOriginalOffset)
+	}
+	reason := cause.Error()
+	if len(reason) > maxFailureReasonLen {
+		reason = reason[:maxFailureReasonLen]
+	}
+
+	headers := copyHeaders(msg.Headers, retryHeaderKeys)
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(HeaderOriginalTopic), Value: []byte(baseTopic)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalPartition), Value: []byte(originalPartition)},
+		sarama.RecordHeader{Key: []byte(HeaderOriginalOffset), Value: []byte(originalOffset)},
+		sarama.RecordHeader{Key: []byte(HeaderRetryCount), Value: []byte(strconv.Itoa(retryCount))},
+		sarama.RecordHeader{Key: []byte(HeaderFailureReason), Value: []byte(reason)},
+		sarama.RecordHeader{Key: []byte(HeaderFailedAt), Value: []byte(strconv.FormatInt(time.Now().UnixMilli(), 10))},
+	)
+	if !notBefore.IsZero() {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(HeaderRetryNotBefore), Value: []byte(strconv.FormatInt(notBefore.UnixMilli(), 10))})
+	}
+	return headers
+}
+
+func replayHeaders(msg *sarama.ConsumerMessage) []sarama.RecordHeader {
+	headers := copyHeaders(msg.Headers, retryHeaderKeys)
+	return append(headers, sarama.RecordHeader{
+		Key:   []byte(HeaderReplayedFrom),
+		Value: []byte(fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)),
+	})
+}
+
+var retryHeaderKeys = map[string]struct{}{
+	HeaderRetryCount:        {},
+	HeaderRetryNotBefore:    {},
+	HeaderOriginalTopic:     {},
+	HeaderOriginalPartition: {},
+	HeaderOriginalOffset:    {},
+	HeaderFailureReason:     {},
+	HeaderFailedAt:          {},
+	HeaderReplayedFrom:      {},
+}
+
+func copyHeaders(headers []*sarama.RecordHeader, skip map[string]struct{}) []sarama.RecordHeader {
+	result := make([]sarama.RecordHeader, 0, len(headers)+8)
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		if _, ok := skip[string(h.Key)]; ok {
+			continue
+		}
+		result = append(result, sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+	return result
+}
+
+func headerString(headers []*sarama.RecordHeader, key string) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func headerInt64(headers []*sarama.RecordHeader, key string) int64 {
+	v, err := strconv.ParseInt(headerString(headers, key), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// waitNotBefore 等待到毫秒时间戳 notBefore，notBefore 不大于 0 时直接返回
+func waitNotBefore(ctx context.Context, notBefore int64) error {
+	if notBefore <= 0 {
+		return nil
+	}
+	wait := time.Until(time.UnixMilli(notBefore))
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func formatDelay(d time.Duration) string {
+	switch {
+	case d > 0 && d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d > 0 && d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	case d > 0 && d%time.Second == 0:
+		return fmt.Sprintf("%ds", d/time.Second)
+	default:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	}
+}
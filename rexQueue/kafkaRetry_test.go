@@ -0,0 +1,98 @@
+package rexQueue
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+func TestFormatDelay(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		want  string
+	}{
+		{delay: 30 * time.Second, want: "30s"},
+		{delay: 5 * time.Minute, want: "5m"},
+		{delay: 2 * time.Hour, want: "2h"},
+		{delay: 90 * time.Second, want: "90s"},
+		{delay: 1500 * time.Millisecond, want: "1500ms"},
+	}
+	for _, tt := range tests {
+		if got := formatDelay(tt.delay); got != tt.want {
+			t.Errorf("formatDelay(%v) = %v, want %v", tt.delay, got, tt.want)
+		}
+	}
+}
+
+func TestRetryPolicyTopics(t *testing.T) {
+	p := NewRetryPolicy(30*time.Second, 5*time.Minute)
+	p.Tiers[1].Topic = "order.slow"
+	if got := p.RetryTopic("order", 0); got != "order.retry.30s" {
+		t.Errorf("RetryTopic(0) = %v", got)
+	}
+	if got := p.RetryTopic("order", 1); got != "order.slow" {
+		t.Errorf("RetryTopic(1) = %v", got)
+	}
+	if got := p.Dlq("order"); got != "order.dlq" {
+		t.Errorf("Dlq() = %v", got)
+	}
+	if got := p.Topics("order", "order"); len(got) != 3 {
+		t.Errorf("Topics() = %v, want 3 unique topics", got)
+	}
+}
+
+func TestFailureHeaders(t *testing.T) {
+	msg := &sarama.ConsumerMessage{
+		Topic:     "order",
+		Partition: 2,
+		Offset:    42,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte("trace"), Value: []byte("t1")},
+			{Key: []byte(HeaderFailureReason), Value: []byte("old")},
+		},
+	}
+	notBefore := time.UnixMilli(1700000000000)
+	headers := failureHeaders(msg, "order", 1, notBefore, errors.New("boom"))
+	got := make(map[string]string, len(headers))
+	for _, h := range headers {
+		if _, ok := got[string(h.Key)]; ok {
+			t.Errorf("duplicate header %s", h.Key)
+		}
+		got[string(h.Key)] = string(h.Value)
+	}
+	want := map[string]string{
+		"trace":                 "t1",
+		HeaderOriginalTopic:     "order",
+		HeaderOriginalPartition: "2",
+		HeaderOriginalOffset:    "42",
+		HeaderRetryCount:        "1",
+		HeaderFailureReason:     "boom",
+		HeaderRetryNotBefore:    strconv.FormatInt(notBefore.UnixMilli(), 10),
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("header %s = %q, want %q", k, got[k], v)
+		}
+	}
+
+	// note: 从重试 topic 再次失败时保留第一次失败时的原始位置
+	retried := &sarama.ConsumerMessage{Topic: "order.retry.30s", Partition: 0, Offset: 7, Headers: toHeaderPtrs(headers)}
+	again := failureHeaders(retried, "order", 2, time.Time{}, errors.New("boom"))
+	if v := headerString(toHeaderPtrs(again), HeaderOriginalOffset); v != "42" {
+		t.Errorf("original offset after retry = %v, want 42", v)
+	}
+	if v := headerString(toHeaderPtrs(again), HeaderRetryNotBefore); v != "" {
+		t.Errorf("not before for dlq = %v, want empty", v)
+	}
+}
+
+func toHeaderPtrs(headers []sarama.RecordHeader) []*sarama.RecordHeader {
+	result := make([]*sarama.RecordHeader, 0, len(headers))
+	for i := range headers {
+		result = append(result, &headers[i])
+	}
+	return result
+}
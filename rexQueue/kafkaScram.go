@@ -0,0 +1,51 @@
+package rexQueue
+
+import (
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+const (
+	SaslMechanismPlain       = "PLAIN"
+	SaslMechanismScramSha256 = "SCRAM-SHA-256"
+	SaslMechanismScramSha512 = "SCRAM-SHA-512"
+)
+
+// scramClient 基于 xdg-go/scram 实现 sarama.SCRAMClient，用户名和密码会做 SASLprep，
+// 服务端给出的迭代次数低于 4096 时拒绝认证
+type scramClient struct {
+	hashFn scram.HashGeneratorFcn
+	// nonce 为空时使用库默认的随机 nonce，测试时替换
+	nonce scram.NonceGeneratorFcn
+	conv  *scram.ClientConversation
+}
+
+func newScramClient(mechanism string) func() sarama.SCRAMClient {
+	hashFn := scram.SHA256
+	if mechanism == SaslMechanismScramSha512 {
+		hashFn = scram.SHA512
+	}
+	return func() sarama.SCRAMClient {
+		return &scramClient{hashFn: hashFn}
+	}
+}
+
+func (c *scramClient) Begin(userName, password, authzId string) error {
+	client, err := c.hashFn.NewClient(userName, password, authzId)
+	if err != nil {
+		return err
+	}
+	if c.nonce != nil {
+		client = client.WithNonceGenerator(c.nonce)
+	}
+	c.conv = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conv.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conv.Done()
+}
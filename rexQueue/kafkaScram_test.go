@@ -0,0 +1,67 @@
+package rexQueue
+
+import (
+	"testing"
+
+	"github.com/xdg-go/scram"
+)
+
+// RFC 7677 第 3 节的 SCRAM-SHA-256 示例
+func TestScramClientSha256(t *testing.T) {
+	c := &scramClient{hashFn: scram.SHA256, nonce: func() string {
+		return "rOprNGfwEbeRWgbNEkqO"
+	}}
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatalf("Begin() error = %v", err)
+	}
+
+	steps := []struct {
+		challenge string
+		want      string
+	}{
+		{"", "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"},
+		{
+			"r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			"c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+		},
+		{"v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=", ""},
+	}
+	for i, step := range steps {
+		got, err := c.Step(step.challenge)
+		if err != nil {
+			t.Fatalf("Step(%d) error = %v", i+1, err)
+		}
+		if got != step.want {
+			t.Errorf("Step(%d) = %q, want %q", i+1, got, step.want)
+		}
+	}
+	if !c.Done() {
+		t.Error("Done() = false after server final")
+	}
+}
+
+func TestScramClientInvalidSignature(t *testing.T) {
+	c := &scramClient{hashFn: scram.SHA256, nonce: func() string {
+		return "rOprNGfwEbeRWgbNEkqO"
+	}}
+	_ = c.Begin("user", "pencil", "")
+	_, _ = c.Step("")
+	_, _ = c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if _, err := c.Step("v=AAAA"); err == nil {
+		t.Error("Step(3) error = nil with invalid server signature")
+	}
+	if c.conv.Valid() {
+		t.Error("conversation valid with invalid signature")
+	}
+}
+
+func TestScramClientMinIterations(t *testing.T) {
+	c := &scramClient{hashFn: scram.SHA256, nonce: func() string {
+		return "rOprNGfwEbeRWgbNEkqO"
+	}}
+	_ = c.Begin("user", "pencil", "")
+	_, _ = c.Step("")
+	if _, err := c.Step("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=1"); err == nil {
+		t.Error("Step(2) error = nil with 1 iteration")
+	}
+}
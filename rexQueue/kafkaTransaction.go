@@ -0,0 +1,129 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/IBM/sarama"
+)
+
+var (
+	ErrProducerNotTransactional = errors.New("producer 未开启事务，请使用 WithTransactionalId")
+	// ErrSendOutsideTransaction 事务 producer 在事务外发送消息，消息会混入其他协程正在进行的事务，一起提交或回滚
+	ErrSendOutsideTransaction = errors.New("事务 producer 只能在 Transaction 中发送消息")
+)
+
+type (
+	// KafkaTx 事务内的操作，消息和消费 offset 在事务提交时一起生效
+	KafkaTx interface {
+		Send(msg *sarama.ProducerMessage) error
+		EasySend(topic, key, value string) error
+		// MarkMessage 在事务内提交消费 offset，使用配置中的 GroupId；
+		// 使用后不要再调用 session.MarkMessage，否则 offset 会绕过事务提交
+		MarkMessage(msg *sarama.ConsumerMessage, metadata string) error
+		// MarkOffsets 在事务内提交指定消费者组的 offset，offset 为下一条要消费的消息
+		MarkOffsets(groupId string, offsets map[string][]*sarama.PartitionOffsetMetadata) error
+	}
+
+	// txnProducer sarama.SyncProducer 和 sarama.AsyncProducer 共同的事务方法
+	txnProducer interface {
+		IsTransactional() bool
+		TxnStatus() sarama.ProducerTxnStatusFlag
+		BeginTxn() error
+		CommitTxn() error
+		AbortTxn() error
+		AddOffsetsToTxn(offsets map[string][]*sarama.PartitionOffsetMetadata, groupId string) error
+		AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string, metadata *string) error
+	}
+
+	kafkaTx struct {
+		ctx      context.Context
+		q        *defaultKafkaQueue
+		producer txnProducer
+	}
+)
+
+func (q *defaultKafkaQueue) Transaction(fn func(tx KafkaTx) error) error {
+	return q.TransactionCtx(context.Background(), fn)
+}
+
+// TransactionCtx 开启一个事务执行 fn，fn 返回 nil 时提交事务，返回错误或 panic 时回滚，同一个 KafkaQueue 上的事务串行执行；
+// 事务 producer 只能通过 KafkaTx 发送，SyncSendMessage、AsyncSendMessage 等方法返回 ErrSendOutsideTransaction
+func (q *defaultKafkaQueue) TransactionCtx(ctx context.Context, fn func(tx KafkaTx) error) (err error) {
+	var producer txnProducer
+	if q.syncProducer != nil {
+		producer = q.syncProducer
+	} else if q.asyncProducer != nil {
+		producer = q.asyncProducer
+	}
+	if producer == nil || !producer.IsTransactional() {
+		return ErrProducerNotTransactional
+	}
+
+	q.txLock.Lock()
+	defer q.txLock.Unlock()
+
+	if err := producer.BeginTxn(); err != nil {
+		return fmt.Errorf("begin txn failed: %w", err)
+	}
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		if r := recover(); r != nil {
+			_ = producer.AbortTxn()
+			panic(r)
+		}
+		// note: 致命错误时 producer 已经不可用，回滚同样会失败，需要重建 KafkaQueue
+		if abortErr := producer.AbortTxn(); abortErr != nil {
+			err = errors.Join(err, fmt.Errorf("abort txn failed: %w", abortErr))
+		}
+	}()
+
+	if err := fn(&kafkaTx{ctx: ctx, q: q, producer: producer}); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := producer.CommitTxn(); err != nil {
+		if producer.TxnStatus()&sarama.ProducerTxnFlagAbortableError == 0 {
+			// note: 不可回滚的错误，跳过 AbortTxn
+			committed = true
+		}
+		return fmt.Errorf("commit txn failed: %w", err)
+	}
+	committed = true
+	return nil
+}
+
+// transactional 配置了 Producer.Transaction.ID，producer 按事务模式创建
+func (q *defaultKafkaQueue) transactional() bool {
+	return q.conf != nil && q.conf.Config != nil && q.conf.Producer.Transaction.ID != ""
+}
+
+func (t *kafkaTx) Send(msg *sarama.ProducerMessage) error {
+	if t.q.syncProducer != nil {
+		_, _, err := t.q.syncSend(msg)
+		return err
+	}
+	return t.q.asyncSend(t.ctx, msg)
+}
+
+func (t *kafkaTx) EasySend(topic, key, value string) error {
+	return t.Send(&sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.StringEncoder(key),
+		Value: sarama.StringEncoder(value),
+	})
+}
+
+func (t *kafkaTx) MarkMessage(msg *sarama.ConsumerMessage, metadata string) error {
+	return t.producer.AddMessageToTxn(msg, t.q.conf.GroupId, &metadata)
+}
+
+func (t *kafkaTx) MarkOffsets(groupId string, offsets map[string][]*sarama.PartitionOffsetMetadata) error {
+	return t.producer.AddOffsetsToTxn(offsets, groupId)
+}
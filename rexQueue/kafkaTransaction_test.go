@@ -0,0 +1,90 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+)
+
+// countingTxnProducer 统计事务的开始、提交和回滚次数
+type countingTxnProducer struct {
+	*mocks.SyncProducer
+	begins, commits, aborts int
+}
+
+func (p *countingTxnProducer) BeginTxn() error {
+	p.begins++
+	return p.SyncProducer.BeginTxn()
+}
+
+func (p *countingTxnProducer) CommitTxn() error {
+	p.commits++
+	return p.SyncProducer.CommitTxn()
+}
+
+func (p *countingTxnProducer) AbortTxn() error {
+	p.aborts++
+	return p.SyncProducer.AbortTxn()
+}
+
+func TestTransactionCtx(t *testing.T) {
+	conf := Default([]string{"localhost:9092"}, "g", nil)
+	if err := conf.WithTransactionalId("tx-1").Build(); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	producer := &countingTxnProducer{SyncProducer: mocks.NewSyncProducer(t, conf.Config)}
+	q := &defaultKafkaQueue{conf: conf, syncProducer: producer, life: newLifecycleState()}
+	defer func() { _ = q.Shutdown(context.Background()) }()
+	ctx := context.Background()
+
+	producer.ExpectSendMessageAndSucceed()
+	err := q.TransactionCtx(ctx, func(tx KafkaTx) error {
+		return tx.EasySend("order", "k", "v")
+	})
+	if err != nil || producer.commits != 1 || producer.aborts != 0 {
+		t.Fatalf("commit: err = %v, commits = %d, aborts = %d", err, producer.commits, producer.aborts)
+	}
+
+	boom := errors.New("boom")
+	producer.ExpectSendMessageAndSucceed()
+	err = q.TransactionCtx(ctx, func(tx KafkaTx) error {
+		if err := tx.EasySend("order", "k", "v"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) || producer.commits != 1 || producer.aborts != 1 {
+		t.Fatalf("abort on error: err = %v, commits = %d, aborts = %d", err, producer.commits, producer.aborts)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r != "panic in txn" {
+				t.Errorf("recover() = %v, want the panic re-raised", r)
+			}
+		}()
+		_ = q.TransactionCtx(ctx, func(tx KafkaTx) error {
+			panic("panic in txn")
+		})
+	}()
+	if producer.commits != 1 || producer.aborts != 2 || producer.begins != 3 {
+		t.Fatalf("abort on panic: begins = %d, commits = %d, aborts = %d", producer.begins, producer.commits, producer.aborts)
+	}
+	if status := producer.TxnStatus(); status&sarama.ProducerTxnFlagInTransaction != 0 {
+		t.Errorf("TxnStatus() = %v, want no open transaction", status)
+	}
+
+	// note: 事务外的发送会混入正在进行的事务，直接拒绝
+	if _, _, err := q.EasySyncSendMessage("order", "k", "v"); !errors.Is(err, ErrSendOutsideTransaction) {
+		t.Errorf("EasySyncSendMessage() error = %v, want ErrSendOutsideTransaction", err)
+	}
+
+	plain := &defaultKafkaQueue{conf: Default([]string{"localhost:9092"}, "g", nil), syncProducer: mocks.NewSyncProducer(t, nil), life: newLifecycleState()}
+	defer func() { _ = plain.Shutdown(context.Background()) }()
+	if err := plain.TransactionCtx(ctx, func(tx KafkaTx) error { return nil }); !errors.Is(err, ErrProducerNotTransactional) {
+		t.Errorf("TransactionCtx() on a plain producer error = %v, want ErrProducerNotTransactional", err)
+	}
+}
@@ -2,10 +2,11 @@ package rexQueue
 
 import (
 	"github.com/IBM/sarama"
+	"github.com/zeromicro/go-zero/core/logc"
 )
 
 type EasyConsumerGroupHandler struct {
-	readMsgFunc func(msg *sarama.ConsumerMessage)
+	readMsgFunc func(msg *sarama.ConsumerMessage) error
 }
 
 func (h *EasyConsumerGroupHandler) Setup(sarama.ConsumerGroupSession) error   { return nil }
@@ -14,8 +15,10 @@ func (h *EasyConsumerGroupHandler) Cleanup(sarama.ConsumerGroupSession) error {
 func (h *EasyConsumerGroupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
 	for msg := range claim.Messages() {
 		if h.readMsgFunc != nil {
-			h.readMsgFunc(msg)
-			continue
+			if err := h.readMsgFunc(msg); err != nil {
+				// note: 没有重试策略时只记录错误，需要重试请使用 RetryConsume
+				logc.Errorf(session.Context(), "consume msg failed, topic: %s, partition: %d, offset: %d, err: %v", msg.Topic, msg.Partition, msg.Offset, err)
+			}
 		}
 		// 标记该消息为已处理，提交 offset
 		session.MarkMessage(msg, "")
@@ -0,0 +1,360 @@
+package rexQueue
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMemoryPartitions = 4
+)
+
+type (
+	MemoryQueueConf struct {
+		// Partitions 每个 topic 的分区数，默认 4，相同 key 的消息总是进入同一个分区
+		Partitions int
+		// MaxRedeliveries nack 后最多重新投递多少次，超过后进入死信，默认 3
+		MaxRedeliveries int
+	}
+
+	// MemoryQueue 进程内的队列实现，支持消费者组和按 key 分区，用于没有 broker 的集成测试
+	MemoryQueue interface {
+		Producer
+		// Consumer 返回消费者组 group 的 Consumer，每次 Subscribe 都是组内的一个成员，分区在成员之间分配
+		Consumer(group string) Consumer
+		// Messages 返回 topic 中的所有消息，按分区和 offset 排序
+		Messages(topic string) []*Message
+		// DeadLetters 返回重新投递次数耗尽的消息
+		DeadLetters() []*Message
+		// Lag 返回消费者组还没有提交的消息数量
+		Lag(group string) int64
+		// WaitIdle 等待所有有成员的分区都消费完成并且没有处理中的消息
+		WaitIdle(ctx context.Context) error
+		Close() error
+	}
+
+	memoryQueue struct {
+		lock        sync.Mutex
+		conf        MemoryQueueConf
+		topics      map[string][][]*Message
+		groups      map[string]*memoryGroup
+		roundRobin  map[string]int
+		deadLetters []*Message
+		// notify 状态变化时关闭并替换，用于唤醒等待的协程
+		notify chan struct{}
+		done   chan struct{}
+		closed bool
+		wg     sync.WaitGroup
+	}
+
+	memoryGroup struct {
+		name     string
+		members  []*memoryMember
+		offsets  map[string][]int64
+		attempts map[string][]int
+		inflight int
+	}
+
+	memoryMember struct {
+		ctx     context.Context
+		topics  map[string]struct{}
+		handler Handler
+	}
+
+	memoryConsumer struct {
+		q     *memoryQueue
+		group string
+	}
+)
+
+func NewMemoryQueue(conf MemoryQueueConf) MemoryQueue {
+	if conf.Partitions <= 0 {
+		conf.Partitions = defaultMemoryPartitions
+	}
+	if conf.MaxRedeliveries <= 0 {
+		conf.MaxRedeliveries = DefaultMaxRedeliveries
+	}
+	return &memoryQueue{
+		conf:       conf,
+		topics:     make(map[string][][]*Message),
+		groups:     make(map[string]*memoryGroup),
+		roundRobin: make(map[string]int),
+		notify:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+func (q *memoryQueue) Send(ctx context.Context, msg *Message) error {
+	if msg.Topic == "" {
+		return ErrEmptyTopic
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+	partitions := q.ensureTopic(msg.Topic)
+	var partition int32
+	if msg.Key != "" {
+		partition = partitionForKey(msg.Key, len(partitions))
+	} else {
+		partition = int32(q.roundRobin[msg.Topic] % len(partitions))
+		q.roundRobin[msg.Topic]++
+	}
+	stored := cloneMessage(msg)
+	stored.Partition = partition
+	stored.Offset = int64(len(partitions[partition]))
+	stored.Attempt = 0
+	if stored.Timestamp.IsZero() {
+		stored.Timestamp = time.Now()
+	}
+	partitions[partition] = append(partitions[partition], stored)
+	q.broadcast()
+	return nil
+}
+
+func (q *memoryQueue) Consumer(group string) Consumer {
+	return &memoryConsumer{q: q, group: group}
+}
+
+func (c *memoryConsumer) Subscribe(ctx context.Context, topics []string, handler Handler) error {
+	for _, topic := range topics {
+		if topic == "" {
+			return ErrEmptyTopic
+		}
+	}
+	q := c.q
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return ErrQueueClosed
+	}
+	g, ok := q.groups[c.group]
+	if !ok {
+		g = &memoryGroup{
+			name:     c.group,
+			offsets:  make(map[string][]int64),
+			attempts: make(map[string][]int),
+		}
+		q.groups[c.group] = g
+	}
+	member := &memoryMember{
+		ctx:     ctx,
+		topics:  make(map[string]struct{}, len(topics)),
+		handler: handler,
+	}
+	for _, topic := range topics {
+		member.topics[topic] = struct{}{}
+		partitions := q.ensureTopic(topic)
+		if _, ok := g.offsets[topic]; !ok {
+			// note: 新的消费者组从最早的消息开始消费
+			g.offsets[topic] = make([]int64, len(partitions))
+			g.attempts[topic] = make([]int, len(partitions))
+			for p := range partitions {
+				q.wg.Add(1)
+				go q.runPartition(g, topic, int32(p))
+			}
+		}
+	}
+	g.members = append(g.members, member)
+	q.broadcast()
+	q.lock.Unlock()
+
+	select {
+	case <-ctx.Done():
+	case <-q.done:
+	}
+
+	q.lock.Lock()
+	for i, m := range g.members {
+		if m == member {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+	q.broadcast()
+	q.lock.Unlock()
+	return nil
+}
+
+// runPartition 按顺序把一个分区的消息投递给当前负责该分区的成员，每个消费者组每个分区一个协程
+func (q *memoryQueue) runPartition(g *memoryGroup, topic string, partition int32) {
+	defer q.wg.Done()
+	for {
+		q.lock.Lock()
+		var owner *memoryMember
+		for {
+			if q.closed {
+				q.lock.Unlock()
+				return
+			}
+			owner = g.owner(topic, partition)
+			if owner != nil && g.offsets[topic][partition] < int64(len(q.topics[topic][partition])) {
+				break
+			}
+			wait := q.notify
+			q.lock.Unlock()
+			<-wait
+			q.lock.Lock()
+		}
+		msg := cloneMessage(q.topics[topic][partition][g.offsets[topic][partition]])
+		msg.Attempt = g.attempts[topic][partition] + 1
+		g.inflight++
+		q.lock.Unlock()
+
+		err := owner.handler(owner.ctx, msg)
+
+		q.lock.Lock()
+		g.inflight--
+		if err == nil || msg.Attempt > q.conf.MaxRedeliveries {
+			if err != nil {
+				q.deadLetters = append(q.deadLetters, msg)
+			}
+			g.offsets[topic][partition]++
+			g.attempts[topic][partition] = 0
+		} else {
+			g.attempts[topic][partition]++
+		}
+		q.broadcast()
+		q.lock.Unlock()
+	}
+}
+
+// owner 按加入顺序把分区分配给订阅了该 topic 的成员
+func (g *memoryGroup) owner(topic string, partition int32) *memoryMember {
+	var candidates []*memoryMember
+	for _, m := range g.members {
+		if _, ok := m.topics[topic]; ok && m.ctx.Err() == nil {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[int(partition)%len(candidates)]
+}
+
+func (q *memoryQueue) Messages(topic string) []*Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	var result []*Message
+	for _, partition := range q.topics[topic] {
+		for _, msg := range partition {
+			result = append(result, cloneMessage(msg))
+		}
+	}
+	return result
+}
+
+func (q *memoryQueue) DeadLetters() []*Message {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	result := make([]*Message, 0, len(q.deadLetters))
+	for _, msg := range q.deadLetters {
+		result = append(result, cloneMessage(msg))
+	}
+	return result
+}
+
+func (q *memoryQueue) Lag(group string) int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	g, ok := q.groups[group]
+	if !ok {
+		return 0
+	}
+	var lag int64
+	for topic, offsets := range g.offsets {
+		for p, offset := range offsets {
+			lag += int64(len(q.topics[topic][p])) - offset
+		}
+	}
+	return lag
+}
+
+func (q *memoryQueue) WaitIdle(ctx context.Context) error {
+	for {
+		q.lock.Lock()
+		if q.idle() {
+			q.lock.Unlock()
+			return nil
+		}
+		wait := q.notify
+		q.lock.Unlock()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func (q *memoryQueue) idle() bool {
+	for _, g := range q.groups {
+		if g.inflight > 0 {
+			return false
+		}
+		for topic, offsets := range g.offsets {
+			for p, offset := range offsets {
+				if offset < int64(len(q.topics[topic][p])) && g.owner(topic, int32(p)) != nil {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Close 停止投递并等待处理中的消息完成，Subscribe 随之返回
+func (q *memoryQueue) Close() error {
+	q.lock.Lock()
+	if q.closed {
+		q.lock.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.done)
+	q.broadcast()
+	q.lock.Unlock()
+	q.wg.Wait()
+	return nil
+}
+
+func (q *memoryQueue) ensureTopic(topic string) [][]*Message {
+	partitions, ok := q.topics[topic]
+	if !ok {
+		partitions = make([][]*Message, q.conf.Partitions)
+		q.topics[topic] = partitions
+	}
+	return partitions
+}
+
+func (q *memoryQueue) broadcast() {
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+func partitionForKey(key string, partitions int) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int32(h.Sum32() % uint32(partitions))
+}
+
+func cloneMessage(msg *Message) *Message {
+	c := *msg
+	if msg.Value != nil {
+		c.Value = append([]byte(nil), msg.Value...)
+	}
+	if msg.Headers != nil {
+		c.Headers = make(map[string]string, len(msg.Headers))
+		for k, v := range msg.Headers {
+			c.Headers[k] = v
+		}
+	}
+	return &c
+}
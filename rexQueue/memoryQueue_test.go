@@ -0,0 +1,124 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryQueueConsumerGroups(t *testing.T) {
+	q := NewMemoryQueue(MemoryQueueConf{Partitions: 4})
+	defer q.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	// note: 同一个消费者组内按接收顺序记录每个 key 的消息
+	received := map[string]map[string][]int{}
+	record := func(group string) Handler {
+		return func(ctx context.Context, msg *Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if received[group] == nil {
+				received[group] = map[string][]int{}
+			}
+			var v int
+			fmt.Sscan(string(msg.Value), &v)
+			received[group][msg.Key] = append(received[group][msg.Key], v)
+			return nil
+		}
+	}
+	go q.Consumer("billing").Subscribe(ctx, []string{"order"}, record("billing"))
+	go q.Consumer("billing").Subscribe(ctx, []string{"order"}, record("billing"))
+	go q.Consumer("audit").Subscribe(ctx, []string{"order"}, record("audit"))
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("user-%d", i%5)
+		if err := q.Send(ctx, &Message{Topic: "order", Key: key, Value: []byte(fmt.Sprint(i))}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	waitConsumed(t, q, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received["billing"]) > 0 && len(received["audit"]) > 0
+	}, "billing", "audit")
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, group := range []string{"billing", "audit"} {
+		total := 0
+		for key, values := range received[group] {
+			total += len(values)
+			if !sort.IntsAreSorted(values) {
+				t.Errorf("group %s key %s out of order: %v", group, key, values)
+			}
+		}
+		if total != 20 {
+			t.Errorf("group %s received %v msgs, want 20", group, total)
+		}
+	}
+}
+
+func TestMemoryQueueNack(t *testing.T) {
+	q := NewMemoryQueue(MemoryQueueConf{Partitions: 1, MaxRedeliveries: 2})
+	defer q.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	var attempts []string
+	go q.Consumer("g").Subscribe(ctx, []string{"t"}, func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts = append(attempts, fmt.Sprintf("%s#%d", msg.Value, msg.Attempt))
+		if string(msg.Value) == "bad" || (string(msg.Value) == "flaky" && msg.Attempt == 1) {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	for _, v := range []string{"flaky", "bad", "ok"} {
+		_ = q.Send(ctx, &Message{Topic: "t", Value: []byte(v)})
+	}
+
+	waitConsumed(t, q, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(attempts) > 0
+	}, "g")
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"flaky#1", "flaky#2", "bad#1", "bad#2", "bad#3", "ok#1"}
+	if !reflect.DeepEqual(attempts, want) {
+		t.Errorf("attempts = %v, want %v", attempts, want)
+	}
+	if dead := q.DeadLetters(); len(dead) != 1 || string(dead[0].Value) != "bad" {
+		t.Errorf("DeadLetters() = %v", dead)
+	}
+}
+
+// waitConsumed 订阅是异步建立的，等到 started 返回 true 之后再等待所有消费者组没有积压
+func waitConsumed(t *testing.T, q MemoryQueue, started func() bool, groups ...string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		if err := q.WaitIdle(ctx); err != nil {
+			t.Fatalf("WaitIdle() error = %v", err)
+		}
+		done := started()
+		for _, group := range groups {
+			done = done && q.Lag(group) == 0
+		}
+		if done {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
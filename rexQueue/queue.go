@@ -0,0 +1,62 @@
+package rexQueue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	// DefaultMaxRedeliveries nack 后默认最多重新投递的次数
+	DefaultMaxRedeliveries = 3
+)
+
+var (
+	ErrQueueClosed = errors.New("queue 已关闭")
+	ErrEmptyTopic  = errors.New("topic 不能为空")
+)
+
+type (
+	// Message 与具体队列无关的消息，业务代码和测试只依赖这个结构
+	Message struct {
+		Topic     string
+		Key       string
+		Value     []byte
+		Headers   map[string]string
+		Partition int32
+		Offset    int64
+		Timestamp time.Time
+		// Attempt 第几次投递，从 1 开始，nack 后重新投递时递增
+		Attempt int
+	}
+
+	// Handler 返回 nil 表示 ack，消息被提交；返回错误表示 nack，消息立即重新投递，Attempt 递增，
+	// 重新投递 MaxRedeliveries 次（默认 DefaultMaxRedeliveries）后仍然失败的消息进入死信并提交，不会阻塞后面的消息：
+	// MemoryQueue 记录在 DeadLetters 中，Kafka 没有 RetryPolicy 时只记录错误日志，有 RetryPolicy 时按重试 topic 和死信 topic 处理
+	Handler func(ctx context.Context, msg *Message) error
+
+	Producer interface {
+		Send(ctx context.Context, msg *Message) error
+	}
+
+	Consumer interface {
+		// Subscribe 以消费者组成员的身份消费 topics，阻塞直到 ctx 结束，同一个分区内的消息按顺序投递
+		Subscribe(ctx context.Context, topics []string, handler Handler) error
+	}
+)
+
+// Header 返回消息头，不存在时返回空字符串
+func (m *Message) Header(key string) string {
+	if m.Headers == nil {
+		return ""
+	}
+	return m.Headers[key]
+}
+
+// SetHeader 设置消息头
+func (m *Message) SetHeader(key, value string) {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[key] = value
+}
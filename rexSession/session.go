@@ -0,0 +1,358 @@
+package rexSession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCrypto"
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"github.com/rootexit/rexLib/rexUserAgent"
+)
+
+// touchAttempts Touch 遇到并发修改时的最多尝试次数
+const touchAttempts = 3
+
+var (
+	// ErrSessionNotFound is an error that indicates the session does not exist or has been cleaned up.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrSessionExpired is an error that indicates the session has passed its idle or absolute expiry.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSessionRevoked is an error that indicates the session has been revoked.
+	ErrSessionRevoked = errors.New("session revoked")
+	// ErrEmptySessionId is an error that indicates no session id is given.
+	ErrEmptySessionId = errors.New("empty session id")
+)
+
+type (
+	SessionConf struct {
+		Prefix string `json:",default=rex"`
+		// IdleTimeout 滑动过期时间，单位秒，每次访问都会顺延
+		IdleTimeout int `json:",default=1800"`
+		// AbsoluteTimeout 绝对过期时间，单位秒，从创建开始计算，不会顺延
+		AbsoluteTimeout int `json:",default=604800"`
+		// RevokedRetention 被吊销的会话保留多久，用于返回准确的吊销错误，单位秒，小于 0 时不保留
+		RevokedRetention int `json:",default=300"`
+	}
+	Session struct {
+		SessionId         string                          `json:"session_id"`
+		UserId            string                          `json:"user_id"`
+		TenantId          string                          `json:"tenant_id"`
+		Status            rexDatabase.CommonSessionStatus `json:"status"`
+		Client            rexUserAgent.Client             `json:"client"`
+		Data              map[string]string               `json:"data,omitempty"`
+		CreatedAt         int64                           `json:"created_at"`
+		LastActiveAt      int64                           `json:"last_active_at"`
+		ExpiresAt         int64                           `json:"expires_at"`
+		AbsoluteExpiresAt int64                           `json:"absolute_expires_at"`
+		RotatedFrom       string                          `json:"rotated_from,omitempty"`
+	}
+	SessionManager interface {
+		GetConf() SessionConf
+		Create(ctx context.Context, userId, tenantId string, client rexUserAgent.Client, data map[string]string) (*Session, error)
+		Get(ctx context.Context, sessionId string) (*Session, error)
+		Touch(ctx context.Context, sessionId string) (*Session, error)
+		Save(ctx context.Context, s *Session) error
+		ListByUser(ctx context.Context, userId string) ([]*Session, error)
+		Revoke(ctx context.Context, sessionId string) error
+		RevokeAll(ctx context.Context, userId string, exceptSessionIds ...string) (int, error)
+		Rotate(ctx context.Context, sessionId string) (*Session, error)
+		Authenticate(ctx context.Context, sessionId, userId, tenantId string) (*Session, error)
+	}
+	defaultSessionManager struct {
+		conf  SessionConf
+		store rexDao.RedisDao
+		now   func() time.Time
+	}
+)
+
+func DefaultSessionConf() SessionConf {
+	return SessionConf{
+		Prefix:           "rex",
+		IdleTimeout:      1800,
+		AbsoluteTimeout:  604800,
+		RevokedRetention: 300,
+	}
+}
+
+// NewSessionManager conf 中为零值的字段使用 DefaultSessionConf 的值，RevokedRetention 为负数时不保留被吊销的会话
+func NewSessionManager(conf SessionConf, store rexDao.RedisDao) SessionManager {
+	def := DefaultSessionConf()
+	if conf.Prefix == "" {
+		conf.Prefix = def.Prefix
+	}
+	if conf.IdleTimeout <= 0 {
+		conf.IdleTimeout = def.IdleTimeout
+	}
+	if conf.AbsoluteTimeout <= 0 {
+		conf.AbsoluteTimeout = def.AbsoluteTimeout
+	}
+	if conf.RevokedRetention == 0 {
+		conf.RevokedRetention = def.RevokedRetention
+	}
+	return &defaultSessionManager{
+		conf:  conf,
+		store: store,
+		now:   time.Now,
+	}
+}
+
+func (m *defaultSessionManager) GetConf() SessionConf {
+	return m.conf
+}
+
+// Create 创建会话，userId 为空时创建匿名会话
+func (m *defaultSessionManager) Create(ctx context.Context, userId, tenantId string, client rexUserAgent.Client, data map[string]string) (*Session, error) {
+	now := m.now()
+	s := &Session{
+		SessionId:         newSessionId(),
+		UserId:            userId,
+		TenantId:          tenantId,
+		Status:            rexDatabase.SessionStatusAnonymous,
+		Client:            client,
+		Data:              data,
+		CreatedAt:         now.Unix(),
+		LastActiveAt:      now.Unix(),
+		ExpiresAt:         now.Add(time.Duration(m.conf.IdleTimeout) * time.Second).Unix(),
+		AbsoluteExpiresAt: now.Add(time.Duration(m.conf.AbsoluteTimeout) * time.Second).Unix(),
+	}
+	if userId != "" {
+		s.Status = rexDatabase.SessionStatusAuthenticated
+	}
+	if err := m.Save(ctx, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 读取会话并校验状态与过期时间，不会顺延过期时间
+func (m *defaultSessionManager) Get(ctx context.Context, sessionId string) (*Session, error) {
+	s, _, err := m.get(ctx, sessionId)
+	return s, err
+}
+
+// Touch 读取会话并顺延滑动过期时间，但不会超过绝对过期时间；
+// 只有值在读取之后没有变化时才写入，不会把并发吊销的会话写回有效状态
+func (m *defaultSessionManager) Touch(ctx context.Context, sessionId string) (*Session, error) {
+	for i := 0; i < touchAttempts; i++ {
+		s, raw, err := m.get(ctx, sessionId)
+		if err != nil {
+			return s, err
+		}
+		now := m.now()
+		s.LastActiveAt = now.Unix()
+		s.ExpiresAt = now.Add(time.Duration(m.conf.IdleTimeout) * time.Second).Unix()
+		ttl := m.ttl(s)
+		if ttl <= 0 {
+			return s, ErrSessionExpired
+		}
+		body, err := json.Marshal(s)
+		if err != nil {
+			return nil, err
+		}
+		// note: 用户索引在 Save 时已经写入，顺延只需要一次写操作
+		ok, err := m.store.CompareAndSwapCtx(ctx, m.sessionKey(sessionId), raw, string(body), ttl)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return s, nil
+		}
+	}
+	// note: 一直有并发修改时不再顺延，返回当前状态，被吊销时返回 ErrSessionRevoked
+	return m.Get(ctx, sessionId)
+}
+
+func (m *defaultSessionManager) Save(ctx context.Context, s *Session) error {
+	if s.SessionId == "" {
+		return ErrEmptySessionId
+	}
+	ttl := m.ttl(s)
+	if ttl <= 0 {
+		return ErrSessionExpired
+	}
+	body, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := m.store.SetExCtx(ctx, m.sessionKey(s.SessionId), string(body), ttl); err != nil {
+		return err
+	}
+	if s.UserId == "" {
+		return nil
+	}
+	if _, err := m.store.SAddCtx(ctx, m.userKey(s.UserId), s.SessionId); err != nil {
+		return err
+	}
+	// note: 用户索引的有效期跟随最晚过期的会话
+	_, err = m.store.ExpireCtx(ctx, m.userKey(s.UserId), m.conf.AbsoluteTimeout)
+	return err
+}
+
+// ListByUser 返回用户所有有效会话，并顺便清理索引中已失效的会话id
+func (m *defaultSessionManager) ListByUser(ctx context.Context, userId string) ([]*Session, error) {
+	ids, err := m.store.SMembersCtx(ctx, m.userKey(userId))
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Session, 0, len(ids))
+	stale := make([]interface{}, 0)
+	for _, id := range ids {
+		s, err := m.Get(ctx, id)
+		switch {
+		case err == nil:
+			result = append(result, s)
+		case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrSessionExpired), errors.Is(err, ErrSessionRevoked):
+			stale = append(stale, id)
+		default:
+			return nil, err
+		}
+	}
+	if len(stale) > 0 {
+		if _, err := m.store.SRemCtx(ctx, m.userKey(userId), stale...); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (m *defaultSessionManager) Revoke(ctx context.Context, sessionId string) error {
+	s, err := m.Get(ctx, sessionId)
+	if err != nil {
+		if errors.Is(err, ErrSessionRevoked) || errors.Is(err, ErrSessionNotFound) {
+			return nil
+		}
+		if !errors.Is(err, ErrSessionExpired) {
+			return err
+		}
+	}
+	return m.revoke(ctx, s)
+}
+
+// RevokeAll 吊销用户的所有会话，exceptSessionIds 中的会话会被保留（比如当前会话）
+func (m *defaultSessionManager) RevokeAll(ctx context.Context, userId string, exceptSessionIds ...string) (int, error) {
+	sessions, err := m.ListByUser(ctx, userId)
+	if err != nil {
+		return 0, err
+	}
+	except := make(map[string]struct{}, len(exceptSessionIds))
+	for _, id := range exceptSessionIds {
+		except[id] = struct{}{}
+	}
+	count := 0
+	for _, s := range sessions {
+		if _, ok := except[s.SessionId]; ok {
+			continue
+		}
+		if err := m.revoke(ctx, s); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Rotate 更换会话id，旧会话立即失效，用于登录、提权等权限变化的场景，防止会话固定攻击
+func (m *defaultSessionManager) Rotate(ctx context.Context, sessionId string) (*Session, error) {
+	s, err := m.Get(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	rotated := *s
+	rotated.SessionId = newSessionId()
+	rotated.RotatedFrom = s.SessionId
+	rotated.LastActiveAt = m.now().Unix()
+	if err := m.Save(ctx, &rotated); err != nil {
+		return nil, err
+	}
+	if err := m.revoke(ctx, s); err != nil {
+		return nil, err
+	}
+	return &rotated, nil
+}
+
+// Authenticate 将匿名会话升级为登录会话，并同时更换会话id
+func (m *defaultSessionManager) Authenticate(ctx context.Context, sessionId, userId, tenantId string) (*Session, error) {
+	s, err := m.Get(ctx, sessionId)
+	if err != nil {
+		return nil, err
+	}
+	s.UserId = userId
+	s.TenantId = tenantId
+	s.Status = rexDatabase.SessionStatusAuthenticated
+	if err := m.Save(ctx, s); err != nil {
+		return nil, err
+	}
+	return m.Rotate(ctx, s.SessionId)
+}
+
+func (m *defaultSessionManager) revoke(ctx context.Context, s *Session) error {
+	s.Status = rexDatabase.SessionStatusRevoked
+	if m.conf.RevokedRetention > 0 {
+		body, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		if err := m.store.SetExCtx(ctx, m.sessionKey(s.SessionId), string(body), m.conf.RevokedRetention); err != nil {
+			return err
+		}
+	} else if _, err := m.store.DelCtx(ctx, m.sessionKey(s.SessionId)); err != nil {
+		return err
+	}
+	if s.UserId != "" {
+		if _, err := m.store.SRemCtx(ctx, m.userKey(s.UserId), s.SessionId); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// get 返回会话和读取到的原始值，原始值用于 CompareAndSwap
+func (m *defaultSessionManager) get(ctx context.Context, sessionId string) (*Session, string, error) {
+	if sessionId == "" {
+		return nil, "", ErrEmptySessionId
+	}
+	val, err := m.store.GetCtx(ctx, m.sessionKey(sessionId))
+	if err != nil {
+		return nil, "", err
+	}
+	if val == "" {
+		return nil, "", ErrSessionNotFound
+	}
+	var s Session
+	if err := json.Unmarshal([]byte(val), &s); err != nil {
+		return nil, "", err
+	}
+	if s.Status == rexDatabase.SessionStatusRevoked {
+		return &s, val, ErrSessionRevoked
+	}
+	now := m.now().Unix()
+	if now >= s.ExpiresAt || now >= s.AbsoluteExpiresAt {
+		return &s, val, ErrSessionExpired
+	}
+	return &s, val, nil
+}
+
+func (m *defaultSessionManager) ttl(s *Session) int {
+	now := m.now().Unix()
+	end := s.ExpiresAt
+	if s.AbsoluteExpiresAt < end {
+		end = s.AbsoluteExpiresAt
+	}
+	return int(end - now)
+}
+
+func (m *defaultSessionManager) sessionKey(sessionId string) string {
+	return fmt.Sprintf("%s:session:%s", m.conf.Prefix, sessionId)
+}
+
+func (m *defaultSessionManager) userKey(userId string) string {
+	return fmt.Sprintf("%s:session-user:%s", m.conf.Prefix, userId)
+}
+
+func newSessionId() string {
+	return rexCrypto.NewRand().RandBytesUrlBaseNoErr(rexCrypto.Bits256Len)
+}
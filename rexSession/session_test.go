@@ -0,0 +1,146 @@
+package rexSession
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rootexit/rexLib/rexDao"
+	"github.com/rootexit/rexLib/rexDatabase"
+	"github.com/rootexit/rexLib/rexUserAgent"
+)
+
+// raceDao 在 Touch 写入之前执行一次 hook，用来模拟读取和写入之间的并发吊销
+type raceDao struct {
+	rexDao.RedisDao
+	hook func()
+}
+
+func (d *raceDao) CompareAndSwapCtx(ctx context.Context, key, expect, value string, seconds int) (bool, error) {
+	if d.hook != nil {
+		hook := d.hook
+		d.hook = nil
+		hook()
+	}
+	return d.RedisDao.CompareAndSwapCtx(ctx, key, expect, value, seconds)
+}
+
+func newTestManager(t *testing.T) (*defaultSessionManager, *raceDao, rexDao.MemoryRedisDao) {
+	t.Helper()
+	mem := rexDao.NewMemoryRedisDao()
+	mem.SetNow(time.Unix(1700000000, 0))
+	store := &raceDao{RedisDao: mem}
+	m := NewSessionManager(SessionConf{IdleTimeout: 60, AbsoluteTimeout: 300}, store).(*defaultSessionManager)
+	m.now = mem.Now
+	return m, store, mem
+}
+
+func TestSessionManagerDefaults(t *testing.T) {
+	m := NewSessionManager(SessionConf{}, rexDao.NewMemoryRedisDao())
+	if got := m.GetConf(); got != DefaultSessionConf() {
+		t.Errorf("GetConf() = %+v, want %+v", got, DefaultSessionConf())
+	}
+}
+
+func TestSessionManagerCreateAndTouch(t *testing.T) {
+	ctx := context.Background()
+	m, _, mem := newTestManager(t)
+
+	s, err := m.Create(ctx, "u1", "t1", rexUserAgent.Client{}, map[string]string{"k": "v"})
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	if s.Status != rexDatabase.SessionStatusAuthenticated {
+		t.Errorf("Create() status = %v, want authenticated", s.Status)
+	}
+	got, err := m.Get(ctx, s.SessionId)
+	if err != nil || got.UserId != "u1" || got.Data["k"] != "v" {
+		t.Fatalf("Get() = %+v, %v", got, err)
+	}
+
+	mem.Advance(50 * time.Second)
+	touched, err := m.Touch(ctx, s.SessionId)
+	if err != nil {
+		t.Fatalf("Touch() error = %v", err)
+	}
+	if want := mem.Now().Add(60 * time.Second).Unix(); touched.ExpiresAt != want {
+		t.Errorf("Touch() expires at = %d, want %d", touched.ExpiresAt, want)
+	}
+	mem.Advance(50 * time.Second)
+	if _, err := m.Get(ctx, s.SessionId); err != nil {
+		t.Errorf("Get() after touch error = %v", err)
+	}
+
+	// note: 顺延不能超过绝对过期时间
+	for i := 0; i < 5; i++ {
+		mem.Advance(50 * time.Second)
+		_, _ = m.Touch(ctx, s.SessionId)
+	}
+	if _, err := m.Get(ctx, s.SessionId); !errors.Is(err, ErrSessionNotFound) && !errors.Is(err, ErrSessionExpired) {
+		t.Errorf("Get() after absolute timeout error = %v, want expired", err)
+	}
+}
+
+func TestSessionManagerTouchDoesNotResurrect(t *testing.T) {
+	ctx := context.Background()
+	m, store, _ := newTestManager(t)
+	s, err := m.Create(ctx, "u1", "", rexUserAgent.Client{}, nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	store.hook = func() {
+		if err := m.Revoke(ctx, s.SessionId); err != nil {
+			t.Errorf("Revoke() error = %v", err)
+		}
+	}
+	if _, err := m.Touch(ctx, s.SessionId); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Touch() racing Revoke error = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := m.Get(ctx, s.SessionId); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Get() after racing Touch error = %v, want ErrSessionRevoked", err)
+	}
+}
+
+func TestSessionManagerRotateAndRevoke(t *testing.T) {
+	ctx := context.Background()
+	m, _, _ := newTestManager(t)
+
+	s, err := m.Create(ctx, "u1", "", rexUserAgent.Client{}, nil)
+	if err != nil {
+		t.Fatalf("Create() error = %v", err)
+	}
+	rotated, err := m.Rotate(ctx, s.SessionId)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotated.SessionId == s.SessionId || rotated.RotatedFrom != s.SessionId {
+		t.Errorf("Rotate() = %+v", rotated)
+	}
+	if _, err := m.Get(ctx, s.SessionId); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Get(old) error = %v, want ErrSessionRevoked", err)
+	}
+
+	other, _ := m.Create(ctx, "u1", "", rexUserAgent.Client{}, nil)
+	third, _ := m.Create(ctx, "u1", "", rexUserAgent.Client{}, nil)
+	if err := m.Revoke(ctx, third.SessionId); err != nil {
+		t.Fatalf("Revoke() error = %v", err)
+	}
+	if err := m.Revoke(ctx, third.SessionId); err != nil {
+		t.Errorf("Revoke() twice error = %v", err)
+	}
+	if list, _ := m.ListByUser(ctx, "u1"); len(list) != 2 {
+		t.Errorf("ListByUser() = %d sessions, want 2", len(list))
+	}
+
+	n, err := m.RevokeAll(ctx, "u1", rotated.SessionId)
+	if err != nil || n != 1 {
+		t.Fatalf("RevokeAll() = %d, %v, want 1", n, err)
+	}
+	if _, err := m.Get(ctx, other.SessionId); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("Get(other) error = %v, want ErrSessionRevoked", err)
+	}
+	if _, err := m.Get(ctx, rotated.SessionId); err != nil {
+		t.Errorf("Get(kept) error = %v", err)
+	}
+}
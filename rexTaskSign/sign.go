@@ -0,0 +1,149 @@
+package rexTaskSign
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rootexit/rexLib/rexCrypto"
+	"github.com/rootexit/rexLib/rexHeaders"
+)
+
+/*
+	note: 定时任务回调的签名，发送方是 rexCrontabPool 的 webhook 任务，接收方是 rexMiddleware 的校验中间件
+	待签名字符串为下面几行用 \n 连接：
+		METHOD
+		PATH（URL.EscapedPath）
+		QUERY（URL.RawQuery，按发送时的原样，没有 query 时为空行）
+		SOURCE_TYPE
+		SOURCE_ID
+		DELIVERY_ID
+		TIMESTAMP（unix 秒）
+		NONCE
+		BODY_SHA256（body 的 sha256 hex，空 body 也要计算）
+	签名为 hex(HMAC-SHA256(secret, 待签名字符串))
+*/
+
+const (
+	DefaultSourceType = "crontab"
+	nonceLen          = rexCrypto.Bits128Len
+)
+
+var (
+	ErrSecretNotFound = errors.New("签名密钥不存在")
+)
+
+type (
+	// Headers 一次投递携带的 X-REx-Task-* 头
+	Headers struct {
+		DeliveryId string
+		SourceType string
+		SourceId   string
+		Timestamp  string
+		Nonce      string
+		Signature  string
+	}
+
+	// SecretStore 按来源查找签名密钥，发送方和接收方使用同一份配置
+	SecretStore interface {
+		// Secret 来源不存在时返回 ErrSecretNotFound
+		Secret(ctx context.Context, sourceId string) (string, error)
+	}
+
+	// StaticSecrets sourceId 到密钥的固定配置，key 为 * 时作为默认密钥
+	StaticSecrets map[string]string
+)
+
+func (s StaticSecrets) Secret(ctx context.Context, sourceId string) (string, error) {
+	if secret, ok := s[sourceId]; ok {
+		return secret, nil
+	}
+	if secret, ok := s["*"]; ok {
+		return secret, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrSecretNotFound, sourceId)
+}
+
+func BodySha256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// StringToSign h 中除 Signature 以外的字段都参与签名
+func StringToSign(method, path, rawQuery string, h *Headers, bodySha256 string) string {
+	return strings.Join([]string{
+		strings.ToUpper(method), path, rawQuery,
+		h.SourceType, h.SourceId, h.DeliveryId, h.Timestamp, h.Nonce,
+		bodySha256,
+	}, "\n")
+}
+
+func Sign(secret, method, path, rawQuery string, h *Headers, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(StringToSign(method, path, rawQuery, h, BodySha256(body))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify 使用常量时间比较 h.Signature
+func Verify(secret, method, path, rawQuery string, h *Headers, body []byte) bool {
+	expected := Sign(secret, method, path, rawQuery, h, body)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(h.Signature)))
+}
+
+// VerifyRequest 校验 req 的签名，h 一般由 ParseHeaders 得到，body 需要是原始的请求内容
+func VerifyRequest(req *http.Request, secret string, h *Headers, body []byte) bool {
+	return Verify(secret, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, h, body)
+}
+
+// ParseTimestamp 解析 unix 秒时间戳
+func ParseTimestamp(timestamp string) (time.Time, error) {
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+// SignRequest 给 req 设置 X-REx-Task-* 头，Timestamp 和 Nonce 为空时自动生成，签名写回 h.Signature；
+// body 需要和实际发送的内容一致
+func SignRequest(req *http.Request, secret string, h *Headers, body []byte) error {
+	if h.Timestamp == "" {
+		h.Timestamp = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+	if h.Nonce == "" {
+		nonce, err := rexCrypto.NewRand().RandBytesHex(nonceLen)
+		if err != nil {
+			return err
+		}
+		h.Nonce = nonce
+	}
+	if h.SourceType == "" {
+		h.SourceType = DefaultSourceType
+	}
+	h.Signature = Sign(secret, req.Method, req.URL.EscapedPath(), req.URL.RawQuery, h, body)
+	req.Header.Set(rexHeaders.HeaderXRExTaskDeliveryId, h.DeliveryId)
+	req.Header.Set(rexHeaders.HeaderXRExTaskSourceType, h.SourceType)
+	req.Header.Set(rexHeaders.HeaderXRExTaskSourceId, h.SourceId)
+	req.Header.Set(rexHeaders.HeaderXRExTaskTimestamp, h.Timestamp)
+	req.Header.Set(rexHeaders.HeaderXRExTaskNonce, h.Nonce)
+	req.Header.Set(rexHeaders.HeaderXRExTaskSignature, h.Signature)
+	return nil
+}
+
+func ParseHeaders(header http.Header) Headers {
+	return Headers{
+		DeliveryId: header.Get(rexHeaders.HeaderXRExTaskDeliveryId),
+		SourceType: header.Get(rexHeaders.HeaderXRExTaskSourceType),
+		SourceId:   header.Get(rexHeaders.HeaderXRExTaskSourceId),
+		Timestamp:  header.Get(rexHeaders.HeaderXRExTaskTimestamp),
+		Nonce:      header.Get(rexHeaders.HeaderXRExTaskNonce),
+		Signature:  header.Get(rexHeaders.HeaderXRExTaskSignature),
+	}
+}